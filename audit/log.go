@@ -0,0 +1,486 @@
+// Package audit writes a tamper-evident, append-only log of the
+// client txns a node has committed, and of the authentication
+// failures it has seen. The log is newline-delimited JSON: one Record
+// per line. Each Record includes the hex encoded hash of the previous
+// line, so altering, removing or reordering any line breaks the chain,
+// which Verify detects. The chain continues across rotations: the
+// first Record of a new file follows on from the last Record of the
+// file it replaces.
+//
+// Without a key, the hash is SHA-256, which anyone able to write the
+// log can recompute, so it only detects accidental damage and
+// tampering by those who do not rewrite the rest of the chain. With a
+// key, the hash is HMAC-SHA256, which cannot be recomputed without the
+// key: keep the key away from the log.
+//
+// Every Record is synced to disk before the txn or failure it records
+// is reported as complete. If a write fails, the log fails closed: the
+// file is truncated back to its last complete Record, the failure is
+// reported (see SetFailureHandler) so that the node can refuse new
+// write txns, and the Records which could not be written are held and
+// retried, in order, until they are written.
+//
+// VarUUIds and TxnIds are hex encoded.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EventCommitted            = "Committed"
+	EventAuthenticationFailed = "AuthenticationFailed"
+
+	rotatedSuffixFormat = "20060102T150405.000000000Z"
+	anchorSuffix        = ".anchor"
+)
+
+// writeRetryDelay is how long a failed Log waits before retrying the
+// Records it holds.
+var writeRetryDelay = 2 * time.Second
+
+type Record struct {
+	Time        time.Time
+	Event       string
+	Fingerprint string   `json:",omitempty"`
+	Connection  uint32   `json:",omitempty"`
+	Remote      string   `json:",omitempty"`
+	TxnId       string   `json:",omitempty"`
+	Read        []string `json:",omitempty"`
+	Written     []*Value `json:",omitempty"`
+	Created     []*Value `json:",omitempty"`
+	Error       string   `json:",omitempty"`
+	Prev        string
+}
+
+// Value identifies a var written or created by a txn and, if the log
+// records value hashes, the hex encoded SHA-256 of its new value.
+type Value struct {
+	VarUUId     string
+	ValueSha256 string `json:",omitempty"`
+}
+
+// Log is safe for concurrent use. A nil Log records nothing.
+type Log struct {
+	lock        sync.Mutex
+	path        string
+	maxSize     int64
+	valueHashes bool
+	key         []byte
+	file        *os.File
+	size        int64
+	prev        string
+	pending     []*Record
+	err         error
+	onFailure   func(error)
+}
+
+// OpenLog opens the log at path for appending, first verifying the
+// chain of any existing log there. key is nil, or at least 256 bits
+// to hash the chain with HMAC-SHA256. A final line which was only
+// partly written (for example, due to a crash) is removed. The log is
+// rotated once it would exceed maxSize bytes: the current file is
+// renamed with a timestamp suffix and a new file started.
+//
+// The first Record of the file at path must follow on from the newest
+// rotated file beside it, or, if the rotated files have all been
+// moved away, from the hash in path.anchor, which is rewritten on
+// every rotation. With neither, it must be the first Record of the
+// chain.
+func OpenLog(path string, maxSize int64, valueHashes bool, key []byte) (*Log, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("Audit log maximum size must be > 0 (%v)", maxSize)
+	} else if key != nil && len(key) < sha256.Size {
+		return nil, fmt.Errorf("Audit log key must be at least %v bits (%v)", 8*sha256.Size, 8*len(key))
+	}
+	anchor, err := readAnchor(path, key)
+	if err != nil {
+		return nil, fmt.Errorf("Audit log %v: %v", path, err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	prev, size, err := verify(file, anchor, key, true)
+	if err == nil {
+		err = file.Truncate(size)
+	}
+	if err == nil {
+		_, err = file.Seek(size, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Audit log %v: %v", path, err)
+	}
+	return &Log{
+		path:        path,
+		maxSize:     maxSize,
+		valueHashes: valueHashes,
+		key:         key,
+		file:        file,
+		size:        size,
+		prev:        prev,
+	}, nil
+}
+
+// Verify checks the chain of the log read from r. prev is the hash
+// of the last line of the previous file, if r is a rotated file, or
+// "" if r is the first file. key is the key the log was written with,
+// or nil. The hash of r's last line is returned, to verify the next
+// file with.
+func Verify(r io.Reader, prev string, key []byte) (string, error) {
+	last, _, err := verify(r, prev, key, false)
+	return last, err
+}
+
+// When opening, a final line which is incomplete is tolerated.
+func verify(r io.Reader, prev string, key []byte, opening bool) (string, int64, error) {
+	reader := bufio.NewReader(r)
+	size := int64(0)
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) != 0 && !opening {
+				return "", 0, fmt.Errorf("Line %v: incomplete", lineNo)
+			} else if len(line) != 0 {
+				log.Printf("Audit log: removing incomplete final line %v.", lineNo)
+			}
+			return prev, size, nil
+		} else if err != nil {
+			return "", 0, err
+		}
+		size += int64(len(line))
+		line = bytes.TrimSuffix(line, []byte{'\n'})
+		record := &Record{}
+		if err = json.Unmarshal(line, record); err != nil {
+			return "", 0, fmt.Errorf("Line %v: %v", lineNo, err)
+		} else if record.Prev != prev {
+			return "", 0, fmt.Errorf("Line %v: chain broken: expected previous hash %v, found %v", lineNo, prev, record.Prev)
+		}
+		prev = chainHash(line, key)
+	}
+}
+
+// readAnchor returns the hash the first Record at path must follow
+// on from.
+func readAnchor(path string, key []byte) (string, error) {
+	rotated, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(rotated)
+	for idx := len(rotated) - 1; idx >= 0; idx-- {
+		if _, err := time.Parse(rotatedSuffixFormat, strings.TrimPrefix(rotated[idx], path+".")); err == nil {
+			return lastHash(rotated[idx], key)
+		}
+	}
+	anchor, err := ioutil.ReadFile(path + anchorSuffix)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return strings.TrimSpace(string(anchor)), err
+}
+
+// lastHash returns the hash of the last line of the rotated file at
+// path. The chain within the file is checked by Verify, not here.
+func lastHash(path string, key []byte) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	last := []byte(nil)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		} else if err == io.EOF {
+			return "", fmt.Errorf("%v: incomplete final line", path)
+		} else if err != nil {
+			return "", err
+		}
+		last = line
+	}
+	if last == nil {
+		return "", nil
+	}
+	return chainHash(bytes.TrimSuffix(last, []byte{'\n'}), key), nil
+}
+
+func chainHash(line, key []byte) string {
+	if key == nil {
+		return hash(line)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(line)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func hash(bites []byte) string {
+	sum := sha256.Sum256(bites)
+	return hex.EncodeToString(sum[:])
+}
+
+// SetFailureHandler sets fun to be called with an error when a write
+// to the log fails, and with nil once the log is writable again and
+// every Record held meanwhile has been written.
+func (l *Log) SetFailureHandler(fun func(error)) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.onFailure = fun
+}
+
+// Close makes a last attempt to write any Records still held, and
+// returns an error if they cannot be written.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	l.flush()
+	err := l.file.Sync()
+	if err == nil && len(l.pending) != 0 {
+		err = fmt.Errorf("%v records not written: %v", len(l.pending), l.err)
+	}
+	if err1 := l.file.Close(); err == nil {
+		err = err1
+	}
+	l.file = nil
+	return err
+}
+
+func (l *Log) append(record *Record) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.file == nil {
+		return
+	}
+	l.pending = append(l.pending, record)
+	// Whilst failed, the retry preserves the order of Records.
+	if l.err == nil {
+		l.flush()
+	}
+}
+
+// flush writes the Records held, in order. If a write fails, the log
+// fails, and flush is retried after writeRetryDelay until every Record
+// has been written.
+func (l *Log) flush() {
+	for len(l.pending) != 0 {
+		if err := l.write(l.pending[0]); err != nil {
+			if l.err == nil {
+				log.Printf("Error writing to audit log %v (refusing new writes until it is writable): %v", l.path, err)
+				l.err = fmt.Errorf("Unable to write to audit log %v: %v", l.path, err)
+				if l.onFailure != nil {
+					l.onFailure(l.err)
+				}
+			}
+			time.AfterFunc(writeRetryDelay, l.retry)
+			return
+		}
+		l.pending[0] = nil
+		l.pending = l.pending[1:]
+	}
+	if l.err != nil {
+		log.Printf("Audit log %v: writable again.", l.path)
+		l.err = nil
+		if l.onFailure != nil {
+			l.onFailure(nil)
+		}
+	}
+}
+
+func (l *Log) retry() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.file != nil && l.err != nil {
+		l.flush()
+	}
+}
+
+func (l *Log) write(record *Record) error {
+	if l.err != nil {
+		// The last write may have left a partial line, which would
+		// break every later Record.
+		err := l.file.Truncate(l.size)
+		if err == nil {
+			_, err = l.file.Seek(l.size, io.SeekStart)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	record.Prev = l.prev
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Error writing to audit log %v: %v", l.path, err)
+		return nil
+	}
+	if l.size > 0 && l.size+int64(len(line))+1 > l.maxSize {
+		// The current file is still open and intact if rotation
+		// fails, so keep appending to it rather than lose records.
+		if err = l.rotate(); err != nil {
+			log.Printf("Error rotating audit log %v (continuing with current file): %v", l.path, err)
+		}
+	}
+	if _, err = l.file.Write(append(line, '\n')); err == nil {
+		err = l.file.Sync()
+	}
+	if err != nil {
+		return err
+	}
+	l.size += int64(len(line)) + 1
+	l.prev = chainHash(line, l.key)
+	return nil
+}
+
+// rotate switches to a new file only once it is open and the anchor
+// written. Until then, l.file is still the current file, and on error
+// it is renamed back to l.path.
+func (l *Log) rotate() error {
+	rotated := fmt.Sprintf("%s.%s", l.path, time.Now().UTC().Format(rotatedSuffixFormat))
+	if err := os.Rename(l.path, rotated); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+	if err == nil {
+		if err = writeAnchor(l.path, l.prev); err != nil {
+			file.Close()
+			os.Remove(l.path)
+		}
+	}
+	if err != nil {
+		if err1 := os.Rename(rotated, l.path); err1 != nil {
+			log.Printf("Error restoring audit log %v from %v: %v", l.path, rotated, err1)
+		}
+		return err
+	}
+	if err = l.file.Close(); err != nil {
+		log.Printf("Error closing rotated audit log %v: %v", rotated, err)
+	}
+	l.file = file
+	l.size = 0
+	return nil
+}
+
+func writeAnchor(path, prev string) error {
+	tmp := path + anchorSuffix + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintln(file, prev); err == nil {
+		err = file.Sync()
+	}
+	if err1 := file.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(tmp, path+anchorSuffix)
+	}
+	return err
+}
+
+// AuthenticationFailed records that a connection from remote was
+// rejected during its handshake. The fingerprint recorded is of the
+// first of peerCerts, if any were presented.
+func (l *Log) AuthenticationFailed(remote string, peerCerts []*x509.Certificate, err error) {
+	if l == nil {
+		return
+	}
+	record := &Record{
+		Time:   time.Now().UTC(),
+		Event:  EventAuthenticationFailed,
+		Remote: remote,
+		Error:  err.Error(),
+	}
+	if len(peerCerts) > 0 {
+		fingerprint := sha256.Sum256(peerCerts[0].Raw)
+		record.Fingerprint = hex.EncodeToString(fingerprint[:])
+	}
+	l.append(record)
+}
+
+// Client records the txns of a single client connection. A nil
+// Client records nothing.
+type Client struct {
+	log         *Log
+	fingerprint string
+	connection  uint32
+}
+
+func (l *Log) Client(fingerprint [sha256.Size]byte, connectionNumber uint32) *Client {
+	if l == nil {
+		return nil
+	}
+	return &Client{
+		log:         l,
+		fingerprint: hex.EncodeToString(fingerprint[:]),
+		connection:  connectionNumber,
+	}
+}
+
+// Committed records that ctxn committed as txnId.
+func (c *Client) Committed(txnId *common.TxnId, ctxn *cmsgs.ClientTxn) {
+	if c == nil {
+		return
+	}
+	record := &Record{
+		Time:        time.Now().UTC(),
+		Event:       EventCommitted,
+		Fingerprint: c.fingerprint,
+		Connection:  c.connection,
+		TxnId:       hex.EncodeToString(txnId[:]),
+	}
+	actions := ctxn.Actions()
+	for idx, l := 0, actions.Len(); idx < l; idx++ {
+		action := actions.At(idx)
+		vUUId := hex.EncodeToString(action.VarId())
+		switch action.Which() {
+		case cmsgs.CLIENTACTION_READ:
+			record.Read = append(record.Read, vUUId)
+		case cmsgs.CLIENTACTION_WRITE:
+			record.Written = append(record.Written, c.value(vUUId, action.Write().Value()))
+		case cmsgs.CLIENTACTION_READWRITE:
+			record.Read = append(record.Read, vUUId)
+			record.Written = append(record.Written, c.value(vUUId, action.Readwrite().Value()))
+		case cmsgs.CLIENTACTION_CREATE:
+			record.Created = append(record.Created, c.value(vUUId, action.Create().Value()))
+		}
+	}
+	c.log.append(record)
+}
+
+func (c *Client) value(vUUId string, value []byte) *Value {
+	v := &Value{VarUUId: vUUId}
+	if c.log.valueHashes {
+		v.ValueSha256 = hash(value)
+	}
+	return v
+}
@@ -0,0 +1,235 @@
+package audit
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	// Small enough that every other record causes a rotation.
+	l, err := OpenLog(path, 300, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx := 0; idx < 5; idx++ {
+		l.AuthenticationFailed("127.0.0.1:1234", nil, errors.New("bad certificate"))
+	}
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopening continues the chain.
+	if l, err = OpenLog(path, 300, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	l.AuthenticationFailed("127.0.0.1:1234", nil, errors.New("bad certificate"))
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := filepath.Glob(path + ".2*")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(rotated) == 0 {
+		t.Fatal("Expected log to have been rotated")
+	}
+	sort.Strings(rotated)
+	prev, last := "", ""
+	all := ""
+	for _, p := range append(rotated, path) {
+		contents, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all += string(contents)
+		prev = last
+		if last, err = Verify(strings.NewReader(string(contents)), prev, nil); err != nil {
+			t.Fatalf("%v: %v", p, err)
+		}
+	}
+	if _, err = Verify(strings.NewReader(all), "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tampering with a record breaks the chain.
+	tampered := strings.Replace(all, "bad certificate", "bad certificatf", 1)
+	if _, err = Verify(strings.NewReader(tampered), "", nil); err == nil {
+		t.Fatal("Expected tampered log to fail verification")
+	}
+
+	// A torn final line is removed on open, but fails verification.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = file.WriteString(`{"Time":`); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if file, err = os.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Verify(file, prev, nil)
+	file.Close()
+	if err == nil {
+		t.Fatal("Expected torn log to fail verification")
+	}
+	if l, err = OpenLog(path, 300, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+}
+
+func TestLogAnchor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	key := []byte(strings.Repeat("k", 32))
+
+	if _, err = OpenLog(path, 600, false, key[:16]); err == nil {
+		t.Fatal("Expected short key to be refused")
+	}
+	// Small enough that every third record causes a rotation.
+	l, err := OpenLog(path, 600, false, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx := 0; idx < 6; idx++ {
+		l.AuthenticationFailed("127.0.0.1:1234", nil, errors.New("bad certificate"))
+	}
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotated files may be moved away: the anchor takes their place.
+	rotated, err := filepath.Glob(path + ".2*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(rotated)
+	prev := ""
+	for _, p := range rotated {
+		file, err := os.Open(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prev, err = Verify(file, prev, key)
+		file.Close()
+		if err != nil {
+			t.Fatalf("%v: %v", p, err)
+		}
+		if err = os.Remove(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = Verify(strings.NewReader(string(contents)), prev, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Verify(strings.NewReader(string(contents)), prev, nil); err == nil {
+		t.Fatal("Expected log to fail verification without its key")
+	}
+	if l, err = OpenLog(path, 600, false, key); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	// Removing the first lines of the current file breaks the chain
+	// at the anchor.
+	lines := strings.SplitAfter(string(contents), "\n")
+	if err = ioutil.WriteFile(path, []byte(strings.Join(lines[1:], "")), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = OpenLog(path, 600, false, key); err == nil {
+		t.Fatal("Expected log with its first line removed to be refused")
+	}
+}
+
+// Once a write fails, the failure is reported, and the Records which
+// could not be written are held until the log is writable again.
+func TestLogFailsClosed(t *testing.T) {
+	defer func(delay time.Duration) { writeRetryDelay = delay }(writeRetryDelay)
+	writeRetryDelay = time.Millisecond
+
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	l, err := OpenLog(path, 1<<20, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failures := make(chan error, 4)
+	l.SetFailureHandler(func(err error) { failures <- err })
+	l.AuthenticationFailed("127.0.0.1:1234", nil, errors.New("first"))
+
+	// Writes to a read-only file fail.
+	swapFile := func(flag int) {
+		file, err := os.OpenFile(path, flag, 0640)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.lock.Lock()
+		l.file.Close()
+		l.file = file
+		l.lock.Unlock()
+	}
+	swapFile(os.O_RDONLY)
+	l.AuthenticationFailed("127.0.0.1:1234", nil, errors.New("second"))
+	if err = <-failures; err == nil {
+		t.Fatal("Expected the failed write to be reported")
+	}
+	l.AuthenticationFailed("127.0.0.1:1234", nil, errors.New("third"))
+	select {
+	case err = <-failures:
+		t.Fatalf("Expected a failure to be reported only once; got %v", err)
+	case <-time.After(10 * writeRetryDelay):
+	}
+
+	swapFile(os.O_WRONLY)
+	if err = <-failures; err != nil {
+		t.Fatalf("Expected recovery to be reported; got %v", err)
+	}
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Verify(strings.NewReader(string(contents)), "", nil); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(strings.TrimSuffix(string(contents), "\n"), "\n")
+	for idx, expected := range []string{"first", "second", "third"} {
+		if idx >= len(lines) || !strings.Contains(lines[idx], expected) {
+			t.Fatalf("Expected record %v to be %q; got %v", idx, expected, lines)
+		}
+	}
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 records; got %v", len(lines))
+	}
+}
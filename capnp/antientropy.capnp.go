@@ -0,0 +1,909 @@
+package capnp
+
+// AUTO GENERATED - DO NOT EDIT
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	C "github.com/glycerine/go-capnproto"
+	"io"
+)
+
+type AntiEntropyRanges C.Struct
+
+func NewAntiEntropyRanges(s *C.Segment) AntiEntropyRanges {
+	return AntiEntropyRanges(s.NewStruct(8, 1))
+}
+func NewRootAntiEntropyRanges(s *C.Segment) AntiEntropyRanges {
+	return AntiEntropyRanges(s.NewRootStruct(8, 1))
+}
+func AutoNewAntiEntropyRanges(s *C.Segment) AntiEntropyRanges {
+	return AntiEntropyRanges(s.NewStructAR(8, 1))
+}
+func ReadRootAntiEntropyRanges(s *C.Segment) AntiEntropyRanges {
+	return AntiEntropyRanges(s.Root(0).ToStruct())
+}
+func (s AntiEntropyRanges) Version() uint32     { return C.Struct(s).Get32(0) }
+func (s AntiEntropyRanges) SetVersion(v uint32) { C.Struct(s).Set32(0, v) }
+func (s AntiEntropyRanges) Ranges() AntiEntropyRange_List {
+	return AntiEntropyRange_List(C.Struct(s).GetObject(0))
+}
+func (s AntiEntropyRanges) SetRanges(v AntiEntropyRange_List) { C.Struct(s).SetObject(0, C.Object(v)) }
+func (s AntiEntropyRanges) WriteJSON(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('{')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"version\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Version()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"ranges\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Ranges()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				err = s.WriteJSON(b)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte('}')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s AntiEntropyRanges) MarshalJSON() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteJSON(&b)
+	return b.Bytes(), err
+}
+func (s AntiEntropyRanges) WriteCapLit(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('(')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("version = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Version()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("ranges = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Ranges()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				err = s.WriteCapLit(b)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(')')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s AntiEntropyRanges) MarshalCapLit() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteCapLit(&b)
+	return b.Bytes(), err
+}
+
+type AntiEntropyRanges_List C.PointerList
+
+func NewAntiEntropyRangesList(s *C.Segment, sz int) AntiEntropyRanges_List {
+	return AntiEntropyRanges_List(s.NewCompositeList(8, 1, sz))
+}
+func (s AntiEntropyRanges_List) Len() int { return C.PointerList(s).Len() }
+func (s AntiEntropyRanges_List) At(i int) AntiEntropyRanges {
+	return AntiEntropyRanges(C.PointerList(s).At(i).ToStruct())
+}
+func (s AntiEntropyRanges_List) ToArray() []AntiEntropyRanges {
+	n := s.Len()
+	a := make([]AntiEntropyRanges, n)
+	for i := 0; i < n; i++ {
+		a[i] = s.At(i)
+	}
+	return a
+}
+func (s AntiEntropyRanges_List) Set(i int, item AntiEntropyRanges) {
+	C.PointerList(s).Set(i, C.Object(item))
+}
+
+type AntiEntropyRange C.Struct
+
+func NewAntiEntropyRange(s *C.Segment) AntiEntropyRange { return AntiEntropyRange(s.NewStruct(8, 3)) }
+func NewRootAntiEntropyRange(s *C.Segment) AntiEntropyRange {
+	return AntiEntropyRange(s.NewRootStruct(8, 3))
+}
+func AutoNewAntiEntropyRange(s *C.Segment) AntiEntropyRange {
+	return AntiEntropyRange(s.NewStructAR(8, 3))
+}
+func ReadRootAntiEntropyRange(s *C.Segment) AntiEntropyRange {
+	return AntiEntropyRange(s.Root(0).ToStruct())
+}
+func (s AntiEntropyRange) Start() []byte     { return C.Struct(s).GetObject(0).ToData() }
+func (s AntiEntropyRange) SetStart(v []byte) { C.Struct(s).SetObject(0, s.Segment.NewData(v)) }
+func (s AntiEntropyRange) End() []byte       { return C.Struct(s).GetObject(1).ToData() }
+func (s AntiEntropyRange) SetEnd(v []byte)   { C.Struct(s).SetObject(1, s.Segment.NewData(v)) }
+func (s AntiEntropyRange) Hash() []byte      { return C.Struct(s).GetObject(2).ToData() }
+func (s AntiEntropyRange) SetHash(v []byte)  { C.Struct(s).SetObject(2, s.Segment.NewData(v)) }
+func (s AntiEntropyRange) Count() uint32     { return C.Struct(s).Get32(0) }
+func (s AntiEntropyRange) SetCount(v uint32) { C.Struct(s).Set32(0, v) }
+func (s AntiEntropyRange) WriteJSON(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('{')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"start\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Start()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"end\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.End()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"hash\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Hash()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"count\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Count()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte('}')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s AntiEntropyRange) MarshalJSON() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteJSON(&b)
+	return b.Bytes(), err
+}
+func (s AntiEntropyRange) WriteCapLit(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('(')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("start = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Start()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("end = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.End()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("hash = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Hash()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("count = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Count()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(')')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s AntiEntropyRange) MarshalCapLit() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteCapLit(&b)
+	return b.Bytes(), err
+}
+
+type AntiEntropyRange_List C.PointerList
+
+func NewAntiEntropyRangeList(s *C.Segment, sz int) AntiEntropyRange_List {
+	return AntiEntropyRange_List(s.NewCompositeList(8, 3, sz))
+}
+func (s AntiEntropyRange_List) Len() int { return C.PointerList(s).Len() }
+func (s AntiEntropyRange_List) At(i int) AntiEntropyRange {
+	return AntiEntropyRange(C.PointerList(s).At(i).ToStruct())
+}
+func (s AntiEntropyRange_List) ToArray() []AntiEntropyRange {
+	n := s.Len()
+	a := make([]AntiEntropyRange, n)
+	for i := 0; i < n; i++ {
+		a[i] = s.At(i)
+	}
+	return a
+}
+func (s AntiEntropyRange_List) Set(i int, item AntiEntropyRange) {
+	C.PointerList(s).Set(i, C.Object(item))
+}
+
+type AntiEntropyEntries C.Struct
+
+func NewAntiEntropyEntries(s *C.Segment) AntiEntropyEntries {
+	return AntiEntropyEntries(s.NewStruct(8, 3))
+}
+func NewRootAntiEntropyEntries(s *C.Segment) AntiEntropyEntries {
+	return AntiEntropyEntries(s.NewRootStruct(8, 3))
+}
+func AutoNewAntiEntropyEntries(s *C.Segment) AntiEntropyEntries {
+	return AntiEntropyEntries(s.NewStructAR(8, 3))
+}
+func ReadRootAntiEntropyEntries(s *C.Segment) AntiEntropyEntries {
+	return AntiEntropyEntries(s.Root(0).ToStruct())
+}
+func (s AntiEntropyEntries) Version() uint32     { return C.Struct(s).Get32(0) }
+func (s AntiEntropyEntries) SetVersion(v uint32) { C.Struct(s).Set32(0, v) }
+func (s AntiEntropyEntries) Start() []byte       { return C.Struct(s).GetObject(0).ToData() }
+func (s AntiEntropyEntries) SetStart(v []byte)   { C.Struct(s).SetObject(0, s.Segment.NewData(v)) }
+func (s AntiEntropyEntries) End() []byte         { return C.Struct(s).GetObject(1).ToData() }
+func (s AntiEntropyEntries) SetEnd(v []byte)     { C.Struct(s).SetObject(1, s.Segment.NewData(v)) }
+func (s AntiEntropyEntries) Entries() AntiEntropyEntry_List {
+	return AntiEntropyEntry_List(C.Struct(s).GetObject(2))
+}
+func (s AntiEntropyEntries) SetEntries(v AntiEntropyEntry_List) {
+	C.Struct(s).SetObject(2, C.Object(v))
+}
+func (s AntiEntropyEntries) WantReply() bool     { return C.Struct(s).Get1(32) }
+func (s AntiEntropyEntries) SetWantReply(v bool) { C.Struct(s).Set1(32, v) }
+func (s AntiEntropyEntries) WriteJSON(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('{')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"version\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Version()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"start\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Start()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"end\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.End()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"entries\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Entries()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				err = s.WriteJSON(b)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"wantReply\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.WantReply()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte('}')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s AntiEntropyEntries) MarshalJSON() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteJSON(&b)
+	return b.Bytes(), err
+}
+func (s AntiEntropyEntries) WriteCapLit(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('(')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("version = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Version()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("start = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Start()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("end = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.End()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("entries = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Entries()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				err = s.WriteCapLit(b)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("wantReply = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.WantReply()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(')')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s AntiEntropyEntries) MarshalCapLit() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteCapLit(&b)
+	return b.Bytes(), err
+}
+
+type AntiEntropyEntries_List C.PointerList
+
+func NewAntiEntropyEntriesList(s *C.Segment, sz int) AntiEntropyEntries_List {
+	return AntiEntropyEntries_List(s.NewCompositeList(8, 3, sz))
+}
+func (s AntiEntropyEntries_List) Len() int { return C.PointerList(s).Len() }
+func (s AntiEntropyEntries_List) At(i int) AntiEntropyEntries {
+	return AntiEntropyEntries(C.PointerList(s).At(i).ToStruct())
+}
+func (s AntiEntropyEntries_List) ToArray() []AntiEntropyEntries {
+	n := s.Len()
+	a := make([]AntiEntropyEntries, n)
+	for i := 0; i < n; i++ {
+		a[i] = s.At(i)
+	}
+	return a
+}
+func (s AntiEntropyEntries_List) Set(i int, item AntiEntropyEntries) {
+	C.PointerList(s).Set(i, C.Object(item))
+}
+
+type AntiEntropyEntry C.Struct
+
+func NewAntiEntropyEntry(s *C.Segment) AntiEntropyEntry { return AntiEntropyEntry(s.NewStruct(8, 2)) }
+func NewRootAntiEntropyEntry(s *C.Segment) AntiEntropyEntry {
+	return AntiEntropyEntry(s.NewRootStruct(8, 2))
+}
+func AutoNewAntiEntropyEntry(s *C.Segment) AntiEntropyEntry {
+	return AntiEntropyEntry(s.NewStructAR(8, 2))
+}
+func ReadRootAntiEntropyEntry(s *C.Segment) AntiEntropyEntry {
+	return AntiEntropyEntry(s.Root(0).ToStruct())
+}
+func (s AntiEntropyEntry) Id() []byte             { return C.Struct(s).GetObject(0).ToData() }
+func (s AntiEntropyEntry) SetId(v []byte)         { C.Struct(s).SetObject(0, s.Segment.NewData(v)) }
+func (s AntiEntropyEntry) WriteTxnId() []byte     { return C.Struct(s).GetObject(1).ToData() }
+func (s AntiEntropyEntry) SetWriteTxnId(v []byte) { C.Struct(s).SetObject(1, s.Segment.NewData(v)) }
+func (s AntiEntropyEntry) ClockElem() uint64      { return C.Struct(s).Get64(0) }
+func (s AntiEntropyEntry) SetClockElem(v uint64)  { C.Struct(s).Set64(0, v) }
+func (s AntiEntropyEntry) WriteJSON(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('{')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"id\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Id()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"writeTxnId\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.WriteTxnId()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"clockElem\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.ClockElem()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte('}')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s AntiEntropyEntry) MarshalJSON() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteJSON(&b)
+	return b.Bytes(), err
+}
+func (s AntiEntropyEntry) WriteCapLit(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('(')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("id = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Id()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("writeTxnId = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.WriteTxnId()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("clockElem = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.ClockElem()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(')')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s AntiEntropyEntry) MarshalCapLit() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteCapLit(&b)
+	return b.Bytes(), err
+}
+
+type AntiEntropyEntry_List C.PointerList
+
+func NewAntiEntropyEntryList(s *C.Segment, sz int) AntiEntropyEntry_List {
+	return AntiEntropyEntry_List(s.NewCompositeList(8, 2, sz))
+}
+func (s AntiEntropyEntry_List) Len() int { return C.PointerList(s).Len() }
+func (s AntiEntropyEntry_List) At(i int) AntiEntropyEntry {
+	return AntiEntropyEntry(C.PointerList(s).At(i).ToStruct())
+}
+func (s AntiEntropyEntry_List) ToArray() []AntiEntropyEntry {
+	n := s.Len()
+	a := make([]AntiEntropyEntry, n)
+	for i := 0; i < n; i++ {
+		a[i] = s.At(i)
+	}
+	return a
+}
+func (s AntiEntropyEntry_List) Set(i int, item AntiEntropyEntry) {
+	C.PointerList(s).Set(i, C.Object(item))
+}
@@ -20,9 +20,9 @@ const (
 	CONFIGURATION_STABLE          Configuration_Which = 1
 )
 
-func NewConfiguration(s *C.Segment) Configuration      { return Configuration(s.NewStruct(24, 14)) }
-func NewRootConfiguration(s *C.Segment) Configuration  { return Configuration(s.NewRootStruct(24, 14)) }
-func AutoNewConfiguration(s *C.Segment) Configuration  { return Configuration(s.NewStructAR(24, 14)) }
+func NewConfiguration(s *C.Segment) Configuration      { return Configuration(s.NewStruct(24, 17)) }
+func NewRootConfiguration(s *C.Segment) Configuration  { return Configuration(s.NewRootStruct(24, 17)) }
+func AutoNewConfiguration(s *C.Segment) Configuration  { return Configuration(s.NewStructAR(24, 17)) }
 func ReadRootConfiguration(s *C.Segment) Configuration { return Configuration(s.Root(0).ToStruct()) }
 func (s Configuration) Which() Configuration_Which     { return Configuration_Which(C.Struct(s).Get16(16)) }
 func (s Configuration) ClusterId() string              { return C.Struct(s).GetObject(0).ToText() }
@@ -48,6 +48,18 @@ func (s Configuration) Fingerprints() Fingerprint_List {
 	return Fingerprint_List(C.Struct(s).GetObject(4))
 }
 func (s Configuration) SetFingerprints(v Fingerprint_List) { C.Struct(s).SetObject(4, C.Object(v)) }
+func (s Configuration) ClusterCertificates() C.DataList {
+	return C.DataList(C.Struct(s).GetObject(14))
+}
+func (s Configuration) SetClusterCertificates(v C.DataList) { C.Struct(s).SetObject(14, C.Object(v)) }
+func (s Configuration) ClientCAs() ClientCA_List {
+	return ClientCA_List(C.Struct(s).GetObject(15))
+}
+func (s Configuration) SetClientCAs(v ClientCA_List) { C.Struct(s).SetObject(15, C.Object(v)) }
+func (s Configuration) ClientLimits() ClientLimits_List {
+	return ClientLimits_List(C.Struct(s).GetObject(16))
+}
+func (s Configuration) SetClientLimits(v ClientLimits_List) { C.Struct(s).SetObject(16, C.Object(v)) }
 func (s Configuration) TransitioningTo() ConfigurationTransitioningTo {
 	return ConfigurationTransitioningTo(s)
 }
@@ -370,6 +382,109 @@ func (s Configuration) WriteJSON(w io.Writer) error {
 			return err
 		}
 	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"clusterCertificates\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.ClusterCertificates()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				buf, err = json.Marshal(s)
+				if err != nil {
+					return err
+				}
+				_, err = b.Write(buf)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"clientCAs\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.ClientCAs()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				err = s.WriteJSON(b)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"clientLimits\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.ClientLimits()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				err = s.WriteJSON(b)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
 	if s.Which() == CONFIGURATION_TRANSITIONINGTO {
 		_, err = b.WriteString("\"transitioningTo\":")
 		if err != nil {
@@ -995,6 +1110,109 @@ func (s Configuration) WriteCapLit(w io.Writer) error {
 			return err
 		}
 	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("clusterCertificates = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.ClusterCertificates()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				buf, err = json.Marshal(s)
+				if err != nil {
+					return err
+				}
+				_, err = b.Write(buf)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("clientCAs = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.ClientCAs()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				err = s.WriteCapLit(b)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("clientLimits = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.ClientLimits()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				err = s.WriteCapLit(b)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
 	if s.Which() == CONFIGURATION_TRANSITIONINGTO {
 		_, err = b.WriteString("transitioningTo = ")
 		if err != nil {
@@ -1361,7 +1579,7 @@ func (s Configuration) MarshalCapLit() ([]byte, error) {
 type Configuration_List C.PointerList
 
 func NewConfigurationList(s *C.Segment, sz int) Configuration_List {
-	return Configuration_List(s.NewCompositeList(24, 14, sz))
+	return Configuration_List(s.NewCompositeList(24, 17, sz))
 }
 func (s Configuration_List) Len() int { return C.PointerList(s).Len() }
 func (s Configuration_List) At(i int) Configuration {
@@ -1674,6 +1892,725 @@ func (s Root_List) ToArray() []Root {
 }
 func (s Root_List) Set(i int, item Root) { C.PointerList(s).Set(i, C.Object(item)) }
 
+type ClientCA C.Struct
+
+func NewClientCA(s *C.Segment) ClientCA         { return ClientCA(s.NewStruct(0, 2)) }
+func NewRootClientCA(s *C.Segment) ClientCA     { return ClientCA(s.NewRootStruct(0, 2)) }
+func AutoNewClientCA(s *C.Segment) ClientCA     { return ClientCA(s.NewStructAR(0, 2)) }
+func ReadRootClientCA(s *C.Segment) ClientCA    { return ClientCA(s.Root(0).ToStruct()) }
+func (s ClientCA) Certificate() []byte          { return C.Struct(s).GetObject(0).ToData() }
+func (s ClientCA) SetCertificate(v []byte)      { C.Struct(s).SetObject(0, s.Segment.NewData(v)) }
+func (s ClientCA) Rules() ClientCARule_List     { return ClientCARule_List(C.Struct(s).GetObject(1)) }
+func (s ClientCA) SetRules(v ClientCARule_List) { C.Struct(s).SetObject(1, C.Object(v)) }
+func (s ClientCA) WriteJSON(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('{')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"certificate\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Certificate()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"rules\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Rules()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				err = s.WriteJSON(b)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte('}')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s ClientCA) MarshalJSON() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteJSON(&b)
+	return b.Bytes(), err
+}
+func (s ClientCA) WriteCapLit(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('(')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("certificate = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Certificate()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("rules = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Rules()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				err = s.WriteCapLit(b)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(')')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s ClientCA) MarshalCapLit() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteCapLit(&b)
+	return b.Bytes(), err
+}
+
+type ClientCA_List C.PointerList
+
+func NewClientCAList(s *C.Segment, sz int) ClientCA_List {
+	return ClientCA_List(s.NewCompositeList(0, 2, sz))
+}
+func (s ClientCA_List) Len() int          { return C.PointerList(s).Len() }
+func (s ClientCA_List) At(i int) ClientCA { return ClientCA(C.PointerList(s).At(i).ToStruct()) }
+func (s ClientCA_List) ToArray() []ClientCA {
+	n := s.Len()
+	a := make([]ClientCA, n)
+	for i := 0; i < n; i++ {
+		a[i] = s.At(i)
+	}
+	return a
+}
+func (s ClientCA_List) Set(i int, item ClientCA) { C.PointerList(s).Set(i, C.Object(item)) }
+
+type ClientCARule C.Struct
+
+func NewClientCARule(s *C.Segment) ClientCARule      { return ClientCARule(s.NewStruct(0, 4)) }
+func NewRootClientCARule(s *C.Segment) ClientCARule  { return ClientCARule(s.NewRootStruct(0, 4)) }
+func AutoNewClientCARule(s *C.Segment) ClientCARule  { return ClientCARule(s.NewStructAR(0, 4)) }
+func ReadRootClientCARule(s *C.Segment) ClientCARule { return ClientCARule(s.Root(0).ToStruct()) }
+func (s ClientCARule) CommonName() string            { return C.Struct(s).GetObject(0).ToText() }
+func (s ClientCARule) CommonNameBytes() []byte       { return C.Struct(s).GetObject(0).ToDataTrimLastByte() }
+func (s ClientCARule) SetCommonName(v string)        { C.Struct(s).SetObject(0, s.Segment.NewText(v)) }
+func (s ClientCARule) OrganizationalUnit() string    { return C.Struct(s).GetObject(1).ToText() }
+func (s ClientCARule) OrganizationalUnitBytes() []byte {
+	return C.Struct(s).GetObject(1).ToDataTrimLastByte()
+}
+func (s ClientCARule) SetOrganizationalUnit(v string) { C.Struct(s).SetObject(1, s.Segment.NewText(v)) }
+func (s ClientCARule) Uri() string                    { return C.Struct(s).GetObject(2).ToText() }
+func (s ClientCARule) UriBytes() []byte               { return C.Struct(s).GetObject(2).ToDataTrimLastByte() }
+func (s ClientCARule) SetUri(v string)                { C.Struct(s).SetObject(2, s.Segment.NewText(v)) }
+func (s ClientCARule) Roots() Root_List               { return Root_List(C.Struct(s).GetObject(3)) }
+func (s ClientCARule) SetRoots(v Root_List)           { C.Struct(s).SetObject(3, C.Object(v)) }
+func (s ClientCARule) WriteJSON(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('{')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"commonName\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.CommonName()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"organizationalUnit\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.OrganizationalUnit()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"uri\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Uri()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"roots\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Roots()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				err = s.WriteJSON(b)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte('}')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s ClientCARule) MarshalJSON() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteJSON(&b)
+	return b.Bytes(), err
+}
+func (s ClientCARule) WriteCapLit(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('(')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("commonName = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.CommonName()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("organizationalUnit = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.OrganizationalUnit()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("uri = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Uri()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("roots = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Roots()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				err = s.WriteCapLit(b)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(')')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s ClientCARule) MarshalCapLit() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteCapLit(&b)
+	return b.Bytes(), err
+}
+
+type ClientCARule_List C.PointerList
+
+func NewClientCARuleList(s *C.Segment, sz int) ClientCARule_List {
+	return ClientCARule_List(s.NewCompositeList(0, 4, sz))
+}
+func (s ClientCARule_List) Len() int { return C.PointerList(s).Len() }
+func (s ClientCARule_List) At(i int) ClientCARule {
+	return ClientCARule(C.PointerList(s).At(i).ToStruct())
+}
+func (s ClientCARule_List) ToArray() []ClientCARule {
+	n := s.Len()
+	a := make([]ClientCARule, n)
+	for i := 0; i < n; i++ {
+		a[i] = s.At(i)
+	}
+	return a
+}
+func (s ClientCARule_List) Set(i int, item ClientCARule) { C.PointerList(s).Set(i, C.Object(item)) }
+
+type ClientLimits C.Struct
+
+func NewClientLimits(s *C.Segment) ClientLimits          { return ClientLimits(s.NewStruct(24, 1)) }
+func NewRootClientLimits(s *C.Segment) ClientLimits      { return ClientLimits(s.NewRootStruct(24, 1)) }
+func AutoNewClientLimits(s *C.Segment) ClientLimits      { return ClientLimits(s.NewStructAR(24, 1)) }
+func ReadRootClientLimits(s *C.Segment) ClientLimits     { return ClientLimits(s.Root(0).ToStruct()) }
+func (s ClientLimits) Sha256() []byte                    { return C.Struct(s).GetObject(0).ToData() }
+func (s ClientLimits) SetSha256(v []byte)                { C.Struct(s).SetObject(0, s.Segment.NewData(v)) }
+func (s ClientLimits) TxnsPerSecond() uint32             { return C.Struct(s).Get32(0) }
+func (s ClientLimits) SetTxnsPerSecond(v uint32)         { C.Struct(s).Set32(0, v) }
+func (s ClientLimits) BytesWrittenPerSecond() uint64     { return C.Struct(s).Get64(8) }
+func (s ClientLimits) SetBytesWrittenPerSecond(v uint64) { C.Struct(s).Set64(8, v) }
+func (s ClientLimits) CreatesPerMinute() uint32          { return C.Struct(s).Get32(4) }
+func (s ClientLimits) SetCreatesPerMinute(v uint32)      { C.Struct(s).Set32(4, v) }
+func (s ClientLimits) MaxValueSize() uint32              { return C.Struct(s).Get32(16) }
+func (s ClientLimits) SetMaxValueSize(v uint32)          { C.Struct(s).Set32(16, v) }
+func (s ClientLimits) MaxActionsPerTxn() uint32          { return C.Struct(s).Get32(20) }
+func (s ClientLimits) SetMaxActionsPerTxn(v uint32)      { C.Struct(s).Set32(20, v) }
+func (s ClientLimits) WriteJSON(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('{')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"sha256\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Sha256()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"txnsPerSecond\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.TxnsPerSecond()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"bytesWrittenPerSecond\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.BytesWrittenPerSecond()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"createsPerMinute\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.CreatesPerMinute()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"maxValueSize\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.MaxValueSize()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"maxActionsPerTxn\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.MaxActionsPerTxn()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte('}')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s ClientLimits) MarshalJSON() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteJSON(&b)
+	return b.Bytes(), err
+}
+func (s ClientLimits) WriteCapLit(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('(')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("sha256 = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Sha256()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("txnsPerSecond = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.TxnsPerSecond()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("bytesWrittenPerSecond = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.BytesWrittenPerSecond()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("createsPerMinute = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.CreatesPerMinute()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("maxValueSize = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.MaxValueSize()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("maxActionsPerTxn = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.MaxActionsPerTxn()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(')')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s ClientLimits) MarshalCapLit() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteCapLit(&b)
+	return b.Bytes(), err
+}
+
+type ClientLimits_List C.PointerList
+
+func NewClientLimitsList(s *C.Segment, sz int) ClientLimits_List {
+	return ClientLimits_List(s.NewCompositeList(24, 1, sz))
+}
+func (s ClientLimits_List) Len() int { return C.PointerList(s).Len() }
+func (s ClientLimits_List) At(i int) ClientLimits {
+	return ClientLimits(C.PointerList(s).At(i).ToStruct())
+}
+func (s ClientLimits_List) ToArray() []ClientLimits {
+	n := s.Len()
+	a := make([]ClientLimits, n)
+	for i := 0; i < n; i++ {
+		a[i] = s.At(i)
+	}
+	return a
+}
+func (s ClientLimits_List) Set(i int, item ClientLimits) { C.PointerList(s).Set(i, C.Object(item)) }
+
 type ConditionPair C.Struct
 
 func NewConditionPair(s *C.Segment) ConditionPair      { return ConditionPair(s.NewStruct(8, 2)) }
@@ -346,6 +346,18 @@ const (
 	MESSAGE_TOPOLOGYCHANGEREQUEST Message_Which = 13
 	MESSAGE_MIGRATION             Message_Which = 14
 	MESSAGE_MIGRATIONCOMPLETE     Message_Which = 15
+	MESSAGE_SCRUBDIGEST           Message_Which = 16
+	MESSAGE_ANTIENTROPYRANGES     Message_Which = 17
+	MESSAGE_ANTIENTROPYENTRIES    Message_Which = 18
+	MESSAGE_ANTIENTROPYREPAIR     Message_Which = 19
+	MESSAGE_GCSTART               Message_Which = 20
+	MESSAGE_GCROOTS               Message_Which = 21
+	MESSAGE_GCTRACE               Message_Which = 22
+	MESSAGE_GCREFERENCES          Message_Which = 23
+	MESSAGE_GCFLUSH               Message_Which = 24
+	MESSAGE_GCSWEEP               Message_Which = 25
+	MESSAGE_GCCANDIDATES          Message_Which = 26
+	MESSAGE_GCCONDEMNED           Message_Which = 27
 )
 
 func NewMessage(s *C.Segment) Message          { return Message(s.NewStruct(8, 1)) }
@@ -438,6 +450,70 @@ func (s Message) SetMigrationComplete(v MigrationComplete) {
 	C.Struct(s).Set16(0, 15)
 	C.Struct(s).SetObject(0, C.Object(v))
 }
+func (s Message) ScrubDigest() ScrubDigest { return ScrubDigest(C.Struct(s).GetObject(0).ToStruct()) }
+func (s Message) SetScrubDigest(v ScrubDigest) {
+	C.Struct(s).Set16(0, 16)
+	C.Struct(s).SetObject(0, C.Object(v))
+}
+func (s Message) AntiEntropyRanges() AntiEntropyRanges {
+	return AntiEntropyRanges(C.Struct(s).GetObject(0).ToStruct())
+}
+func (s Message) SetAntiEntropyRanges(v AntiEntropyRanges) {
+	C.Struct(s).Set16(0, 17)
+	C.Struct(s).SetObject(0, C.Object(v))
+}
+func (s Message) AntiEntropyEntries() AntiEntropyEntries {
+	return AntiEntropyEntries(C.Struct(s).GetObject(0).ToStruct())
+}
+func (s Message) SetAntiEntropyEntries(v AntiEntropyEntries) {
+	C.Struct(s).Set16(0, 18)
+	C.Struct(s).SetObject(0, C.Object(v))
+}
+func (s Message) AntiEntropyRepair() Migration { return Migration(C.Struct(s).GetObject(0).ToStruct()) }
+func (s Message) SetAntiEntropyRepair(v Migration) {
+	C.Struct(s).Set16(0, 19)
+	C.Struct(s).SetObject(0, C.Object(v))
+}
+func (s Message) GcStart() GcStart { return GcStart(C.Struct(s).GetObject(0).ToStruct()) }
+func (s Message) SetGcStart(v GcStart) {
+	C.Struct(s).Set16(0, 20)
+	C.Struct(s).SetObject(0, C.Object(v))
+}
+func (s Message) GcRoots() GcVars { return GcVars(C.Struct(s).GetObject(0).ToStruct()) }
+func (s Message) SetGcRoots(v GcVars) {
+	C.Struct(s).Set16(0, 21)
+	C.Struct(s).SetObject(0, C.Object(v))
+}
+func (s Message) GcTrace() GcVars { return GcVars(C.Struct(s).GetObject(0).ToStruct()) }
+func (s Message) SetGcTrace(v GcVars) {
+	C.Struct(s).Set16(0, 22)
+	C.Struct(s).SetObject(0, C.Object(v))
+}
+func (s Message) GcReferences() GcVars { return GcVars(C.Struct(s).GetObject(0).ToStruct()) }
+func (s Message) SetGcReferences(v GcVars) {
+	C.Struct(s).Set16(0, 23)
+	C.Struct(s).SetObject(0, C.Object(v))
+}
+func (s Message) GcFlush() GcVars { return GcVars(C.Struct(s).GetObject(0).ToStruct()) }
+func (s Message) SetGcFlush(v GcVars) {
+	C.Struct(s).Set16(0, 24)
+	C.Struct(s).SetObject(0, C.Object(v))
+}
+func (s Message) GcSweep() GcVars { return GcVars(C.Struct(s).GetObject(0).ToStruct()) }
+func (s Message) SetGcSweep(v GcVars) {
+	C.Struct(s).Set16(0, 25)
+	C.Struct(s).SetObject(0, C.Object(v))
+}
+func (s Message) GcCandidates() GcVars { return GcVars(C.Struct(s).GetObject(0).ToStruct()) }
+func (s Message) SetGcCandidates(v GcVars) {
+	C.Struct(s).Set16(0, 26)
+	C.Struct(s).SetObject(0, C.Object(v))
+}
+func (s Message) GcCondemned() GcVars { return GcVars(C.Struct(s).GetObject(0).ToStruct()) }
+func (s Message) SetGcCondemned(v GcVars) {
+	C.Struct(s).Set16(0, 27)
+	C.Struct(s).SetObject(0, C.Object(v))
+}
 func (s Message) WriteJSON(w io.Writer) error {
 	b := bufio.NewWriter(w)
 	var err error
@@ -659,6 +735,162 @@ func (s Message) WriteJSON(w io.Writer) error {
 			}
 		}
 	}
+	if s.Which() == MESSAGE_SCRUBDIGEST {
+		_, err = b.WriteString("\"scrubDigest\":")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.ScrubDigest()
+			err = s.WriteJSON(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_ANTIENTROPYRANGES {
+		_, err = b.WriteString("\"antiEntropyRanges\":")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.AntiEntropyRanges()
+			err = s.WriteJSON(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_ANTIENTROPYENTRIES {
+		_, err = b.WriteString("\"antiEntropyEntries\":")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.AntiEntropyEntries()
+			err = s.WriteJSON(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_ANTIENTROPYREPAIR {
+		_, err = b.WriteString("\"antiEntropyRepair\":")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.AntiEntropyRepair()
+			err = s.WriteJSON(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_GCSTART {
+		_, err = b.WriteString("\"gcStart\":")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.GcStart()
+			err = s.WriteJSON(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_GCROOTS {
+		_, err = b.WriteString("\"gcRoots\":")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.GcRoots()
+			err = s.WriteJSON(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_GCTRACE {
+		_, err = b.WriteString("\"gcTrace\":")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.GcTrace()
+			err = s.WriteJSON(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_GCREFERENCES {
+		_, err = b.WriteString("\"gcReferences\":")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.GcReferences()
+			err = s.WriteJSON(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_GCFLUSH {
+		_, err = b.WriteString("\"gcFlush\":")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.GcFlush()
+			err = s.WriteJSON(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_GCSWEEP {
+		_, err = b.WriteString("\"gcSweep\":")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.GcSweep()
+			err = s.WriteJSON(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_GCCANDIDATES {
+		_, err = b.WriteString("\"gcCandidates\":")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.GcCandidates()
+			err = s.WriteJSON(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_GCCONDEMNED {
+		_, err = b.WriteString("\"gcCondemned\":")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.GcCondemned()
+			err = s.WriteJSON(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
 	err = b.WriteByte('}')
 	if err != nil {
 		return err
@@ -892,6 +1124,162 @@ func (s Message) WriteCapLit(w io.Writer) error {
 			}
 		}
 	}
+	if s.Which() == MESSAGE_SCRUBDIGEST {
+		_, err = b.WriteString("scrubDigest = ")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.ScrubDigest()
+			err = s.WriteCapLit(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_ANTIENTROPYRANGES {
+		_, err = b.WriteString("antiEntropyRanges = ")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.AntiEntropyRanges()
+			err = s.WriteCapLit(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_ANTIENTROPYENTRIES {
+		_, err = b.WriteString("antiEntropyEntries = ")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.AntiEntropyEntries()
+			err = s.WriteCapLit(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_ANTIENTROPYREPAIR {
+		_, err = b.WriteString("antiEntropyRepair = ")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.AntiEntropyRepair()
+			err = s.WriteCapLit(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_GCSTART {
+		_, err = b.WriteString("gcStart = ")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.GcStart()
+			err = s.WriteCapLit(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_GCROOTS {
+		_, err = b.WriteString("gcRoots = ")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.GcRoots()
+			err = s.WriteCapLit(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_GCTRACE {
+		_, err = b.WriteString("gcTrace = ")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.GcTrace()
+			err = s.WriteCapLit(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_GCREFERENCES {
+		_, err = b.WriteString("gcReferences = ")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.GcReferences()
+			err = s.WriteCapLit(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_GCFLUSH {
+		_, err = b.WriteString("gcFlush = ")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.GcFlush()
+			err = s.WriteCapLit(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_GCSWEEP {
+		_, err = b.WriteString("gcSweep = ")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.GcSweep()
+			err = s.WriteCapLit(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_GCCANDIDATES {
+		_, err = b.WriteString("gcCandidates = ")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.GcCandidates()
+			err = s.WriteCapLit(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_GCCONDEMNED {
+		_, err = b.WriteString("gcCondemned = ")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.GcCondemned()
+			err = s.WriteCapLit(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
 	err = b.WriteByte(')')
 	if err != nil {
 		return err
@@ -0,0 +1,443 @@
+package capnp
+
+// AUTO GENERATED - DO NOT EDIT
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	C "github.com/glycerine/go-capnproto"
+	"io"
+)
+
+type GcStart C.Struct
+
+func NewGcStart(s *C.Segment) GcStart      { return GcStart(s.NewStruct(16, 0)) }
+func NewRootGcStart(s *C.Segment) GcStart  { return GcStart(s.NewRootStruct(16, 0)) }
+func AutoNewGcStart(s *C.Segment) GcStart  { return GcStart(s.NewStructAR(16, 0)) }
+func ReadRootGcStart(s *C.Segment) GcStart { return GcStart(s.Root(0).ToStruct()) }
+func (s GcStart) Cycle() uint64            { return C.Struct(s).Get64(0) }
+func (s GcStart) SetCycle(v uint64)        { C.Struct(s).Set64(0, v) }
+func (s GcStart) Version() uint32          { return C.Struct(s).Get32(8) }
+func (s GcStart) SetVersion(v uint32)      { C.Struct(s).Set32(8, v) }
+func (s GcStart) DryRun() bool             { return C.Struct(s).Get1(96) }
+func (s GcStart) SetDryRun(v bool)         { C.Struct(s).Set1(96, v) }
+func (s GcStart) WriteJSON(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('{')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"cycle\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Cycle()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"version\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Version()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"dryRun\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.DryRun()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte('}')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s GcStart) MarshalJSON() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteJSON(&b)
+	return b.Bytes(), err
+}
+func (s GcStart) WriteCapLit(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('(')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("cycle = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Cycle()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("version = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Version()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("dryRun = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.DryRun()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(')')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s GcStart) MarshalCapLit() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteCapLit(&b)
+	return b.Bytes(), err
+}
+
+type GcStart_List C.PointerList
+
+func NewGcStartList(s *C.Segment, sz int) GcStart_List {
+	return GcStart_List(s.NewCompositeList(16, 0, sz))
+}
+func (s GcStart_List) Len() int         { return C.PointerList(s).Len() }
+func (s GcStart_List) At(i int) GcStart { return GcStart(C.PointerList(s).At(i).ToStruct()) }
+func (s GcStart_List) ToArray() []GcStart {
+	n := s.Len()
+	a := make([]GcStart, n)
+	for i := 0; i < n; i++ {
+		a[i] = s.At(i)
+	}
+	return a
+}
+func (s GcStart_List) Set(i int, item GcStart) { C.PointerList(s).Set(i, C.Object(item)) }
+
+type GcVars C.Struct
+
+func NewGcVars(s *C.Segment) GcVars      { return GcVars(s.NewStruct(16, 1)) }
+func NewRootGcVars(s *C.Segment) GcVars  { return GcVars(s.NewRootStruct(16, 1)) }
+func AutoNewGcVars(s *C.Segment) GcVars  { return GcVars(s.NewStructAR(16, 1)) }
+func ReadRootGcVars(s *C.Segment) GcVars { return GcVars(s.Root(0).ToStruct()) }
+func (s GcVars) Cycle() uint64           { return C.Struct(s).Get64(0) }
+func (s GcVars) SetCycle(v uint64)       { C.Struct(s).Set64(0, v) }
+func (s GcVars) Version() uint32         { return C.Struct(s).Get32(8) }
+func (s GcVars) SetVersion(v uint32)     { C.Struct(s).Set32(8, v) }
+func (s GcVars) Vars() C.DataList        { return C.DataList(C.Struct(s).GetObject(0)) }
+func (s GcVars) SetVars(v C.DataList)    { C.Struct(s).SetObject(0, C.Object(v)) }
+func (s GcVars) Last() bool              { return C.Struct(s).Get1(96) }
+func (s GcVars) SetLast(v bool)          { C.Struct(s).Set1(96, v) }
+func (s GcVars) WriteJSON(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('{')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"cycle\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Cycle()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"version\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Version()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"vars\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Vars()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				buf, err = json.Marshal(s)
+				if err != nil {
+					return err
+				}
+				_, err = b.Write(buf)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"last\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Last()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte('}')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s GcVars) MarshalJSON() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteJSON(&b)
+	return b.Bytes(), err
+}
+func (s GcVars) WriteCapLit(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('(')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("cycle = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Cycle()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("version = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Version()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("vars = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Vars()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				buf, err = json.Marshal(s)
+				if err != nil {
+					return err
+				}
+				_, err = b.Write(buf)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("last = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Last()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(')')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s GcVars) MarshalCapLit() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteCapLit(&b)
+	return b.Bytes(), err
+}
+
+type GcVars_List C.PointerList
+
+func NewGcVarsList(s *C.Segment, sz int) GcVars_List {
+	return GcVars_List(s.NewCompositeList(16, 1, sz))
+}
+func (s GcVars_List) Len() int        { return C.PointerList(s).Len() }
+func (s GcVars_List) At(i int) GcVars { return GcVars(C.PointerList(s).At(i).ToStruct()) }
+func (s GcVars_List) ToArray() []GcVars {
+	n := s.Len()
+	a := make([]GcVars, n)
+	for i := 0; i < n; i++ {
+		a[i] = s.At(i)
+	}
+	return a
+}
+func (s GcVars_List) Set(i int, item GcVars) { C.PointerList(s).Set(i, C.Object(item)) }
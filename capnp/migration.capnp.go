@@ -288,20 +288,22 @@ func (s MigrationComplete_List) Set(i int, item MigrationComplete) {
 
 type MigrationElement C.Struct
 
-func NewMigrationElement(s *C.Segment) MigrationElement { return MigrationElement(s.NewStruct(0, 2)) }
+func NewMigrationElement(s *C.Segment) MigrationElement { return MigrationElement(s.NewStruct(1, 2)) }
 func NewRootMigrationElement(s *C.Segment) MigrationElement {
-	return MigrationElement(s.NewRootStruct(0, 2))
+	return MigrationElement(s.NewRootStruct(1, 2))
 }
 func AutoNewMigrationElement(s *C.Segment) MigrationElement {
-	return MigrationElement(s.NewStructAR(0, 2))
+	return MigrationElement(s.NewStructAR(1, 2))
 }
 func ReadRootMigrationElement(s *C.Segment) MigrationElement {
 	return MigrationElement(s.Root(0).ToStruct())
 }
-func (s MigrationElement) Txn() []byte        { return C.Struct(s).GetObject(0).ToData() }
-func (s MigrationElement) SetTxn(v []byte)    { C.Struct(s).SetObject(0, s.Segment.NewData(v)) }
-func (s MigrationElement) Vars() Var_List     { return Var_List(C.Struct(s).GetObject(1)) }
-func (s MigrationElement) SetVars(v Var_List) { C.Struct(s).SetObject(1, C.Object(v)) }
+func (s MigrationElement) Txn() []byte          { return C.Struct(s).GetObject(0).ToData() }
+func (s MigrationElement) SetTxn(v []byte)      { C.Struct(s).SetObject(0, s.Segment.NewData(v)) }
+func (s MigrationElement) Vars() Var_List       { return Var_List(C.Struct(s).GetObject(1)) }
+func (s MigrationElement) SetVars(v Var_List)   { C.Struct(s).SetObject(1, C.Object(v)) }
+func (s MigrationElement) Compressed() bool     { return C.Struct(s).Get1(0) }
+func (s MigrationElement) SetCompressed(v bool) { C.Struct(s).Set1(0, v) }
 func (s MigrationElement) WriteJSON(w io.Writer) error {
 	b := bufio.NewWriter(w)
 	var err error
@@ -359,6 +361,25 @@ func (s MigrationElement) WriteJSON(w io.Writer) error {
 			return err
 		}
 	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"compressed\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Compressed()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
 	err = b.WriteByte('}')
 	if err != nil {
 		return err
@@ -428,6 +449,25 @@ func (s MigrationElement) WriteCapLit(w io.Writer) error {
 			return err
 		}
 	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("compressed = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Compressed()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
 	err = b.WriteByte(')')
 	if err != nil {
 		return err
@@ -444,7 +484,7 @@ func (s MigrationElement) MarshalCapLit() ([]byte, error) {
 type MigrationElement_List C.PointerList
 
 func NewMigrationElementList(s *C.Segment, sz int) MigrationElement_List {
-	return MigrationElement_List(s.NewCompositeList(0, 2, sz))
+	return MigrationElement_List(s.NewCompositeList(1, 2, sz))
 }
 func (s MigrationElement_List) Len() int { return C.PointerList(s).Len() }
 func (s MigrationElement_List) At(i int) MigrationElement {
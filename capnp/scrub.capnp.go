@@ -0,0 +1,357 @@
+package capnp
+
+// AUTO GENERATED - DO NOT EDIT
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	C "github.com/glycerine/go-capnproto"
+	"io"
+)
+
+type ScrubDigest C.Struct
+
+func NewScrubDigest(s *C.Segment) ScrubDigest      { return ScrubDigest(s.NewStruct(8, 1)) }
+func NewRootScrubDigest(s *C.Segment) ScrubDigest  { return ScrubDigest(s.NewRootStruct(8, 1)) }
+func AutoNewScrubDigest(s *C.Segment) ScrubDigest  { return ScrubDigest(s.NewStructAR(8, 1)) }
+func ReadRootScrubDigest(s *C.Segment) ScrubDigest { return ScrubDigest(s.Root(0).ToStruct()) }
+func (s ScrubDigest) Version() uint32              { return C.Struct(s).Get32(0) }
+func (s ScrubDigest) SetVersion(v uint32)          { C.Struct(s).Set32(0, v) }
+func (s ScrubDigest) Vars() ScrubVar_List          { return ScrubVar_List(C.Struct(s).GetObject(0)) }
+func (s ScrubDigest) SetVars(v ScrubVar_List)      { C.Struct(s).SetObject(0, C.Object(v)) }
+func (s ScrubDigest) WriteJSON(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('{')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"version\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Version()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"vars\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Vars()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				err = s.WriteJSON(b)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte('}')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s ScrubDigest) MarshalJSON() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteJSON(&b)
+	return b.Bytes(), err
+}
+func (s ScrubDigest) WriteCapLit(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('(')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("version = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Version()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("vars = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Vars()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				err = s.WriteCapLit(b)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(')')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s ScrubDigest) MarshalCapLit() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteCapLit(&b)
+	return b.Bytes(), err
+}
+
+type ScrubDigest_List C.PointerList
+
+func NewScrubDigestList(s *C.Segment, sz int) ScrubDigest_List {
+	return ScrubDigest_List(s.NewCompositeList(8, 1, sz))
+}
+func (s ScrubDigest_List) Len() int { return C.PointerList(s).Len() }
+func (s ScrubDigest_List) At(i int) ScrubDigest {
+	return ScrubDigest(C.PointerList(s).At(i).ToStruct())
+}
+func (s ScrubDigest_List) ToArray() []ScrubDigest {
+	n := s.Len()
+	a := make([]ScrubDigest, n)
+	for i := 0; i < n; i++ {
+		a[i] = s.At(i)
+	}
+	return a
+}
+func (s ScrubDigest_List) Set(i int, item ScrubDigest) { C.PointerList(s).Set(i, C.Object(item)) }
+
+type ScrubVar C.Struct
+
+func NewScrubVar(s *C.Segment) ScrubVar      { return ScrubVar(s.NewStruct(0, 3)) }
+func NewRootScrubVar(s *C.Segment) ScrubVar  { return ScrubVar(s.NewRootStruct(0, 3)) }
+func AutoNewScrubVar(s *C.Segment) ScrubVar  { return ScrubVar(s.NewStructAR(0, 3)) }
+func ReadRootScrubVar(s *C.Segment) ScrubVar { return ScrubVar(s.Root(0).ToStruct()) }
+func (s ScrubVar) Id() []byte                { return C.Struct(s).GetObject(0).ToData() }
+func (s ScrubVar) SetId(v []byte)            { C.Struct(s).SetObject(0, s.Segment.NewData(v)) }
+func (s ScrubVar) WriteTxnId() []byte        { return C.Struct(s).GetObject(1).ToData() }
+func (s ScrubVar) SetWriteTxnId(v []byte)    { C.Struct(s).SetObject(1, s.Segment.NewData(v)) }
+func (s ScrubVar) WritesClock() []byte       { return C.Struct(s).GetObject(2).ToData() }
+func (s ScrubVar) SetWritesClock(v []byte)   { C.Struct(s).SetObject(2, s.Segment.NewData(v)) }
+func (s ScrubVar) WriteJSON(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('{')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"id\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Id()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"writeTxnId\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.WriteTxnId()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"writesClock\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.WritesClock()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte('}')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s ScrubVar) MarshalJSON() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteJSON(&b)
+	return b.Bytes(), err
+}
+func (s ScrubVar) WriteCapLit(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('(')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("id = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Id()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("writeTxnId = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.WriteTxnId()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("writesClock = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.WritesClock()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(')')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s ScrubVar) MarshalCapLit() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteCapLit(&b)
+	return b.Bytes(), err
+}
+
+type ScrubVar_List C.PointerList
+
+func NewScrubVarList(s *C.Segment, sz int) ScrubVar_List {
+	return ScrubVar_List(s.NewCompositeList(0, 3, sz))
+}
+func (s ScrubVar_List) Len() int          { return C.PointerList(s).Len() }
+func (s ScrubVar_List) At(i int) ScrubVar { return ScrubVar(C.PointerList(s).At(i).ToStruct()) }
+func (s ScrubVar_List) ToArray() []ScrubVar {
+	n := s.Len()
+	a := make([]ScrubVar, n)
+	for i := 0; i < n; i++ {
+		a[i] = s.At(i)
+	}
+	return a
+}
+func (s ScrubVar_List) Set(i int, item ScrubVar) { C.PointerList(s).Set(i, C.Object(item)) }
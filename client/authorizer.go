@@ -0,0 +1,104 @@
+package client
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+)
+
+// Authorizer lets an application which embeds the server impose its
+// own rules on client txns, beyond the capabilities granted by the
+// configuration and carried by references: for example, time of day
+// restrictions, tenant isolation, or forbidding creates by certain
+// clients. AuthorizeTransaction is called once a txn has passed all
+// other validation, and before anything is sent to paxos. A non-nil
+// error vetoes the txn, and is reported to the client. It is called
+// concurrently from every client connection, and must not modify its
+// arguments.
+type Authorizer interface {
+	AuthorizeTransaction(client *ClientIdentity, txnId *common.TxnId, retry bool, actions []*ClientAction) error
+}
+
+var registeredAuthorizer Authorizer
+
+// RegisterAuthorizer installs the Authorizer the server uses for
+// every client connection. To embed the server with an Authorizer,
+// add a file to cmd/goshawkdb (or a copy of it) which imports the
+// package registering it, and call RegisterAuthorizer from that
+// package's init function: it must not be called once the server has
+// started. It panics if an Authorizer is already registered.
+func RegisterAuthorizer(authorizer Authorizer) {
+	if authorizer == nil {
+		panic("RegisterAuthorizer: authorizer is nil")
+	} else if registeredAuthorizer != nil {
+		panic("RegisterAuthorizer: an authorizer is already registered")
+	}
+	registeredAuthorizer = authorizer
+}
+
+// RegisteredAuthorizer returns the Authorizer installed by
+// RegisterAuthorizer, or nil if there is none.
+func RegisteredAuthorizer() Authorizer {
+	return registeredAuthorizer
+}
+
+// ClientIdentity describes the client of a connection.
+type ClientIdentity struct {
+	// Fingerprint is the SHA-256 of Certificate.
+	Fingerprint      [sha256.Size]byte
+	Certificate      *x509.Certificate
+	ConnectionNumber uint32
+	// Roots maps the var of each root the client has access to, to
+	// the root's name.
+	Roots map[common.VarUUId]string
+}
+
+// ClientAction is a decoded action of a client txn. Value and
+// References are nil for reads.
+type ClientAction struct {
+	VarUUId    *common.VarUUId
+	Which      cmsgs.ClientAction_Which
+	Value      []byte
+	References []*ClientReference
+}
+
+type ClientReference struct {
+	VarUUId    *common.VarUUId
+	Capability *common.Capability
+}
+
+func decodeClientActions(actions *cmsgs.ClientAction_List) []*ClientAction {
+	result := make([]*ClientAction, actions.Len())
+	for idx := range result {
+		action := actions.At(idx)
+		clientAction := &ClientAction{
+			VarUUId: common.MakeVarUUId(action.VarId()),
+			Which:   action.Which(),
+		}
+		var references cmsgs.ClientVarIdPos_List
+		switch clientAction.Which {
+		case cmsgs.CLIENTACTION_WRITE:
+			write := action.Write()
+			clientAction.Value, references = write.Value(), write.References()
+		case cmsgs.CLIENTACTION_READWRITE:
+			readWrite := action.Readwrite()
+			clientAction.Value, references = readWrite.Value(), readWrite.References()
+		case cmsgs.CLIENTACTION_CREATE:
+			create := action.Create()
+			clientAction.Value, references = create.Value(), create.References()
+		}
+		if l := references.Len(); l > 0 {
+			clientAction.References = make([]*ClientReference, l)
+			for idy := range clientAction.References {
+				ref := references.At(idy)
+				clientAction.References[idy] = &ClientReference{
+					VarUUId:    common.MakeVarUUId(ref.VarId()),
+					Capability: common.NewCapability(ref.Capability()),
+				}
+			}
+		}
+		result[idx] = clientAction
+	}
+	return result
+}
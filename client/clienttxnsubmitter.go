@@ -2,14 +2,24 @@ package client
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	capn "github.com/glycerine/go-capnproto"
 	"goshawkdb.io/common"
 	cmsgs "goshawkdb.io/common/capnp"
 	"goshawkdb.io/server"
+	"goshawkdb.io/server/audit"
 	msgs "goshawkdb.io/server/capnp"
 	"goshawkdb.io/server/paxos"
 	eng "goshawkdb.io/server/txnengine"
+	"time"
+)
+
+// A retry txn which is cancelled, or reaches its deadline, before any
+// of the vars it read are written is ended with one of these errors.
+var (
+	RetryCancelled = errors.New("Retry txn cancelled")
+	RetryTimedOut  = errors.New("Retry txn timed out")
 )
 
 type ClientTxnCompletionConsumer func(*cmsgs.ClientTxnOutcome, error) error
@@ -17,47 +27,228 @@ type ClientTxnCompletionConsumer func(*cmsgs.ClientTxnOutcome, error) error
 type ClientTxnSubmitter struct {
 	*SimpleTxnSubmitter
 	versionCache versionCache
-	txnLive      bool
-	backoff      *server.BinaryBackoffEngine
+	limiter      *Limiter
+	auditor      *audit.Client
+	authorizer   Authorizer
+	identity     *ClientIdentity
+	live         map[common.TxnId]server.EmptyStruct
+	maxLive      int
+	queued       []*queuedTxn
+	creating     creating
+	retries      map[common.TxnId]*retryTxn
 }
 
-func NewClientTxnSubmitter(rmId common.RMId, bootCount uint32, roots map[common.VarUUId]*common.Capability, cm paxos.ConnectionManager) *ClientTxnSubmitter {
+// NewClientTxnSubmitter creates a submitter for a client connection
+// which may have up to maxLive txns in flight at once. Each is
+// identified by the TxnId the client gave it, and its outcome is
+// returned as soon as it is known, which may be out of order. Txns
+// submitted whilst maxLive are in flight are queued, and submitted in
+// order as others finish.
+func NewClientTxnSubmitter(rmId common.RMId, bootCount uint32, roots map[common.VarUUId]*common.Capability, limiter *Limiter, auditor *audit.Client, authorizer Authorizer, identity *ClientIdentity, maxLive int, cm paxos.ConnectionManager) *ClientTxnSubmitter {
 	sts := NewSimpleTxnSubmitter(rmId, bootCount, cm)
 	return &ClientTxnSubmitter{
 		SimpleTxnSubmitter: sts,
 		versionCache:       NewVersionCache(roots),
-		txnLive:            false,
-		backoff:            server.NewBinaryBackoffEngine(sts.rng, server.SubmissionMinSubmitDelay, server.SubmissionMaxSubmitDelay),
+		limiter:            limiter,
+		auditor:            auditor,
+		authorizer:         authorizer,
+		identity:           identity,
+		live:               make(map[common.TxnId]server.EmptyStruct),
+		maxLive:            maxLive,
+		creating:           make(creating),
+		retries:            make(map[common.TxnId]*retryTxn),
 	}
 }
 
 func (cts *ClientTxnSubmitter) Status(sc *server.StatusConsumer) {
-	sc.Emit(fmt.Sprintf("ClientTxnSubmitter: txnLive? %v", cts.txnLive))
+	sc.Emit(fmt.Sprintf("ClientTxnSubmitter: live txns: %v (max %v); queued txns: %v", len(cts.live), cts.maxLive, len(cts.queued)))
 	cts.SimpleTxnSubmitter.Status(sc.Fork())
 	sc.Join()
 }
 
-func (cts *ClientTxnSubmitter) SubmitClientTransaction(ctxnCap *cmsgs.ClientTxn, continuation ClientTxnCompletionConsumer) error {
-	if cts.txnLive {
-		return continuation(nil, fmt.Errorf("Cannot submit client as a live txn already exists"))
+// CachedVars returns every var the client could refer to in a txn.
+// A txn may only act on vars in the version cache, and may only
+// write references to vars whose positions are in the hash cache, so
+// between them they fence off everything else: in particular, a
+// client which reconnects starts again from its roots, and can only
+// name the vars it rediscovers by reading from them.
+func (cts *ClientTxnSubmitter) CachedVars() []*common.VarUUId {
+	return append(cts.versionCache.Vars(), cts.hashCache.Vars()...)
+}
+
+// IsWriteTxn returns true if ctxnCap includes any action other than a
+// read.
+func IsWriteTxn(ctxnCap *cmsgs.ClientTxn) bool {
+	actions := ctxnCap.Actions()
+	for idx, l := 0, actions.Len(); idx < l; idx++ {
+		if actions.At(idx).Which() != cmsgs.CLIENTACTION_READ {
+			return true
+		}
 	}
+	return false
+}
+
+// creating maps each var which a live txn is creating to the TxnId
+// the client gave that txn. Validation only refuses to create a var
+// which is already in the version cache, which a created var is not
+// until its txn commits, so with several txns in flight this is what
+// stops two of them creating the same var.
+type creating map[common.VarUUId]*common.TxnId
 
-	if err := cts.versionCache.ValidateTransaction(ctxnCap); err != nil {
+// add records the creates of ctxnCap, which the client gave txnId,
+// unless a live txn is already creating one of the same vars.
+func (c creating) add(txnId *common.TxnId, ctxnCap *cmsgs.ClientTxn) ([]*common.VarUUId, error) {
+	var vUUIds []*common.VarUUId
+	actions := ctxnCap.Actions()
+	for idx, l := 0, actions.Len(); idx < l; idx++ {
+		action := actions.At(idx)
+		if action.Which() != cmsgs.CLIENTACTION_CREATE {
+			continue
+		}
+		vUUId := common.MakeVarUUId(action.VarId())
+		if other, found := c[*vUUId]; found {
+			c.remove(vUUIds)
+			return nil, fmt.Errorf("Transaction tries to create object %v which live txn %v is already creating", vUUId, other)
+		}
+		c[*vUUId] = txnId
+		vUUIds = append(vUUIds, vUUId)
+	}
+	return vUUIds, nil
+}
+
+func (c creating) remove(vUUIds []*common.VarUUId) {
+	for _, vUUId := range vUUIds {
+		delete(c, *vUUId)
+	}
+}
+
+// retryTxn tracks a live retry txn so that it can be ended early.
+// txnId is the TxnId of its current submission.
+type retryTxn struct {
+	txnId     *common.TxnId
+	deadline  time.Time
+	cancelled bool
+	ended     error
+}
+
+// reason returns why the retry txn should be ended, or nil if it
+// should not.
+func (retry *retryTxn) reason(now time.Time) error {
+	switch {
+	case retry.cancelled:
+		return RetryCancelled
+	case expired(retry.deadline, now):
+		return RetryTimedOut
+	default:
+		return nil
+	}
+}
+
+func expired(deadline, now time.Time) bool {
+	return !deadline.IsZero() && !now.Before(deadline)
+}
+
+// queuedTxn is a client txn which was submitted whilst maxLive txns
+// were live. A retry txn's deadline runs from when it was queued.
+type queuedTxn struct {
+	ctxnCap      *cmsgs.ClientTxn
+	deadline     time.Time
+	continuation ClientTxnCompletionConsumer
+}
+
+// SetLimiter replaces the Limiter, which may be nil, when the
+// client's limits change.
+func (cts *ClientTxnSubmitter) SetLimiter(limiter *Limiter) {
+	cts.limiter = limiter
+}
+
+// SubmitClientTransaction submits ctxnCap, or queues it if maxLive
+// txns are already live. If it is a retry txn and retryTimeout is > 0,
+// it is ended with RetryTimedOut if none of the vars it read have been
+// written within retryTimeout. Deadlines are checked by
+// ExpireRetries, and whenever the txn is resubmitted.
+func (cts *ClientTxnSubmitter) SubmitClientTransaction(ctxnCap *cmsgs.ClientTxn, retryTimeout time.Duration, continuation ClientTxnCompletionConsumer) error {
+	origTxnId := common.MakeTxnId(ctxnCap.Id())
+	if _, found := cts.live[*origTxnId]; found || cts.isQueued(origTxnId) {
+		return continuation(nil, fmt.Errorf("Cannot submit client txn as a live txn with the same id (%v) already exists", origTxnId))
+	}
+	deadline := time.Time{}
+	if ctxnCap.Retry() && retryTimeout > 0 {
+		deadline = time.Now().Add(retryTimeout)
+	}
+	if len(cts.live) >= cts.maxLive {
+		cts.queued = append(cts.queued, &queuedTxn{ctxnCap: ctxnCap, deadline: deadline, continuation: continuation})
+		return nil
+	}
+	return cts.submit(ctxnCap, deadline, continuation)
+}
+
+func (cts *ClientTxnSubmitter) isQueued(txnId *common.TxnId) bool {
+	for _, queued := range cts.queued {
+		if common.MakeTxnId(queued.ctxnCap.Id()).Compare(txnId) == common.EQ {
+			return true
+		}
+	}
+	return false
+}
+
+// submitQueued submits queued txns, oldest first, whilst fewer than
+// maxLive txns are live.
+func (cts *ClientTxnSubmitter) submitQueued() error {
+	for len(cts.queued) != 0 && len(cts.live) < cts.maxLive {
+		queued := cts.queued[0]
+		cts.queued[0] = nil
+		cts.queued = cts.queued[1:]
+		if err := cts.submit(queued.ctxnCap, queued.deadline, queued.continuation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cts *ClientTxnSubmitter) submit(ctxnCap *cmsgs.ClientTxn, deadline time.Time, continuation ClientTxnCompletionConsumer) error {
+	origTxnId := common.MakeTxnId(ctxnCap.Id())
+	if err := cts.versionCache.ValidateTransaction(ctxnCap, cts.limiter.Limits(), cts.authorizer, cts.identity); err != nil {
+		return continuation(nil, err)
+	}
+	creates, err := cts.creating.add(origTxnId, ctxnCap)
+	if err != nil {
+		return continuation(nil, err)
+	}
+	if err := cts.limiter.Admit(ctxnCap); err != nil {
+		cts.creating.remove(creates)
 		return continuation(nil, err)
 	}
+	// Once the txn is done, a queued txn may take its place.
+	finished := func(clientOutcome *cmsgs.ClientTxnOutcome, err error) error {
+		delete(cts.live, *origTxnId)
+		delete(cts.retries, *origTxnId)
+		cts.creating.remove(creates)
+		if err := continuation(clientOutcome, err); err != nil {
+			return err
+		}
+		return cts.submitQueued()
+	}
 
 	seg := capn.NewBuffer(nil)
 	clientOutcome := cmsgs.NewClientTxnOutcome(seg)
 	clientOutcome.SetId(ctxnCap.Id())
 
 	curTxnId := common.MakeTxnId(ctxnCap.Id())
-	cts.backoff.Shrink(server.SubmissionMinSubmitDelay)
+	backoff := server.NewBinaryBackoffEngine(cts.rng, server.SubmissionMinSubmitDelay, server.SubmissionMaxSubmitDelay)
+
+	var retry *retryTxn
+	if ctxnCap.Retry() {
+		retry = &retryTxn{txnId: curTxnId, deadline: deadline}
+	}
 
 	var cont TxnCompletionConsumer
 	cont = func(txn *eng.TxnReader, outcome *msgs.Outcome, err error) error {
-		if outcome == nil || err != nil { // node is shutting down or error
-			cts.txnLive = false
-			return continuation(nil, err)
+		if outcome == nil || err != nil { // node is shutting down, error, or retry ended
+			if retry != nil && retry.ended != nil && err == nil {
+				err = retry.ended
+			}
+			return finished(nil, err)
 		}
 		txnId := txn.Id
 		switch outcome.Which() {
@@ -66,8 +257,8 @@ func (cts *ClientTxnSubmitter) SubmitClientTransaction(ctxnCap *cmsgs.ClientTxn,
 			clientOutcome.SetFinalId(txnId[:])
 			clientOutcome.SetCommit()
 			cts.addCreatesToCache(txn)
-			cts.txnLive = false
-			return continuation(&clientOutcome, nil)
+			cts.auditor.Committed(txnId, ctxnCap)
+			return finished(&clientOutcome, nil)
 
 		default:
 			abort := outcome.Abort()
@@ -80,14 +271,19 @@ func (cts *ClientTxnSubmitter) SubmitClientTransaction(ctxnCap *cmsgs.ClientTxn,
 				if !resubmit {
 					clientOutcome.SetFinalId(txnId[:])
 					clientOutcome.SetAbort(cts.translateUpdates(seg, validUpdates))
-					cts.txnLive = false
-					return continuation(&clientOutcome, nil)
+					return finished(&clientOutcome, nil)
 				}
 			}
+			if retry != nil && retry.ended == nil {
+				retry.ended = retry.reason(time.Now())
+			}
+			if retry != nil && retry.ended != nil {
+				return finished(nil, retry.ended)
+			}
 			server.Log("Resubmitting", txnId, "; orig resubmit?", abort.Which() == msgs.OUTCOMEABORT_RESUBMIT)
 
-			cts.backoff.Advance()
-			//fmt.Printf("%v ", cts.backoff.Cur)
+			backoff.Advance()
+			//fmt.Printf("%v ", backoff.Cur)
 
 			curTxnIdNum := binary.BigEndian.Uint64(txnId[:8])
 			curTxnIdNum += 1 + uint64(cts.rng.Intn(8))
@@ -98,13 +294,86 @@ func (cts *ClientTxnSubmitter) SubmitClientTransaction(ctxnCap *cmsgs.ClientTxn,
 			newCtxnCap.SetRetry(ctxnCap.Retry())
 			newCtxnCap.SetActions(ctxnCap.Actions())
 
-			return cts.SimpleTxnSubmitter.SubmitClientTransaction(nil, &newCtxnCap, curTxnId, cont, cts.backoff, false, cts.versionCache)
+			return cts.SimpleTxnSubmitter.SubmitClientTransaction(nil, &newCtxnCap, curTxnId, cont, backoff, false, cts.versionCache)
 		}
 	}
 
-	cts.txnLive = true
+	cts.live[*origTxnId] = server.EmptyStructVal
+	if retry != nil {
+		cts.retries[*origTxnId] = retry
+	}
 	// fmt.Printf("%v ", delay)
-	return cts.SimpleTxnSubmitter.SubmitClientTransaction(nil, ctxnCap, curTxnId, cont, cts.backoff, false, cts.versionCache)
+	return cts.SimpleTxnSubmitter.SubmitClientTransaction(nil, ctxnCap, curTxnId, cont, backoff, false, cts.versionCache)
+}
+
+// CancelRetry ends, with RetryCancelled, the live or queued retry txn
+// which the client submitted as origTxnId. It does nothing if there is
+// no such txn: it may have finished already. A retry txn whose current
+// submission is still buffered awaiting a topology is ended by a later
+// call to ExpireRetries.
+func (cts *ClientTxnSubmitter) CancelRetry(origTxnId *common.TxnId) error {
+	for idx, q := range cts.queued {
+		if q.ctxnCap.Retry() && common.MakeTxnId(q.ctxnCap.Id()).Compare(origTxnId) == common.EQ {
+			cts.queued = append(cts.queued[:idx], cts.queued[idx+1:]...)
+			return q.continuation(nil, RetryCancelled)
+		}
+	}
+	if retry, found := cts.retries[*origTxnId]; found && retry.ended == nil {
+		retry.cancelled = true
+		return cts.endRetry(retry, RetryCancelled)
+	}
+	return nil
+}
+
+// ExpireRetries ends, with RetryTimedOut, every live or queued retry
+// txn whose deadline is not after now, and, with RetryCancelled, every
+// live retry txn which has been cancelled. A retry txn whose current
+// submission is still buffered awaiting a topology cannot yet be
+// aborted, so it is left for a later call.
+func (cts *ClientTxnSubmitter) ExpireRetries(now time.Time) error {
+	queued := cts.queued[:0]
+	var timedOut []*queuedTxn
+	for _, q := range cts.queued {
+		if q.ctxnCap.Retry() && expired(q.deadline, now) {
+			timedOut = append(timedOut, q)
+		} else {
+			queued = append(queued, q)
+		}
+	}
+	cts.queued = queued
+	for _, q := range timedOut {
+		if err := q.continuation(nil, RetryTimedOut); err != nil {
+			return err
+		}
+	}
+	for _, retry := range cts.retries {
+		if reason := retry.reason(now); retry.ended == nil && reason != nil {
+			if err := cts.endRetry(retry, reason); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// endRetry aborts the current submission of retry. The abort calls
+// the submission's continuation, which ends the retry txn with reason,
+// so reason is recorded first, and forgotten again if there was
+// nothing to abort.
+func (cts *ClientTxnSubmitter) endRetry(retry *retryTxn, reason error) error {
+	retry.ended = reason
+	aborted, err := cts.SimpleTxnSubmitter.AbortRetryTransaction(retry.txnId)
+	if !aborted {
+		retry.ended = nil
+	}
+	return err
+}
+
+// Shutdown drops the queued txns, then ends the live txns as
+// SimpleTxnSubmitter.Shutdown does.
+func (cts *ClientTxnSubmitter) Shutdown() {
+	cts.queued = nil
+	cts.SimpleTxnSubmitter.Shutdown()
 }
 
 func (cts *ClientTxnSubmitter) addCreatesToCache(txn *eng.TxnReader) {
@@ -0,0 +1,334 @@
+package client
+
+import (
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/paxos"
+	eng "goshawkdb.io/server/txnengine"
+	"testing"
+	"time"
+)
+
+func clientTxnCreating(vUUIds ...*common.VarUUId) *cmsgs.ClientTxn {
+	seg := capn.NewBuffer(nil)
+	ctxn := cmsgs.NewClientTxn(seg)
+	actions := cmsgs.NewClientActionList(seg, len(vUUIds))
+	for idx, vUUId := range vUUIds {
+		action := actions.At(idx)
+		action.SetVarId(vUUId[:])
+		action.SetCreate()
+		action.Create().SetValue([]byte{})
+		action.Create().SetReferences(cmsgs.NewClientVarIdPosList(seg, 0))
+	}
+	ctxn.SetActions(actions)
+	return &ctxn
+}
+
+func TestCreatingRefusesDuplicates(t *testing.T) {
+	a, b, c := &common.VarUUId{1}, &common.VarUUId{2}, &common.VarUUId{3}
+	txn1, txn2, txn3 := &common.TxnId{1}, &common.TxnId{2}, &common.TxnId{3}
+	cr := make(creating)
+
+	creates1, err := cr.add(txn1, clientTxnCreating(a, b))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(creates1) != 2 {
+		t.Fatalf("Expected 2 creates; found %v", creates1)
+	}
+	// txn 2 creates a var txn 1 is creating: refused, and none of its
+	// creates are recorded.
+	if _, err = cr.add(txn2, clientTxnCreating(c, b)); err == nil {
+		t.Fatal("Expected duplicate create to be refused")
+	} else if _, found := cr[*c]; found {
+		t.Fatal("Refused txn's creates should not be recorded")
+	}
+	// A txn may not create the same var twice either.
+	if _, err = cr.add(txn2, clientTxnCreating(c, c)); err == nil {
+		t.Fatal("Expected duplicate create within a txn to be refused")
+	}
+
+	// Once txn 1 is done, its vars may be created again: validation
+	// against the version cache refuses them if txn 1 committed.
+	cr.remove(creates1)
+	if _, err = cr.add(txn3, clientTxnCreating(a, b, c)); err != nil {
+		t.Fatal(err)
+	} else if len(cr) != 3 {
+		t.Fatalf("Expected 3 vars being created; found %v", len(cr))
+	}
+}
+
+func TestExpireRetries(t *testing.T) {
+	now := time.Now()
+	cts := &ClientTxnSubmitter{
+		SimpleTxnSubmitter: NewSimpleTxnSubmitter(common.RMIdEmpty, 0, nil),
+		retries:            make(map[common.TxnId]*retryTxn),
+	}
+	aborted := make(map[common.TxnId]int)
+	submitted := func(txnId *common.TxnId) {
+		shutdownFun := func(shutdown bool) error {
+			aborted[*txnId]++
+			delete(cts.retryShutdowns, *txnId)
+			return nil
+		}
+		cts.retryShutdowns[*txnId] = &shutdownFun
+	}
+	expired := &retryTxn{txnId: &common.TxnId{1}, deadline: now.Add(-time.Second)}
+	buffered := &retryTxn{txnId: &common.TxnId{2}, deadline: now.Add(-time.Second)}
+	pending := &retryTxn{txnId: &common.TxnId{3}, deadline: now.Add(time.Second)}
+	unlimited := &retryTxn{txnId: &common.TxnId{4}}
+	for _, retry := range []*retryTxn{expired, buffered, pending, unlimited} {
+		cts.retries[*retry.txnId] = retry
+	}
+	submitted(expired.txnId)
+	submitted(pending.txnId)
+	submitted(unlimited.txnId)
+
+	if err := cts.ExpireRetries(now); err != nil {
+		t.Fatal(err)
+	}
+	if expired.ended != RetryTimedOut || aborted[*expired.txnId] != 1 {
+		t.Fatalf("Expected expired retry to be aborted; ended: %v, aborted %v times", expired.ended, aborted[*expired.txnId])
+	}
+	// Buffered awaiting a topology, so there was nothing to abort.
+	if buffered.ended != nil {
+		t.Fatalf("Expected buffered retry not to be ended yet; ended: %v", buffered.ended)
+	}
+	if pending.ended != nil || unlimited.ended != nil || aborted[*pending.txnId] != 0 || aborted[*unlimited.txnId] != 0 {
+		t.Fatal("Expected retries within their deadlines to be left alone")
+	}
+
+	// Once submitted, the buffered retry is aborted by the next call.
+	delete(cts.retries, *expired.txnId)
+	submitted(buffered.txnId)
+	if err := cts.ExpireRetries(now.Add(2 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if buffered.ended != RetryTimedOut || aborted[*buffered.txnId] != 1 {
+		t.Fatalf("Expected buffered retry to be aborted; ended: %v, aborted %v times", buffered.ended, aborted[*buffered.txnId])
+	}
+	if pending.ended != RetryTimedOut || unlimited.ended != nil {
+		t.Fatal("Expected only the retry with a deadline to have expired")
+	}
+}
+
+func TestQueuedTxns(t *testing.T) {
+	now := time.Now()
+	cts := &ClientTxnSubmitter{
+		SimpleTxnSubmitter: NewSimpleTxnSubmitter(common.RMIdEmpty, 0, nil),
+		live:               map[common.TxnId]server.EmptyStruct{common.TxnId{1}: server.EmptyStructVal},
+		maxLive:            1,
+		retries:            make(map[common.TxnId]*retryTxn),
+	}
+	outcomes := make(map[common.TxnId]error)
+	submit := func(txnId *common.TxnId, retry bool, retryTimeout time.Duration) {
+		ctxn := clientTxnCreating(&common.VarUUId{txnId[0]})
+		ctxn.SetId(txnId[:])
+		ctxn.SetRetry(retry)
+		err := cts.SubmitClientTransaction(ctxn, retryTimeout, func(clientOutcome *cmsgs.ClientTxnOutcome, err error) error {
+			outcomes[*txnId] = err
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// With maxLive txns live, further txns are queued rather than
+	// refused.
+	submit(&common.TxnId{2}, false, 0)
+	submit(&common.TxnId{3}, true, time.Second)
+	submit(&common.TxnId{4}, true, 0)
+	if len(outcomes) != 0 || len(cts.queued) != 3 {
+		t.Fatalf("Expected 3 txns to be queued; got %v queued and outcomes %v", len(cts.queued), outcomes)
+	}
+	// A txn may not reuse the id of a queued txn.
+	submit(&common.TxnId{2}, false, 0)
+	if err := outcomes[common.TxnId{2}]; err == nil || len(cts.queued) != 3 {
+		t.Fatalf("Expected duplicate of a queued txn to be refused; got %v", err)
+	}
+	delete(outcomes, common.TxnId{2})
+
+	// A queued retry txn's deadline runs from when it was queued.
+	if err := cts.ExpireRetries(now.Add(2 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err, found := outcomes[common.TxnId{3}]; !found || err != RetryTimedOut || len(outcomes) != 1 {
+		t.Fatalf("Expected only the queued retry with a deadline to have expired; got %v", outcomes)
+	}
+	if len(cts.queued) != 2 || common.MakeTxnId(cts.queued[0].ctxnCap.Id()).Compare(&common.TxnId{2}) != common.EQ {
+		t.Fatalf("Expected the other queued txns to remain, in order; got %v", len(cts.queued))
+	}
+
+	// Queued txns are dropped on shutdown.
+	cts.Shutdown()
+	if len(cts.queued) != 0 || len(outcomes) != 1 {
+		t.Fatalf("Expected queued txns to be dropped; got %v queued and outcomes %v", len(cts.queued), outcomes)
+	}
+}
+
+// testConnPub connects every subscriber to conns as soon as it is
+// added.
+type testConnPub struct {
+	conns map[common.RMId]paxos.Connection
+}
+
+func (pub *testConnPub) AddServerConnectionSubscriber(obs paxos.ServerConnectionSubscriber) {
+	obs.ConnectedRMs(pub.conns)
+}
+
+func (pub *testConnPub) RemoveServerConnectionSubscriber(obs paxos.ServerConnectionSubscriber) {}
+
+// testConn records the type of every msg sent to it.
+type testConn struct {
+	rmId common.RMId
+	sent []msgs.Message_Which
+}
+
+func (conn *testConn) Host() string        { return "" }
+func (conn *testConn) RMId() common.RMId   { return conn.rmId }
+func (conn *testConn) BootCount() uint32   { return 1 }
+func (conn *testConn) TieBreak() uint32    { return 0 }
+func (conn *testConn) ClusterUUId() uint64 { return 0 }
+func (conn *testConn) Send(msg []byte) {
+	seg, _, err := capn.ReadFromMemoryZeroCopy(msg)
+	if err != nil {
+		panic(err)
+	}
+	conn.sent = append(conn.sent, msgs.ReadRootMessage(seg).Which())
+}
+
+func retryServerTxn(txnId *common.TxnId, rmId common.RMId) *msgs.Txn {
+	seg := capn.NewBuffer(nil)
+	txn := msgs.NewRootTxn(seg)
+	txn.SetId(txnId[:])
+	txn.SetRetry(true)
+	txn.SetFInc(1)
+	allocs := msgs.NewAllocationList(seg, 1)
+	allocs.At(0).SetRmId(uint32(rmId))
+	txn.SetAllocations(allocs)
+	return &txn
+}
+
+// A retry txn which is cancelled or expires is aborted through
+// AbortRetryTransaction, exactly as if its connection had shut down:
+// the proposers are told to abort it, and its outcome, should one
+// still arrive, is not delivered.
+func TestEndedRetryIsAborted(t *testing.T) {
+	rmId := common.RMId(1)
+	conn := &testConn{rmId: rmId}
+	pub := &testConnPub{conns: map[common.RMId]paxos.Connection{rmId: conn}}
+	now := time.Now()
+
+	for idx, reason := range []error{RetryCancelled, RetryTimedOut} {
+		cts := &ClientTxnSubmitter{
+			SimpleTxnSubmitter: NewSimpleTxnSubmitter(rmId, 0, pub),
+			retries:            make(map[common.TxnId]*retryTxn),
+		}
+		origTxnId, txnId := &common.TxnId{byte(idx), 1}, &common.TxnId{byte(idx), 2}
+		retry := &retryTxn{txnId: txnId, deadline: now.Add(time.Second)}
+		cts.retries[*origTxnId] = retry
+		var ended []error
+		// Stands in for the continuation of SubmitClientTransaction,
+		// which ends the retry txn with retry.ended.
+		cts.SubmitTransaction(retryServerTxn(txnId, rmId), txnId, []common.RMId{rmId}, func(txn *eng.TxnReader, outcome *msgs.Outcome, err error) error {
+			if outcome != nil || err != nil {
+				t.Fatalf("%v: expected the retry txn to be aborted; got outcome %v, error %v", reason, outcome, err)
+			}
+			ended = append(ended, retry.ended)
+			return nil
+		}, nil)
+		if len(conn.sent) != 1 || conn.sent[0] != msgs.MESSAGE_TXNSUBMISSION {
+			t.Fatalf("%v: expected the txn to be submitted; sent %v", reason, conn.sent)
+		}
+
+		end := func() {
+			conn.sent = nil
+			var err error
+			if reason == RetryCancelled {
+				err = cts.CancelRetry(origTxnId)
+			} else {
+				err = cts.ExpireRetries(now.Add(2 * time.Second))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		end()
+		if len(ended) != 1 || ended[0] != reason {
+			t.Fatalf("Expected the retry txn to be ended once with %v; got %v", reason, ended)
+		}
+		if _, found := cts.retryShutdowns[*txnId]; found || !cts.IsIdle() {
+			t.Fatalf("%v: expected the submission to be forgotten", reason)
+		}
+		aborted := false
+		for _, which := range conn.sent {
+			aborted = aborted || which == msgs.MESSAGE_SUBMISSIONABORT
+		}
+		if !aborted {
+			t.Fatalf("%v: expected the proposers to be told to abort the txn; sent %v", reason, conn.sent)
+		}
+
+		// An outcome which arrives later is only acknowledged.
+		conn.sent = nil
+		outcome := msgs.NewOutcome(capn.NewBuffer(nil))
+		if err := cts.SubmissionOutcomeReceived(rmId, &eng.TxnReader{Id: txnId}, &outcome); err != nil {
+			t.Fatal(err)
+		}
+		if len(ended) != 1 || len(conn.sent) != 1 || conn.sent[0] != msgs.MESSAGE_SUBMISSIONCOMPLETE {
+			t.Fatalf("%v: expected a later outcome not to be delivered; ended %v, sent %v", reason, ended, conn.sent)
+		}
+
+		// Ending it again does nothing.
+		end()
+		if len(ended) != 1 || len(conn.sent) != 0 {
+			t.Fatalf("%v: expected the retry txn to be ended only once; ended %v, sent %v", reason, ended, conn.sent)
+		}
+	}
+
+	// Cancelling a txn which is not live does nothing.
+	cts := &ClientTxnSubmitter{
+		SimpleTxnSubmitter: NewSimpleTxnSubmitter(rmId, 0, pub),
+		retries:            make(map[common.TxnId]*retryTxn),
+	}
+	if err := cts.CancelRetry(&common.TxnId{9}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCancelQueuedRetry(t *testing.T) {
+	cts := &ClientTxnSubmitter{
+		SimpleTxnSubmitter: NewSimpleTxnSubmitter(common.RMIdEmpty, 0, nil),
+		live:               map[common.TxnId]server.EmptyStruct{common.TxnId{1}: server.EmptyStructVal},
+		maxLive:            1,
+		retries:            make(map[common.TxnId]*retryTxn),
+	}
+	outcomes := make(map[common.TxnId][]error)
+	for _, txnId := range []*common.TxnId{&common.TxnId{2}, &common.TxnId{3}} {
+		txnId := txnId
+		ctxn := clientTxnCreating(&common.VarUUId{txnId[0]})
+		ctxn.SetId(txnId[:])
+		ctxn.SetRetry(true)
+		err := cts.SubmitClientTransaction(ctxn, 0, func(clientOutcome *cmsgs.ClientTxnOutcome, err error) error {
+			outcomes[*txnId] = append(outcomes[*txnId], err)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cts.CancelRetry(&common.TxnId{2}); err != nil {
+		t.Fatal(err)
+	}
+	if errs := outcomes[common.TxnId{2}]; len(errs) != 1 || errs[0] != RetryCancelled || len(outcomes) != 1 {
+		t.Fatalf("Expected only the cancelled txn to be ended, with %v; got %v", RetryCancelled, outcomes)
+	}
+	if len(cts.queued) != 1 || cts.isQueued(&common.TxnId{2}) {
+		t.Fatal("Expected the cancelled txn to be removed from the queue")
+	}
+	if err := cts.CancelRetry(&common.TxnId{2}); err != nil || len(outcomes[common.TxnId{2}]) != 1 {
+		t.Fatalf("Expected cancelling again to do nothing; got %v", outcomes)
+	}
+}
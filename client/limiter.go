@@ -0,0 +1,139 @@
+package client
+
+import (
+	"expvar"
+	"fmt"
+	cmsgs "goshawkdb.io/common/capnp"
+	"goshawkdb.io/server/configuration"
+	"sync"
+	"time"
+)
+
+var limitMetrics = expvar.NewMap("ClientLimits")
+
+// Limiter enforces the rate limits of a single client. All of the
+// client's connections share the same Limiter, so it is safe for
+// concurrent use. A nil Limiter imposes no limits.
+type Limiter struct {
+	lock    sync.Mutex
+	limits  *configuration.ClientLimits
+	txns    tokenBucket
+	bytes   tokenBucket
+	creates tokenBucket
+}
+
+func NewLimiter(limits *configuration.ClientLimits) *Limiter {
+	l := &Limiter{}
+	l.SetLimits(limits)
+	return l
+}
+
+// SetLimits changes the limits, for example following a topology
+// change. Buckets whose rate is unchanged keep their current level.
+func (l *Limiter) SetLimits(limits *configuration.ClientLimits) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.limits = limits
+	now := time.Now()
+	if limits == nil {
+		limits = &configuration.ClientLimits{}
+	}
+	l.txns.setRate(float64(limits.TxnsPerSecond), time.Second, now)
+	l.bytes.setRate(float64(limits.BytesWrittenPerSecond), time.Second, now)
+	l.creates.setRate(float64(limits.CreatesPerMinute), time.Minute, now)
+}
+
+// Limits returns the current limits, or nil if there are none.
+func (l *Limiter) Limits() *configuration.ClientLimits {
+	if l == nil {
+		return nil
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.limits
+}
+
+// Admit charges ctxnCap against the client's rates, or returns an
+// error if the client has exceeded any of them, in which case nothing
+// is charged.
+func (l *Limiter) Admit(ctxnCap *cmsgs.ClientTxn) error {
+	if l == nil {
+		return nil
+	}
+	bytes, creates := 0, 0
+	actions := ctxnCap.Actions()
+	for idx, n := 0, actions.Len(); idx < n; idx++ {
+		action := actions.At(idx)
+		switch action.Which() {
+		case cmsgs.CLIENTACTION_WRITE:
+			bytes += len(action.Write().Value())
+		case cmsgs.CLIENTACTION_READWRITE:
+			bytes += len(action.Readwrite().Value())
+		case cmsgs.CLIENTACTION_CREATE:
+			bytes += len(action.Create().Value())
+			creates++
+		}
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	switch {
+	case !l.txns.available(1, now):
+		limitMetrics.Add("TxnsPerSecondExceeded", 1)
+		return fmt.Errorf("Rate limit exceeded: more than %v txns per second", l.limits.TxnsPerSecond)
+	case !l.bytes.available(float64(bytes), now):
+		limitMetrics.Add("BytesWrittenPerSecondExceeded", 1)
+		return fmt.Errorf("Rate limit exceeded: more than %v bytes written per second", l.limits.BytesWrittenPerSecond)
+	case !l.creates.available(float64(creates), now):
+		limitMetrics.Add("CreatesPerMinuteExceeded", 1)
+		return fmt.Errorf("Rate limit exceeded: more than %v objects created per minute", l.limits.CreatesPerMinute)
+	}
+	l.txns.take(1)
+	l.bytes.take(float64(bytes))
+	l.creates.take(float64(creates))
+	return nil
+}
+
+// tokenBucket holds up to one period's worth of its rate. A charge
+// larger than that is admitted when the bucket is full, leaving the
+// bucket in debt, so large txns are slowed rather than refused
+// forever. A zero rate is unlimited.
+type tokenBucket struct {
+	rate     float64
+	period   time.Duration
+	tokens   float64
+	lastFill time.Time
+}
+
+func (tb *tokenBucket) setRate(rate float64, period time.Duration, now time.Time) {
+	if tb.rate != rate || tb.period != period {
+		tb.rate = rate
+		tb.period = period
+		tb.tokens = rate
+		tb.lastFill = now
+	}
+}
+
+func (tb *tokenBucket) available(cost float64, now time.Time) bool {
+	if tb.rate == 0 {
+		return true
+	}
+	if elapsed := now.Sub(tb.lastFill); elapsed > 0 {
+		tb.tokens += tb.rate * float64(elapsed) / float64(tb.period)
+		if tb.tokens > tb.rate {
+			tb.tokens = tb.rate
+		}
+		tb.lastFill = now
+	}
+	if cost > tb.rate {
+		cost = tb.rate
+	}
+	return tb.tokens >= cost
+}
+
+func (tb *tokenBucket) take(cost float64) {
+	if tb.rate != 0 {
+		tb.tokens -= cost
+	}
+}
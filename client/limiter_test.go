@@ -0,0 +1,67 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Now()
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+	tb := &tokenBucket{}
+
+	tb.setRate(0, time.Second, start)
+	if !tb.available(1e9, start) {
+		t.Fatal("Zero rate should be unlimited")
+	}
+
+	tb.setRate(10, time.Second, start)
+	if !tb.available(10, start) {
+		t.Fatal("New bucket should be full")
+	}
+	tb.take(10)
+	if tb.available(1, start) {
+		t.Fatal("Empty bucket should refuse")
+	}
+	if !tb.available(1, at(100*time.Millisecond)) {
+		t.Fatal("Bucket should refill at its rate")
+	}
+	if tb.available(2, at(100*time.Millisecond)) {
+		t.Fatal("Bucket should not refill faster than its rate")
+	}
+
+	// A bucket never holds more than one period's worth.
+	if !tb.available(10, at(time.Hour)) {
+		t.Fatal("Bucket should be full")
+	}
+	tb.take(10)
+	if tb.available(1, at(time.Hour)) {
+		t.Fatal("Bucket should have been capped at its rate")
+	}
+
+	// A charge bigger than the bucket is admitted when full, and
+	// leaves the bucket in debt.
+	now := at(2 * time.Hour)
+	if !tb.available(25, now) {
+		t.Fatal("Oversized charge should be admitted by a full bucket")
+	}
+	tb.take(25)
+	if tb.available(1, now.Add(1500*time.Millisecond)) {
+		t.Fatal("Bucket should still be in debt")
+	}
+	if !tb.available(1, now.Add(1600*time.Millisecond)) {
+		t.Fatal("Bucket should have repaid its debt")
+	}
+
+	// Setting the same rate keeps the level; a new rate refills.
+	now = now.Add(1600 * time.Millisecond)
+	tb.take(1)
+	tb.setRate(10, time.Second, now)
+	if tb.available(1, now) {
+		t.Fatal("Unchanged rate should keep the level")
+	}
+	tb.setRate(10, time.Minute, now)
+	if !tb.available(10, now) {
+		t.Fatal("Changed rate should refill the bucket")
+	}
+}
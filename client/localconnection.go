@@ -26,6 +26,7 @@ type LocalConnection struct {
 	submitter         *SimpleTxnSubmitter
 	nextTxnNumber     uint64
 	nextVarNumber     uint64
+	heldRoots         map[common.VarUUId]int
 	txnQuery          localConnectionTxnQuery
 }
 
@@ -129,6 +130,47 @@ func (lc *LocalConnection) NextVarUUId() *common.VarUUId {
 	return vUUId
 }
 
+// HoldRoots makes vUUIds roots for the garbage collector until the
+// returned function is called. A var created through the local
+// connection is reachable from nothing until it is referenced by some
+// other var, so whoever creates it must hold it until then: holding
+// it from before the txn which creates it is submitted.
+func (lc *LocalConnection) HoldRoots(vUUIds ...*common.VarUUId) func() {
+	lc.Lock()
+	defer lc.Unlock()
+	for _, vUUId := range vUUIds {
+		lc.heldRoots[*vUUId]++
+	}
+	released := false
+	return func() {
+		lc.Lock()
+		defer lc.Unlock()
+		if released {
+			return
+		}
+		released = true
+		for _, vUUId := range vUUIds {
+			if count := lc.heldRoots[*vUUId] - 1; count == 0 {
+				delete(lc.heldRoots, *vUUId)
+			} else {
+				lc.heldRoots[*vUUId] = count
+			}
+		}
+	}
+}
+
+// HeldRoots returns every var currently held by HoldRoots.
+func (lc *LocalConnection) HeldRoots() []*common.VarUUId {
+	lc.Lock()
+	defer lc.Unlock()
+	vUUIds := make([]*common.VarUUId, 0, len(lc.heldRoots))
+	for vUUId := range lc.heldRoots {
+		vUUIdCopy := vUUId
+		vUUIds = append(vUUIds, &vUUIdCopy)
+	}
+	return vUUIds
+}
+
 func (lc *LocalConnection) enqueueQuery(msg localConnectionMsg) bool {
 	var f cc.CurCellConsumer
 	f = func(cell *cc.ChanCell) (bool, cc.CurCellConsumer) {
@@ -252,6 +294,7 @@ func NewLocalConnection(rmId common.RMId, bootCount uint32, cm paxos.ConnectionM
 		submitter:         NewSimpleTxnSubmitter(rmId, bootCount, cm),
 		nextTxnNumber:     0,
 		nextVarNumber:     0,
+		heldRoots:         make(map[common.VarUUId]int),
 	}
 	var head *cc.ChanCellHead
 	head, lc.cellTail = cc.NewChanCellTail(
@@ -0,0 +1,39 @@
+package client
+
+import (
+	"goshawkdb.io/common"
+	"testing"
+)
+
+func TestHoldRoots(t *testing.T) {
+	a, b, c := &common.VarUUId{1}, &common.VarUUId{2}, &common.VarUUId{3}
+	lc := &LocalConnection{heldRoots: make(map[common.VarUUId]int)}
+	held := func(expected ...*common.VarUUId) {
+		roots := make(map[common.VarUUId]bool)
+		for _, vUUId := range lc.HeldRoots() {
+			roots[*vUUId] = true
+		}
+		if len(roots) != len(expected) {
+			t.Fatalf("Expected %v held; got %v", expected, lc.HeldRoots())
+		}
+		for _, vUUId := range expected {
+			if !roots[*vUUId] {
+				t.Fatalf("Expected %v held; got %v", expected, lc.HeldRoots())
+			}
+		}
+	}
+
+	releaseAB := lc.HoldRoots(a, b)
+	releaseBC := lc.HoldRoots(b, c)
+	held(a, b, c)
+
+	// b is still held by the second hold.
+	releaseAB()
+	held(b, c)
+	// Releasing twice is harmless.
+	releaseAB()
+	held(b, c)
+
+	releaseBC()
+	held()
+}
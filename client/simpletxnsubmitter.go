@@ -25,6 +25,7 @@ type SimpleTxnSubmitter struct {
 	connPub             paxos.ServerConnectionPublisher
 	outcomeConsumers    map[common.TxnId]txnOutcomeConsumer
 	onShutdown          map[*func(bool) error]server.EmptyStruct
+	retryShutdowns      map[common.TxnId]*func(bool) error
 	resolver            *ch.Resolver
 	hashCache           *ch.ConsistentHashCache
 	topology            *configuration.Topology
@@ -46,6 +47,7 @@ func NewSimpleTxnSubmitter(rmId common.RMId, bootCount uint32, connPub paxos.Ser
 		connPub:          connPub,
 		outcomeConsumers: make(map[common.TxnId]txnOutcomeConsumer),
 		onShutdown:       make(map[*func(bool) error]server.EmptyStruct),
+		retryShutdowns:   make(map[common.TxnId]*func(bool) error),
 		hashCache:        cache,
 		rng:              rng,
 	}
@@ -109,6 +111,7 @@ func (sts *SimpleTxnSubmitter) SubmitTransaction(txnCap *msgs.Txn, txnId *common
 
 	shutdownFun := func(shutdown bool) error {
 		delete(sts.outcomeConsumers, *txnId)
+		delete(sts.retryShutdowns, *txnId)
 		// fmt.Printf("sts%v ", len(sts.outcomeConsumers))
 		if sleeping {
 			txnSenderRemovedChan := make(chan server.EmptyStruct)
@@ -134,6 +137,9 @@ func (sts *SimpleTxnSubmitter) SubmitTransaction(txnCap *msgs.Txn, txnId *common
 	}
 	shutdownFunPtr := &shutdownFun
 	sts.onShutdown[shutdownFunPtr] = server.EmptyStructVal
+	if txnCap.Retry() {
+		sts.retryShutdowns[*txnId] = shutdownFunPtr
+	}
 
 	outcomeAccumulator := paxos.NewOutcomeAccumulator(int(txnCap.FInc()), acceptors)
 	consumer := func(sender common.RMId, txn *eng.TxnReader, outcome *msgs.Outcome) error {
@@ -231,6 +237,20 @@ func (sts *SimpleTxnSubmitter) calculateDisabledHashcodes() error {
 	return nil
 }
 
+// AbortRetryTransaction abandons the retry txn txnId exactly as
+// Shutdown would: the proposers are told to abort it, which removes it
+// from the vars it is waiting on, and its continuation is called with
+// a nil outcome. Returns false if txnId is not a submitted retry txn
+// awaiting its outcome; for example, it may still be buffered waiting
+// for a topology.
+func (sts *SimpleTxnSubmitter) AbortRetryTransaction(txnId *common.TxnId) (bool, error) {
+	if fun, found := sts.retryShutdowns[*txnId]; found {
+		delete(sts.onShutdown, fun)
+		return true, (*fun)(true)
+	}
+	return false, nil
+}
+
 func (sts *SimpleTxnSubmitter) Shutdown() {
 	for fun := range sts.onShutdown {
 		(*fun)(true)
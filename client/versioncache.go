@@ -6,6 +6,7 @@ import (
 	"goshawkdb.io/common"
 	cmsgs "goshawkdb.io/common/capnp"
 	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/configuration"
 	ch "goshawkdb.io/server/consistenthash"
 	eng "goshawkdb.io/server/txnengine"
 )
@@ -45,8 +46,14 @@ func NewVersionCache(roots map[common.VarUUId]*common.Capability) versionCache {
 	return cache
 }
 
-func (vc versionCache) ValidateTransaction(cTxn *cmsgs.ClientTxn) error {
+// ValidateTransaction checks cTxn against the client's capabilities
+// and limits, and finally, if there is an authorizer, asks it.
+func (vc versionCache) ValidateTransaction(cTxn *cmsgs.ClientTxn, limits *configuration.ClientLimits, authorizer Authorizer, identity *ClientIdentity) error {
 	actions := cTxn.Actions()
+	if limits != nil && limits.MaxActionsPerTxn != 0 && actions.Len() > int(limits.MaxActionsPerTxn) {
+		limitMetrics.Add("MaxActionsPerTxnExceeded", 1)
+		return fmt.Errorf("Transaction has %v actions; at most %v are allowed", actions.Len(), limits.MaxActionsPerTxn)
+	}
 	if cTxn.Retry() {
 		for idx, l := 0, actions.Len(); idx < l; idx++ {
 			action := actions.At(idx)
@@ -65,6 +72,9 @@ func (vc versionCache) ValidateTransaction(cTxn *cmsgs.ClientTxn) error {
 			action := actions.At(idx)
 			vUUId := common.MakeVarUUId(action.VarId())
 			vc, found := vc[*vUUId]
+			if err := validateValueSize(&action, vUUId, limits); err != nil {
+				return err
+			}
 			switch act := action.Which(); act {
 			case cmsgs.CLIENTACTION_READ, cmsgs.CLIENTACTION_WRITE, cmsgs.CLIENTACTION_READWRITE:
 				if !found {
@@ -93,6 +103,31 @@ func (vc versionCache) ValidateTransaction(cTxn *cmsgs.ClientTxn) error {
 			}
 		}
 	}
+	if authorizer != nil {
+		if err := authorizer.AuthorizeTransaction(identity, common.MakeTxnId(cTxn.Id()), cTxn.Retry(), decodeClientActions(&actions)); err != nil {
+			return fmt.Errorf("Transaction not authorized: %v", err)
+		}
+	}
+	return nil
+}
+
+func validateValueSize(action *cmsgs.ClientAction, vUUId *common.VarUUId, limits *configuration.ClientLimits) error {
+	if limits == nil || limits.MaxValueSize == 0 {
+		return nil
+	}
+	var value []byte
+	switch action.Which() {
+	case cmsgs.CLIENTACTION_WRITE:
+		value = action.Write().Value()
+	case cmsgs.CLIENTACTION_READWRITE:
+		value = action.Readwrite().Value()
+	case cmsgs.CLIENTACTION_CREATE:
+		value = action.Create().Value()
+	}
+	if len(value) > int(limits.MaxValueSize) {
+		limitMetrics.Add("MaxValueSizeExceeded", 1)
+		return fmt.Errorf("Transaction writes %v bytes to object %v; at most %v are allowed", len(value), vUUId, limits.MaxValueSize)
+	}
 	return nil
 }
 
@@ -116,6 +151,28 @@ func (vc versionCache) EnsureSubset(vUUId *common.VarUUId, cap cmsgs.Capability)
 	return true
 }
 
+// Vars returns every var the client knows of: the vars in the cache
+// and everything they reference.
+func (vc versionCache) Vars() []*common.VarUUId {
+	vUUIds := make([]*common.VarUUId, 0, len(vc))
+	for vUUId, c := range vc {
+		vUUIdCopy := vUUId
+		vUUIds = append(vUUIds, &vUUIdCopy)
+		for _, ref := range c.references {
+			vUUIds = append(vUUIds, common.MakeVarUUId(ref.Id()))
+		}
+	}
+	return vUUIds
+}
+
+// UpdateFromCommit updates the cache with the writes of txn. As a
+// client may have several txns in flight, commits may be processed
+// out of order, and the cache may already hold a later version of a
+// var from another txn's commit or abort, in which case the var is
+// left alone. That includes a var which txn created: another txn's
+// updates may have reached it first. It cannot have been created by
+// another txn, as the submitter refuses to have two live txns create
+// the same var.
 func (vc versionCache) UpdateFromCommit(txn *eng.TxnReader, outcome *msgs.Outcome) {
 	txnId := txn.Id
 	clock := eng.VectorClockFromData(outcome.Commit(), false)
@@ -136,12 +193,19 @@ func (vc versionCache) UpdateFromCommit(txn *eng.TxnReader, outcome *msgs.Outcom
 					references: create.References().ToArray(),
 				}
 				vc[*vUUId] = c
-			case !found, act == msgs.ACTION_CREATE:
+			case !found:
 				panic(fmt.Sprintf("%v contained illegal action (%v) for %v", txnId, act, vUUId))
 			}
 
+			clockElem := clock.At(vUUId)
+			if c.txnId != nil {
+				cmp := c.txnId.Compare(txnId)
+				if !(clockElem > c.clockElem || (clockElem == c.clockElem && cmp != common.GT)) {
+					continue
+				}
+			}
 			c.txnId = txnId
-			c.clockElem = clock.At(vUUId)
+			c.clockElem = clockElem
 
 			switch act {
 			case msgs.ACTION_WRITE:
@@ -153,6 +217,10 @@ func (vc versionCache) UpdateFromCommit(txn *eng.TxnReader, outcome *msgs.Outcom
 				c.value = rw.Value()
 				c.references = rw.References().ToArray()
 			case msgs.ACTION_CREATE:
+				create := action.Create()
+				c.caps = common.MaxCapability
+				c.value = create.Value()
+				c.references = create.References().ToArray()
 			default:
 				panic(fmt.Sprintf("Unexpected action type on txn commit! %v %v", txnId, act))
 			}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	eng "goshawkdb.io/server/txnengine"
+	"testing"
+)
+
+// committedTxn returns a txn which wrote (or created) value to vUUId,
+// and its commit outcome, in which vUUId's clock element is clockElem.
+func committedTxn(txnNum byte, vUUId *common.VarUUId, create bool, value string, clockElem uint64) (*eng.TxnReader, *msgs.Outcome) {
+	seg := capn.NewBuffer(nil)
+	wrapper := msgs.NewRootActionListWrapper(seg)
+	actions := msgs.NewActionList(seg, 1)
+	wrapper.SetActions(actions)
+	action := actions.At(0)
+	action.SetVarId(vUUId[:])
+	if create {
+		action.SetCreate()
+		action.Create().SetValue([]byte(value))
+		action.Create().SetReferences(msgs.NewVarIdPosList(seg, 0))
+	} else {
+		action.SetWrite()
+		action.Write().SetValue([]byte(value))
+		action.Write().SetReferences(msgs.NewVarIdPosList(seg, 0))
+	}
+
+	txnId := &common.TxnId{}
+	txnId[7] = txnNum
+	txnSeg := capn.NewBuffer(nil)
+	txnCap := msgs.NewRootTxn(txnSeg)
+	txnCap.SetId(txnId[:])
+	txnCap.SetActions(server.SegToBytes(seg))
+
+	outcomeSeg := capn.NewBuffer(nil)
+	outcome := msgs.NewRootOutcome(outcomeSeg)
+	outcome.SetCommit(eng.NewVectorClock().AsMutable().Bump(vUUId, clockElem).AsData())
+	return eng.TxnReaderFromData(server.SegToBytes(txnSeg)), &outcome
+}
+
+func TestUpdateFromCommitOutOfOrder(t *testing.T) {
+	vUUId := &common.VarUUId{1}
+	vc := NewVersionCache(nil)
+	check := func(value string, txnNum byte) {
+		c, found := vc[*vUUId]
+		if !found {
+			t.Fatalf("%v not in cache", vUUId)
+		} else if string(c.value) != value || c.txnId[7] != txnNum {
+			t.Fatalf("Expected %q from txn %v; found %q from %v", value, txnNum, c.value, c.txnId)
+		} else if c.caps != common.MaxCapability {
+			t.Fatalf("Expected creator to have every capability; found %v", c.caps)
+		}
+	}
+
+	vc.UpdateFromCommit(committedTxn(1, vUUId, true, "a", 1))
+	check("a", 1)
+	// txn 3 commits after txn 2, but its outcome arrives first.
+	vc.UpdateFromCommit(committedTxn(3, vUUId, false, "c", 3))
+	check("c", 3)
+	vc.UpdateFromCommit(committedTxn(2, vUUId, false, "b", 2))
+	check("c", 3)
+
+	// Another txn's updates reached the var before its create's
+	// outcome arrived.
+	vUUId = &common.VarUUId{2}
+	vc[*vUUId] = &cached{}
+	vc.UpdateFromCommit(committedTxn(4, vUUId, true, "d", 1))
+	check("d", 4)
+}
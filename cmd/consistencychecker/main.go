@@ -2,181 +2,253 @@ package main
 
 import (
 	"bytes"
-	"encoding/binary"
+	"flag"
 	"fmt"
-	capn "github.com/glycerine/go-capnproto"
-	mdb "github.com/msackman/gomdb"
-	mdbs "github.com/msackman/gomdb/server"
 	"goshawkdb.io/common"
-	"goshawkdb.io/server"
 	msgs "goshawkdb.io/server/capnp"
 	"goshawkdb.io/server/configuration"
 	ch "goshawkdb.io/server/consistenthash"
+	"goshawkdb.io/server/datadir"
 	"goshawkdb.io/server/db"
 	eng "goshawkdb.io/server/txnengine"
-	"io/ioutil"
 	"log"
 	"os"
 	"runtime"
-	"time"
 )
 
-type store struct {
-	dir      string
-	db       *db.Databases
-	rmId     common.RMId
-	topology *configuration.Topology
-}
-
-type stores []*store
-
 func main() {
 	log.SetPrefix(common.ProductName + "ConsistencyChecker ")
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
 	log.Println(os.Args)
 
-	dirs := os.Args[1:]
+	var reportPath, repairLogPath, keyFile string
+	var repair bool
+	flag.StringVar(&keyFile, "keyfile", "", "`Path` to key file, if the data dirs are encrypted. All must use the same key.")
+	flag.StringVar(&reportPath, "report", "", "`Path` to write a JSON report of all discrepancies found (optional).")
+	flag.BoolVar(&repair, "repair", false, "Repair the data dirs. The servers must be stopped.")
+	flag.StringVar(&repairLogPath, "repair-log", "", "`Path` to append a record of every repair to (required with -repair).")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s: [flags] dir...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	dirs := flag.Args()
 	if len(dirs) == 0 {
 		log.Fatal("No dirs supplied")
 	}
+	if repair && repairLogPath == "" {
+		log.Fatal("-repair-log must be provided with -repair")
+	}
+
+	keys, err := db.LoadKeyring(keyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	db.DB.Keys = keys
 
 	runtime.GOMAXPROCS(1 + (2 * len(dirs)))
 
-	stores := stores(make([]*store, 0, len(dirs)))
-	defer stores.Shutdown()
-	for _, dir := range dirs {
-		log.Printf("...loading from %v\n", dir)
-		store := &store{dir: dir}
-		var err error
-		if err = store.LoadRMId(); err == nil {
-			if err = store.StartDisk(); err == nil {
-				err = store.LoadTopology()
-			}
-		}
-		if err != nil {
+	r := newReport(dirs)
+	if err := check(dirs, r, repair, repairLogPath); err != nil {
+		log.Println(err)
+		r.Fatal = err.Error()
+	} else {
+		log.Printf("Finished with no fatal errors. %v discrepancies found; %v repairs made.\n", len(r.Discrepancies), r.Repairs)
+	}
+	if reportPath != "" {
+		if err := r.writeTo(reportPath); err != nil {
 			log.Println(err)
-			return
 		}
-		stores = append(stores, store)
 	}
+}
+
+func check(dirs []string, r *report, repair bool, repairLogPath string) error {
+	stores, err := datadir.OpenAll(dirs)
+	if err != nil {
+		return err
+	}
+	defer stores.Shutdown()
 
 	if err := stores.CheckEqualTopology(); err != nil {
-		log.Println(err)
-		return
+		return err
 	}
 
-	locationChecker := newLocationChecker(stores)
-	if err := stores.IterateVars(locationChecker.locationCheck); err != nil {
-		log.Println(err)
-	} else {
-		log.Println("Finished with no fatal errors.")
+	var rep *repairer
+	if repair {
+		if rep, err = newRepairer(stores, repairLogPath); err != nil {
+			return err
+		}
+		defer func() {
+			r.Repairs = rep.changes
+			rep.close()
+		}()
+	}
+
+	locationChecker := newLocationChecker(stores, r, repair)
+	if err := IterateVars(stores, locationChecker.locationCheck); err != nil {
+		return err
 	}
+
+	// Fix up the ref counts first so that copying vars, which adjusts
+	// the ref counts, starts from a good state.
+	refCountChecker := newRefCountChecker(r, rep)
+	for _, s := range stores {
+		if err := refCountChecker.checkStore(s); err != nil {
+			return err
+		}
+	}
+	if rep != nil {
+		for _, vr := range locationChecker.repairs {
+			if err := rep.repairVar(vr); err != nil {
+				return err
+			}
+		}
+	}
+	for _, s := range stores {
+		if err := refCountChecker.checkReferences(s); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type locationChecker struct {
-	resolver *ch.Resolver
-	stores   map[common.RMId]*store
+	resolver  *ch.Resolver
+	stores    map[common.RMId]*datadir.Store
+	report    *report
+	repair    bool
+	repairs   []*varRepair
+	lastVUUId *common.VarUUId
 }
 
-func newLocationChecker(stores stores) *locationChecker {
-	resolver := ch.NewResolver(stores[0].topology.RMs(), stores[0].topology.TwoFInc)
-	m := make(map[common.RMId]*store, len(stores))
+func newLocationChecker(stores datadir.Stores, r *report, repair bool) *locationChecker {
+	resolver := ch.NewResolver(stores[0].Topology.RMs(), stores[0].Topology.TwoFInc)
+	m := make(map[common.RMId]*datadir.Store, len(stores))
 	for _, s := range stores {
-		m[s.rmId] = s
+		m[s.RMId] = s
 	}
 	return &locationChecker{
 		resolver: resolver,
 		stores:   m,
+		report:   r,
+		repair:   repair,
 	}
 }
 
+type replica struct {
+	store  *datadir.Store
+	varCap *msgs.Var
+	txnId  *common.TxnId
+	elem   uint64
+}
+
+// locationCheck is called with the same var once for each store that
+// holds it, in succession. We compare all the replicas the first time
+// we see each var. A store which is not one of the var's replicas may
+// still hold the var: it must have emigrated but we don't delete. A
+// replica which cannot be read (for example, it fails its checksum) is
+// treated as missing, so that repair overwrites it.
 func (lc *locationChecker) locationCheck(cell *varWrapperCell) error {
 	vUUId := cell.vUUId
-	varCap := cell.varCap
 	foundIn := cell.store
 	fmt.Printf("%v %v\n", foundIn, vUUId)
-	txnId := common.MakeTxnId(varCap.WriteTxnId())
-
-	res, err := foundIn.db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
-		return foundIn.db.ReadTxnBytesFromDisk(rtxn, txnId)
-	}).ResultError()
-	if err != nil {
-		return err
-	}
-	txnBites, ok := res.([]byte)
-	if res == nil || (ok && txnBites == nil) {
-		return fmt.Errorf("Failed to find %v from %v in %v", txnId, vUUId, foundIn)
+	if lc.lastVUUId != nil && lc.lastVUUId.Compare(vUUId) == common.EQ {
+		return nil
 	}
-	if _, _, err = capn.ReadFromMemoryZeroCopy(txnBites); err != nil {
-		return err
+	lc.lastVUUId = vUUId
+
+	positions := lc.positions(cell)
+	if positions == nil {
+		lc.report.add(locationDiscrepancy, foundIn, vUUId, nil, "No copy of %v can be read: unable to check or repair", vUUId)
+		return nil
 	}
-	positions := varCap.Positions().ToArray()
 	rmIds, err := lc.resolver.ResolveHashCodes(positions)
 	if err != nil {
 		return err
 	}
-	foundLocal := false
+
+	var newest *replica
+	replicas := make([]*replica, 0, len(rmIds))
+	missing := []*datadir.Store{}
 	for _, rmId := range rmIds {
-		if foundLocal = rmId == foundIn.rmId; foundLocal {
-			break
+		s, found := lc.stores[rmId]
+		if !found {
+			continue
+		}
+		varCap, readErr := cell.varCap, cell.decodeErr
+		if s != foundIn {
+			varCap, readErr = s.ReadVar(vUUId)
+		}
+		if readErr != nil {
+			lc.report.add(locationDiscrepancy, s, vUUId, nil, "Unable to read %v in %v: %v", vUUId, s, readErr)
+			missing = append(missing, s)
+			continue
+		} else if varCap == nil {
+			lc.report.add(locationDiscrepancy, s, vUUId, nil, "Failed to find %v in %v (%v, %v, %v)", vUUId, s, rmIds, positions, foundIn)
+			missing = append(missing, s)
+			continue
+		}
+		r := &replica{
+			store:  s,
+			varCap: varCap,
+			txnId:  common.MakeTxnId(varCap.WriteTxnId()),
+			elem:   eng.VectorClockFromData(varCap.WriteTxnClock(), false).At(vUUId),
+		}
+		replicas = append(replicas, r)
+		if newest == nil || r.elem > newest.elem {
+			newest = r
 		}
 	}
-	if !foundLocal {
-		// It must have emigrated but we don't delete.
-		txnId = nil
+
+	if newest == nil {
+		// Nothing readable on the var's replicas to repair from.
+		return nil
 	}
-	for _, rmId := range rmIds {
-		if rmId == foundIn.rmId {
+	source := newest.store
+	behind := missing
+	for _, r := range replicas {
+		if r == newest {
 			continue
-		} else if remote, found := lc.stores[rmId]; found {
-			res, err := remote.db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
-				bites, err := rtxn.Get(remote.db.Vars, vUUId[:])
-				if err == mdb.NotFound {
-					return nil
-				} else if err == nil {
-					return bites
-				} else {
-					return nil
-				}
-			}).ResultError()
-			if err != nil {
-				return err
-			}
-			varBites, ok := res.([]byte)
-			if res == nil || (ok && varBites == nil) {
-				return fmt.Errorf("Failed to find %v in %v (%v, %v, %v)", vUUId, remote, rmIds, positions, foundIn)
-			} else {
-				seg, _, err := capn.ReadFromMemoryZeroCopy(varBites)
-				if err != nil {
-					return err
-				}
-				remoteTxnId := common.MakeTxnId(msgs.ReadRootVar(seg).WriteTxnId())
-				if txnId == nil {
-					txnId = remoteTxnId
-				}
-				if remoteTxnId.Compare(txnId) != common.EQ {
-					return fmt.Errorf("%v on %v is at %v; on %v is at %v", vUUId, foundIn, txnId, remote, remoteTxnId)
-				}
+		} else if r.txnId.Compare(newest.txnId) != common.EQ {
+			lc.report.add(locationDiscrepancy, r.store, vUUId, r.txnId, "%v on %v is at %v; on %v is at %v", vUUId, newest.store, newest.txnId, r.store, r.txnId)
+			if r.elem == newest.elem {
+				// Different writes, so the var's element must
+				// differ: one replica's clock failed to advance.
+				lc.report.add(clockConflict, r.store, vUUId, r.txnId, "Write txn clock element is %v both here and on %v, which is at %v", r.elem, newest.store, newest.txnId)
 			}
+			behind = append(behind, r.store)
+		} else if r.elem != newest.elem {
+			// Same write txn, so the clock element for the var
+			// must be the same too.
+			lc.report.add(clockInconsistency, r.store, vUUId, r.txnId, "Write txn clock element is %v on %v but %v here", newest.elem, newest.store, r.elem)
 		}
 	}
+	if lc.repair && len(behind) != 0 {
+		lc.repairs = append(lc.repairs, &varRepair{
+			vUUId:   vUUId,
+			source:  source,
+			targets: behind,
+		})
+	}
 	return nil
 }
 
-func (ss stores) CheckEqualTopology() error {
-	var first *store
-	for idx, s := range ss {
-		if idx == 0 {
-			first = s
-		} else if !first.topology.Configuration.Equal(s.topology.Configuration) {
-			return fmt.Errorf("Unequal topologies: %v has %v; %v has %v",
-				first, first.topology, s, s.topology)
+// positions returns the var's positions from the first copy of it that
+// can be read, or nil if there is none.
+func (lc *locationChecker) positions(cell *varWrapperCell) []uint8 {
+	if cell.varCap != nil {
+		return cell.varCap.Positions().ToArray()
+	}
+	for _, s := range lc.stores {
+		if varCap, err := s.ReadVar(cell.vUUId); err == nil && varCap != nil {
+			return varCap.Positions().ToArray()
 		}
 	}
 	return nil
 }
 
-func (ss stores) IterateVars(f func(*varWrapperCell) error) error {
+func IterateVars(ss datadir.Stores, f func(*varWrapperCell) error) error {
 	is := &iterateState{
 		stores:   ss,
 		wrappers: make([]*varWrapper, len(ss)),
@@ -185,125 +257,21 @@ func (ss stores) IterateVars(f func(*varWrapperCell) error) error {
 	return is.iterate()
 }
 
-func (ss stores) Shutdown() {
-	for _, s := range ss {
-		s.Shutdown()
-	}
-}
-
-func (s *store) Shutdown() {
-	if s.db == nil {
-		return
-	}
-	s.db.Shutdown()
-	s.db = nil
-}
-
-func (s *store) String() string {
-	return fmt.Sprintf("%v(%v)", s.rmId, s.dir)
-}
-
-func (s *store) LoadRMId() error {
-	rmIdBytes, err := ioutil.ReadFile(s.dir + "/rmid")
-	if err != nil {
-		return err
-	}
-	s.rmId = common.RMId(binary.BigEndian.Uint32(rmIdBytes))
-	return nil
-}
-
-func (s *store) StartDisk() error {
-	log.Printf("Starting disk server on %v", s.dir)
-	disk, err := mdbs.NewMDBServer(s.dir, 0, 0600, server.MDBInitialSize, 2, 10*time.Millisecond, db.DB)
-	if err != nil {
-		return err
-	}
-	s.db = disk.(*db.Databases)
-	return nil
-}
-
-func (s *store) LoadTopology() error {
-	res, err := s.db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
-		bites, err := rtxn.Get(s.db.Vars, configuration.TopologyVarUUId[:])
-		if err != nil {
-			rtxn.Error(err)
-			return nil
-		}
-		seg, _, err := capn.ReadFromMemoryZeroCopy(bites)
-		if err != nil {
-			rtxn.Error(err)
-			return nil
-		}
-		varCap := msgs.ReadRootVar(seg)
-		txnId := common.MakeTxnId(varCap.WriteTxnId())
-		bites = s.db.ReadTxnBytesFromDisk(rtxn, txnId)
-		if bites == nil {
-			rtxn.Error(fmt.Errorf("Unable to find txn for topology: %v", txnId))
-			return nil
-		}
-		txnReader := eng.TxnReaderFromData(bites)
-		actions := txnReader.Actions(true)
-		if l := actions.Actions().Len(); l != 1 {
-			rtxn.Error(fmt.Errorf("Topology txn has %v actions; expected 1", l))
-			return nil
-		}
-		action := actions.Actions().At(0)
-		var refs msgs.VarIdPos_List
-		switch action.Which() {
-		case msgs.ACTION_WRITE:
-			w := action.Write()
-			bites = w.Value()
-			refs = w.References()
-		case msgs.ACTION_READWRITE:
-			rw := action.Readwrite()
-			bites = rw.Value()
-			refs = rw.References()
-		case msgs.ACTION_CREATE:
-			c := action.Create()
-			bites = c.Value()
-			refs = c.References()
-		default:
-			rtxn.Error(fmt.Errorf("Expected topology txn action to be w, rw, or c; found %v", action.Which()))
-			return nil
-		}
-
-		if refs.Len() != 1 {
-			rtxn.Error(fmt.Errorf("Topology txn action has %v references; expected 1", refs.Len()))
-			return nil
-		}
-
-		seg, _, err = capn.ReadFromMemoryZeroCopy(bites)
-		if err != nil {
-			rtxn.Error(err)
-			return nil
-		}
-		topology, err := configuration.TopologyFromCap(txnId, &refs, bites)
-		if err != nil {
-			rtxn.Error(err)
-			return nil
-		}
-		return topology
-	}).ResultError()
-	if err != nil {
-		return err
-	}
-	s.topology = res.(*configuration.Topology)
-	return nil
-}
-
 type varWrapper struct {
 	*iterateState
-	store   *store
+	store   *datadir.Store
 	c       chan *varWrapperCell
 	curCell *varWrapperCell
 }
 
+// If the var cannot be decoded, varCap is nil and decodeErr is set.
 type varWrapperCell struct {
 	*varWrapper
-	vUUId  *common.VarUUId
-	varCap *msgs.Var
-	err    error
-	other  *varWrapperCell
+	vUUId     *common.VarUUId
+	varCap    *msgs.Var
+	decodeErr error
+	err       error
+	other     *varWrapperCell
 }
 
 func (vw *varWrapper) start() {
@@ -314,33 +282,29 @@ func (vw *varWrapper) start() {
 	c1.other, c2.other = c2, c1
 
 	curCell := c1
-	_, err := vw.store.db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
-		rtxn.WithCursor(vw.store.db.Vars, func(cursor *mdbs.Cursor) interface{} {
-			vUUIdBytes, varBytes, err := cursor.Get(nil, nil, mdb.FIRST)
+	_, err := vw.store.DB.ReadonlyTransaction(func(rtxn db.RTxn) interface{} {
+		rtxn.WithCursor(db.Vars, func(cursor db.Cursor) interface{} {
+			vUUIdBytes, varBytes, err := cursor.First()
 			if err != nil {
-				cursor.Error(fmt.Errorf("Err on finding first var in %v: %v", vw.store, err))
+				rtxn.Error(fmt.Errorf("Err on finding first var in %v: %v", vw.store, err))
 				return nil
 			}
 			if !bytes.Equal(vUUIdBytes, configuration.TopologyVarUUId[:]) {
 				vUUId := common.MakeVarUUId(vUUIdBytes)
-				cursor.Error(fmt.Errorf("Err on finding first var in %v: expected to find topology var, but found %v instead! (%v)", vw.store, vUUId, varBytes))
+				rtxn.Error(fmt.Errorf("Err on finding first var in %v: expected to find topology var, but found %v instead! (%v)", vw.store, vUUId, varBytes))
 				return nil
 			}
-			for ; err == nil; vUUIdBytes, varBytes, err = cursor.Get(nil, nil, mdb.NEXT) {
+			for ; err == nil; vUUIdBytes, varBytes, err = cursor.Next() {
 				vUUId := common.MakeVarUUId(vUUIdBytes)
-				seg, _, err := capn.ReadFromMemoryZeroCopy(varBytes)
-				if err != nil {
-					cursor.Error(fmt.Errorf("Err on decoding %v in %v: %v (%v)", vUUId, vw.store, err, varBytes))
-					return nil
-				}
-				varCap := msgs.ReadRootVar(seg)
+				varCap, err := vw.store.VarFromData(vUUIdBytes, varBytes)
 				curCell.vUUId = vUUId
-				curCell.varCap = &varCap
+				curCell.varCap = varCap
+				curCell.decodeErr = err
 				vw.c <- curCell
 				curCell = curCell.other
 			}
-			if err != nil && err != mdb.NotFound {
-				cursor.Error(err)
+			if err != nil && err != db.NotFound {
+				rtxn.Error(err)
 			}
 			return nil
 		})
@@ -364,7 +328,7 @@ func (vw *varWrapper) next() error {
 }
 
 type iterateState struct {
-	stores   stores
+	stores   datadir.Stores
 	wrappers []*varWrapper
 	f        func(*varWrapperCell) error
 }
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/configuration"
+	ch "goshawkdb.io/server/consistenthash"
+	"goshawkdb.io/server/datadir"
+	"goshawkdb.io/server/db"
+	eng "goshawkdb.io/server/txnengine"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func testVarUUId(n byte) *common.VarUUId {
+	vUUId := common.MakeVarUUId(make([]byte, common.KeyLen))
+	vUUId[0] = n
+	return vUUId
+}
+
+func testTxnId(n byte) *common.TxnId {
+	txnId := &common.TxnId{}
+	txnId[7] = n
+	return txnId
+}
+
+// newTestStores returns n memory backed stores, with RMIds from 1, in
+// a cluster of n RMs of which every RM is a replica of every var. Each
+// holds the topology var.
+func newTestStores(t *testing.T, n int) datadir.Stores {
+	rmIds := make(common.RMIds, n)
+	for idx := range rmIds {
+		rmIds[idx] = common.RMId(idx + 1)
+	}
+	topology := &configuration.Topology{Configuration: &configuration.Configuration{Version: 1}, TwoFInc: uint16(n)}
+	topology.SetRMs(rmIds)
+	stores := make(datadir.Stores, n)
+	for idx, rmId := range rmIds {
+		s := &datadir.Store{RMId: rmId, DB: db.DB.WithStorage(db.NewMemoryStorage()), Topology: topology}
+		stores[idx] = s
+		putTestVar(t, s, configuration.TopologyVarUUId, 1, 1)
+	}
+	return stores
+}
+
+func testTxn(vUUId *common.VarUUId, txnId *common.TxnId, refs ...*common.VarUUId) []byte {
+	seg := capn.NewBuffer(nil)
+	wrapper := msgs.NewRootActionListWrapper(seg)
+	actions := msgs.NewActionList(seg, 1)
+	wrapper.SetActions(actions)
+	action := actions.At(0)
+	action.SetVarId(vUUId[:])
+	action.SetWrite()
+	action.Write().SetValue([]byte{})
+	refsCap := msgs.NewVarIdPosList(seg, len(refs))
+	for idx, ref := range refs {
+		refCap := refsCap.At(idx)
+		refCap.SetId(ref[:])
+		refCap.SetPositions(seg.NewUInt8List(3))
+		capability := cmsgs.NewCapability(seg)
+		capability.SetReadWrite()
+		refCap.SetCapability(capability)
+	}
+	action.Write().SetReferences(refsCap)
+
+	txnSeg := capn.NewBuffer(nil)
+	txnCap := msgs.NewRootTxn(txnSeg)
+	txnCap.SetId(txnId[:])
+	txnCap.SetActions(server.SegToBytes(seg))
+	return server.SegToBytes(txnSeg)
+}
+
+// putTestVar writes vUUId and its write txn to the store, as the var
+// itself would, with clockElem as the var's element of the txn's
+// clock.
+func putTestVar(t *testing.T, s *datadir.Store, vUUId *common.VarUUId, txnNum byte, clockElem uint64, refs ...*common.VarUUId) {
+	txnId := testTxnId(txnNum)
+	seg := capn.NewBuffer(nil)
+	varCap := msgs.NewRootVar(seg)
+	varCap.SetId(vUUId[:])
+	varCap.SetPositions(seg.NewUInt8List(3))
+	varCap.SetWriteTxnId(txnId[:])
+	varCap.SetWriteTxnClock(eng.NewVectorClock().AsMutable().Bump(vUUId, clockElem).AsData())
+	varCap.SetWritesClock(eng.NewVectorClock().AsData())
+
+	writeTestStore(t, s, func(rwtxn db.RWTxn) error {
+		if err := s.DB.WriteTxnToDisk(rwtxn, txnId, testTxn(vUUId, txnId, refs...)); err != nil {
+			return err
+		}
+		return rwtxn.Put(db.Vars, vUUId[:], s.DB.SealRecord(db.Vars, vUUId[:], server.SegToBytes(seg)))
+	})
+}
+
+func writeTestStore(t *testing.T, s *datadir.Store, f func(rwtxn db.RWTxn) error) {
+	_, err := s.DB.ReadWriteTransaction(func(rwtxn db.RWTxn) interface{} {
+		if err := f(rwtxn); err != nil {
+			rwtxn.Error(err)
+		}
+		return nil
+	}).ResultError()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkTestStores runs the same checks, in the same order, as
+// check. IterateVars holds a read txn open on every store whilst
+// locationCheck reads the others, which memory storage, running one
+// txn at a time, cannot do. So each var is instead passed to
+// locationCheck from the first store which holds it.
+func checkTestStores(t *testing.T, stores datadir.Stores, rep *repairer) *report {
+	r := newReport(nil)
+	lc := newLocationChecker(stores, r, rep != nil)
+	seen := make(map[string]*datadir.Store)
+	var keys []string
+	for _, s := range stores {
+		err := s.ForEach(db.Vars, func(key, value []byte) error {
+			if _, found := seen[string(key)]; !found && !bytes.Equal(key, configuration.TopologyVarUUId[:]) {
+				seen[string(key)] = s
+				keys = append(keys, string(key))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s, vUUId := seen[key], common.MakeVarUUId([]byte(key))
+		cell := &varWrapperCell{varWrapper: &varWrapper{store: s}, vUUId: vUUId}
+		cell.varCap, cell.decodeErr = s.ReadVar(vUUId)
+		if err := lc.locationCheck(cell); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rc := newRefCountChecker(r, rep)
+	for _, s := range stores {
+		if err := rc.checkStore(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rep != nil {
+		for _, vr := range lc.repairs {
+			if err := rep.repairVar(vr); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, s := range stores {
+		if err := rc.checkReferences(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+// seedDiscrepancies writes vars to three stores with one instance of
+// each kind of discrepancy that can be repaired, plus references to a
+// var which is on no replica. It returns the counts expected, and the
+// store which has fallen behind: the last of the vars' replicas, so
+// that the others are found to be newer when the clock elements are
+// equal.
+func seedDiscrepancies(t *testing.T, stores datadir.Stores) (map[discrepancyKind]int, *datadir.Store) {
+	rmIds, err := ch.NewResolver(stores[0].Topology.RMs(), stores[0].Topology.TwoFInc).ResolveHashCodes([]uint8{0, 0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var one, two, three *datadir.Store
+	for _, s := range stores {
+		switch s.RMId {
+		case rmIds[0]:
+			one = s
+		case rmIds[1]:
+			two = s
+		default:
+			three = s
+		}
+	}
+	stale, conflicted, dangling, miscounted := testVarUUId(1), testVarUUId(2), testVarUUId(3), testVarUUId(4)
+	for _, s := range stores {
+		putTestVar(t, s, dangling, 0x30, 1, testVarUUId(9))
+		putTestVar(t, s, miscounted, 0x40, 1)
+	}
+	// Three is behind.
+	putTestVar(t, one, stale, 0x11, 2)
+	putTestVar(t, two, stale, 0x11, 2)
+	putTestVar(t, three, stale, 0x10, 1)
+	// Three has a different write with the same clock element.
+	putTestVar(t, one, conflicted, 0x20, 1)
+	putTestVar(t, two, conflicted, 0x20, 1)
+	putTestVar(t, three, conflicted, 0x21, 1)
+
+	writeTestStore(t, one, func(rwtxn db.RWTxn) error {
+		bites := []byte{0, 0, 0, 0}
+		binary.BigEndian.PutUint32(bites, 5)
+		return rwtxn.Put(db.TransactionRefs, testTxnId(0x40)[:], bites)
+	})
+	writeTestStore(t, two, func(rwtxn db.RWTxn) error {
+		return two.DB.WriteTxnToDisk(rwtxn, testTxnId(0x50), testTxn(testVarUUId(5), testTxnId(0x50)))
+	})
+
+	return map[discrepancyKind]int{
+		locationDiscrepancy: 2,
+		clockConflict:       1,
+		refCountMismatch:    1,
+		orphanedTxn:         1,
+		orphanedTxnRef:      1,
+		danglingReference:   3,
+	}, three
+}
+
+func checkCounts(t *testing.T, r *report, expected map[discrepancyKind]int) {
+	total := 0
+	for kind, count := range expected {
+		if r.Counts[kind] != count {
+			t.Fatalf("Expected %v %v discrepancies; got %v: %v", count, kind, r.Counts[kind], r.Counts)
+		}
+		total += count
+	}
+	if len(r.Discrepancies) != total {
+		t.Fatalf("Expected %v discrepancies; got %v: %v", total, len(r.Discrepancies), r.Counts)
+	}
+}
+
+func TestCheckReportsDiscrepancies(t *testing.T) {
+	stores := newTestStores(t, 3)
+	defer stores.Shutdown()
+	expected, behind := seedDiscrepancies(t, stores)
+
+	r := checkTestStores(t, stores, nil)
+	checkCounts(t, r, expected)
+	for _, d := range r.Discrepancies {
+		if (d.Kind == locationDiscrepancy || d.Kind == clockConflict) && d.RMId != fmt.Sprint(behind.RMId) {
+			t.Fatalf("Expected only %v to have location discrepancies; got %v", behind, d)
+		}
+	}
+
+	// Without repair, nothing changes.
+	checkCounts(t, checkTestStores(t, stores, nil), expected)
+}
+
+// Repair leaves only the discrepancies which cannot be repaired, and
+// records every change in the repair log before making it.
+func TestCheckRepairs(t *testing.T) {
+	stores := newTestStores(t, 3)
+	defer stores.Shutdown()
+	_, behind := seedDiscrepancies(t, stores)
+
+	dir, err := ioutil.TempDir("", "consistencychecker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "repair.log")
+	rep, err := newRepairer(stores, logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestStores(t, stores, rep)
+	if err = rep.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	actions := make(map[repairAction]int)
+	dec := json.NewDecoder(file)
+	for dec.More() {
+		entry := &repairEntry{}
+		if err = dec.Decode(entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.Action == copyVar && entry.RMId != fmt.Sprint(behind.RMId) {
+			t.Fatalf("Expected vars to be copied only to %v; got %v", behind, entry)
+		}
+		actions[entry.Action]++
+	}
+	expected := map[repairAction]int{copyVar: 2, setRefCount: 1, deleteTxn: 1, deleteTxnRef: 1}
+	for action, count := range expected {
+		if actions[action] != count {
+			t.Fatalf("Expected %v %v repairs; got %v", count, action, actions)
+		}
+	}
+	if len(actions) != len(expected) || rep.changes != 5 {
+		t.Fatalf("Expected 5 repairs; got %v: %v", rep.changes, actions)
+	}
+
+	// The repaired var's old write txn has lost its only reference.
+	for _, txnNum := range []byte{0x10, 0x21} {
+		if txn, err := behind.ReadTxn(testTxnId(txnNum)); err != nil || txn != nil {
+			t.Fatalf("Expected %v to have been deleted from %v; got %v, %v", testTxnId(txnNum), behind, txn, err)
+		}
+	}
+	checkCounts(t, checkTestStores(t, stores, nil), map[discrepancyKind]int{danglingReference: 3})
+}
+
+// The vars are iterated in order, once per store that holds them,
+// without the topology var.
+func TestIterateVars(t *testing.T) {
+	stores := newTestStores(t, 2)
+	defer stores.Shutdown()
+	putTestVar(t, stores[0], testVarUUId(1), 0x10, 1)
+	putTestVar(t, stores[1], testVarUUId(1), 0x10, 1)
+	putTestVar(t, stores[1], testVarUUId(2), 0x20, 1)
+	putTestVar(t, stores[0], testVarUUId(3), 0x30, 1)
+
+	var found []byte
+	err := IterateVars(stores, func(cell *varWrapperCell) error {
+		if cell.varCap == nil || cell.decodeErr != nil {
+			t.Fatalf("Expected %v to be decoded; got %v", cell.vUUId, cell.decodeErr)
+		}
+		found = append(found, cell.vUUId[0])
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(found) != string([]byte{1, 1, 2, 3}) {
+		t.Fatalf("Expected vars [1 1 2 3]; got %v", found)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/binary"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	"goshawkdb.io/server/configuration"
+	"goshawkdb.io/server/datadir"
+	"goshawkdb.io/server/db"
+	eng "goshawkdb.io/server/txnengine"
+)
+
+// Every var on disk holds one reference to its write txn (see
+// db.WriteTxnToDisk and db.DeleteTxnFromDisk). So within each store,
+// the count in TransactionRefs for a txn must equal the number of
+// vars whose write txn it is, and every txn must be referenced by at
+// least one var.
+type refCountChecker struct {
+	report *report
+	// nil unless we are repairing
+	repairer *repairer
+	// all the VarUUIds found in any store
+	vars map[common.VarUUId]server.EmptyStruct
+}
+
+func newRefCountChecker(r *report, repairer *repairer) *refCountChecker {
+	return &refCountChecker{
+		report:   r,
+		repairer: repairer,
+		vars:     make(map[common.VarUUId]server.EmptyStruct),
+	}
+}
+
+func (rc *refCountChecker) checkStore(s *datadir.Store) error {
+	expected := make(map[common.TxnId]uint32)
+	err := s.ForEach(db.Vars, func(key, value []byte) error {
+		vUUId := common.MakeVarUUId(key)
+		rc.vars[*vUUId] = server.EmptyStructVal
+		varCap, err := s.VarFromData(key, value)
+		if err != nil {
+			rc.report.add(undecodable, s, vUUId, nil, "Unable to decode var: %v", err)
+			return nil
+		}
+		txnId := common.MakeTxnId(varCap.WriteTxnId())
+		expected[*txnId]++
+
+		// The writes clock accumulates the clocks of the var's
+		// previous writes. Each write must advance the var's element,
+		// so if the current write txn's element is behind, the var's
+		// clock has gone backwards.
+		if vUUId.Compare(configuration.TopologyVarUUId) != common.EQ {
+			txnElem := eng.VectorClockFromData(varCap.WriteTxnClock(), false).At(vUUId)
+			writesElem := eng.VectorClockFromData(varCap.WritesClock(), false).At(vUUId)
+			if txnElem == 0 {
+				rc.report.add(clockInconsistency, s, vUUId, txnId, "Write txn clock has no element for the var")
+			} else if writesElem > txnElem {
+				rc.report.add(clockRegression, s, vUUId, txnId, "Write txn clock element %v is behind element %v of the var's previous writes", txnElem, writesElem)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	actual := make(map[common.TxnId]uint32)
+	err = s.ForEach(db.TransactionRefs, func(key, value []byte) error {
+		txnId := common.MakeTxnId(key)
+		if len(value) != 4 {
+			rc.report.add(undecodable, s, nil, txnId, "Txn ref count has length %v", len(value))
+			return nil
+		}
+		actual[*txnId] = binary.BigEndian.Uint32(value)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	stored := make(map[common.TxnId]server.EmptyStruct)
+	err = s.ForEach(db.Transactions, func(key, value []byte) error {
+		txnId := common.MakeTxnId(key)
+		stored[*txnId] = server.EmptyStructVal
+		if _, err := s.DB.OpenRecord(db.Transactions, key, value); err != nil {
+			rc.report.add(undecodable, s, nil, txnId, "Unable to decode txn: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Only once we've finished reading do we start making repairs.
+	for txnId := range stored {
+		if _, found := expected[txnId]; !found {
+			txnIdCopy := txnId
+			rc.report.add(orphanedTxn, s, nil, &txnIdCopy, "Txn is not the write txn of any var")
+			if rc.repairer != nil {
+				if err = rc.repairer.deleteTxn(s, &txnIdCopy); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for txnId, count := range expected {
+		txnIdCopy := txnId
+		if _, found := stored[txnId]; !found {
+			rc.report.add(missingTxn, s, nil, &txnIdCopy, "Txn is the write txn of %v vars but is not stored", count)
+			if rc.repairer != nil {
+				if err = rc.repairer.copyTxn(s, &txnIdCopy); err != nil {
+					return err
+				}
+			}
+		}
+		actualCount, found := actual[txnId]
+		if !found {
+			rc.report.add(refCountMismatch, s, nil, &txnIdCopy, "Txn is the write txn of %v vars but has no ref count", count)
+		} else if actualCount != count {
+			rc.report.add(refCountMismatch, s, nil, &txnIdCopy, "Txn is the write txn of %v vars but has ref count %v", count, actualCount)
+		}
+		if rc.repairer != nil && (!found || actualCount != count) {
+			if err = rc.repairer.setRefCount(s, &txnIdCopy, count); err != nil {
+				return err
+			}
+		}
+	}
+	for txnId, count := range actual {
+		if _, found := expected[txnId]; !found {
+			txnIdCopy := txnId
+			rc.report.add(orphanedTxnRef, s, nil, &txnIdCopy, "Txn has ref count %v but is not the write txn of any var", count)
+			if rc.repairer != nil {
+				if err = rc.repairer.deleteTxnRef(s, &txnIdCopy); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkReferences must be called after checkStore has been called for
+// every store. It finds references from vars to VarUUIds which are not
+// on any replica.
+func (rc *refCountChecker) checkReferences(s *datadir.Store) error {
+	return s.ForEach(db.Vars, func(key, value []byte) error {
+		vUUId := common.MakeVarUUId(key)
+		if vUUId.Compare(configuration.TopologyVarUUId) == common.EQ {
+			return nil
+		}
+		varCap, err := s.VarFromData(key, value)
+		if err != nil {
+			return nil // already reported
+		}
+		_, refs, err := s.ReadVarValue(vUUId, varCap)
+		if err != nil {
+			rc.report.add(missingTxn, s, vUUId, common.MakeTxnId(varCap.WriteTxnId()), "Unable to read value: %v", err)
+			return nil
+		}
+		for idx, l := 0, refs.Len(); idx < l; idx++ {
+			target := common.MakeVarUUId(refs.At(idx).Id())
+			if _, found := rc.vars[*target]; !found {
+				rc.report.add(danglingReference, s, vUUId, nil, "Reference to %v which is not on any replica", target)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server/datadir"
+	"goshawkdb.io/server/db"
+	"log"
+	"os"
+	"time"
+)
+
+// repairer makes changes to the data dirs. It must only ever be used
+// against the data dirs of stopped servers. Every change it makes is
+// appended to the repair log, one JSON object per line, before the
+// change is made.
+type repairer struct {
+	stores  datadir.Stores
+	file    *os.File
+	enc     *json.Encoder
+	changes int
+}
+
+type repairAction string
+
+const (
+	copyVar      repairAction = "CopyVar"
+	copyTxn      repairAction = "CopyTxn"
+	setRefCount  repairAction = "SetRefCount"
+	deleteTxn    repairAction = "DeleteTxn"
+	deleteTxnRef repairAction = "DeleteTxnRef"
+)
+
+type repairEntry struct {
+	Time    time.Time
+	Action  repairAction
+	RMId    string
+	Dir     string
+	VarUUId string `json:",omitempty"`
+	TxnId   string `json:",omitempty"`
+	Detail  string
+}
+
+// varRepair records that the targets are missing the var, or hold an
+// older version of it than the source.
+type varRepair struct {
+	vUUId   *common.VarUUId
+	source  *datadir.Store
+	targets []*datadir.Store
+}
+
+func newRepairer(stores datadir.Stores, logPath string) (*repairer, error) {
+	file, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &repairer{
+		stores: stores,
+		file:   file,
+		enc:    json.NewEncoder(file),
+	}, nil
+}
+
+func (r *repairer) close() error {
+	return r.file.Close()
+}
+
+func (r *repairer) record(action repairAction, s *datadir.Store, vUUId *common.VarUUId, txnId *common.TxnId, format string, args ...interface{}) error {
+	entry := &repairEntry{
+		Time:   time.Now(),
+		Action: action,
+		RMId:   fmt.Sprint(s.RMId),
+		Dir:    s.Dir,
+		Detail: fmt.Sprintf(format, args...),
+	}
+	if vUUId != nil {
+		entry.VarUUId = fmt.Sprintf("%x", vUUId[:])
+	}
+	if txnId != nil {
+		entry.TxnId = fmt.Sprintf("%x", txnId[:])
+	}
+	log.Printf("Repair %v: %v: %v\n", action, s, entry.Detail)
+	if err := r.enc.Encode(entry); err != nil {
+		return err
+	}
+	// The log must be on disk before we change anything.
+	if err := r.file.Sync(); err != nil {
+		return err
+	}
+	r.changes++
+	return nil
+}
+
+func (r *repairer) readRaw(s *datadir.Store, table db.Table, key []byte) ([]byte, error) {
+	res, err := s.DB.ReadonlyTransaction(func(rtxn db.RTxn) interface{} {
+		bites, err := rtxn.Get(table, key)
+		if err == db.NotFound {
+			return nil
+		} else if err != nil {
+			rtxn.Error(err)
+			return nil
+		}
+		return bites
+	}).ResultError()
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res.([]byte), nil
+}
+
+func (r *repairer) write(s *datadir.Store, f func(rwtxn db.RWTxn) error) error {
+	_, err := s.DB.ReadWriteTransaction(func(rwtxn db.RWTxn) interface{} {
+		if err := f(rwtxn); err != nil {
+			rwtxn.Error(err)
+		}
+		return nil
+	}).ResultError()
+	return err
+}
+
+// repairVar copies the var and its write txn from the source to each
+// target, in the same way as the var itself writes to disk: the new
+// txn gains a reference and the old txn loses one.
+func (r *repairer) repairVar(vr *varRepair) error {
+	vUUId := vr.vUUId
+	varBites, err := r.readRaw(vr.source, db.Vars, vUUId[:])
+	if err != nil {
+		return err
+	} else if varBites == nil {
+		return fmt.Errorf("%v has disappeared from %v", vUUId, vr.source)
+	}
+	varCap, err := vr.source.VarFromData(vUUId[:], varBites)
+	if err != nil {
+		return err
+	}
+	// The target may not share the source's key.
+	payload, err := vr.source.DB.OpenRecord(db.Vars, vUUId[:], varBites)
+	if err != nil {
+		return err
+	}
+	txnId := common.MakeTxnId(varCap.WriteTxnId())
+	txn, err := vr.source.ReadTxn(txnId)
+	if err != nil {
+		return err
+	} else if txn == nil {
+		log.Printf("Unable to repair %v: %v is missing its write txn %v\n", vUUId, vr.source, txnId)
+		return nil
+	}
+
+	for _, target := range vr.targets {
+		// An unreadable var is overwritten as if missing. Its write
+		// txn is unknown, so it keeps its ref count: the ref count
+		// check has already excluded the unreadable var.
+		oldVarCap, readErr := target.ReadVar(vUUId)
+		var oldTxnId *common.TxnId
+		if readErr == nil && oldVarCap != nil {
+			oldTxnId = common.MakeTxnId(oldVarCap.WriteTxnId())
+		}
+		detail := fmt.Sprintf("previous write txn %v", oldTxnId)
+		if readErr != nil {
+			detail = fmt.Sprintf("previous var unreadable: %v", readErr)
+		}
+		if err = r.record(copyVar, target, vUUId, txnId, "Copying from %v; %v", vr.source, detail); err != nil {
+			return err
+		}
+		err = r.write(target, func(rwtxn db.RWTxn) error {
+			if err := target.DB.WriteTxnToDisk(rwtxn, txnId, txn.Data); err != nil {
+				return err
+			}
+			if err := rwtxn.Put(db.Vars, vUUId[:], target.DB.SealCompressibleRecord(db.Vars, vUUId[:], payload)); err != nil {
+				return err
+			}
+			// The server rebuilds the reverse reference index on start up.
+			if err := db.SetReverseRefsComplete(rwtxn, false); err != nil {
+				return err
+			}
+			if oldTxnId != nil {
+				return target.DB.DeleteTxnFromDisk(rwtxn, oldTxnId)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *repairer) setRefCount(s *datadir.Store, txnId *common.TxnId, count uint32) error {
+	if err := r.record(setRefCount, s, nil, txnId, "Setting ref count to %v", count); err != nil {
+		return err
+	}
+	return r.write(s, func(rwtxn db.RWTxn) error {
+		bites := []byte{0, 0, 0, 0}
+		binary.BigEndian.PutUint32(bites, count)
+		return rwtxn.Put(db.TransactionRefs, txnId[:], bites)
+	})
+}
+
+// copyTxn copies the txn from the first other store which has it.
+func (r *repairer) copyTxn(s *datadir.Store, txnId *common.TxnId) error {
+	for _, source := range r.stores {
+		if source == s {
+			continue
+		}
+		txn, err := source.ReadTxn(txnId)
+		if err != nil {
+			return err
+		} else if txn == nil {
+			continue
+		}
+		if err = r.record(copyTxn, s, nil, txnId, "Copying from %v", source); err != nil {
+			return err
+		}
+		return r.write(s, func(rwtxn db.RWTxn) error {
+			return rwtxn.Put(db.Transactions, txnId[:], s.DB.SealCompressibleRecord(db.Transactions, txnId[:], txn.Data))
+		})
+	}
+	log.Printf("Unable to repair %v: %v is not held by any other store\n", s, txnId)
+	return nil
+}
+
+func (r *repairer) deleteTxn(s *datadir.Store, txnId *common.TxnId) error {
+	if err := r.record(deleteTxn, s, nil, txnId, "Deleting orphaned txn"); err != nil {
+		return err
+	}
+	return r.write(s, func(rwtxn db.RWTxn) error {
+		if err := rwtxn.Del(db.Transactions, txnId[:]); err != nil && err != db.NotFound {
+			return err
+		}
+		return nil
+	})
+}
+
+func (r *repairer) deleteTxnRef(s *datadir.Store, txnId *common.TxnId) error {
+	if err := r.record(deleteTxnRef, s, nil, txnId, "Deleting orphaned txn ref count"); err != nil {
+		return err
+	}
+	return r.write(s, func(rwtxn db.RWTxn) error {
+		if err := rwtxn.Del(db.TransactionRefs, txnId[:]); err != nil && err != db.NotFound {
+			return err
+		}
+		return nil
+	})
+}
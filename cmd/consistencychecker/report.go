@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server/datadir"
+	"log"
+	"os"
+)
+
+type discrepancyKind string
+
+const (
+	locationDiscrepancy discrepancyKind = "Location"
+	refCountMismatch    discrepancyKind = "RefCountMismatch"
+	missingTxn          discrepancyKind = "MissingTxn"
+	orphanedTxn         discrepancyKind = "OrphanedTxn"
+	orphanedTxnRef      discrepancyKind = "OrphanedTxnRef"
+	danglingReference   discrepancyKind = "DanglingReference"
+	clockRegression     discrepancyKind = "ClockRegression"
+	clockConflict       discrepancyKind = "ClockConflict"
+	clockInconsistency  discrepancyKind = "ClockInconsistency"
+	undecodable         discrepancyKind = "Undecodable"
+)
+
+type discrepancy struct {
+	Kind    discrepancyKind
+	RMId    string
+	Dir     string
+	VarUUId string `json:",omitempty"`
+	TxnId   string `json:",omitempty"`
+	Detail  string
+}
+
+// report is the machine-readable result of a run. It is written as
+// JSON if a report path is given.
+type report struct {
+	Dirs          []string
+	Discrepancies []*discrepancy
+	Counts        map[discrepancyKind]int
+	Repairs       int
+	Fatal         string `json:",omitempty"`
+}
+
+func newReport(dirs []string) *report {
+	return &report{
+		Dirs:          dirs,
+		Discrepancies: []*discrepancy{},
+		Counts:        make(map[discrepancyKind]int),
+	}
+}
+
+func (r *report) add(kind discrepancyKind, store *datadir.Store, vUUId *common.VarUUId, txnId *common.TxnId, format string, args ...interface{}) {
+	d := &discrepancy{
+		Kind:   kind,
+		RMId:   fmt.Sprint(store.RMId),
+		Dir:    store.Dir,
+		Detail: fmt.Sprintf(format, args...),
+	}
+	if vUUId != nil {
+		d.VarUUId = fmt.Sprintf("%x", vUUId[:])
+	}
+	if txnId != nil {
+		d.TxnId = fmt.Sprintf("%x", txnId[:])
+	}
+	log.Printf("%v: %v: %v\n", kind, store, d.Detail)
+	r.Discrepancies = append(r.Discrepancies, d)
+	r.Counts[kind]++
+}
+
+func (r *report) writeTo(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(r); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/datadir"
+	"goshawkdb.io/server/db"
+	"goshawkdb.io/server/export"
+	eng "goshawkdb.io/server/txnengine"
+	"io"
+	"log"
+	"os"
+	"runtime"
+)
+
+// The exporter walks the object graph from the roots and writes every
+// reachable object to an export stream (see the export package for the
+// format). Every node's data directory should be supplied: each node
+// only holds replicas of some of the objects. Where several
+// directories hold a replica of the same object, the most recent
+// version is exported. All the nodes must be stopped.
+func main() {
+	log.SetPrefix(common.ProductName + "Exporter ")
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	log.Println(os.Args)
+
+	var outFile, keyFile string
+	flag.StringVar(&outFile, "out", "", "`Path` to write export to (default stdout).")
+	flag.StringVar(&keyFile, "keyfile", "", "`Path` to key file, if the data dirs are encrypted. All must use the same key.")
+	flag.Parse()
+
+	dirs := flag.Args()
+	if len(dirs) == 0 {
+		log.Fatal("No dirs supplied")
+	}
+
+	keys, err := db.LoadKeyring(keyFile)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	db.DB.Keys = keys
+
+	runtime.GOMAXPROCS(1 + (2 * len(dirs)))
+
+	stores, err := datadir.OpenAll(dirs)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer stores.Shutdown()
+
+	if err := stores.CheckEqualTopology(); err != nil {
+		log.Println(err)
+		return
+	}
+
+	var out io.Writer = os.Stdout
+	if len(outFile) != 0 {
+		file, err := os.Create(outFile)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		defer file.Close()
+		out = file
+	}
+
+	exporter := newExporter(stores)
+	if err := exporter.export(out); err != nil {
+		log.Println(err)
+	} else {
+		log.Printf("Finished: exported %v objects.\n", exporter.count)
+	}
+}
+
+type exporter struct {
+	stores  datadir.Stores
+	visited map[common.VarUUId]server.EmptyStruct
+	stack   []*exportFrame
+	count   int
+}
+
+type exportFrame struct {
+	obj  *export.Object
+	refs []*common.VarUUId
+	idx  int
+}
+
+func newExporter(stores datadir.Stores) *exporter {
+	return &exporter{
+		stores:  stores,
+		visited: make(map[common.VarUUId]server.EmptyStruct),
+	}
+}
+
+func (e *exporter) export(out io.Writer) error {
+	topology := e.stores[0].Topology
+	names := topology.RootNames()
+	header := &export.Header{
+		ClusterId: topology.ClusterId,
+		Roots:     make([]export.Root, len(names)),
+	}
+	for idx, name := range names {
+		header.Roots[idx] = export.Root{
+			Name:    name,
+			VarUUId: hex.EncodeToString(topology.Roots[idx].VarUUId[:]),
+		}
+	}
+
+	w, err := export.NewWriter(out, header)
+	if err != nil {
+		return err
+	}
+	for idx := range names {
+		if err := e.walk(w, topology.Roots[idx].VarUUId); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// walk is an iterative depth-first post-order traversal so that
+// objects are written after the objects they reference.
+func (e *exporter) walk(w *export.Writer, root *common.VarUUId) error {
+	if err := e.push(root); err != nil {
+		return err
+	}
+	for len(e.stack) != 0 {
+		top := e.stack[len(e.stack)-1]
+		if top.idx < len(top.refs) {
+			ref := top.refs[top.idx]
+			top.idx++
+			if err := e.push(ref); err != nil {
+				return err
+			}
+		} else {
+			e.stack = e.stack[:len(e.stack)-1]
+			if err := w.Write(top.obj); err != nil {
+				return err
+			}
+			e.count++
+		}
+	}
+	return nil
+}
+
+func (e *exporter) push(vUUId *common.VarUUId) error {
+	if _, found := e.visited[*vUUId]; found {
+		return nil
+	}
+	e.visited[*vUUId] = server.EmptyStructVal
+
+	store, varCap, err := e.newest(vUUId)
+	if err != nil {
+		return err
+	}
+	value, refsCap, err := store.ReadVarValue(vUUId, varCap)
+	if err != nil {
+		return err
+	}
+
+	obj := &export.Object{
+		VarUUId:    hex.EncodeToString(vUUId[:]),
+		Version:    hex.EncodeToString(varCap.WriteTxnId()),
+		Value:      value,
+		References: make([]export.Reference, refsCap.Len()),
+	}
+	refs := make([]*common.VarUUId, refsCap.Len())
+	for idx := range refs {
+		refCap := refsCap.At(idx)
+		refs[idx] = common.MakeVarUUId(refCap.Id())
+		obj.References[idx] = export.Reference{
+			VarUUId:    hex.EncodeToString(refCap.Id()),
+			Capability: export.CapabilityName(refCap.Capability()),
+		}
+	}
+	e.stack = append(e.stack, &exportFrame{obj: obj, refs: refs})
+	return nil
+}
+
+// newest finds the replica of the var with the greatest local element
+// in its write txn clock.
+func (e *exporter) newest(vUUId *common.VarUUId) (*datadir.Store, *msgs.Var, error) {
+	var (
+		store  *datadir.Store
+		varCap *msgs.Var
+		elem   uint64
+	)
+	for _, s := range e.stores {
+		v, err := s.ReadVar(vUUId)
+		if err != nil {
+			return nil, nil, err
+		} else if v == nil {
+			continue
+		}
+		if vElem := eng.VectorClockFromData(v.WriteTxnClock(), false).At(vUUId); varCap == nil || vElem > elem {
+			store, varCap, elem = s, v, vElem
+		}
+	}
+	if varCap == nil {
+		return nil, nil, fmt.Errorf("Unable to find %v in any supplied data dir: are all the data dirs supplied?", vUUId)
+	}
+	return store, varCap, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/datadir"
+	"goshawkdb.io/server/db"
+	"goshawkdb.io/server/export"
+	eng "goshawkdb.io/server/txnengine"
+	"io"
+	"testing"
+)
+
+func testVarUUId(n byte) *common.VarUUId {
+	vUUId := common.MakeVarUUId(make([]byte, common.KeyLen))
+	vUUId[0] = n
+	return vUUId
+}
+
+func newTestStore(rmId common.RMId) *datadir.Store {
+	return &datadir.Store{RMId: rmId, DB: db.DB.WithStorage(db.NewMemoryStorage())}
+}
+
+// putTestVar writes vUUId to the store as written by txnNum, with
+// read-write references to refs.
+func putTestVar(t *testing.T, s *datadir.Store, vUUId *common.VarUUId, txnNum byte, clockElem uint64, value string, refs ...*common.VarUUId) {
+	seg := capn.NewBuffer(nil)
+	wrapper := msgs.NewRootActionListWrapper(seg)
+	actions := msgs.NewActionList(seg, 1)
+	wrapper.SetActions(actions)
+	action := actions.At(0)
+	action.SetVarId(vUUId[:])
+	action.SetWrite()
+	action.Write().SetValue([]byte(value))
+	refsCap := msgs.NewVarIdPosList(seg, len(refs))
+	for idx, ref := range refs {
+		refCap := refsCap.At(idx)
+		refCap.SetId(ref[:])
+		refCap.SetPositions(seg.NewUInt8List(3))
+		capability := cmsgs.NewCapability(seg)
+		capability.SetReadWrite()
+		refCap.SetCapability(capability)
+	}
+	action.Write().SetReferences(refsCap)
+
+	txnId := &common.TxnId{}
+	txnId[7] = txnNum
+	txnSeg := capn.NewBuffer(nil)
+	txnCap := msgs.NewRootTxn(txnSeg)
+	txnCap.SetId(txnId[:])
+	txnCap.SetActions(server.SegToBytes(seg))
+
+	varSeg := capn.NewBuffer(nil)
+	varCap := msgs.NewRootVar(varSeg)
+	varCap.SetId(vUUId[:])
+	varCap.SetPositions(varSeg.NewUInt8List(3))
+	varCap.SetWriteTxnId(txnId[:])
+	varCap.SetWriteTxnClock(eng.NewVectorClock().AsMutable().Bump(vUUId, clockElem).AsData())
+
+	_, err := s.DB.ReadWriteTransaction(func(rwtxn db.RWTxn) interface{} {
+		if err := s.DB.WriteTxnToDisk(rwtxn, txnId, server.SegToBytes(txnSeg)); err != nil {
+			rwtxn.Error(err)
+		} else if err = rwtxn.Put(db.Vars, vUUId[:], s.DB.SealRecord(db.Vars, vUUId[:], server.SegToBytes(varSeg))); err != nil {
+			rwtxn.Error(err)
+		}
+		return nil
+	}).ResultError()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Objects are written after everything they reference, except where
+// that is impossible due to a cycle, and each is written once, from
+// whichever store has its most recent version.
+func TestExportWalk(t *testing.T) {
+	one, two := newTestStore(1), newTestStore(2)
+	stores := datadir.Stores{one, two}
+	defer stores.Shutdown()
+
+	root, a, b, unreachable := testVarUUId(1), testVarUUId(2), testVarUUId(3), testVarUUId(4)
+	putTestVar(t, one, root, 1, 1, "root", a, b)
+	putTestVar(t, one, a, 2, 1, "a old", b)
+	putTestVar(t, two, a, 3, 2, "a new", b)
+	// b and a form a cycle.
+	putTestVar(t, two, b, 4, 1, "b", a)
+	putTestVar(t, one, unreachable, 5, 1, "unreachable")
+
+	buf := new(bytes.Buffer)
+	w, err := export.NewWriter(buf, &export.Header{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := newExporter(stores)
+	if err = e.walk(w, root); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if e.count != 3 {
+		t.Fatalf("Expected 3 objects to be exported; got %v", e.count)
+	}
+
+	r, err := export.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		vUUId *common.VarUUId
+		txnId byte
+		value string
+		refs  []*common.VarUUId
+	}{
+		{vUUId: b, txnId: 4, value: "b", refs: []*common.VarUUId{a}},
+		{vUUId: a, txnId: 3, value: "a new", refs: []*common.VarUUId{b}},
+		{vUUId: root, txnId: 1, value: "root", refs: []*common.VarUUId{a, b}},
+	}
+	for _, exp := range expected {
+		obj, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		txnId := &common.TxnId{}
+		txnId[7] = exp.txnId
+		if obj.VarUUId != hex.EncodeToString(exp.vUUId[:]) || obj.Version != hex.EncodeToString(txnId[:]) || string(obj.Value) != exp.value {
+			t.Fatalf("Expected %v at %v with value %q; got %v", exp.vUUId, txnId, exp.value, obj)
+		}
+		if len(obj.References) != len(exp.refs) {
+			t.Fatalf("Expected %v references from %v; got %v", len(exp.refs), exp.vUUId, obj.References)
+		}
+		for idx, ref := range exp.refs {
+			if found := obj.References[idx]; found.VarUUId != hex.EncodeToString(ref[:]) || found.Capability != export.CapabilityReadWrite {
+				t.Fatalf("Expected a read-write reference to %v from %v; got %v", ref, exp.vUUId, found)
+			}
+		}
+	}
+	if _, err = r.Read(); err != io.EOF {
+		t.Fatalf("Expected only reachable objects to be exported; got %v", err)
+	}
+}
+
+// A var which is in none of the stores cannot be exported.
+func TestExportMissingVar(t *testing.T) {
+	one := newTestStore(1)
+	defer one.Shutdown()
+	root := testVarUUId(1)
+	putTestVar(t, one, root, 1, 1, "root", testVarUUId(2))
+
+	w, err := export.NewWriter(new(bytes.Buffer), &export.Header{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = newExporter(datadir.Stores{one}).walk(w, root); err == nil {
+		t.Fatal("Expected a dangling reference to fail the export")
+	}
+}
@@ -7,22 +7,28 @@ import (
 	"flag"
 	"fmt"
 	mdb "github.com/msackman/gomdb"
-	mdbs "github.com/msackman/gomdb/server"
 	"goshawkdb.io/common"
 	"goshawkdb.io/common/certs"
 	goshawk "goshawkdb.io/server"
+	"goshawkdb.io/server/audit"
+	"goshawkdb.io/server/client"
 	"goshawkdb.io/server/configuration"
 	"goshawkdb.io/server/db"
+	"goshawkdb.io/server/export"
 	"goshawkdb.io/server/network"
 	"goshawkdb.io/server/paxos"
+	eng "goshawkdb.io/server/txnengine"
+	"io"
 	"io/ioutil"
 	"log"
 	"math/rand"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
 	"runtime/pprof"
 	"runtime/trace"
+	"strings"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -44,17 +50,48 @@ func main() {
 }
 
 func newServer() (*server, error) {
-	var configFile, dataDir, certFile string
-	var port int
-	var version, genClusterCert, genClientCert bool
+	var configFile, dataDir, certFile, keyFile, revokedFile, auditLogFile, auditLogKeyFile, importFile, importRoot string
+	var tlsMinVersion, tlsMaxVersion, tlsCipherSuites, clientKeyTypes string
+	var port, metricsPort, groupCommitSize, clientTxnsPerConn int
+	var groupCommitDelay, gcInterval, retryTimeout time.Duration
+	var maxMapSize, minFree uint64
+	var auditLogMaxSize int64
+	var oldKeyFiles string
+	var version, genClusterCert, genClientCert, compress, inMemory, gcDryRun, reverseRefs, auditValueHashes, acceptPlaintext bool
 
 	flag.StringVar(&configFile, "config", "", "`Path` to configuration file (required to start server).")
 	flag.StringVar(&dataDir, "dir", "", "`Path` to data directory (required to run server).")
-	flag.StringVar(&certFile, "cert", "", "`Path` to cluster certificate and key file (required to run server).")
+	flag.BoolVar(&inMemory, "inmemory", false, "Keep all data in memory, and lose it all on shutdown. No data directory is used (for tests and ephemeral clusters only).")
+	flag.StringVar(&certFile, "cert", "", "`Path` to cluster certificate and key file (required to run server). Reloaded on SIGHUP.")
+	flag.StringVar(&revokedFile, "revoked", "", "`Path` to file listing revoked client certificate fingerprints, one per line (optional). Reloaded on SIGHUP.")
+	flag.StringVar(&tlsMinVersion, "tlsminversion", "1.2", "Minimum TLS `version` to accept: 1.2 or 1.3.")
+	flag.StringVar(&tlsMaxVersion, "tlsmaxversion", "1.3", "Maximum TLS `version` to accept: 1.2 or 1.3. TLS 1.3 is accepted by default, and its cipher suites are not configurable: give 1.2 to restrict every connection to -tlsciphersuites.")
+	flag.StringVar(&tlsCipherSuites, "tlsciphersuites", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "Comma separated `list` of TLS 1.2 cipher suites to accept. At least one ECDSA cipher suite is required if TLS 1.2 is accepted.")
+	flag.StringVar(&clientKeyTypes, "clientkeytypes", "ecdsa", "Comma separated `list` of key types to accept in client certificates: ecdsa, rsa, ed25519.")
+	flag.StringVar(&auditLogFile, "auditlog", "", "`Path` to audit log of committed client txns and authentication failures (optional).")
+	flag.StringVar(&auditLogKeyFile, "auditlogkeyfile", "", "`Path` to file containing a hex encoded key of at least 256 bits to chain the audit log with HMAC-SHA256 (optional).")
+	flag.Int64Var(&auditLogMaxSize, "auditlogmaxsize", goshawk.AuditLogMaxSizeDefault, "Size in bytes at which the audit log is rotated.")
+	flag.BoolVar(&auditValueHashes, "auditvaluehashes", false, "Include the SHA-256 of every value written in the audit log.")
+	flag.StringVar(&keyFile, "keyfile", "", "`Path` to file containing a hex encoded 256-bit key to encrypt data at rest with (optional).")
+	flag.StringVar(&oldKeyFiles, "oldkeyfiles", "", "Comma separated `paths` to files containing previous keys, used only to read data encrypted before -keyfile was changed (optional).")
+	flag.BoolVar(&acceptPlaintext, "acceptplaintext", false, "Read data which is not encrypted despite -keyfile being given, whilst migrating an existing data directory to encryption.")
+	flag.BoolVar(&compress, "compress", false, "Compress transactions and values on disk and when migrating. Every node must support compression.")
+	flag.Uint64Var(&maxMapSize, "maxmapsize", 0, "Maximum size in bytes the database map may grow to (0 for no limit other than free disk space).")
+	flag.Uint64Var(&minFree, "minfree", goshawk.DiskMinFreeDefault, "Minimum free disk space in bytes, below which new writes are refused.")
+	flag.IntVar(&groupCommitSize, "groupcommitsize", goshawk.GroupCommitMaxBatch, "Maximum number of writes to commit to disk together (1 to disable group commit).")
+	flag.DurationVar(&groupCommitDelay, "groupcommitdelay", goshawk.GroupCommitMaxDelay, "Maximum time to delay a write whilst waiting for more to commit with it.")
+	flag.DurationVar(&gcInterval, "gcinterval", 0, "Interval between garbage collection cycles, which are coordinated by the node with the lowest RM Id (0 to disable).")
+	flag.BoolVar(&gcDryRun, "gcdryrun", false, "Only report what garbage collection would delete.")
+	flag.BoolVar(&reverseRefs, "reverserefs", false, "Maintain an index from each var to the vars which reference it, for use by the inspector. Building the index for existing data can take some time.")
+	flag.IntVar(&clientTxnsPerConn, "clienttxnsperconn", 1, "Maximum number of txns each client connection may have in flight at once. Clients must be configured to match.")
+	flag.DurationVar(&retryTimeout, "retrytimeout", 0, "Maximum time a client retry txn may wait for any of the objects it read to be modified before it fails (0 for no limit). Applies to every retry txn of every client.")
 	flag.IntVar(&port, "port", common.DefaultPort, "Port to listen on (required if non-default).")
+	flag.IntVar(&metricsPort, "metricsport", 0, "Port to serve metrics on, on localhost only (0 to disable).")
 	flag.BoolVar(&version, "version", false, "Display version and exit.")
 	flag.BoolVar(&genClusterCert, "gen-cluster-cert", false, "Generate new cluster certificate key pair.")
 	flag.BoolVar(&genClientCert, "gen-client-cert", false, "Generate client certificate key pair.")
+	flag.StringVar(&importFile, "import", "", "`Path` to export file to import once the cluster is ready. Completed imports are recorded in the data directory and not repeated.")
+	flag.StringVar(&importRoot, "import-root", "", "`Name` of root to import under (required with -import).")
 	flag.Parse()
 
 	if version {
@@ -90,16 +127,23 @@ func newServer() (*server, error) {
 		return nil, nil
 	}
 
-	if dataDir == "" {
-		dataDir, err = ioutil.TempDir("", common.ProductName+"_Data_")
+	if inMemory {
+		if dataDir != "" {
+			return nil, fmt.Errorf("Supply at most one of -dir and -inmemory.")
+		}
+		log.Println("Keeping all data in memory: it will be lost on shutdown.")
+	} else {
+		if dataDir == "" {
+			dataDir, err = ioutil.TempDir("", common.ProductName+"_Data_")
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("No data dir supplied (missing -dir parameter). Using %v for data.\n", dataDir)
+		}
+		err = os.MkdirAll(dataDir, 0750)
 		if err != nil {
 			return nil, err
 		}
-		log.Printf("No data dir supplied (missing -dir parameter). Using %v for data.\n", dataDir)
-	}
-	err = os.MkdirAll(dataDir, 0750)
-	if err != nil {
-		return nil, err
 	}
 
 	if configFile != "" {
@@ -109,17 +153,109 @@ func newServer() (*server, error) {
 		}
 	}
 
+	if keyFile == "" && (oldKeyFiles != "" || acceptPlaintext) {
+		return nil, fmt.Errorf("No key file supplied (missing -keyfile parameter) for -oldkeyfiles or -acceptplaintext.")
+	}
+	var oldKeyPaths []string
+	if oldKeyFiles != "" {
+		oldKeyPaths = strings.Split(oldKeyFiles, ",")
+	}
+	keys, err := db.LoadKeyring(keyFile, oldKeyPaths...)
+	if err != nil {
+		return nil, err
+	}
+	if keys != nil && acceptPlaintext {
+		keys.AcceptPlaintext()
+	}
+
+	tlsSettings, err := network.NewTLSSettings(tlsMinVersion, tlsMaxVersion, tlsCipherSuites, clientKeyTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	if revokedFile != "" {
+		if _, err := configuration.LoadRevokedFingerprints(revokedFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if auditLogFile == "" && auditValueHashes {
+		return nil, fmt.Errorf("No audit log supplied (missing -auditlog parameter) for -auditvaluehashes.")
+	}
+	if auditLogFile == "" && auditLogKeyFile != "" {
+		return nil, fmt.Errorf("No audit log supplied (missing -auditlog parameter) for -auditlogkeyfile.")
+	}
+	auditLogKey, err := db.LoadKeyFile(auditLogKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if auditLogMaxSize <= 0 {
+		return nil, fmt.Errorf("Supplied audit log maximum size is illegal (%v). It must be > 0", auditLogMaxSize)
+	}
+
+	if importFile != "" {
+		if importRoot == "" {
+			return nil, fmt.Errorf("No root to import under supplied (missing -import-root parameter).")
+		}
+		if _, err := os.Stat(importFile); err != nil {
+			return nil, err
+		}
+	}
+
 	if !(0 < port && port < 65536) {
 		return nil, fmt.Errorf("Supplied port is illegal (%v). Port must be > 0 and < 65536", port)
 	}
+	if !(0 <= metricsPort && metricsPort < 65536) {
+		return nil, fmt.Errorf("Supplied metrics port is illegal (%v). Port must be >= 0 and < 65536", metricsPort)
+	}
+	if groupCommitSize < 1 {
+		return nil, fmt.Errorf("Supplied group commit size is illegal (%v). It must be >= 1", groupCommitSize)
+	}
+	if clientTxnsPerConn < 1 {
+		return nil, fmt.Errorf("Supplied client txns per connection is illegal (%v). It must be >= 1", clientTxnsPerConn)
+	}
+	if retryTimeout < 0 {
+		return nil, fmt.Errorf("Supplied retry timeout is illegal (%v). It must be >= 0", retryTimeout)
+	}
+	if groupCommitDelay < 0 {
+		return nil, fmt.Errorf("Supplied group commit delay is illegal (%v). It must be >= 0", groupCommitDelay)
+	}
+	if gcInterval < 0 {
+		return nil, fmt.Errorf("Supplied garbage collection interval is illegal (%v). It must be >= 0", gcInterval)
+	}
+	if maxMapSize != 0 && maxMapSize < goshawk.MDBInitialSize {
+		return nil, fmt.Errorf("Supplied maximum map size is illegal (%v). It must be 0 or >= %v", maxMapSize, goshawk.MDBInitialSize)
+	}
 
 	s := &server{
-		configFile:   configFile,
-		certificate:  certificate,
-		dataDir:      dataDir,
-		port:         uint16(port),
-		onShutdown:   []func(){},
-		shutdownChan: make(chan goshawk.EmptyStruct),
+		configFile:        configFile,
+		certFile:          certFile,
+		certificate:       certificate,
+		revokedFile:       revokedFile,
+		tlsSettings:       tlsSettings,
+		auditLogFile:      auditLogFile,
+		auditLogMaxSize:   auditLogMaxSize,
+		auditValueHashes:  auditValueHashes,
+		auditLogKey:       auditLogKey,
+		dataDir:           dataDir,
+		inMemory:          inMemory,
+		keys:              keys,
+		compress:          compress,
+		maxMapSize:        maxMapSize,
+		minFree:           minFree,
+		groupCommitSize:   groupCommitSize,
+		groupCommitDelay:  groupCommitDelay,
+		gcInterval:        gcInterval,
+		gcDryRun:          gcDryRun,
+		reverseRefs:       reverseRefs,
+		clientTxnsPerConn: clientTxnsPerConn,
+		retryTimeout:      retryTimeout,
+		port:              uint16(port),
+		metricsPort:       uint16(metricsPort),
+		importFile:        importFile,
+		importRoot:        importRoot,
+		onShutdown:        []func(){},
+		shutdownChan:      make(chan goshawk.EmptyStruct),
 	}
 
 	if err = s.ensureRMId(); err != nil {
@@ -134,9 +270,31 @@ func newServer() (*server, error) {
 
 type server struct {
 	configFile        string
+	certFile          string
 	certificate       []byte
+	revokedFile       string
+	tlsSettings       *network.TLSSettings
+	auditLogFile      string
+	auditLogMaxSize   int64
+	auditValueHashes  bool
+	auditLogKey       []byte
 	dataDir           string
+	inMemory          bool
+	keys              *db.Keyring
+	compress          bool
+	maxMapSize        uint64
+	minFree           uint64
+	groupCommitSize   int
+	groupCommitDelay  time.Duration
+	gcInterval        time.Duration
+	gcDryRun          bool
+	reverseRefs       bool
+	clientTxnsPerConn int
+	retryTimeout      time.Duration
 	port              uint16
+	metricsPort       uint16
+	importFile        string
+	importRoot        string
 	rmId              common.RMId
 	bootCount         uint32
 	connectionManager *network.ConnectionManager
@@ -167,16 +325,47 @@ func (s *server) start() {
 	s.certificate = nil
 	s.maybeShutdown(err)
 
-	disk, err := mdbs.NewMDBServer(s.dataDir, 0, 0600, goshawk.MDBInitialSize, procs/2, time.Millisecond, db.DB)
-	s.maybeShutdown(err)
-	db := disk.(*db.Databases)
+	db.DB.Keys = s.keys
+	db.DB.Compress = s.compress
+	db.DB.ReverseRefs = s.reverseRefs
+	var disk db.Storage
+	if s.inMemory {
+		disk = db.NewMemoryStorage()
+	} else {
+		disk, err = db.OpenLMDB(s.dataDir, goshawk.MDBInitialSize, procs/2, time.Millisecond)
+		s.maybeShutdown(err)
+	}
+	if s.groupCommitSize > 1 {
+		disk = db.NewGroupCommitStorage(disk, s.groupCommitSize, s.groupCommitDelay)
+	}
+	db := db.DB.WithStorage(disk)
 	s.addOnShutdown(db.Shutdown)
+	s.maybeShutdown(eng.MaintainReverseRefs(db))
+	if !s.inMemory {
+		monitor := db.StartDiskMonitor(s.dataDir, s.maxMapSize, s.minFree)
+		s.addOnShutdown(monitor.Shutdown)
+	}
+
+	var auditLog *audit.Log
+	if s.auditLogFile != "" {
+		auditLog, err = audit.OpenLog(s.auditLogFile, s.auditLogMaxSize, s.auditValueHashes, s.auditLogKey)
+		s.maybeShutdown(err)
+		s.addOnShutdown(func() {
+			if err := auditLog.Close(); err != nil {
+				log.Println("Error closing audit log:", err)
+			}
+		})
+	}
 
-	cm, transmogrifier := network.NewConnectionManager(s.rmId, s.bootCount, procs, db, nodeCertPrivKeyPair, s.port, s, commandLineConfig)
+	cm, transmogrifier := network.NewConnectionManager(s.rmId, s.bootCount, procs, db, nodeCertPrivKeyPair, s.tlsSettings, auditLog, client.RegisteredAuthorizer(), s.clientTxnsPerConn, s.retryTimeout, s.port, s, commandLineConfig)
 	s.addOnShutdown(func() { cm.Shutdown(paxos.Sync) })
 	s.addOnShutdown(transmogrifier.Shutdown)
 	s.connectionManager = cm
 	s.transmogrifier = transmogrifier
+	s.maybeShutdown(s.reloadRevocations())
+	if s.gcInterval > 0 {
+		cm.Collector.Schedule(s.gcInterval, s.gcDryRun)
+	}
 
 	go s.signalHandler()
 
@@ -184,10 +373,72 @@ func (s *server) start() {
 	s.maybeShutdown(err)
 	s.addOnShutdown(listener.Shutdown)
 
+	if s.importFile != "" {
+		s.maybeShutdown(s.startImport())
+	}
+
+	if s.metricsPort != 0 {
+		// expvar registers itself with the default mux.
+		go func() {
+			log.Println(http.ListenAndServe(fmt.Sprintf("localhost:%v", s.metricsPort), nil))
+		}()
+	}
+
 	defer s.shutdown(nil)
 	<-s.shutdownChan
 }
 
+// An import is run at most once per data directory: the journal
+// records completed imports so that restarting with the same -import
+// is harmless.
+func (s *server) startImport() error {
+	file, err := os.Open(s.importFile)
+	if err != nil {
+		return err
+	}
+	digest, err := export.Digest(file)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	var journal *export.ImportJournal
+	if !s.inMemory {
+		journal = export.OpenImportJournal(s.dataDir)
+	}
+	if err = journal.Begin(digest, s.importRoot); err == export.AlreadyImported {
+		file.Close()
+		log.Printf("Import: %v has already been imported under root %v. Ignoring.\n", s.importFile, s.importRoot)
+		return nil
+	} else if err != nil {
+		file.Close()
+		return err
+	}
+	reader, err := export.NewReader(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	importer := network.NewImporter(s.connectionManager, reader, s.importRoot)
+	go func() {
+		defer file.Close()
+		if err := importer.Run(); err != nil {
+			created := importer.Created()
+			log.Printf("Import failed having created %v objects, which are not reachable from any root: %v\n", len(created), err)
+			if report, err := journal.Failed(digest, s.importRoot, created); err != nil {
+				log.Println("Import: unable to record failure:", err)
+			} else if report != "" {
+				log.Printf("Import: VarUUIds of the unreachable objects written to %v. Garbage collection will remove them.\n", report)
+			}
+		} else if err := journal.Completed(digest, s.importRoot); err != nil {
+			log.Println("Import: unable to record completion:", err)
+		}
+	}()
+	return nil
+}
+
 func (s *server) addOnShutdown(f func()) {
 	if f != nil {
 		s.onShutdown = append(s.onShutdown, f)
@@ -213,22 +464,30 @@ func (s *server) maybeShutdown(err error) {
 
 func (s *server) ensureRMId() error {
 	path := s.dataDir + "/rmid"
-	if b, err := ioutil.ReadFile(path); err == nil {
-		s.rmId = common.RMId(binary.BigEndian.Uint32(b))
-		return nil
-
-	} else {
-		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
-		for s.rmId == common.RMIdEmpty {
-			s.rmId = common.RMId(rng.Uint32())
+	if !s.inMemory {
+		if b, err := ioutil.ReadFile(path); err == nil {
+			s.rmId = common.RMId(binary.BigEndian.Uint32(b))
+			return nil
 		}
-		b := make([]byte, 4)
-		binary.BigEndian.PutUint32(b, uint32(s.rmId))
-		return ioutil.WriteFile(path, b, 0400)
 	}
+
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for s.rmId == common.RMIdEmpty {
+		s.rmId = common.RMId(rng.Uint32())
+	}
+	if s.inMemory {
+		return nil
+	}
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(s.rmId))
+	return ioutil.WriteFile(path, b, 0400)
 }
 
 func (s *server) ensureBootCount() error {
+	if s.inMemory {
+		s.bootCount = 1
+		return nil
+	}
 	path := s.dataDir + "/bootcount"
 	if b, err := ioutil.ReadFile(path); err == nil {
 		s.bootCount = binary.BigEndian.Uint32(b) + 1
@@ -263,10 +522,22 @@ func (s *server) signalStatus() {
 	sc.Emit(fmt.Sprintf("Configuration File: %v", s.configFile))
 	sc.Emit(fmt.Sprintf("Data Directory: %v", s.dataDir))
 	sc.Emit(fmt.Sprintf("Port: %v", s.port))
+	sc.Emit(fmt.Sprintf("Disk: %v", s.connectionManager.Health))
 	s.connectionManager.Status(sc)
 }
 
+// signalReloadConfig reloads the cluster certificate and the revoked
+// client certificates as well as the configuration. To rotate the
+// cluster certificate: add the new certificate to the
+// configuration's ClusterCertificates (alongside the old one) and
+// reload; then replace the certificate file on each node in turn and
+// reload; finally remove the old certificate from the configuration
+// and reload once more.
 func (s *server) signalReloadConfig() {
+	s.reloadCertificate()
+	if err := s.reloadRevocations(); err != nil {
+		log.Println("Cannot reload revoked client certificates due to error:", err)
+	}
 	if s.configFile == "" {
 		log.Println("Attempt to reload config failed as no path to configuration provided on command line.")
 		return
@@ -279,6 +550,40 @@ func (s *server) signalReloadConfig() {
 	s.transmogrifier.RequestConfigurationChange(config)
 }
 
+func (s *server) reloadCertificate() {
+	certificate, err := ioutil.ReadFile(s.certFile)
+	if err != nil {
+		log.Println("Cannot reload certificate due to error:", err)
+		return
+	}
+	nodeCertPrivKeyPair, err := certs.GenerateNodeCertificatePrivateKeyPair(certificate)
+	for idx := range certificate {
+		certificate[idx] = 0
+	}
+	if err != nil {
+		log.Println("Cannot reload certificate due to error:", err)
+		return
+	}
+	old := s.connectionManager.NodeCertificatePrivateKeyPair()
+	if !old.CertificateRoot.Equal(nodeCertPrivKeyPair.CertificateRoot) {
+		log.Printf("Cluster certificate changed to %v. Node certificate regenerated.\n", nodeCertPrivKeyPair.CertificateRoot.Subject)
+		s.connectionManager.SetNodeCertificatePrivateKeyPair(nodeCertPrivKeyPair)
+	}
+}
+
+func (s *server) reloadRevocations() error {
+	if s.revokedFile == "" {
+		return nil
+	}
+	revoked, err := configuration.LoadRevokedFingerprints(s.revokedFile)
+	if err != nil {
+		return err
+	}
+	s.connectionManager.SetRevokedFingerprints(revoked)
+	log.Printf("Loaded %v revoked client certificate fingerprints.\n", len(revoked))
+	return nil
+}
+
 func (s *server) signalDumpStacks() {
 	size := 16384
 	for {
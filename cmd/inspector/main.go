@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/datadir"
+	"goshawkdb.io/server/db"
+	"goshawkdb.io/server/export"
+	eng "goshawkdb.io/server/txnengine"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const usage = `Commands:
+  vars                 List and decode every var.
+  var <VarUUId>        Decode one var.
+  txn <TxnId>          Decode a transaction: its actions and allocations.
+  acceptors            List the acceptor states persisted in BallotOutcomes.
+  proposers            List the persisted proposer states.
+  refs <VarUUId>       Follow references from a var, as far as this data dir holds the vars.
+  referrers <VarUUId>  List the vars in this data dir which reference a var.
+  topology             Print the stored topology.
+VarUUIds and TxnIds are given in hex.`
+
+// The inspector only ever reads from the data dir. The server must
+// not be running against the same data dir.
+func main() {
+	log.SetPrefix(common.ProductName + "Inspector ")
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+
+	var dir, keyFile string
+	var asJSON bool
+	var depth int
+	flag.StringVar(&dir, "dir", "", "`Path` to data directory (required).")
+	flag.StringVar(&keyFile, "keyfile", "", "`Path` to key file, if the data directory is encrypted.")
+	flag.BoolVar(&asJSON, "json", false, "Output JSON rather than text.")
+	flag.IntVar(&depth, "depth", 1, "Maximum depth to follow references to with the refs command (0 for unlimited).")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s: [flags] command [args]\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintln(os.Stderr, usage)
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	if dir == "" || len(args) == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	keys, err := db.LoadKeyring(keyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	db.DB.Keys = keys
+
+	store, err := datadir.Open(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Shutdown()
+
+	i := &inspector{
+		store:  store,
+		asJSON: asJSON,
+		out:    os.Stdout,
+	}
+
+	switch cmd := args[0]; {
+	case cmd == "vars" && len(args) == 1:
+		err = i.vars()
+	case cmd == "var" && len(args) == 2:
+		err = i.oneVar(args[1])
+	case cmd == "txn" && len(args) == 2:
+		err = i.txn(args[1])
+	case cmd == "acceptors" && len(args) == 1:
+		err = i.acceptors()
+	case cmd == "proposers" && len(args) == 1:
+		err = i.proposers()
+	case cmd == "refs" && len(args) == 2:
+		err = i.refs(args[1], depth)
+	case cmd == "referrers" && len(args) == 2:
+		err = i.referrers(args[1])
+	case cmd == "topology" && len(args) == 1:
+		err = i.print(newTopologyView(store.Topology))
+	default:
+		flag.Usage()
+		store.Shutdown()
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+type inspector struct {
+	store  *datadir.Store
+	asJSON bool
+	out    io.Writer
+}
+
+func (i *inspector) vars() error {
+	return i.store.ForEach(db.Vars, func(key, value []byte) error {
+		varCap, err := i.store.VarFromData(key, value)
+		if err != nil {
+			return fmt.Errorf("Err on decoding %v: %v", common.MakeVarUUId(key), err)
+		}
+		return i.print(newVarView(varCap))
+	})
+}
+
+func (i *inspector) oneVar(idStr string) error {
+	vUUId, err := parseVarUUId(idStr)
+	if err != nil {
+		return err
+	}
+	varCap, err := i.store.ReadVar(vUUId)
+	if err != nil {
+		return err
+	} else if varCap == nil {
+		return fmt.Errorf("%v not found", vUUId)
+	}
+	return i.print(newVarView(varCap))
+}
+
+func (i *inspector) txn(idStr string) error {
+	bites, err := export.DecodeId(idStr, common.KeyLen)
+	if err != nil {
+		return err
+	}
+	txnId := common.MakeTxnId(bites)
+	txn, err := i.store.ReadTxn(txnId)
+	if err != nil {
+		return err
+	} else if txn == nil {
+		return fmt.Errorf("%v not found", txnId)
+	}
+	return i.print(newTxnView(txn))
+}
+
+func (i *inspector) acceptors() error {
+	return i.store.ForEach(db.BallotOutcomes, func(key, value []byte) error {
+		txnId := common.MakeTxnId(key)
+		value, err := i.store.DB.OpenRecord(db.BallotOutcomes, key, value)
+		if err != nil {
+			return fmt.Errorf("Err on decoding acceptor state for %v: %v", txnId, err)
+		}
+		seg, _, err := capn.ReadFromMemoryZeroCopy(value)
+		if err != nil {
+			return fmt.Errorf("Err on decoding acceptor state for %v: %v", txnId, err)
+		}
+		state := msgs.ReadRootAcceptorState(seg)
+		return i.print(newAcceptorView(txnId, &state))
+	})
+}
+
+func (i *inspector) proposers() error {
+	return i.store.ForEach(db.Proposers, func(key, value []byte) error {
+		txnId := common.MakeTxnId(key)
+		value, err := i.store.DB.OpenRecord(db.Proposers, key, value)
+		if err != nil {
+			return fmt.Errorf("Err on decoding proposer state for %v: %v", txnId, err)
+		}
+		seg, _, err := capn.ReadFromMemoryZeroCopy(value)
+		if err != nil {
+			return fmt.Errorf("Err on decoding proposer state for %v: %v", txnId, err)
+		}
+		state := msgs.ReadRootProposerState(seg)
+		return i.print(newProposerView(txnId, &state))
+	})
+}
+
+type refsView struct {
+	VarUUId    string
+	Depth      int
+	Present    bool
+	Version    string          `json:",omitempty"`
+	Value      []byte          `json:",omitempty"`
+	References []referenceView `json:",omitempty"`
+}
+
+// refs does a breadth-first walk from the var. Vars which are not
+// held in this data dir are reported as not present, and not followed.
+func (i *inspector) refs(idStr string, maxDepth int) error {
+	vUUId, err := parseVarUUId(idStr)
+	if err != nil {
+		return err
+	}
+	type pending struct {
+		vUUId *common.VarUUId
+		depth int
+	}
+	visited := map[common.VarUUId]bool{*vUUId: true}
+	queue := []pending{{vUUId: vUUId}}
+	for len(queue) != 0 {
+		p := queue[0]
+		queue = queue[1:]
+		rv := &refsView{
+			VarUUId: hexId(p.vUUId[:]),
+			Depth:   p.depth,
+		}
+		varCap, err := i.store.ReadVar(p.vUUId)
+		if err != nil {
+			return err
+		}
+		if varCap != nil {
+			value, refs, err := i.store.ReadVarValue(p.vUUId, varCap)
+			if err != nil {
+				return err
+			}
+			rv.Present = true
+			rv.Version = hexId(varCap.WriteTxnId())
+			rv.Value = value
+			rv.References = newReferenceViews(refs)
+			if maxDepth == 0 || p.depth < maxDepth {
+				for idx, l := 0, refs.Len(); idx < l; idx++ {
+					target := common.MakeVarUUId(refs.At(idx).Id())
+					if !visited[*target] {
+						visited[*target] = true
+						queue = append(queue, pending{vUUId: target, depth: p.depth + 1})
+					}
+				}
+			}
+		}
+		if err = i.print(rv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type referrersView struct {
+	VarUUId   string
+	Indexed   bool
+	Referrers []string
+}
+
+// referrers uses the reverse reference index if the server has kept
+// it complete (see -reverserefs). Otherwise it reads every var.
+func (i *inspector) referrers(idStr string) error {
+	vUUId, err := parseVarUUId(idStr)
+	if err != nil {
+		return err
+	}
+	rv := &referrersView{VarUUId: hexId(vUUId[:])}
+	_, err = i.store.DB.ReadonlyTransaction(func(rtxn db.RTxn) interface{} {
+		var referrers []*common.VarUUId
+		indexed, err := db.ReverseRefsComplete(rtxn)
+		if err == nil && indexed {
+			referrers, err = db.ReadReferrers(rtxn, vUUId)
+		} else if err == nil {
+			referrers, err = i.scanReferrers(rtxn, vUUId)
+		}
+		if err != nil {
+			rtxn.Error(err)
+			return nil
+		}
+		rv.Indexed = indexed
+		for _, referrer := range referrers {
+			rv.Referrers = append(rv.Referrers, hexId(referrer[:]))
+		}
+		return nil
+	}).ResultError()
+	if err != nil {
+		return err
+	}
+	return i.print(rv)
+}
+
+func (i *inspector) scanReferrers(rtxn db.RTxn, target *common.VarUUId) ([]*common.VarUUId, error) {
+	var vUUIds []*common.VarUUId
+	var err error
+	rtxn.WithCursor(db.Vars, func(cursor db.Cursor) interface{} {
+		key, _, err1 := cursor.First()
+		for ; err1 == nil; key, _, err1 = cursor.Next() {
+			vUUIds = append(vUUIds, common.MakeVarUUId(key))
+		}
+		if err1 != db.NotFound {
+			err = err1
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var referrers []*common.VarUUId
+	for _, vUUId := range vUUIds {
+		references, err := eng.ReadReferences(rtxn, i.store.DB, vUUId)
+		if err != nil {
+			return nil, err
+		}
+		for _, reference := range references {
+			if reference.Compare(target) == common.EQ {
+				referrers = append(referrers, vUUId)
+				break
+			}
+		}
+	}
+	return referrers, nil
+}
+
+func parseVarUUId(str string) (*common.VarUUId, error) {
+	bites, err := export.DecodeId(str, common.KeyLen)
+	if err != nil {
+		return nil, err
+	}
+	return common.MakeVarUUId(bites), nil
+}
+
+// print writes JSON as one object per line, or text as an indented
+// list of fields, with a blank line between objects.
+func (i *inspector) print(v interface{}) error {
+	if i.asJSON {
+		return json.NewEncoder(i.out).Encode(v)
+	}
+	buf := []string{}
+	printText(&buf, "", reflect.ValueOf(v))
+	buf = append(buf, "")
+	_, err := io.WriteString(i.out, strings.Join(buf, "\n")+"\n")
+	return err
+}
+
+func printText(buf *[]string, indent string, v reflect.Value) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		*buf = append(*buf, indent+textValue(v))
+		return
+	}
+	t := v.Type()
+	for idx := 0; idx < t.NumField(); idx++ {
+		name, field := t.Field(idx).Name, v.Field(idx)
+		switch field.Kind() {
+		case reflect.Ptr:
+			if !field.IsNil() {
+				*buf = append(*buf, fmt.Sprintf("%s%s:", indent, name))
+				printText(buf, indent+"  ", field)
+			}
+		case reflect.Struct:
+			*buf = append(*buf, fmt.Sprintf("%s%s:", indent, name))
+			printText(buf, indent+"  ", field)
+		case reflect.Slice:
+			if field.Len() == 0 || field.Type().Elem().Kind() != reflect.Struct {
+				*buf = append(*buf, fmt.Sprintf("%s%s: %s", indent, name, textValue(field)))
+			} else {
+				*buf = append(*buf, fmt.Sprintf("%s%s: (%v)", indent, name, field.Len()))
+				for idy := 0; idy < field.Len(); idy++ {
+					*buf = append(*buf, fmt.Sprintf("%s  [%v]", indent, idy))
+					printText(buf, indent+"    ", field.Index(idy))
+				}
+			}
+		default:
+			*buf = append(*buf, fmt.Sprintf("%s%s: %s", indent, name, textValue(field)))
+		}
+	}
+}
+
+func textValue(v reflect.Value) string {
+	switch {
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		return base64.StdEncoding.EncodeToString(v.Bytes())
+	case v.Kind() == reflect.Map:
+		keys := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			keys = append(keys, fmt.Sprintf("%v:%v", k.Interface(), v.MapIndex(k).Interface()))
+		}
+		sort.Strings(keys)
+		return "{" + strings.Join(keys, ", ") + "}"
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/datadir"
+	"goshawkdb.io/server/db"
+	eng "goshawkdb.io/server/txnengine"
+	"strings"
+	"testing"
+)
+
+func testVarUUId(n byte) *common.VarUUId {
+	vUUId := common.MakeVarUUId(make([]byte, common.KeyLen))
+	vUUId[0] = n
+	return vUUId
+}
+
+// putTestVar writes vUUId to the store as written by txnNum, with
+// read references to refs.
+func putTestVar(t *testing.T, s *datadir.Store, vUUId *common.VarUUId, txnNum byte, value string, refs ...*common.VarUUId) {
+	seg := capn.NewBuffer(nil)
+	wrapper := msgs.NewRootActionListWrapper(seg)
+	actions := msgs.NewActionList(seg, 1)
+	wrapper.SetActions(actions)
+	action := actions.At(0)
+	action.SetVarId(vUUId[:])
+	action.SetCreate()
+	action.Create().SetValue([]byte(value))
+	refsCap := msgs.NewVarIdPosList(seg, len(refs))
+	for idx, ref := range refs {
+		refCap := refsCap.At(idx)
+		refCap.SetId(ref[:])
+		refCap.SetPositions(seg.NewUInt8List(3))
+		capability := cmsgs.NewCapability(seg)
+		capability.SetRead()
+		refCap.SetCapability(capability)
+	}
+	action.Create().SetReferences(refsCap)
+
+	txnId := &common.TxnId{}
+	txnId[7] = txnNum
+	txnSeg := capn.NewBuffer(nil)
+	txnCap := msgs.NewRootTxn(txnSeg)
+	txnCap.SetId(txnId[:])
+	txnCap.SetActions(server.SegToBytes(seg))
+
+	varSeg := capn.NewBuffer(nil)
+	varCap := msgs.NewRootVar(varSeg)
+	varCap.SetId(vUUId[:])
+	varCap.SetPositions(varSeg.NewUInt8List(3))
+	varCap.SetWriteTxnId(txnId[:])
+	varCap.SetWriteTxnClock(eng.NewVectorClock().AsMutable().Bump(vUUId, 1).AsData())
+
+	_, err := s.DB.ReadWriteTransaction(func(rwtxn db.RWTxn) interface{} {
+		if err := s.DB.WriteTxnToDisk(rwtxn, txnId, server.SegToBytes(txnSeg)); err != nil {
+			rwtxn.Error(err)
+		} else if err = rwtxn.Put(db.Vars, vUUId[:], s.DB.SealRecord(db.Vars, vUUId[:], server.SegToBytes(varSeg))); err != nil {
+			rwtxn.Error(err)
+		}
+		return nil
+	}).ResultError()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestInspector returns an inspector, writing JSON, over a store
+// holding root -> a -> b -> root, plus root -> missing, which is not
+// held in the store.
+func newTestInspector(t *testing.T) (*inspector, *bytes.Buffer) {
+	s := &datadir.Store{RMId: 1, DB: db.DB.WithStorage(db.NewMemoryStorage())}
+	putTestVar(t, s, testVarUUId(1), 1, "root", testVarUUId(2), testVarUUId(9))
+	putTestVar(t, s, testVarUUId(2), 2, "a", testVarUUId(3))
+	putTestVar(t, s, testVarUUId(3), 3, "b", testVarUUId(1))
+	out := new(bytes.Buffer)
+	return &inspector{store: s, asJSON: true, out: out}, out
+}
+
+func decodeRefsViews(t *testing.T, out *bytes.Buffer) []*refsView {
+	var views []*refsView
+	dec := json.NewDecoder(out)
+	for dec.More() {
+		rv := &refsView{}
+		if err := dec.Decode(rv); err != nil {
+			t.Fatal(err)
+		}
+		views = append(views, rv)
+	}
+	return views
+}
+
+func TestInspectorRefs(t *testing.T) {
+	i, out := newTestInspector(t)
+	defer i.store.Shutdown()
+
+	if err := i.refs(hexId(testVarUUId(1)[:]), 0); err != nil {
+		t.Fatal(err)
+	}
+	views := decodeRefsViews(t, out)
+	// Breadth first, and each var is visited once despite the cycle.
+	expected := []struct {
+		vUUId   *common.VarUUId
+		depth   int
+		present bool
+		value   string
+	}{
+		{testVarUUId(1), 0, true, "root"},
+		{testVarUUId(2), 1, true, "a"},
+		{testVarUUId(9), 1, false, ""},
+		{testVarUUId(3), 2, true, "b"},
+	}
+	if len(views) != len(expected) {
+		t.Fatalf("Expected %v vars; got %v", len(expected), len(views))
+	}
+	for idx, exp := range expected {
+		rv := views[idx]
+		if rv.VarUUId != hexId(exp.vUUId[:]) || rv.Depth != exp.depth || rv.Present != exp.present || string(rv.Value) != exp.value {
+			t.Fatalf("Expected %v at depth %v (present: %v, value: %q); got %v", exp.vUUId, exp.depth, exp.present, exp.value, rv)
+		}
+	}
+
+	if err := i.refs(hexId(testVarUUId(1)[:]), 1); err != nil {
+		t.Fatal(err)
+	}
+	if views = decodeRefsViews(t, out); len(views) != 3 {
+		t.Fatalf("Expected the walk to stop at depth 1; got %v vars", len(views))
+	}
+
+	if err := i.refs("0102", 0); err == nil {
+		t.Fatal("Expected a short VarUUId to be refused")
+	}
+}
+
+func TestInspectorReferrers(t *testing.T) {
+	i, out := newTestInspector(t)
+	defer i.store.Shutdown()
+
+	// The reverse reference index is not complete, so every var is
+	// read.
+	if err := i.referrers(hexId(testVarUUId(1)[:])); err != nil {
+		t.Fatal(err)
+	}
+	rv := &referrersView{}
+	if err := json.NewDecoder(out).Decode(rv); err != nil {
+		t.Fatal(err)
+	}
+	if rv.Indexed || len(rv.Referrers) != 1 || rv.Referrers[0] != hexId(testVarUUId(3)[:]) {
+		t.Fatalf("Expected only %v to refer to %v, unindexed; got %v", testVarUUId(3), testVarUUId(1), rv)
+	}
+}
+
+func TestInspectorPrintText(t *testing.T) {
+	out := new(bytes.Buffer)
+	i := &inspector{out: out}
+	rv := &refsView{
+		VarUUId:    "01",
+		Present:    true,
+		Value:      []byte("hi"),
+		References: []referenceView{{}, {}},
+	}
+	if err := i.print(rv); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(out.String(), "\n")
+	for _, expected := range []string{"VarUUId: 01", "Present: true", "Value: aGk=", "References: (2)", "  [1]"} {
+		found := false
+		for _, line := range lines {
+			if found = line == expected; found {
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("Expected a line %q; got %q", expected, out.String())
+		}
+	}
+	if !strings.HasSuffix(out.String(), "\n\n") {
+		t.Fatal("Expected objects to be separated by a blank line")
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/configuration"
+	"goshawkdb.io/server/export"
+	eng "goshawkdb.io/server/txnengine"
+	"sort"
+)
+
+// The views are what we print: both as JSON and as indented text.
+
+type varView struct {
+	VarUUId       string
+	Positions     []int
+	WriteTxnId    string
+	WriteTxnClock clockView
+	WritesClock   clockView
+}
+
+type clockView map[string]uint64
+
+type txnView struct {
+	TxnId              string
+	Submitter          string
+	SubmitterBootCount uint32
+	Retry              bool
+	FInc               uint8
+	TopologyVersion    uint32
+	Actions            []actionView
+	Allocations        []allocationView
+}
+
+type actionView struct {
+	VarUUId    string
+	Type       string
+	Version    string          `json:",omitempty"`
+	Positions  []int           `json:",omitempty"`
+	Value      []byte          `json:",omitempty"`
+	References []referenceView `json:",omitempty"`
+}
+
+type referenceView struct {
+	VarUUId    string
+	Positions  []int
+	Capability string
+}
+
+type allocationView struct {
+	RMId          string
+	ActionIndices []uint16
+	Active        uint32
+}
+
+type acceptorView struct {
+	TxnId     string
+	Outcome   outcomeView
+	SendToAll bool
+	Instances []instancesView
+}
+
+type outcomeView struct {
+	Result  string
+	Clock   clockView      `json:",omitempty"`
+	Updates []updateView   `json:",omitempty"`
+	Voters  []outcomeIdVar `json:",omitempty"`
+}
+
+type updateView struct {
+	TxnId   string
+	Clock   clockView
+	Actions []actionView
+}
+
+type outcomeIdVar struct {
+	VarUUId   string
+	Instances []outcomeIdInstance
+}
+
+type outcomeIdInstance struct {
+	RMId string
+	Vote string
+}
+
+type instancesView struct {
+	VarUUId   string
+	Instances []acceptedInstanceView
+	Result    *ballotView `json:",omitempty"`
+}
+
+type acceptedInstanceView struct {
+	RMId        string
+	RoundNumber uint64
+	Ballot      *ballotView
+}
+
+type ballotView struct {
+	VarUUId      string
+	Vote         string
+	Clock        clockView
+	BadReadTxnId string `json:",omitempty"`
+}
+
+type proposerView struct {
+	TxnId     string
+	Acceptors []string
+}
+
+type topologyView struct {
+	ClusterId   string
+	ClusterUUId uint64
+	Version     uint32
+	DBVersion   string
+	Hosts       []string
+	F           uint8
+	MaxRMCount  uint16
+	NoSync      bool
+	RMs         []string
+	RMsRemoved  []string
+	Roots       []rootView
+	Next        string `json:",omitempty"`
+}
+
+type rootView struct {
+	Name      string
+	VarUUId   string
+	Positions []int
+}
+
+func rmIdString(rmId common.RMId) string {
+	return fmt.Sprint(rmId)
+}
+
+// positions are held as ints so that they don't get treated as
+// bytes (base64) by encoding/json.
+func positionsView(positions capn.UInt8List) []int {
+	ps := make([]int, positions.Len())
+	for idx := range ps {
+		ps[idx] = int(positions.At(idx))
+	}
+	return ps
+}
+
+func hexId(bites []byte) string {
+	return hex.EncodeToString(bites)
+}
+
+func newClockView(data []byte) clockView {
+	cv := make(clockView)
+	eng.VectorClockFromData(data, true).ForEach(func(vUUId *common.VarUUId, v uint64) bool {
+		cv[hexId(vUUId[:])] = v
+		return true
+	})
+	return cv
+}
+
+func newVarView(varCap *msgs.Var) *varView {
+	return &varView{
+		VarUUId:       hexId(varCap.Id()),
+		Positions:     positionsView(varCap.Positions()),
+		WriteTxnId:    hexId(varCap.WriteTxnId()),
+		WriteTxnClock: newClockView(varCap.WriteTxnClock()),
+		WritesClock:   newClockView(varCap.WritesClock()),
+	}
+}
+
+func newTxnView(txn *eng.TxnReader) *txnView {
+	txnCap := txn.Txn
+	tv := &txnView{
+		TxnId:              hexId(txn.Id[:]),
+		Submitter:          rmIdString(common.RMId(txnCap.Submitter())),
+		SubmitterBootCount: txnCap.SubmitterBootCount(),
+		Retry:              txnCap.Retry(),
+		FInc:               txnCap.FInc(),
+		TopologyVersion:    txnCap.TopologyVersion(),
+		Actions:            newActionViews(txn.Actions(true).Actions()),
+	}
+	allocations := txnCap.Allocations()
+	tv.Allocations = make([]allocationView, allocations.Len())
+	for idx := range tv.Allocations {
+		alloc := allocations.At(idx)
+		tv.Allocations[idx] = allocationView{
+			RMId:          rmIdString(common.RMId(alloc.RmId())),
+			ActionIndices: alloc.ActionIndices().ToArray(),
+			Active:        alloc.Active(),
+		}
+	}
+	return tv
+}
+
+func newActionViews(actions *msgs.Action_List) []actionView {
+	views := make([]actionView, actions.Len())
+	for idx := range views {
+		action := actions.At(idx)
+		av := &views[idx]
+		av.VarUUId = hexId(action.VarId())
+		var refs *msgs.VarIdPos_List
+		switch action.Which() {
+		case msgs.ACTION_READ:
+			av.Type = "read"
+			av.Version = hexId(action.Read().Version())
+		case msgs.ACTION_WRITE:
+			av.Type = "write"
+			w := action.Write()
+			av.Value = w.Value()
+			r := w.References()
+			refs = &r
+		case msgs.ACTION_READWRITE:
+			av.Type = "readwrite"
+			rw := action.Readwrite()
+			av.Version = hexId(rw.Version())
+			av.Value = rw.Value()
+			r := rw.References()
+			refs = &r
+		case msgs.ACTION_CREATE:
+			av.Type = "create"
+			c := action.Create()
+			av.Positions = positionsView(c.Positions())
+			av.Value = c.Value()
+			r := c.References()
+			refs = &r
+		case msgs.ACTION_ROLL:
+			av.Type = "roll"
+			r := action.Roll()
+			av.Version = hexId(r.Version())
+			av.Value = r.Value()
+			rr := r.References()
+			refs = &rr
+		case msgs.ACTION_MISSING:
+			av.Type = "missing"
+		}
+		if refs != nil {
+			av.References = newReferenceViews(refs)
+		}
+	}
+	return views
+}
+
+func newReferenceViews(refs *msgs.VarIdPos_List) []referenceView {
+	views := make([]referenceView, refs.Len())
+	for idx := range views {
+		ref := refs.At(idx)
+		views[idx] = referenceView{
+			VarUUId:    hexId(ref.Id()),
+			Positions:  positionsView(ref.Positions()),
+			Capability: export.CapabilityName(ref.Capability()),
+		}
+	}
+	return views
+}
+
+func newAcceptorView(txnId *common.TxnId, state *msgs.AcceptorState) *acceptorView {
+	outcome := state.Outcome()
+	av := &acceptorView{
+		TxnId:     hexId(txnId[:]),
+		Outcome:   *newOutcomeView(&outcome),
+		SendToAll: state.SendToAll(),
+	}
+	instancesList := state.Instances()
+	av.Instances = make([]instancesView, instancesList.Len())
+	for idx := range av.Instances {
+		instancesForVar := instancesList.At(idx)
+		iv := &av.Instances[idx]
+		iv.VarUUId = hexId(instancesForVar.VarId())
+		instances := instancesForVar.Instances()
+		iv.Instances = make([]acceptedInstanceView, instances.Len())
+		for idy := range iv.Instances {
+			instance := instances.At(idy)
+			iv.Instances[idy] = acceptedInstanceView{
+				RMId:        rmIdString(common.RMId(instance.RmId())),
+				RoundNumber: instance.RoundNumber(),
+				Ballot:      newBallotView(instance.Ballot()),
+			}
+		}
+		if result := instancesForVar.Result(); len(result) != 0 {
+			iv.Result = newBallotView(result)
+		}
+	}
+	return av
+}
+
+func newOutcomeView(outcome *msgs.Outcome) *outcomeView {
+	ov := &outcomeView{}
+	switch outcome.Which() {
+	case msgs.OUTCOME_COMMIT:
+		ov.Result = "commit"
+		ov.Clock = newClockView(outcome.Commit())
+	default:
+		abort := outcome.Abort()
+		if abort.Which() == msgs.OUTCOMEABORT_RESUBMIT {
+			ov.Result = "abort-resubmit"
+		} else {
+			ov.Result = "abort-rerun"
+			updates := abort.Rerun()
+			ov.Updates = make([]updateView, updates.Len())
+			for idx := range ov.Updates {
+				update := updates.At(idx)
+				ov.Updates[idx] = updateView{
+					TxnId:   hexId(update.TxnId()),
+					Clock:   newClockView(update.Clock()),
+					Actions: newActionViews(eng.TxnActionsFromData(update.Actions(), true).Actions()),
+				}
+			}
+		}
+	}
+	ids := outcome.Id()
+	ov.Voters = make([]outcomeIdVar, ids.Len())
+	for idx := range ov.Voters {
+		id := ids.At(idx)
+		accepted := id.AcceptedInstances()
+		voter := &ov.Voters[idx]
+		voter.VarUUId = hexId(id.VarId())
+		voter.Instances = make([]outcomeIdInstance, accepted.Len())
+		for idy := range voter.Instances {
+			instance := accepted.At(idy)
+			vote := "commit"
+			switch instance.Vote() {
+			case msgs.VOTEENUM_ABORTBADREAD:
+				vote = "abort-badread"
+			case msgs.VOTEENUM_ABORTDEADLOCK:
+				vote = "abort-deadlock"
+			}
+			voter.Instances[idy] = outcomeIdInstance{
+				RMId: rmIdString(common.RMId(instance.RmId())),
+				Vote: vote,
+			}
+		}
+	}
+	return ov
+}
+
+func newBallotView(data []byte) *ballotView {
+	ballot := eng.BallotFromData(data)
+	bv := &ballotView{
+		VarUUId: hexId(ballot.VarUUId[:]),
+		Clock:   newClockView(ballot.Clock.AsData()),
+	}
+	switch ballot.VoteCap.Which() {
+	case msgs.VOTE_COMMIT:
+		bv.Vote = "commit"
+	case msgs.VOTE_ABORTBADREAD:
+		bv.Vote = "abort-badread"
+		bv.BadReadTxnId = hexId(ballot.VoteCap.AbortBadRead().TxnId())
+	case msgs.VOTE_ABORTDEADLOCK:
+		bv.Vote = "abort-deadlock"
+	}
+	return bv
+}
+
+func newProposerView(txnId *common.TxnId, state *msgs.ProposerState) *proposerView {
+	acceptors := state.Acceptors()
+	pv := &proposerView{
+		TxnId:     hexId(txnId[:]),
+		Acceptors: make([]string, acceptors.Len()),
+	}
+	for idx := range pv.Acceptors {
+		pv.Acceptors[idx] = rmIdString(common.RMId(acceptors.At(idx)))
+	}
+	return pv
+}
+
+func newTopologyView(topology *configuration.Topology) *topologyView {
+	tv := &topologyView{
+		ClusterId:   topology.ClusterId,
+		ClusterUUId: topology.ClusterUUId(),
+		Version:     topology.Version,
+		DBVersion:   hexId(topology.DBVersion[:]),
+		Hosts:       topology.Hosts,
+		F:           topology.F,
+		MaxRMCount:  topology.MaxRMCount,
+		NoSync:      topology.NoSync,
+	}
+	for _, rmId := range topology.RMs() {
+		tv.RMs = append(tv.RMs, rmIdString(rmId))
+	}
+	for rmId := range topology.RMsRemoved() {
+		tv.RMsRemoved = append(tv.RMsRemoved, rmIdString(rmId))
+	}
+	sort.Strings(tv.RMsRemoved)
+	names := topology.RootNames()
+	for idx, root := range topology.Roots {
+		rv := rootView{
+			VarUUId:   hexId(root.VarUUId[:]),
+			Positions: positionsView(capn.UInt8List(*root.Positions)),
+		}
+		if idx < len(names) {
+			rv.Name = names[idx]
+		}
+		tv.Roots = append(tv.Roots, rv)
+	}
+	if next := topology.Next(); next != nil {
+		tv.Next = next.String()
+	}
+	return tv
+}
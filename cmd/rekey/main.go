@@ -0,0 +1,175 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server/datadir"
+	"goshawkdb.io/server/db"
+	"log"
+	"os"
+)
+
+const rekeyBatchSize = 1024
+
+type kv struct {
+	key   []byte
+	value []byte
+}
+
+// The rekey tool rewrites every record in the Vars, Transactions,
+// Proposers and BallotOutcomes tables with a new key. With no new key,
+// it decrypts them; with no old key, it encrypts data which was
+// previously in the clear. Txns and vars are compressed as they are
+// rewritten only if -compress is given. Encrypted records written
+// before records were bound to their table and key are bound as they
+// are rewritten. Each batch is rewritten atomically and
+// records written with either key can be read, so if it is
+// interrupted it is safe to run again. The server must be stopped.
+func main() {
+	log.SetPrefix(common.ProductName + "Rekey ")
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	log.Println(os.Args)
+
+	var oldKeyFile, newKeyFile string
+	var compress bool
+	flag.StringVar(&oldKeyFile, "old-keyfile", "", "`Path` to the key file the data dirs are currently encrypted with (omit if not encrypted).")
+	flag.StringVar(&newKeyFile, "new-keyfile", "", "`Path` to the key file to encrypt the data dirs with (omit to decrypt).")
+	flag.BoolVar(&compress, "compress", false, "Compress transactions and values as they are rewritten (otherwise they are decompressed).")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s: [flags] dir...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	dirs := flag.Args()
+	if len(dirs) == 0 {
+		log.Fatal("No dirs supplied")
+	}
+
+	oldKey, err := db.LoadKeyFile(oldKeyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	newKey, err := db.LoadKeyFile(newKeyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var others [][]byte
+	if oldKey != nil {
+		others = append(others, oldKey)
+	}
+	keys, err := db.NewKeyring(newKey, others...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if keys != nil {
+		// Records not yet encrypted are what we are here to encrypt.
+		keys.AcceptPlaintext()
+	}
+	db.DB.Keys = keys
+	db.DB.Compress = compress
+
+	for _, dir := range dirs {
+		if err := rekeyDir(dir); err != nil {
+			log.Fatal(err)
+		}
+	}
+	log.Println("Finished.")
+}
+
+func rekeyDir(dir string) error {
+	s, err := datadir.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer s.Shutdown()
+
+	tables := []struct {
+		table        db.Table
+		compressible bool
+	}{
+		{db.Vars, true},
+		{db.Transactions, true},
+		{db.Proposers, false},
+		{db.BallotOutcomes, false},
+	}
+	for _, t := range tables {
+		count, err := rekeyTable(s, t.table, t.compressible)
+		if err != nil {
+			return fmt.Errorf("%v: %v: %v", s, t.table, err)
+		}
+		log.Printf("%v: %v: rewrote %v records\n", s, t.table, count)
+	}
+	return nil
+}
+
+func rekeyTable(s *datadir.Store, table db.Table, compressible bool) (int, error) {
+	seal := s.DB.SealRecord
+	if compressible {
+		seal = s.DB.SealCompressibleRecord
+	}
+	count := 0
+	var position []byte
+	for {
+		batch, next, err := readBatch(s, table, position)
+		if err != nil {
+			return count, err
+		}
+		_, err = s.DB.ReadWriteTransaction(func(rwtxn db.RWTxn) interface{} {
+			for _, entry := range batch {
+				payload, err := s.DB.OpenRecord(table, entry.key, entry.value)
+				if err != nil {
+					rwtxn.Error(fmt.Errorf("%x: %v", entry.key, err))
+					return nil
+				}
+				if err = rwtxn.Put(table, entry.key, seal(table, entry.key, payload)); err != nil {
+					rwtxn.Error(err)
+					return nil
+				}
+			}
+			return nil
+		}).ResultError()
+		if err != nil {
+			return count, err
+		}
+		count += len(batch)
+		if next == nil {
+			return count, nil
+		}
+		position = next
+	}
+}
+
+// readBatch returns up to rekeyBatchSize entries starting from
+// position, and the key to start the next batch from, which is nil
+// once there are no more entries.
+func readBatch(s *datadir.Store, table db.Table, position []byte) ([]*kv, []byte, error) {
+	batch := make([]*kv, 0, rekeyBatchSize)
+	var next []byte
+	_, err := s.DB.ReadonlyTransaction(func(rtxn db.RTxn) interface{} {
+		rtxn.WithCursor(table, func(cursor db.Cursor) interface{} {
+			// The cursor returns copies, so we can keep them.
+			var key, value []byte
+			var err error
+			if position == nil {
+				key, value, err = cursor.First()
+			} else {
+				key, value, err = cursor.Seek(position)
+			}
+			for ; err == nil; key, value, err = cursor.Next() {
+				if len(batch) == rekeyBatchSize {
+					next = key
+					return nil
+				}
+				batch = append(batch, &kv{key: key, value: value})
+			}
+			if err != db.NotFound {
+				rtxn.Error(err)
+			}
+			return nil
+		})
+		return nil
+	}).ResultError()
+	return batch, next, err
+}
@@ -0,0 +1,274 @@
+package configuration
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	msgs "goshawkdb.io/server/capnp"
+	"log"
+	"path"
+)
+
+// ClientCertificateAuthority allows clients to authenticate with any
+// certificate issued by an external CA, rather than only with those
+// listed by fingerprint. The capabilities granted are given by the
+// first rule which matches the certificate.
+type ClientCertificateAuthority struct {
+	Certificate string
+	Rules       []*ClientCertificateRule
+}
+
+// ClientCertificateRule matches a client certificate by its subject.
+// Each attribute is a pattern, as understood by path.Match, and must
+// match if given: CommonName the subject's common name;
+// OrganizationalUnit any of the subject's organizational units; URI
+// any of the URIs in the subject alternative names. At least one
+// attribute must be given.
+type ClientCertificateRule struct {
+	CommonName         string
+	OrganizationalUnit string
+	URI                string
+	Roots              map[string]*RootCapability
+}
+
+type clientCA struct {
+	certificate *x509.Certificate
+	roots       *x509.CertPool
+	rules       []*clientCARule
+}
+
+type clientCARule struct {
+	commonName         string
+	organizationalUnit string
+	uri                string
+	roots              map[string]*common.Capability
+}
+
+func decodeClientCAs(seg *capn.Segment, cas []*ClientCertificateAuthority) ([]*clientCA, error) {
+	result := make([]*clientCA, 0, len(cas))
+	for _, ca := range cas {
+		block, rest := pem.Decode([]byte(ca.Certificate))
+		for block != nil && block.Type != "CERTIFICATE" {
+			block, rest = pem.Decode(rest)
+		}
+		if block == nil {
+			return nil, errors.New("No certificate found in ClientCertificateAuthorities entry")
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		} else if !cert.IsCA {
+			return nil, fmt.Errorf("Client certificate authority %v is not a CA certificate", cert.Subject)
+		}
+		if len(ca.Rules) == 0 {
+			return nil, fmt.Errorf("No rules configured for client certificate authority %v; at least 1 needed", cert.Subject)
+		}
+		rules := make([]*clientCARule, len(ca.Rules))
+		for idx, rule := range ca.Rules {
+			what := fmt.Sprintf("Client certificate authority %v, rule %v", cert.Subject, idx)
+			if rule.CommonName == "" && rule.OrganizationalUnit == "" && rule.URI == "" {
+				return nil, fmt.Errorf("%v: no attributes to match given", what)
+			}
+			for _, pattern := range []string{rule.CommonName, rule.OrganizationalUnit, rule.URI} {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return nil, fmt.Errorf("%v: invalid pattern %v: %v", what, pattern, err)
+				}
+			}
+			roots, err := rootCapabilities(seg, what, rule.Roots)
+			if err != nil {
+				return nil, err
+			}
+			rules[idx] = &clientCARule{
+				commonName:         rule.CommonName,
+				organizationalUnit: rule.OrganizationalUnit,
+				uri:                rule.URI,
+				roots:              roots,
+			}
+		}
+		result = append(result, newClientCA(cert, rules))
+	}
+	return result, nil
+}
+
+func newClientCA(cert *x509.Certificate, rules []*clientCARule) *clientCA {
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return &clientCA{
+		certificate: cert,
+		roots:       roots,
+		rules:       rules,
+	}
+}
+
+// rootCapabilities converts the capabilities on each root given in
+// the configuration file.
+func rootCapabilities(seg *capn.Segment, what string, rootsCapability map[string]*RootCapability) (map[string]*common.Capability, error) {
+	if len(rootsCapability) == 0 {
+		return nil, fmt.Errorf("No roots configured for %v; at least 1 needed", what)
+	}
+	roots := make(map[string]*common.Capability, len(rootsCapability))
+	for name, rootCapability := range rootsCapability {
+		if !rootCapability.Read && !rootCapability.Write {
+			return nil, fmt.Errorf("%v, root %s: no capability has been granted.", what, name)
+		}
+		var capability *common.Capability
+		if rootCapability.Read && rootCapability.Write {
+			capability = common.MaxCapability
+		} else {
+			cap := cmsgs.NewCapability(seg)
+			switch {
+			case rootCapability.Read:
+				cap.SetRead()
+			case rootCapability.Write:
+				cap.SetWrite()
+			}
+			capability = common.NewCapability(cap)
+		}
+		roots[name] = capability
+	}
+	return roots, nil
+}
+
+// ClientCertificateRoots returns the roots granted to a client by the
+// first client certificate authority which issued the client's
+// certificate and which has a rule matching it, or nil if there is
+// none. peerCerts are as presented by the client: its own
+// certificate first, followed by any intermediates.
+func (config *Configuration) ClientCertificateRoots(peerCerts []*x509.Certificate) map[string]*common.Capability {
+	if len(peerCerts) == 0 || len(config.clientCAs) == 0 {
+		return nil
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range peerCerts[1:] {
+		intermediates.AddCert(cert)
+	}
+	cert := peerCerts[0]
+	for _, ca := range config.clientCAs {
+		opts := x509.VerifyOptions{
+			Roots:         ca.roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		if _, err := cert.Verify(opts); err != nil {
+			continue
+		}
+		for _, rule := range ca.rules {
+			if rule.matches(cert) {
+				return rule.roots
+			}
+		}
+	}
+	return nil
+}
+
+func (rule *clientCARule) matches(cert *x509.Certificate) bool {
+	if rule.commonName != "" && !patternMatches(rule.commonName, cert.Subject.CommonName) {
+		return false
+	}
+	if rule.organizationalUnit != "" && !patternMatches(rule.organizationalUnit, cert.Subject.OrganizationalUnit...) {
+		return false
+	}
+	if rule.uri != "" {
+		uris := make([]string, len(cert.URIs))
+		for idx, uri := range cert.URIs {
+			uris[idx] = uri.String()
+		}
+		if !patternMatches(rule.uri, uris...) {
+			return false
+		}
+	}
+	return true
+}
+
+func patternMatches(pattern string, values ...string) bool {
+	for _, value := range values {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func clientCAsFromCap(cas *msgs.ClientCA_List) []*clientCA {
+	result := make([]*clientCA, 0, cas.Len())
+	for idx, l := 0, cas.Len(); idx < l; idx++ {
+		caCap := cas.At(idx)
+		// These were verified when the configuration was loaded, but
+		// not necessarily by this node, nor this version of it.
+		cert, err := x509.ParseCertificate(caCap.Certificate())
+		if err != nil {
+			log.Printf("Configuration: ignoring client certificate authority %v of %v: unable to parse it: %v. Clients which rely on it will be refused.\n", idx+1, l, err)
+			continue
+		}
+		rulesCap := caCap.Rules()
+		rules := make([]*clientCARule, rulesCap.Len())
+		for idy := range rules {
+			ruleCap := rulesCap.At(idy)
+			rootsCap := ruleCap.Roots()
+			roots := make(map[string]*common.Capability, rootsCap.Len())
+			for idz, m := 0, rootsCap.Len(); idz < m; idz++ {
+				rootCap := rootsCap.At(idz)
+				roots[rootCap.Name()] = common.NewCapability(rootCap.Capability())
+			}
+			rules[idy] = &clientCARule{
+				commonName:         ruleCap.CommonName(),
+				organizationalUnit: ruleCap.OrganizationalUnit(),
+				uri:                ruleCap.Uri(),
+				roots:              roots,
+			}
+		}
+		result = append(result, newClientCA(cert, rules))
+	}
+	return result
+}
+
+func clientCAsToCap(seg *capn.Segment, cas []*clientCA) msgs.ClientCA_List {
+	casCap := msgs.NewClientCAList(seg, len(cas))
+	for idx, ca := range cas {
+		caCap := msgs.NewClientCA(seg)
+		caCap.SetCertificate(ca.certificate.Raw)
+		rulesCap := msgs.NewClientCARuleList(seg, len(ca.rules))
+		for idy, rule := range ca.rules {
+			ruleCap := msgs.NewClientCARule(seg)
+			ruleCap.SetCommonName(rule.commonName)
+			ruleCap.SetOrganizationalUnit(rule.organizationalUnit)
+			ruleCap.SetUri(rule.uri)
+			rootsCap := msgs.NewRootList(seg, len(rule.roots))
+			idz := 0
+			for name, capability := range rule.roots {
+				rootCap := msgs.NewRoot(seg)
+				rootCap.SetName(name)
+				rootCap.SetCapability(capability.Capability)
+				rootsCap.Set(idz, rootCap)
+				idz++
+			}
+			ruleCap.SetRoots(rootsCap)
+			rulesCap.Set(idy, ruleCap)
+		}
+		caCap.SetRules(rulesCap)
+		casCap.Set(idx, caCap)
+	}
+	return casCap
+}
+
+func (a *clientCA) equal(b *clientCA) bool {
+	if !a.certificate.Equal(b.certificate) || len(a.rules) != len(b.rules) {
+		return false
+	}
+	for idx, aRule := range a.rules {
+		bRule := b.rules[idx]
+		if aRule.commonName != bRule.commonName || aRule.organizationalUnit != bRule.organizationalUnit || aRule.uri != bRule.uri || len(aRule.roots) != len(bRule.roots) {
+			return false
+		}
+		for name, aCap := range aRule.roots {
+			if bCap, found := bRule.roots[name]; !found || !aCap.Equal(bCap) {
+				return false
+			}
+		}
+	}
+	return true
+}
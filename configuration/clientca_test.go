@@ -0,0 +1,176 @@
+package configuration
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+var testSerial int64
+
+// newTestCert creates a certificate from template, signed by parent,
+// or self-signed if parent is nil.
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSerial++
+	template.SerialNumber = big.NewInt(testSerial)
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if template.NotAfter.IsZero() {
+		template.NotAfter = time.Now().Add(time.Hour)
+	}
+	signer, signerCert := key, template
+	if parent != nil {
+		signer, signerCert = parent.key, parent.cert
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+func newTestCA(t *testing.T, name string, parent *testCert) *testCert {
+	return newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, parent)
+}
+
+func newTestClient(t *testing.T, parent *testCert, cn, ou, uri string, notAfter time.Time) *testCert {
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: cn},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		NotAfter:    notAfter,
+	}
+	if ou != "" {
+		template.Subject.OrganizationalUnit = []string{ou}
+	}
+	if uri != "" {
+		parsed, err := url.Parse(uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.URIs = []*url.URL{parsed}
+	}
+	return newTestCert(t, template, parent)
+}
+
+func certPEM(cert *testCert) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.cert.Raw}))
+}
+
+func TestDecodeClientCAs(t *testing.T) {
+	ca := newTestCA(t, "Client CA", nil)
+	keyDER, err := x509.MarshalECPrivateKey(ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	notCA := newTestClient(t, ca, "alice", "", "", time.Time{})
+	rules := []*ClientCertificateRule{{CommonName: "*", Roots: map[string]*RootCapability{"r": {Read: true, Write: true}}}}
+
+	tests := []struct {
+		name        string
+		certificate string
+		rules       []*ClientCertificateRule
+		ok          bool
+	}{
+		{"certificate", certPEM(ca), rules, true},
+		{"key before certificate", keyPEM + certPEM(ca), rules, true},
+		{"no certificate", keyPEM, rules, false},
+		{"not a CA", certPEM(notCA), rules, false},
+		{"no rules", certPEM(ca), nil, false},
+		{"no attributes", certPEM(ca), []*ClientCertificateRule{{Roots: rules[0].Roots}}, false},
+		{"bad pattern", certPEM(ca), []*ClientCertificateRule{{CommonName: "[", Roots: rules[0].Roots}}, false},
+		{"no roots", certPEM(ca), []*ClientCertificateRule{{CommonName: "*"}}, false},
+	}
+	for _, test := range tests {
+		cas, err := decodeClientCAs(nil, []*ClientCertificateAuthority{{Certificate: test.certificate, Rules: test.rules}})
+		switch {
+		case test.ok && err != nil:
+			t.Errorf("%v: %v", test.name, err)
+		case test.ok && !cas[0].certificate.Equal(ca.cert):
+			t.Errorf("%v: decoded the wrong certificate: %v", test.name, cas[0].certificate.Subject)
+		case !test.ok && err == nil:
+			t.Errorf("%v: expected error", test.name)
+		}
+	}
+}
+
+func TestClientCertificateRoots(t *testing.T) {
+	ca := newTestCA(t, "Client CA", nil)
+	intermediate := newTestCA(t, "Intermediate CA", ca)
+	otherCA := newTestCA(t, "Other CA", nil)
+
+	rootsFor := func(name string) map[string]*RootCapability {
+		return map[string]*RootCapability{name: {Read: true, Write: true}}
+	}
+	cas, err := decodeClientCAs(nil, []*ClientCertificateAuthority{{
+		Certificate: certPEM(ca),
+		Rules: []*ClientCertificateRule{
+			{CommonName: "admin-*", OrganizationalUnit: "ops", Roots: rootsFor("admin")},
+			{CommonName: "alice", Roots: rootsFor("cn")},
+			{OrganizationalUnit: "eng*", Roots: rootsFor("ou")},
+			{URI: "spiffe://example.org/app/*", Roots: rootsFor("uri")},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Configuration{clientCAs: cas}
+	expired := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name      string
+		peerCerts []*x509.Certificate
+		root      string
+	}{
+		{"common name", []*x509.Certificate{newTestClient(t, ca, "alice", "", "", time.Time{}).cert}, "cn"},
+		{"organizational unit", []*x509.Certificate{newTestClient(t, ca, "bob", "engineering", "", time.Time{}).cert}, "ou"},
+		{"uri", []*x509.Certificate{newTestClient(t, ca, "carol", "", "spiffe://example.org/app/web", time.Time{}).cert}, "uri"},
+		{"all attributes of first rule", []*x509.Certificate{newTestClient(t, ca, "admin-dave", "ops", "", time.Time{}).cert}, "admin"},
+		{"first matching rule wins", []*x509.Certificate{newTestClient(t, ca, "alice", "engineering", "", time.Time{}).cert}, "cn"},
+		{"only some attributes of rule", []*x509.Certificate{newTestClient(t, ca, "admin-erin", "sales", "", time.Time{}).cert}, ""},
+		{"uri outside pattern", []*x509.Certificate{newTestClient(t, ca, "carol", "", "spiffe://example.org/db/main", time.Time{}).cert}, ""},
+		{"no matching rule", []*x509.Certificate{newTestClient(t, ca, "mallory", "", "", time.Time{}).cert}, ""},
+		{"expired", []*x509.Certificate{newTestClient(t, ca, "alice", "", "", expired).cert}, ""},
+		{"other CA", []*x509.Certificate{newTestClient(t, otherCA, "alice", "", "", time.Time{}).cert}, ""},
+		{"via intermediate", []*x509.Certificate{newTestClient(t, intermediate, "alice", "", "", time.Time{}).cert, intermediate.cert}, "cn"},
+		{"missing intermediate", []*x509.Certificate{newTestClient(t, intermediate, "alice", "", "", time.Time{}).cert}, ""},
+		{"no certificate", nil, ""},
+	}
+	for _, test := range tests {
+		roots := config.ClientCertificateRoots(test.peerCerts)
+		if test.root == "" {
+			if roots != nil {
+				t.Errorf("%v: expected no roots; got %v", test.name, roots)
+			}
+		} else if _, found := roots[test.root]; !found || len(roots) != 1 {
+			t.Errorf("%v: expected root %v; got %v", test.name, test.root, roots)
+		}
+	}
+}
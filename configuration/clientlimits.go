@@ -0,0 +1,116 @@
+package configuration
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	msgs "goshawkdb.io/server/capnp"
+)
+
+// ClientLimits restrict what a client may do, so that a single
+// client cannot flood the cluster with txns or creates. Rates are
+// enforced per client across all of its connections to a node. A
+// zero limit means no limit.
+type ClientLimits struct {
+	TxnsPerSecond         uint32
+	BytesWrittenPerSecond uint64
+	CreatesPerMinute      uint32
+	MaxValueSize          uint32
+	MaxActionsPerTxn      uint32
+}
+
+func (a *ClientLimits) Equal(b *ClientLimits) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func (limits *ClientLimits) String() string {
+	return fmt.Sprintf("ClientLimits{TxnsPerSecond: %v, BytesWrittenPerSecond: %v, CreatesPerMinute: %v, MaxValueSize: %v, MaxActionsPerTxn: %v}",
+		limits.TxnsPerSecond, limits.BytesWrittenPerSecond, limits.CreatesPerMinute, limits.MaxValueSize, limits.MaxActionsPerTxn)
+}
+
+func decodeClientLimits(limitsMap map[string]*ClientLimits) (map[[sha256.Size]byte]*ClientLimits, error) {
+	result := make(map[[sha256.Size]byte]*ClientLimits, len(limitsMap))
+	for fingerprint, limits := range limitsMap {
+		fingerprintBytes, err := hex.DecodeString(fingerprint)
+		if err != nil {
+			return nil, err
+		} else if l := len(fingerprintBytes); l != sha256.Size {
+			return nil, fmt.Errorf("Invalid fingerprint in ClientLimits: expected %v bytes, and found %v", sha256.Size, l)
+		} else if limits == nil {
+			return nil, fmt.Errorf("No limits given for client fingerprint %v", fingerprint)
+		}
+		ary := [sha256.Size]byte{}
+		copy(ary[:], fingerprintBytes)
+		result[ary] = limits
+	}
+	return result, nil
+}
+
+func clientLimitsFromCap(limitsCap *msgs.ClientLimits) *ClientLimits {
+	return &ClientLimits{
+		TxnsPerSecond:         limitsCap.TxnsPerSecond(),
+		BytesWrittenPerSecond: limitsCap.BytesWrittenPerSecond(),
+		CreatesPerMinute:      limitsCap.CreatesPerMinute(),
+		MaxValueSize:          limitsCap.MaxValueSize(),
+		MaxActionsPerTxn:      limitsCap.MaxActionsPerTxn(),
+	}
+}
+
+func (limits *ClientLimits) addToSeg(seg *capn.Segment, fingerprint []byte) msgs.ClientLimits {
+	limitsCap := msgs.NewClientLimits(seg)
+	limitsCap.SetSha256(fingerprint)
+	limitsCap.SetTxnsPerSecond(limits.TxnsPerSecond)
+	limitsCap.SetBytesWrittenPerSecond(limits.BytesWrittenPerSecond)
+	limitsCap.SetCreatesPerMinute(limits.CreatesPerMinute)
+	limitsCap.SetMaxValueSize(limits.MaxValueSize)
+	limitsCap.SetMaxActionsPerTxn(limits.MaxActionsPerTxn)
+	return limitsCap
+}
+
+// The default limits are held in the list with an empty fingerprint.
+func clientLimitsListFromCap(config *Configuration, limitsList *msgs.ClientLimits_List) {
+	config.clientLimits = make(map[[sha256.Size]byte]*ClientLimits, limitsList.Len())
+	for idx, l := 0, limitsList.Len(); idx < l; idx++ {
+		limitsCap := limitsList.At(idx)
+		if fingerprint := limitsCap.Sha256(); len(fingerprint) == 0 {
+			config.defaultClientLimits = clientLimitsFromCap(&limitsCap)
+		} else {
+			ary := [sha256.Size]byte{}
+			copy(ary[:], fingerprint)
+			config.clientLimits[ary] = clientLimitsFromCap(&limitsCap)
+		}
+	}
+}
+
+func clientLimitsListToCap(seg *capn.Segment, config *Configuration) msgs.ClientLimits_List {
+	l := len(config.clientLimits)
+	if config.defaultClientLimits != nil {
+		l++
+	}
+	limitsList := msgs.NewClientLimitsList(seg, l)
+	idx := 0
+	for fingerprint, limits := range config.clientLimits {
+		fingerprintCopy := fingerprint
+		limitsList.Set(idx, limits.addToSeg(seg, fingerprintCopy[:]))
+		idx++
+	}
+	if config.defaultClientLimits != nil {
+		limitsList.Set(idx, config.defaultClientLimits.addToSeg(seg, []byte{}))
+	}
+	return limitsList
+}
+
+// LimitsForClient returns the limits which apply to the client with the
+// given certificate fingerprint: those configured specifically for
+// it, failing that the DefaultClientLimits, and failing that nil: no
+// limits.
+func (config *Configuration) LimitsForClient(fingerprint [sha256.Size]byte) *ClientLimits {
+	if limits, found := config.clientLimits[fingerprint]; found {
+		return limits
+	}
+	return config.defaultClientLimits
+}
@@ -2,16 +2,18 @@ package configuration
 
 import (
 	"crypto/sha256"
+	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	capn "github.com/glycerine/go-capnproto"
 	"goshawkdb.io/common"
-	cmsgs "goshawkdb.io/common/capnp"
 	"goshawkdb.io/server"
 	msgs "goshawkdb.io/server/capnp"
 	ch "goshawkdb.io/server/consistenthash"
+	"log"
 	"math/rand"
 	"net"
 	"os"
@@ -28,11 +30,19 @@ type Configuration struct {
 	MaxRMCount                    uint16
 	NoSync                        bool
 	ClientCertificateFingerprints map[string]map[string]*RootCapability
+	ClusterCertificates           []string
+	ClientCertificateAuthorities  []*ClientCertificateAuthority
+	ClientLimits                  map[string]*ClientLimits
+	DefaultClientLimits           *ClientLimits
 	clusterUUId                   uint64
 	roots                         []string
 	rms                           common.RMIds
 	rmsRemoved                    map[common.RMId]server.EmptyStruct
 	fingerprints                  map[[sha256.Size]byte]map[string]*common.Capability
+	clusterCertificates           []*x509.Certificate
+	clientCAs                     []*clientCA
+	clientLimits                  map[[sha256.Size]byte]*ClientLimits
+	defaultClientLimits           *ClientLimits
 	nextConfiguration             *NextConfiguration
 }
 
@@ -198,11 +208,19 @@ func decodeConfiguration(decoder *json.Decoder) (*Configuration, error) {
 			return nil, err
 		}
 	}
-	if len(config.ClientCertificateFingerprints) == 0 {
-		return nil, errors.New("No ClientCertificateFingerprints defined")
+	if len(config.ClientCertificateFingerprints) == 0 && len(config.ClientCertificateAuthorities) == 0 {
+		return nil, errors.New("No ClientCertificateFingerprints or ClientCertificateAuthorities defined")
 	} else {
 		rootsMap := make(map[string]server.EmptyStruct)
 		rootsName := []string{}
+		addRootsName := func(roots map[string]*common.Capability) {
+			for name := range roots {
+				if _, found := rootsMap[name]; !found {
+					rootsMap[name] = server.EmptyStructVal
+					rootsName = append(rootsName, name)
+				}
+			}
+		}
 		fingerprints := make(map[[sha256.Size]byte]map[string]*common.Capability, len(config.ClientCertificateFingerprints))
 		seg := capn.NewBuffer(nil)
 		for fingerprint, rootsCapability := range config.ClientCertificateFingerprints {
@@ -212,50 +230,77 @@ func decodeConfiguration(decoder *json.Decoder) (*Configuration, error) {
 			} else if l := len(fingerprintBytes); l != sha256.Size {
 				return nil, fmt.Errorf("Invalid fingerprint: expected %v bytes, and found %v", sha256.Size, l)
 			}
-			if len(rootsCapability) == 0 {
-				return nil, fmt.Errorf("No roots configured for client fingerprint %v; at least 1 needed", fingerprint)
-			}
-			roots := make(map[string]*common.Capability, len(rootsCapability))
-			for name, rootCapability := range rootsCapability {
-				if _, found := rootsMap[name]; !found {
-					rootsMap[name] = server.EmptyStructVal
-					rootsName = append(rootsName, name)
-				}
-				if !rootCapability.Read && !rootCapability.Write {
-					return nil, fmt.Errorf("Client fingerprint %v, root %s: no capability has been granted.",
-						fingerprint, name)
-				}
-				var capability *common.Capability
-				if rootCapability.Read && rootCapability.Write {
-					capability = common.MaxCapability
-				} else {
-					cap := cmsgs.NewCapability(seg)
-					switch {
-					case rootCapability.Read && rootCapability.Write:
-						cap.SetReadWrite()
-					case rootCapability.Read:
-						cap.SetRead()
-					case rootCapability.Write:
-						cap.SetWrite()
-					default:
-						cap.SetNone()
-					}
-					capability = common.NewCapability(cap)
-				}
-				roots[name] = capability
+			roots, err := rootCapabilities(seg, "client fingerprint "+fingerprint, rootsCapability)
+			if err != nil {
+				return nil, err
 			}
+			addRootsName(roots)
 			ary := [sha256.Size]byte{}
 			copy(ary[:], fingerprintBytes)
 			fingerprints[ary] = roots
 		}
 		config.fingerprints = fingerprints
 		config.ClientCertificateFingerprints = nil
+		clientCAs, err := decodeClientCAs(seg, config.ClientCertificateAuthorities)
+		if err != nil {
+			return nil, err
+		}
+		for _, ca := range clientCAs {
+			for _, rule := range ca.rules {
+				addRootsName(rule.roots)
+			}
+		}
+		config.clientCAs = clientCAs
+		config.ClientCertificateAuthorities = nil
 		sort.Strings(rootsName)
 		config.roots = rootsName
 	}
+	for _, certsPEM := range config.ClusterCertificates {
+		certs, err := parseClusterCertificates([]byte(certsPEM))
+		if err != nil {
+			return nil, err
+		}
+		config.clusterCertificates = append(config.clusterCertificates, certs...)
+	}
+	config.ClusterCertificates = nil
+	clientLimits, err := decodeClientLimits(config.ClientLimits)
+	if err != nil {
+		return nil, err
+	}
+	config.clientLimits = clientLimits
+	config.defaultClientLimits = config.DefaultClientLimits
+	config.ClientLimits = nil
+	config.DefaultClientLimits = nil
 	return &config, err
 }
 
+// parseClusterCertificates finds the certificates in the PEM, which
+// may be as written by -gen-cluster-cert and so also contain the
+// private key: that is ignored.
+func parseClusterCertificates(certsPEM []byte) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, certsPEM = pem.Decode(certsPEM)
+		if block == nil {
+			break
+		} else if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		} else if !cert.IsCA {
+			return nil, fmt.Errorf("Cluster certificate %v is not a CA certificate", cert.Subject)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("No certificate found in ClusterCertificates entry")
+	}
+	return certs, nil
+}
+
 func ConfigurationFromCap(config *msgs.Configuration) *Configuration {
 	c := &Configuration{
 		ClusterId:   config.ClusterId(),
@@ -302,9 +347,36 @@ func ConfigurationFromCap(config *msgs.Configuration) *Configuration {
 		fingerprintsMap[ary] = roots
 	}
 	c.fingerprints = fingerprintsMap
+	clientCAs := config.ClientCAs()
+	c.clientCAs = clientCAsFromCap(&clientCAs)
+	for _, ca := range c.clientCAs {
+		for _, rule := range ca.rules {
+			for name := range rule.roots {
+				if _, found := rootsMap[name]; !found {
+					rootsName = append(rootsName, name)
+					rootsMap[name] = server.EmptyStructVal
+				}
+			}
+		}
+	}
 	sort.Strings(rootsName)
 	c.roots = rootsName
 
+	clusterCerts := config.ClusterCertificates()
+	c.clusterCertificates = make([]*x509.Certificate, 0, clusterCerts.Len())
+	for idx, l := 0, clusterCerts.Len(); idx < l; idx++ {
+		// These were verified when the configuration was loaded, but
+		// not necessarily by this node, nor this version of it.
+		if cert, err := x509.ParseCertificate(clusterCerts.At(idx)); err == nil {
+			c.clusterCertificates = append(c.clusterCertificates, cert)
+		} else {
+			log.Printf("Configuration: ignoring cluster certificate %v of %v: unable to parse it: %v. Nodes which rely on it will be unable to connect.\n", idx+1, l, err)
+		}
+	}
+
+	clientLimits := config.ClientLimits()
+	clientLimitsListFromCap(c, &clientLimits)
+
 	if config.Which() == msgs.CONFIGURATION_TRANSITIONINGTO {
 		next := config.TransitioningTo()
 		nextConfig := next.Configuration()
@@ -364,7 +436,7 @@ func (a *Configuration) Equal(b *Configuration) bool {
 	if a == nil || b == nil {
 		return a == b
 	}
-	if !(a.ClusterId == b.ClusterId && a.clusterUUId == b.clusterUUId && a.Version == b.Version && a.F == b.F && a.MaxRMCount == b.MaxRMCount && a.NoSync == b.NoSync && len(a.Hosts) == len(b.Hosts) && len(a.fingerprints) == len(b.fingerprints) && len(a.rms) == len(b.rms) && len(a.rmsRemoved) == len(b.rmsRemoved)) {
+	if !(a.ClusterId == b.ClusterId && a.clusterUUId == b.clusterUUId && a.Version == b.Version && a.F == b.F && a.MaxRMCount == b.MaxRMCount && a.NoSync == b.NoSync && len(a.Hosts) == len(b.Hosts) && len(a.fingerprints) == len(b.fingerprints) && len(a.rms) == len(b.rms) && len(a.rmsRemoved) == len(b.rmsRemoved) && len(a.clusterCertificates) == len(b.clusterCertificates) && len(a.clientCAs) == len(b.clientCAs) && len(a.clientLimits) == len(b.clientLimits) && a.defaultClientLimits.Equal(b.defaultClientLimits)) {
 		return false
 	}
 	for idx, aHost := range a.Hosts {
@@ -382,6 +454,21 @@ func (a *Configuration) Equal(b *Configuration) bool {
 			return false
 		}
 	}
+	for idx, aCert := range a.clusterCertificates {
+		if !aCert.Equal(b.clusterCertificates[idx]) {
+			return false
+		}
+	}
+	for idx, aCA := range a.clientCAs {
+		if !aCA.equal(b.clientCAs[idx]) {
+			return false
+		}
+	}
+	for fingerprint, aLimits := range a.clientLimits {
+		if !aLimits.Equal(b.clientLimits[fingerprint]) {
+			return false
+		}
+	}
 	for fingerprint, aRoots := range a.fingerprints {
 		if bRoots, found := b.fingerprints[fingerprint]; !found || len(aRoots) != len(bRoots) {
 			return false
@@ -428,6 +515,14 @@ func (config *Configuration) RootNames() []string {
 	return config.roots
 }
 
+// ClusterCertificates are the cluster certificates trusted in
+// addition to the one each node was started with. Listing both the
+// old and new certificates allows the cluster certificate to be
+// rotated one node at a time.
+func (config *Configuration) ClusterCertificates() []*x509.Certificate {
+	return config.clusterCertificates
+}
+
 func (config *Configuration) NextBarrierReached1(rmId common.RMId) bool {
 	if config.nextConfiguration != nil {
 		for _, r := range config.nextConfiguration.BarrierReached1 {
@@ -484,11 +579,19 @@ func (config *Configuration) Clone() *Configuration {
 		MaxRMCount:  config.MaxRMCount,
 		NoSync:      config.NoSync,
 		ClientCertificateFingerprints: nil,
-		roots:             make([]string, len(config.roots)),
-		rms:               make([]common.RMId, len(config.rms)),
-		rmsRemoved:        make(map[common.RMId]server.EmptyStruct, len(config.rmsRemoved)),
-		fingerprints:      make(map[[sha256.Size]byte]map[string]*common.Capability, len(config.fingerprints)),
-		nextConfiguration: config.nextConfiguration.Clone(),
+		ClusterCertificates:           nil,
+		ClientCertificateAuthorities:  nil,
+		ClientLimits:                  nil,
+		DefaultClientLimits:           nil,
+		roots:               make([]string, len(config.roots)),
+		rms:                 make([]common.RMId, len(config.rms)),
+		rmsRemoved:          make(map[common.RMId]server.EmptyStruct, len(config.rmsRemoved)),
+		fingerprints:        make(map[[sha256.Size]byte]map[string]*common.Capability, len(config.fingerprints)),
+		clusterCertificates: make([]*x509.Certificate, len(config.clusterCertificates)),
+		clientCAs:           make([]*clientCA, len(config.clientCAs)),
+		clientLimits:        make(map[[sha256.Size]byte]*ClientLimits, len(config.clientLimits)),
+		defaultClientLimits: config.defaultClientLimits,
+		nextConfiguration:   config.nextConfiguration.Clone(),
 	}
 
 	copy(clone.Hosts, config.Hosts)
@@ -498,7 +601,24 @@ func (config *Configuration) Clone() *Configuration {
 			clone.ClientCertificateFingerprints[k] = v
 		}
 	}
+	if config.ClusterCertificates != nil {
+		clone.ClusterCertificates = make([]string, len(config.ClusterCertificates))
+		copy(clone.ClusterCertificates, config.ClusterCertificates)
+	}
+	if config.ClientCertificateAuthorities != nil {
+		clone.ClientCertificateAuthorities = make([]*ClientCertificateAuthority, len(config.ClientCertificateAuthorities))
+		copy(clone.ClientCertificateAuthorities, config.ClientCertificateAuthorities)
+	}
+	if config.ClientLimits != nil {
+		clone.ClientLimits = make(map[string]*ClientLimits, len(config.ClientLimits))
+		for k, v := range config.ClientLimits {
+			clone.ClientLimits[k] = v
+		}
+	}
+	clone.DefaultClientLimits = config.DefaultClientLimits
 	copy(clone.roots, config.roots)
+	copy(clone.clusterCertificates, config.clusterCertificates)
+	copy(clone.clientCAs, config.clientCAs)
 	copy(clone.rms, config.rms)
 	for k, v := range config.rmsRemoved {
 		clone.rmsRemoved[k] = v
@@ -506,6 +626,9 @@ func (config *Configuration) Clone() *Configuration {
 	for k, v := range config.fingerprints {
 		clone.fingerprints[k] = v
 	}
+	for k, v := range config.clientLimits {
+		clone.clientLimits[k] = v
+	}
 	return clone
 }
 
@@ -560,6 +683,15 @@ func (config *Configuration) AddToSegAutoRoot(seg *capn.Segment) msgs.Configurat
 	}
 	cap.SetFingerprints(fingerprintsCap)
 
+	clusterCertsCap := seg.NewDataList(len(config.clusterCertificates))
+	for idx, cert := range config.clusterCertificates {
+		clusterCertsCap.Set(idx, cert.Raw)
+	}
+	cap.SetClusterCertificates(clusterCertsCap)
+
+	cap.SetClientCAs(clientCAsToCap(seg, config.clientCAs))
+	cap.SetClientLimits(clientLimitsListToCap(seg, config))
+
 	if config.nextConfiguration == nil {
 		cap.SetStable()
 	} else {
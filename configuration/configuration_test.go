@@ -0,0 +1,50 @@
+package configuration
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+)
+
+func TestParseClusterCertificates(t *testing.T) {
+	oldCA, newCA := newTestCA(t, "Old cluster", nil), newTestCA(t, "New cluster", nil)
+	keyDER, err := x509.MarshalECPrivateKey(oldCA.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// As written by -gen-cluster-cert.
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	node := newTestClient(t, oldCA, "node", "", "", time.Time{})
+
+	tests := []struct {
+		name     string
+		pem      string
+		expected []*testCert
+	}{
+		{"certificate", certPEM(oldCA), []*testCert{oldCA}},
+		{"with key", keyPEM + certPEM(oldCA), []*testCert{oldCA}},
+		{"several", certPEM(oldCA) + certPEM(newCA), []*testCert{oldCA, newCA}},
+		{"no certificate", keyPEM, nil},
+		{"not a CA", certPEM(node), nil},
+		{"not a CA amongst CAs", certPEM(oldCA) + certPEM(node), nil},
+		{"garbage", "not a certificate", nil},
+	}
+	for _, test := range tests {
+		certs, err := parseClusterCertificates([]byte(test.pem))
+		switch {
+		case test.expected == nil && err == nil:
+			t.Errorf("%v: expected error", test.name)
+		case test.expected != nil && err != nil:
+			t.Errorf("%v: %v", test.name, err)
+		case test.expected != nil && len(certs) != len(test.expected):
+			t.Errorf("%v: expected %v certificates; got %v", test.name, len(test.expected), len(certs))
+		case test.expected != nil:
+			for idx, cert := range certs {
+				if !cert.Equal(test.expected[idx].cert) {
+					t.Errorf("%v: decoded the wrong certificate: %v", test.name, cert.Subject)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package configuration
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"goshawkdb.io/server"
+	"os"
+	"strings"
+)
+
+// LoadRevokedFingerprints reads a list of revoked client certificate
+// fingerprints: one hex encoded SHA-256 fingerprint per line, as in
+// ClientCertificateFingerprints. Blank lines, and lines starting with
+// #, are ignored. Unlike the configuration, the list is local to
+// each node and can be changed without a topology change.
+func LoadRevokedFingerprints(path string) (map[[sha256.Size]byte]server.EmptyStruct, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	revoked := make(map[[sha256.Size]byte]server.EmptyStruct)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fingerprintBytes, err := hex.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: %v", path, lineNo, err)
+		} else if l := len(fingerprintBytes); l != sha256.Size {
+			return nil, fmt.Errorf("%v:%v: Invalid fingerprint: expected %v bytes, and found %v", path, lineNo, sha256.Size, l)
+		}
+		ary := [sha256.Size]byte{}
+		copy(ary[:], fingerprintBytes)
+		revoked[ary] = server.EmptyStructVal
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return revoked, nil
+}
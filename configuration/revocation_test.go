@@ -0,0 +1,60 @@
+package configuration
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadRevokedFingerprints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revocation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	alice, bob := sha256.Sum256([]byte("alice")), sha256.Sum256([]byte("bob"))
+	write := func(lines ...string) string {
+		path := filepath.Join(dir, "revoked")
+		if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	revoked, err := LoadRevokedFingerprints(write(
+		"# Revoked 2026-10-01",
+		hex.EncodeToString(alice[:]),
+		"",
+		"  "+hex.EncodeToString(bob[:])+"  ",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 2 {
+		t.Fatalf("Expected 2 revoked fingerprints; got %v", len(revoked))
+	}
+	for _, fingerprint := range [][sha256.Size]byte{alice, bob} {
+		if _, found := revoked[fingerprint]; !found {
+			t.Fatalf("Expected %x to be revoked", fingerprint)
+		}
+	}
+
+	if revoked, err = LoadRevokedFingerprints(write("# Nothing revoked")); err != nil || len(revoked) != 0 {
+		t.Fatalf("Expected nothing to be revoked; got %v, %v", revoked, err)
+	}
+
+	for _, line := range []string{"not hex", hex.EncodeToString(alice[:16])} {
+		if _, err = LoadRevokedFingerprints(write(hex.EncodeToString(bob[:]), line)); err == nil || !strings.Contains(err.Error(), ":2:") {
+			t.Fatalf("Expected %q to be refused, reporting its line; got %v", line, err)
+		}
+	}
+
+	if _, err = LoadRevokedFingerprints(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("Expected a missing file to be an error")
+	}
+}
@@ -37,6 +37,16 @@ func NewCache(resolver *Resolver, rng *rand.Rand) *ConsistentHashCache {
 	}
 }
 
+// Vars returns every var whose positions are known to the cache.
+func (chc *ConsistentHashCache) Vars() []*common.VarUUId {
+	vUUIds := make([]*common.VarUUId, 0, len(chc.hashCodesPositions))
+	for vUUId := range chc.hashCodesPositions {
+		vUUIdCopy := vUUId
+		vUUIds = append(vUUIds, &vUUIdCopy)
+	}
+	return vUUIds
+}
+
 func (chc *ConsistentHashCache) GetPositions(vUUId *common.VarUUId) *common.Positions {
 	return chc.hashCodesPositions[*vUUId].positions
 }
@@ -19,5 +19,20 @@ const (
 	ConnectionRestartDelayMin     = 3 * time.Second
 	MostRandomByteIndex           = 7 // will be the lsb of a big-endian client-n in the txnid.
 	MigrationBatchElemCount       = 64
+	ImportBatchObjectCount        = 512
+	ScrubBatchVarCount            = 64
+	ScrubBatchDelay               = 500 * time.Millisecond
+	ScrubPassDelay                = 10 * time.Minute
+	AntiEntropyInterval           = time.Hour
+	AntiEntropyRangeVarCount      = 1024
+	AntiEntropyLeafVarCount       = 32
 	PoissonSamples                = 64
+	DiskMinFreeDefault            = 64 * 1048576
+	GroupCommitMaxBatch           = 256
+	GroupCommitMaxDelay           = 500 * time.Microsecond
+	GcBatchVarCount               = 256
+	GcBatchDelay                  = 100 * time.Millisecond
+	GcSettleDelay                 = 5 * time.Second
+	GcStallTimeout                = time.Minute
+	AuditLogMaxSizeDefault        = 64 * 1048576
 )
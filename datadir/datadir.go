@@ -0,0 +1,299 @@
+package datadir
+
+import (
+	"encoding/binary"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/configuration"
+	"goshawkdb.io/server/db"
+	eng "goshawkdb.io/server/txnengine"
+	"io/ioutil"
+	"log"
+	"time"
+)
+
+// Store gives offline tools access to the data directory of a
+// stopped server. The server must not be running against the same
+// directory.
+type Store struct {
+	Dir      string
+	DB       *db.Databases
+	RMId     common.RMId
+	Topology *configuration.Topology
+}
+
+type Stores []*Store
+
+func Open(dir string) (*Store, error) {
+	s := &Store{Dir: dir}
+	var err error
+	if err = s.LoadRMId(); err == nil {
+		if err = s.StartDisk(); err == nil {
+			if err = s.LoadTopology(); err != nil {
+				s.Shutdown()
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func OpenAll(dirs []string) (Stores, error) {
+	ss := Stores(make([]*Store, 0, len(dirs)))
+	for _, dir := range dirs {
+		log.Printf("...loading from %v\n", dir)
+		s, err := Open(dir)
+		if err != nil {
+			ss.Shutdown()
+			return nil, err
+		}
+		ss = append(ss, s)
+	}
+	return ss, nil
+}
+
+func (ss Stores) CheckEqualTopology() error {
+	var first *Store
+	for idx, s := range ss {
+		if idx == 0 {
+			first = s
+		} else if !first.Topology.Configuration.Equal(s.Topology.Configuration) {
+			return fmt.Errorf("Unequal topologies: %v has %v; %v has %v",
+				first, first.Topology, s, s.Topology)
+		}
+	}
+	return nil
+}
+
+func (ss Stores) Shutdown() {
+	for _, s := range ss {
+		s.Shutdown()
+	}
+}
+
+func (s *Store) Shutdown() {
+	if s.DB == nil {
+		return
+	}
+	s.DB.Shutdown()
+	s.DB = nil
+}
+
+func (s *Store) String() string {
+	return fmt.Sprintf("%v(%v)", s.RMId, s.Dir)
+}
+
+func (s *Store) LoadRMId() error {
+	rmIdBytes, err := ioutil.ReadFile(s.Dir + "/rmid")
+	if err != nil {
+		return err
+	}
+	s.RMId = common.RMId(binary.BigEndian.Uint32(rmIdBytes))
+	return nil
+}
+
+func (s *Store) StartDisk() error {
+	log.Printf("Starting disk server on %v", s.Dir)
+	disk, err := db.OpenLMDB(s.Dir, server.MDBInitialSize, 2, 10*time.Millisecond)
+	if err != nil {
+		return err
+	}
+	s.DB = db.DB.WithStorage(disk)
+	return nil
+}
+
+func (s *Store) LoadTopology() error {
+	res, err := s.DB.ReadonlyTransaction(func(rtxn db.RTxn) interface{} {
+		varCap, err := s.readVar(rtxn, configuration.TopologyVarUUId)
+		if err != nil {
+			rtxn.Error(err)
+			return nil
+		} else if varCap == nil {
+			rtxn.Error(fmt.Errorf("Unable to find topology var in %v", s))
+			return nil
+		}
+		txnId := common.MakeTxnId(varCap.WriteTxnId())
+		bites, err := s.DB.ReadTxnBytesFromDisk(rtxn, txnId)
+		if err != nil {
+			rtxn.Error(err)
+			return nil
+		} else if bites == nil {
+			rtxn.Error(fmt.Errorf("Unable to find txn for topology: %v", txnId))
+			return nil
+		}
+		txnReader := eng.TxnReaderFromData(bites)
+		actions := txnReader.Actions(true)
+		if l := actions.Actions().Len(); l != 1 {
+			rtxn.Error(fmt.Errorf("Topology txn has %v actions; expected 1", l))
+			return nil
+		}
+		action := actions.Actions().At(0)
+		bites, refs, ok := ActionValue(&action)
+		if !ok {
+			rtxn.Error(fmt.Errorf("Expected topology txn action to be w, rw, or c; found %v", action.Which()))
+			return nil
+		}
+
+		if refs.Len() != 1 {
+			rtxn.Error(fmt.Errorf("Topology txn action has %v references; expected 1", refs.Len()))
+			return nil
+		}
+
+		topology, err := configuration.TopologyFromCap(txnId, &refs, bites)
+		if err != nil {
+			rtxn.Error(err)
+			return nil
+		}
+		return topology
+	}).ResultError()
+	if err != nil {
+		return err
+	}
+	s.Topology = res.(*configuration.Topology)
+	return nil
+}
+
+// ReadVar returns nil, nil if the var is not found in this store.
+func (s *Store) ReadVar(vUUId *common.VarUUId) (*msgs.Var, error) {
+	res, err := s.DB.ReadonlyTransaction(func(rtxn db.RTxn) interface{} {
+		varCap, err := s.readVar(rtxn, vUUId)
+		if err != nil {
+			rtxn.Error(err)
+			return nil
+		}
+		return varCap
+	}).ResultError()
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res.(*msgs.Var), nil
+}
+
+func (s *Store) readVar(rtxn db.RTxn, vUUId *common.VarUUId) (*msgs.Var, error) {
+	bites, err := rtxn.Get(db.Vars, vUUId[:])
+	if err == db.NotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return s.VarFromData(vUUId[:], bites)
+}
+
+// ReadTxn returns nil, nil if the txn is not found in this store.
+func (s *Store) ReadTxn(txnId *common.TxnId) (*eng.TxnReader, error) {
+	res, err := s.DB.ReadonlyTransaction(func(rtxn db.RTxn) interface{} {
+		bites, err := s.DB.ReadTxnBytesFromDisk(rtxn, txnId)
+		if err != nil {
+			rtxn.Error(err)
+			return nil
+		}
+		return bites
+	}).ResultError()
+	if err != nil {
+		return nil, err
+	}
+	bites, ok := res.([]byte)
+	if res == nil || (ok && bites == nil) {
+		return nil, nil
+	}
+	if _, _, err = capn.ReadFromMemoryZeroCopy(bites); err != nil {
+		return nil, err
+	}
+	return eng.TxnReaderFromData(bites), nil
+}
+
+// ReadVarValue finds the action within the var's current write txn
+// and returns the value and references it wrote.
+func (s *Store) ReadVarValue(vUUId *common.VarUUId, varCap *msgs.Var) ([]byte, *msgs.VarIdPos_List, error) {
+	txnId := common.MakeTxnId(varCap.WriteTxnId())
+	txn, err := s.ReadTxn(txnId)
+	if err != nil {
+		return nil, nil, err
+	} else if txn == nil {
+		return nil, nil, fmt.Errorf("Failed to find %v from %v in %v", txnId, vUUId, s)
+	}
+	action := FindAction(txn, vUUId)
+	if action == nil {
+		return nil, nil, fmt.Errorf("%v: txn %v has no action for %v", s, txnId, vUUId)
+	}
+	value, refs, ok := ActionValue(action)
+	if !ok {
+		return nil, nil, fmt.Errorf("%v: txn %v has unexpected action %v for %v", s, txnId, action.Which(), vUUId)
+	}
+	return value, &refs, nil
+}
+
+// ForEach invokes f with every key and value in the table, in key
+// order, stopping at the first error.
+func (s *Store) ForEach(table db.Table, f func(key, value []byte) error) error {
+	_, err := s.DB.ReadonlyTransaction(func(rtxn db.RTxn) interface{} {
+		rtxn.WithCursor(table, func(cursor db.Cursor) interface{} {
+			key, value, err := cursor.First()
+			for ; err == nil; key, value, err = cursor.Next() {
+				if err = f(key, value); err != nil {
+					rtxn.Error(err)
+					return nil
+				}
+			}
+			if err != db.NotFound {
+				rtxn.Error(err)
+			}
+			return nil
+		})
+		return nil
+	}).ResultError()
+	return err
+}
+
+// VarFromData decodes the value of key in the Vars table, verifying
+// its checksum and decrypting it if necessary.
+func (s *Store) VarFromData(key, data []byte) (*msgs.Var, error) {
+	data, err := s.DB.OpenRecord(db.Vars, key, data)
+	if err != nil {
+		return nil, err
+	}
+	seg, _, err := capn.ReadFromMemoryZeroCopy(data)
+	if err != nil {
+		return nil, err
+	}
+	varCap := msgs.ReadRootVar(seg)
+	return &varCap, nil
+}
+
+func FindAction(txn *eng.TxnReader, vUUId *common.VarUUId) *msgs.Action {
+	actions := txn.Actions(true).Actions()
+	for idx, l := 0, actions.Len(); idx < l; idx++ {
+		action := actions.At(idx)
+		if common.MakeVarUUId(action.VarId()).Compare(vUUId) == common.EQ {
+			return &action
+		}
+	}
+	return nil
+}
+
+// ActionValue returns the value and references written by the
+// action. Roll actions count as writes. ok is false for actions that
+// do not write.
+func ActionValue(action *msgs.Action) (value []byte, refs msgs.VarIdPos_List, ok bool) {
+	switch action.Which() {
+	case msgs.ACTION_WRITE:
+		w := action.Write()
+		return w.Value(), w.References(), true
+	case msgs.ACTION_READWRITE:
+		rw := action.Readwrite()
+		return rw.Value(), rw.References(), true
+	case msgs.ACTION_CREATE:
+		c := action.Create()
+		return c.Value(), c.References(), true
+	case msgs.ACTION_ROLL:
+		r := action.Roll()
+		return r.Value(), r.References(), true
+	default:
+		return nil, refs, false
+	}
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// MaxDecompressedLen is the most that Decompress will inflate a single
+// record or txn to. Anything bigger is not something we wrote, and
+// stopping there stops a small corrupt or malicious input from
+// exhausting memory.
+const MaxDecompressedLen = 256 << 20
+
+// Compress deflates bites. It returns nil if that doesn't make bites
+// any smaller, in which case they should be stored or sent as they
+// are.
+func Compress(bites []byte) []byte {
+	buf := new(bytes.Buffer)
+	w, err := flate.NewWriter(buf, flate.DefaultCompression)
+	if err != nil {
+		panic(err) // only possible with an illegal level
+	}
+	if _, err = w.Write(bites); err != nil {
+		return nil
+	}
+	if err = w.Close(); err != nil {
+		return nil
+	}
+	if buf.Len() >= len(bites) {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+func Decompress(bites []byte) ([]byte, error) {
+	return decompress(bites, MaxDecompressedLen)
+}
+
+func decompress(bites []byte, limit int64) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(bites))
+	defer r.Close()
+	inflated, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	} else if int64(len(inflated)) > limit {
+		return nil, fmt.Errorf("Decompressed length exceeds %v bytes", limit)
+	}
+	return inflated, nil
+}
@@ -1,32 +1,34 @@
 package db
 
-import (
-	mdbs "github.com/msackman/gomdb/server"
-)
-
 type Databases struct {
-	*mdbs.MDBServer
-	Vars            *mdbs.DBISettings
-	Proposers       *mdbs.DBISettings
-	BallotOutcomes  *mdbs.DBISettings
-	Transactions    *mdbs.DBISettings
-	TransactionRefs *mdbs.DBISettings
+	Storage
+	// Keys is nil unless records are to be encrypted at rest.
+	Keys *Keyring
+	// Compress enables compression of txns and vars, both at rest and
+	// when migrating.
+	Compress bool
+	// ReverseRefs enables maintenance of the ReverseRefs index, from
+	// each var to the vars which reference it.
+	ReverseRefs bool
+	// Health is shared by everything using the same storage, and
+	// records whether the node can currently accept new writes.
+	Health *Health
 }
 
 var (
-	DB = &Databases{}
+	// DB holds the settings (keys, compression) with which storage is
+	// opened. Set them before calling WithStorage.
+	DB = &Databases{Health: NewHealth()}
 )
 
-func (db *Databases) Clone() mdbs.DBIsInterface {
+// WithStorage returns Databases using storage, with the same settings
+// as db.
+func (db *Databases) WithStorage(storage Storage) *Databases {
 	return &Databases{
-		Vars:            db.Vars.Clone(),
-		Proposers:       db.Proposers.Clone(),
-		BallotOutcomes:  db.BallotOutcomes.Clone(),
-		Transactions:    db.Transactions.Clone(),
-		TransactionRefs: db.TransactionRefs.Clone(),
+		Storage:     storage,
+		Keys:        db.Keys,
+		Compress:    db.Compress,
+		ReverseRefs: db.ReverseRefs,
+		Health:      db.Health,
 	}
 }
-
-func (db *Databases) SetServer(server *mdbs.MDBServer) {
-	db.MDBServer = server
-}
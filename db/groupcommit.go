@@ -0,0 +1,423 @@
+package db
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// groupCommitStorage coalesces read-write txns, from however many
+// go-routines, into batches which are each committed to the
+// underlying storage as a single txn, and so with a single fsync.
+// A batch is committed once it holds maxBatch txns, or maxDelay
+// after its first txn arrived. Txns are run in the order they were
+// submitted, and each remains atomic: a txn which calls Error is
+// dropped from its batch without affecting the others. If the batch
+// as a whole fails to commit, every txn in it gets the error. A
+// read-only txn submitted while there are read-write txns which have
+// not yet been submitted to the underlying storage is queued behind
+// them, and the current batch is committed without waiting out
+// maxDelay, so that reads still see every write submitted before
+// them.
+type groupCommitStorage struct {
+	Storage
+	maxBatch   int
+	maxDelay   time.Duration
+	lock       sync.Mutex
+	pending    []*groupCommitTxn
+	submitting bool
+	flush      bool
+	terminated bool
+	wake       chan struct{}
+	terminate  chan struct{}
+	finished   chan struct{}
+}
+
+var groupCommitBatchSizes = new(expvar.Map).Init()
+
+func init() {
+	StorageMetrics.Set("GroupCommitBatchSizes", groupCommitBatchSizes)
+}
+
+func NewGroupCommitStorage(storage Storage, maxBatch int, maxDelay time.Duration) Storage {
+	s := &groupCommitStorage{
+		Storage:   storage,
+		maxBatch:  maxBatch,
+		maxDelay:  maxDelay,
+		wake:      make(chan struct{}, 1),
+		terminate: make(chan struct{}),
+		finished:  make(chan struct{}),
+	}
+	go s.loop()
+	return s
+}
+
+// A groupCommitTxn is either a read-write txn (txnFun) or a read-only
+// txn (readFun).
+type groupCommitTxn struct {
+	txnFun  func(RWTxn) interface{}
+	readFun func(RTxn) interface{}
+	result  interface{}
+	err     error
+	done    chan struct{}
+}
+
+func (txn *groupCommitTxn) ResultError() (interface{}, error) {
+	<-txn.done
+	return txn.result, txn.err
+}
+
+func (s *groupCommitStorage) ReadWriteTransaction(txnFun func(RWTxn) interface{}) Future {
+	txn := &groupCommitTxn{txnFun: txnFun, done: make(chan struct{})}
+	s.lock.Lock()
+	if s.terminated {
+		s.lock.Unlock()
+		close(txn.done)
+		return txn
+	}
+	s.pending = append(s.pending, txn)
+	s.lock.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return txn
+}
+
+func (s *groupCommitStorage) ReadonlyTransaction(txnFun func(RTxn) interface{}) Future {
+	s.lock.Lock()
+	if s.terminated || (len(s.pending) == 0 && !s.submitting) {
+		s.lock.Unlock()
+		return s.Storage.ReadonlyTransaction(txnFun)
+	}
+	txn := &groupCommitTxn{readFun: txnFun, done: make(chan struct{})}
+	s.pending = append(s.pending, txn)
+	s.flush = true
+	s.lock.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return txn
+}
+
+func (s *groupCommitStorage) Shutdown() {
+	s.lock.Lock()
+	if s.terminated {
+		s.lock.Unlock()
+		return
+	}
+	s.terminated = true
+	s.lock.Unlock()
+	close(s.terminate)
+	<-s.finished
+	s.Storage.Shutdown()
+}
+
+func (s *groupCommitStorage) maybeGrowMap(free, maxMapSize uint64) error {
+	if grower, ok := s.Storage.(mapGrower); ok {
+		return grower.maybeGrowMap(free, maxMapSize)
+	}
+	return nil
+}
+
+func (s *groupCommitStorage) loop() {
+	defer close(s.finished)
+	for {
+		select {
+		case <-s.wake:
+		case <-s.terminate:
+			s.commitAll()
+			return
+		}
+		if s.maxDelay > 0 && s.gathering() {
+			deadline := time.NewTimer(s.maxDelay)
+		Gather:
+			for s.gathering() {
+				select {
+				case <-s.wake:
+				case <-deadline.C:
+					break Gather
+				case <-s.terminate:
+					deadline.Stop()
+					s.commitAll()
+					return
+				}
+			}
+			deadline.Stop()
+		}
+		if batch := s.take(); len(batch) != 0 {
+			s.commit(batch)
+		}
+		if s.pendingCount() != 0 {
+			select {
+			case s.wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (s *groupCommitStorage) pendingCount() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.pending)
+}
+
+// gathering is true while the current batch should wait for more
+// txns: it is not full, and no read-only txn is waiting on it.
+func (s *groupCommitStorage) gathering() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.pending) < s.maxBatch && !s.flush
+}
+
+// take removes the next batch from pending. Until commit has
+// submitted it, read-only txns must queue behind it.
+func (s *groupCommitStorage) take() []*groupCommitTxn {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	batch := s.pending
+	if len(batch) > s.maxBatch {
+		batch = batch[:s.maxBatch]
+	}
+	s.pending = s.pending[len(batch):]
+	s.submitting = len(batch) != 0
+	s.flush = false
+	for _, txn := range s.pending {
+		if txn.readFun != nil {
+			s.flush = true
+			break
+		}
+	}
+	return batch
+}
+
+func (s *groupCommitStorage) commitAll() {
+	for batch := s.take(); len(batch) != 0; batch = s.take() {
+		s.commit(batch)
+	}
+}
+
+// commit submits the batch to the underlying storage, which runs txns
+// in the order they are submitted, so we need not wait for the
+// commit before starting on the next batch. Each run of read-write
+// txns is committed as one txn, and each read-only txn is submitted
+// after the writes which preceded it.
+func (s *groupCommitStorage) commit(batch []*groupCommitTxn) {
+	var writes []*groupCommitTxn
+	for _, txn := range batch {
+		if txn.readFun == nil {
+			writes = append(writes, txn)
+			continue
+		}
+		if len(writes) != 0 {
+			s.commitWrites(writes)
+			writes = nil
+		}
+		s.read(txn)
+	}
+	if len(writes) != 0 {
+		s.commitWrites(writes)
+	}
+	s.lock.Lock()
+	s.submitting = false
+	s.lock.Unlock()
+}
+
+func (s *groupCommitStorage) read(txn *groupCommitTxn) {
+	future := s.Storage.ReadonlyTransaction(txn.readFun)
+	go func() {
+		txn.result, txn.err = future.ResultError()
+		close(txn.done)
+	}()
+}
+
+func (s *groupCommitStorage) commitWrites(batch []*groupCommitTxn) {
+	StorageMetrics.Add("GroupCommits", 1)
+	StorageMetrics.Add("GroupCommitTxns", int64(len(batch)))
+	groupCommitBatchSizes.Add(batchSizeBucket(len(batch)), 1)
+
+	future := s.Storage.ReadWriteTransaction(func(rwtxn RWTxn) interface{} {
+		for _, txn := range batch {
+			grouped := &groupedRWTxn{RWTxn: rwtxn}
+			txn.result = txn.txnFun(grouped)
+			if grouped.err != nil {
+				txn.result, txn.err = nil, grouped.err
+			} else if err := grouped.apply(); err != nil {
+				rwtxn.Error(err)
+				return nil
+			}
+		}
+		return true
+	})
+	go func() {
+		ran, err := future.ResultError()
+		for _, txn := range batch {
+			if err != nil {
+				txn.result, txn.err = nil, err
+			} else if ran == nil { // shutdown
+				txn.result, txn.err = nil, nil
+			}
+			close(txn.done)
+		}
+	}()
+}
+
+// batchSizeBucket returns the histogram bucket for size: the next
+// power of two, zero padded so the buckets sort numerically.
+func batchSizeBucket(size int) string {
+	bucket := 1
+	for bucket < size {
+		bucket *= 2
+	}
+	return fmt.Sprintf("%05d", bucket)
+}
+
+// groupedRWTxn buffers the writes of one txn within a batch, so that
+// they can be discarded if the txn calls Error. Get and cursors see
+// the txn's own writes overlaid on what was written by earlier txns
+// in the batch, just as they would within an unbatched txn. A cursor
+// sees the writes made before it was created.
+type groupedRWTxn struct {
+	RWTxn
+	writes [tableCount]map[string]*groupedWrite
+	err    error
+}
+
+type groupedWrite struct {
+	value   []byte
+	deleted bool
+}
+
+func (t *groupedRWTxn) Get(table Table, key []byte) ([]byte, error) {
+	if write, found := t.writes[table][string(key)]; found {
+		if write.deleted {
+			return nil, NotFound
+		}
+		return append([]byte(nil), write.value...), nil
+	}
+	return t.RWTxn.Get(table, key)
+}
+
+func (t *groupedRWTxn) WithCursor(table Table, cursorFun func(Cursor) interface{}) interface{} {
+	writes := t.writes[table]
+	if len(writes) == 0 {
+		return t.RWTxn.WithCursor(table, cursorFun)
+	}
+	keys := make([]string, 0, len(writes))
+	for key := range writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return t.RWTxn.WithCursor(table, func(cursor Cursor) interface{} {
+		return cursorFun(&groupedCursor{Cursor: cursor, writes: writes, keys: keys})
+	})
+}
+
+func (t *groupedRWTxn) Put(table Table, key, value []byte) error {
+	t.record(table, key, &groupedWrite{value: append([]byte{}, value...)})
+	return nil
+}
+
+func (t *groupedRWTxn) Del(table Table, key []byte) error {
+	if _, err := t.Get(table, key); err != nil {
+		return err
+	}
+	t.record(table, key, &groupedWrite{deleted: true})
+	return nil
+}
+
+func (t *groupedRWTxn) Error(err error) {
+	if t.err == nil {
+		t.err = err
+	}
+}
+
+func (t *groupedRWTxn) record(table Table, key []byte, write *groupedWrite) {
+	if t.writes[table] == nil {
+		t.writes[table] = make(map[string]*groupedWrite)
+	}
+	t.writes[table][string(key)] = write
+}
+
+func (t *groupedRWTxn) apply() error {
+	for idx, writes := range t.writes {
+		table := Table(idx)
+		for key, write := range writes {
+			var err error
+			if write.deleted {
+				if err = t.RWTxn.Del(table, []byte(key)); err == NotFound {
+					err = nil
+				}
+			} else {
+				err = t.RWTxn.Put(table, []byte(key), write.value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// groupedCursor merges a txn's buffered writes (keys, sorted) into
+// the underlying cursor. The underlying cursor is always one entry
+// ahead: key, value and err are its current entry. Where both have
+// the same key, the buffered write wins.
+type groupedCursor struct {
+	Cursor
+	writes map[string]*groupedWrite
+	keys   []string
+	idx    int
+	key    []byte
+	value  []byte
+	err    error
+}
+
+func (c *groupedCursor) First() ([]byte, []byte, error) {
+	c.key, c.value, c.err = c.Cursor.First()
+	c.idx = 0
+	return c.Next()
+}
+
+func (c *groupedCursor) Seek(key []byte) ([]byte, []byte, error) {
+	c.key, c.value, c.err = c.Cursor.Seek(key)
+	c.idx = sort.SearchStrings(c.keys, string(key))
+	return c.Next()
+}
+
+func (c *groupedCursor) Next() ([]byte, []byte, error) {
+	for {
+		if c.err != nil && c.err != NotFound {
+			return nil, nil, c.err
+		}
+		underlying, buffered := c.err == nil, c.idx < len(c.keys)
+		cmp := 0
+		switch {
+		case !underlying && !buffered:
+			return nil, nil, NotFound
+		case !buffered:
+			cmp = -1
+		case !underlying:
+			cmp = 1
+		default:
+			cmp = bytes.Compare(c.key, []byte(c.keys[c.idx]))
+		}
+		if cmp < 0 {
+			key, value := c.key, c.value
+			c.key, c.value, c.err = c.Cursor.Next()
+			return key, value, nil
+		} else if cmp == 0 {
+			c.key, c.value, c.err = c.Cursor.Next()
+		}
+		key := c.keys[c.idx]
+		c.idx++
+		if write := c.writes[key]; !write.deleted {
+			return []byte(key), append([]byte(nil), write.value...), nil
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGroupCommit(t *testing.T) {
+	disk := DB.WithStorage(NewGroupCommitStorage(NewMemoryStorage(), 16, time.Millisecond))
+	defer disk.Shutdown()
+
+	// Every txn increments the same counter, so any lost or
+	// reordered write shows up in the final count.
+	key := []byte("counter")
+	increment := func(rwtxn RWTxn) interface{} {
+		count := uint64(0)
+		if bites, err := rwtxn.Get(Vars, key); err == nil {
+			count = binary.BigEndian.Uint64(bites)
+		} else if err != NotFound {
+			rwtxn.Error(err)
+			return nil
+		}
+		bites := make([]byte, 8)
+		binary.BigEndian.PutUint64(bites, count+1)
+		rwtxn.Put(Vars, key, bites)
+		return count + 1
+	}
+	abort := errors.New("abort")
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := 0; idx < 50; idx++ {
+				if _, err := disk.ReadWriteTransaction(increment).ResultError(); err != nil {
+					t.Error(err)
+				}
+				// Aborted txns must not affect the rest of their batch.
+				_, err := disk.ReadWriteTransaction(func(rwtxn RWTxn) interface{} {
+					increment(rwtxn)
+					rwtxn.Error(abort)
+					return nil
+				}).ResultError()
+				if err != abort {
+					t.Errorf("Expected %v; got %v", abort, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	result, err := disk.ReadonlyTransaction(func(rtxn RTxn) interface{} {
+		bites, err := rtxn.Get(Vars, key)
+		if err != nil {
+			rtxn.Error(err)
+			return nil
+		}
+		return binary.BigEndian.Uint64(bites)
+	}).ResultError()
+	if err != nil {
+		t.Fatal(err)
+	} else if count := result.(uint64); count != 400 {
+		t.Fatalf("Expected 400; got %v", count)
+	}
+
+	disk.Shutdown()
+	if result, err := disk.ReadWriteTransaction(increment).ResultError(); result != nil || err != nil {
+		t.Fatalf("Expected nil result after shutdown; got %v, %v", result, err)
+	}
+}
+
+func TestGroupCommitReadsSeeWrites(t *testing.T) {
+	// The batch would otherwise wait an hour to fill up.
+	disk := DB.WithStorage(NewGroupCommitStorage(NewMemoryStorage(), 1024, time.Hour))
+	defer disk.Shutdown()
+
+	key := []byte("key")
+	for idx := byte(0); idx < 10; idx++ {
+		written := disk.ReadWriteTransaction(func(rwtxn RWTxn) interface{} {
+			return rwtxn.Put(Vars, key, []byte{idx})
+		})
+		result, err := disk.ReadonlyTransaction(func(rtxn RTxn) interface{} {
+			bites, err := rtxn.Get(Vars, key)
+			if err != nil {
+				rtxn.Error(err)
+				return nil
+			}
+			return bites[0]
+		}).ResultError()
+		if err != nil {
+			t.Fatal(err)
+		} else if result.(byte) != idx {
+			t.Fatalf("Expected %v; got %v", idx, result)
+		}
+		if _, err = written.ResultError(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGroupCommitCursorSeesOwnWrites(t *testing.T) {
+	disk := DB.WithStorage(NewGroupCommitStorage(NewMemoryStorage(), 16, time.Millisecond))
+	defer disk.Shutdown()
+
+	_, err := disk.ReadWriteTransaction(func(rwtxn RWTxn) interface{} {
+		for _, key := range []string{"a", "c", "e"} {
+			rwtxn.Put(Vars, []byte(key), []byte("old-"+key))
+		}
+		return nil
+	}).ResultError()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scan := func(rtxn RTxn, seek string) string {
+		var found []string
+		rtxn.WithCursor(Vars, func(cursor Cursor) interface{} {
+			key, value, err := cursor.First()
+			if seek != "" {
+				key, value, err = cursor.Seek([]byte(seek))
+			}
+			for ; err == nil; key, value, err = cursor.Next() {
+				found = append(found, string(key)+"="+string(value))
+			}
+			if err != NotFound {
+				rtxn.Error(err)
+			}
+			return nil
+		})
+		return fmt.Sprint(found)
+	}
+	result, err := disk.ReadWriteTransaction(func(rwtxn RWTxn) interface{} {
+		rwtxn.Put(Vars, []byte("b"), []byte("new-b"))
+		rwtxn.Put(Vars, []byte("c"), []byte("new-c"))
+		rwtxn.Del(Vars, []byte("e"))
+		rwtxn.Put(Vars, []byte("f"), []byte("new-f"))
+		return []string{scan(rwtxn, ""), scan(rwtxn, "bb")}
+	}).ResultError()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"[a=old-a b=new-b c=new-c f=new-f]", "[c=new-c f=new-f]"}
+	if found := result.([]string); found[0] != expected[0] || found[1] != expected[1] {
+		t.Fatalf("Expected %v; got %v", expected, found)
+	}
+}
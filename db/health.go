@@ -0,0 +1,235 @@
+package db
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	mdb "github.com/msackman/gomdb"
+	"log"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	diskMonitorInterval  = 10 * time.Second
+	mapGrowthThreshold   = 0.8
+	mapNearlyFullPercent = 95
+)
+
+// diskFullRetryDelay is how long DurableReadWriteTransaction waits
+// before retrying a txn which failed because the disk was full.
+var diskFullRetryDelay = 2 * time.Second
+
+// Health records whether the node is able to accept new writes. It is
+// degraded either because the disk monitor has found too little
+// space, because a write has failed because the disk or the map is
+// full, or because the audit log cannot be written. Whilst degraded, new write txns from clients are refused, but
+// reads and in-flight paxos instances continue: their writes are
+// retried until they succeed.
+type Health struct {
+	lock        sync.RWMutex
+	spaceLow    error
+	writeFailed error
+	auditFailed error
+	wake        chan struct{}
+}
+
+func NewHealth() *Health {
+	return &Health{wake: make(chan struct{}, 1)}
+}
+
+// Degraded returns nil if the node can accept new writes, and
+// otherwise an error explaining why not.
+func (h *Health) Degraded() error {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	if h.writeFailed != nil {
+		return h.writeFailed
+	} else if h.auditFailed != nil {
+		return h.auditFailed
+	}
+	return h.spaceLow
+}
+
+func (h *Health) String() string {
+	if err := h.Degraded(); err != nil {
+		return fmt.Sprintf("Degraded: %v", err)
+	}
+	return "Healthy"
+}
+
+func (h *Health) setSpaceLow(err error) {
+	h.lock.Lock()
+	changed := (h.spaceLow == nil) != (err == nil)
+	h.spaceLow = err
+	h.lock.Unlock()
+	if changed {
+		h.logChange()
+	}
+}
+
+func (h *Health) setWriteFailed(err error) {
+	h.lock.Lock()
+	changed := (h.writeFailed == nil) != (err == nil)
+	h.writeFailed = err
+	h.lock.Unlock()
+	if changed {
+		h.logChange()
+	}
+	if err != nil {
+		// Prompt the disk monitor to try to grow the map now.
+		select {
+		case h.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// SetAuditFailed records that the audit log cannot be written (err),
+// or that it can again (nil).
+func (h *Health) SetAuditFailed(err error) {
+	h.lock.Lock()
+	changed := (h.auditFailed == nil) != (err == nil)
+	h.auditFailed = err
+	h.lock.Unlock()
+	if changed {
+		h.logChange()
+	}
+}
+
+func (h *Health) logChange() {
+	err := h.Degraded()
+	if err == nil {
+		StorageMetrics.Set("Degraded", intVar(0))
+		log.Println("Disk: no longer degraded; accepting writes.")
+	} else {
+		StorageMetrics.Set("Degraded", intVar(1))
+		log.Printf("Disk: degraded, refusing new writes: %v\n", err)
+	}
+}
+
+// IsDiskFull returns true if err indicates that a write failed because
+// either the LMDB map or the underlying disk is full.
+func IsDiskFull(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == mdb.MapFull || err == syscall.ENOSPC {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "MDB_MAP_FULL") || strings.Contains(msg, "no space left")
+}
+
+// DurableReadWriteTransaction is the same as ReadWriteTransaction,
+// except that if the txn fails because the disk is full, the node
+// becomes degraded and the txn is retried until it succeeds. The first
+// attempt is scheduled from the calling go-routine so that the order
+// of writes is preserved. The returned func blocks until the txn has
+// run, so must not be called from an executor go-routine.
+func (db *Databases) DurableReadWriteTransaction(txnFun func(RWTxn) interface{}) func() (interface{}, error) {
+	future := db.ReadWriteTransaction(txnFun)
+	return func() (interface{}, error) {
+		for {
+			result, err := future.ResultError()
+			if !IsDiskFull(err) {
+				if err == nil && result != nil {
+					db.Health.setWriteFailed(nil)
+				}
+				return result, err
+			}
+			StorageMetrics.Add("DiskFullWrites", 1)
+			db.Health.setWriteFailed(fmt.Errorf("Unable to write to disk: %v", err))
+			time.Sleep(diskFullRetryDelay)
+			future = db.ReadWriteTransaction(txnFun)
+		}
+	}
+}
+
+// mapGrower is implemented by storage, such as LMDB, which is limited
+// to a map of a fixed size.
+type mapGrower interface {
+	maybeGrowMap(free, maxMapSize uint64) error
+}
+
+// DiskMonitor periodically checks the free space on the disk holding
+// the data dir, and grows the LMDB map as it fills up, up to
+// maxMapSize (0 for no limit other than the disk itself).
+type DiskMonitor struct {
+	db         *Databases
+	dir        string
+	maxMapSize uint64
+	minFree    uint64
+	terminate  chan struct{}
+	stopOnce   sync.Once
+}
+
+func (db *Databases) StartDiskMonitor(dir string, maxMapSize, minFree uint64) *DiskMonitor {
+	dm := &DiskMonitor{
+		db:         db,
+		dir:        dir,
+		maxMapSize: maxMapSize,
+		minFree:    minFree,
+		terminate:  make(chan struct{}),
+	}
+	go dm.loop()
+	return dm
+}
+
+func (dm *DiskMonitor) Shutdown() {
+	dm.stopOnce.Do(func() { close(dm.terminate) })
+}
+
+func (dm *DiskMonitor) loop() {
+	ticker := time.NewTicker(diskMonitorInterval)
+	defer ticker.Stop()
+	for {
+		dm.check()
+		select {
+		case <-ticker.C:
+		case <-dm.db.Health.wake:
+		case <-dm.terminate:
+			return
+		}
+	}
+}
+
+func (dm *DiskMonitor) check() {
+	free, err := freeSpace(dm.dir)
+	if err != nil {
+		log.Printf("Disk: unable to determine free space on %v: %v\n", dm.dir, err)
+		return
+	}
+	StorageMetrics.Set("FreeBytes", intVar(free))
+
+	var problems []string
+	if free < dm.minFree {
+		problems = append(problems, fmt.Sprintf("only %v bytes free on %v (minimum %v)", free, dm.dir, dm.minFree))
+	}
+	if grower, ok := dm.db.Storage.(mapGrower); ok {
+		if err := grower.maybeGrowMap(free, dm.maxMapSize); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) == 0 {
+		dm.db.Health.setSpaceLow(nil)
+	} else {
+		dm.db.Health.setSpaceLow(errors.New(strings.Join(problems, "; ")))
+	}
+}
+
+func freeSpace(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+func intVar(n uint64) *expvar.Int {
+	i := new(expvar.Int)
+	i.Set(int64(n))
+	return i
+}
@@ -0,0 +1,165 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fullStorage fails the first failures read-write txns as if the disk
+// were full. Every later attempt waits for resume to be closed before
+// it is submitted, and attempts receives the number of each attempt.
+type fullStorage struct {
+	Storage
+	failures int
+	attempt  int
+	attempts chan int
+	resume   chan struct{}
+}
+
+func (s *fullStorage) ReadWriteTransaction(txnFun func(RWTxn) interface{}) Future {
+	s.attempt++
+	s.attempts <- s.attempt
+	if s.attempt <= s.failures {
+		return s.Storage.ReadWriteTransaction(func(rwtxn RWTxn) interface{} {
+			rwtxn.Error(syscall.ENOSPC)
+			return nil
+		})
+	}
+	<-s.resume
+	return s.Storage.ReadWriteTransaction(txnFun)
+}
+
+func TestDurableReadWriteTransaction(t *testing.T) {
+	defer func(delay time.Duration) { diskFullRetryDelay = delay }(diskFullRetryDelay)
+	diskFullRetryDelay = time.Millisecond
+
+	storage := &fullStorage{
+		Storage:  NewMemoryStorage(),
+		failures: 2,
+		attempts: make(chan int, 8),
+		resume:   make(chan struct{}),
+	}
+	disk := &Databases{Storage: storage, Health: NewHealth()}
+	defer disk.Shutdown()
+
+	key := []byte("key")
+	result := disk.DurableReadWriteTransaction(func(rwtxn RWTxn) interface{} {
+		if err := rwtxn.Put(Vars, key, []byte("value")); err != nil {
+			rwtxn.Error(err)
+		}
+		return true
+	})
+	if attempt := <-storage.attempts; attempt != 1 {
+		t.Fatalf("Expected the first attempt to be submitted immediately; got attempt %v", attempt)
+	}
+	if err := disk.Health.Degraded(); err != nil {
+		t.Fatalf("Did not expect to be degraded before the disk is found to be full: %v", err)
+	}
+
+	type resultErr struct {
+		result interface{}
+		err    error
+	}
+	done := make(chan resultErr, 1)
+	go func() {
+		result, err := result()
+		done <- resultErr{result: result, err: err}
+	}()
+
+	// The txn is retried for as long as the disk is full, and the
+	// node is degraded meanwhile.
+	for expected := 2; expected <= 3; expected++ {
+		if attempt := <-storage.attempts; attempt != expected {
+			t.Fatalf("Expected attempt %v; got %v", expected, attempt)
+		}
+	}
+	if err := disk.Health.Degraded(); err == nil {
+		t.Fatal("Expected to be degraded whilst the disk is full")
+	}
+	select {
+	case re := <-done:
+		t.Fatalf("Did not expect the txn to complete whilst the disk is full; got %v, %v", re.result, re.err)
+	default:
+	}
+
+	close(storage.resume)
+	re := <-done
+	if re.err != nil || re.result != true {
+		t.Fatalf("Expected the txn to succeed once there is space; got %v, %v", re.result, re.err)
+	}
+	if err := disk.Health.Degraded(); err != nil {
+		t.Fatalf("Expected to recover once a write succeeds; got %v", err)
+	}
+	value, err := disk.ReadonlyTransaction(func(rtxn RTxn) interface{} {
+		bites, err := rtxn.Get(Vars, key)
+		if err != nil {
+			rtxn.Error(err)
+		}
+		return bites
+	}).ResultError()
+	if err != nil || string(value.([]byte)) != "value" {
+		t.Fatalf("Expected the txn to have been written exactly once; got %v, %v", value, err)
+	}
+}
+
+// Any other error is returned immediately, without degrading the node.
+func TestDurableReadWriteTransactionError(t *testing.T) {
+	disk := &Databases{Storage: NewMemoryStorage(), Health: NewHealth()}
+	defer disk.Shutdown()
+
+	failure := errors.New("failure")
+	_, err := disk.DurableReadWriteTransaction(func(rwtxn RWTxn) interface{} {
+		rwtxn.Error(failure)
+		return nil
+	})()
+	if err != failure {
+		t.Fatalf("Expected %v; got %v", failure, err)
+	}
+	if err = disk.Health.Degraded(); err != nil {
+		t.Fatalf("Did not expect to be degraded: %v", err)
+	}
+}
+
+func TestHealthDegraded(t *testing.T) {
+	h := NewHealth()
+	spaceLow, writeFailed, auditFailed := errors.New("space low"), errors.New("write failed"), errors.New("audit failed")
+	h.setSpaceLow(spaceLow)
+	h.SetAuditFailed(auditFailed)
+	h.setWriteFailed(writeFailed)
+	// A failed write is the more pressing.
+	if err := h.Degraded(); err != writeFailed {
+		t.Fatalf("Expected %v; got %v", writeFailed, err)
+	}
+	h.setWriteFailed(nil)
+	if err := h.Degraded(); err != auditFailed {
+		t.Fatalf("Expected %v; got %v", auditFailed, err)
+	}
+	h.SetAuditFailed(nil)
+	if err := h.Degraded(); err != spaceLow {
+		t.Fatalf("Expected %v; got %v", spaceLow, err)
+	}
+	h.setSpaceLow(nil)
+	if err := h.Degraded(); err != nil || h.String() != "Healthy" {
+		t.Fatalf("Expected to be healthy; got %v", err)
+	}
+}
+
+func TestIsDiskFull(t *testing.T) {
+	for _, err := range []error{
+		syscall.ENOSPC,
+		fmt.Errorf("write /data/data.mdb: no space left on device"),
+		errors.New("mdb_txn_commit: MDB_MAP_FULL: Environment mapsize limit reached"),
+	} {
+		if !IsDiskFull(err) {
+			t.Fatalf("Expected %v to indicate the disk is full", err)
+		}
+	}
+	for _, err := range []error{nil, NotFound, syscall.EIO} {
+		if IsDiskFull(err) {
+			t.Fatalf("Did not expect %v to indicate the disk is full", err)
+		}
+	}
+}
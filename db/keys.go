@@ -0,0 +1,172 @@
+package db
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// A Keyring holds the keys used to encrypt records at rest. Records
+// are always written with the current key, if there is one, and may
+// be read with any key in the keyring. Each encrypted record carries
+// the id of the key it was written with. Once there is a current
+// key, records which are not encrypted are refused, unless
+// AcceptPlaintext has been called: otherwise anyone able to write to
+// the data directory could replace a record's ciphertext with
+// plaintext of their choosing.
+type Keyring struct {
+	current         *recordKey
+	keys            map[uint32]*recordKey
+	acceptPlaintext bool
+}
+
+type recordKey struct {
+	id   uint32
+	aead cipher.AEAD
+}
+
+const (
+	KeyLen         = 32
+	recordKeyIdLen = 4
+)
+
+// NewKeyring creates a keyring which writes with current and can read
+// with current and any of others. current may be nil, in which case
+// records are written in the clear.
+func NewKeyring(current []byte, others ...[]byte) (*Keyring, error) {
+	kr := &Keyring{keys: make(map[uint32]*recordKey)}
+	if current != nil {
+		key, err := kr.add(current)
+		if err != nil {
+			return nil, err
+		}
+		kr.current = key
+	}
+	for _, other := range others {
+		if _, err := kr.add(other); err != nil {
+			return nil, err
+		}
+	}
+	return kr, nil
+}
+
+func (kr *Keyring) add(bites []byte) (*recordKey, error) {
+	if len(bites) != KeyLen {
+		return nil, fmt.Errorf("Key must be %v bytes long; found %v bytes", KeyLen, len(bites))
+	}
+	block, err := aes.NewCipher(bites)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(bites)
+	key := &recordKey{
+		id:   binary.BigEndian.Uint32(sum[:recordKeyIdLen]),
+		aead: aead,
+	}
+	kr.keys[key.id] = key
+	return key, nil
+}
+
+// LoadKeyFile reads a key file, which must contain exactly one key,
+// hex encoded. If path is empty, it returns nil, nil.
+func LoadKeyFile(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode key file %v: %v", path, err)
+	}
+	return key, nil
+}
+
+// LoadKeyring loads the current key from the key file at path, and
+// any previous keys, which are only used to read records written
+// before the key was changed, from oldPaths. Records are rewritten
+// with the current key as they change, or all at once by the rekey
+// tool, so the server need not be stopped to change the key. If path
+// is empty, it returns nil, nil.
+func LoadKeyring(path string, oldPaths ...string) (*Keyring, error) {
+	key, err := LoadKeyFile(path)
+	if err != nil || key == nil {
+		return nil, err
+	}
+	oldKeys := make([][]byte, 0, len(oldPaths))
+	for _, oldPath := range oldPaths {
+		oldKey, err := LoadKeyFile(oldPath)
+		if err != nil {
+			return nil, err
+		} else if oldKey != nil {
+			oldKeys = append(oldKeys, oldKey)
+		}
+	}
+	return NewKeyring(key, oldKeys...)
+}
+
+// AcceptPlaintext allows records which are not encrypted to be read
+// despite the keyring having a current key. It is for migrating a
+// data directory to encryption: such records are encrypted as they
+// are rewritten.
+func (kr *Keyring) AcceptPlaintext() {
+	kr.acceptPlaintext = true
+}
+
+func (kr *Keyring) refusesPlaintext() bool {
+	return kr != nil && kr.current != nil && !kr.acceptPlaintext
+}
+
+// encrypt returns keyId | nonce | ciphertext, or nil if there is no
+// current key. aad is authenticated but not encrypted.
+func (kr *Keyring) encrypt(payload, aad []byte) []byte {
+	if kr == nil || kr.current == nil {
+		return nil
+	}
+	aead := kr.current.aead
+	nonceLen := aead.NonceSize()
+	bites := make([]byte, recordKeyIdLen+nonceLen, recordKeyIdLen+nonceLen+len(payload)+aead.Overhead())
+	binary.BigEndian.PutUint32(bites, kr.current.id)
+	nonce := bites[recordKeyIdLen:]
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("Unable to generate nonce: %v", err))
+	}
+	return aead.Seal(bites, nonce, payload, aad)
+}
+
+func (kr *Keyring) decrypt(bites, aad []byte) ([]byte, error) {
+	if len(bites) < recordKeyIdLen {
+		return nil, corrupt("truncated key id (%v bytes)", len(bites))
+	}
+	keyId := binary.BigEndian.Uint32(bites)
+	var key *recordKey
+	if kr != nil {
+		key = kr.keys[keyId]
+	}
+	if key == nil {
+		// Not corruption: we've just not been given the key.
+		return nil, fmt.Errorf("Record is encrypted with key %08x, which has not been supplied", keyId)
+	}
+	bites = bites[recordKeyIdLen:]
+	nonceLen := key.aead.NonceSize()
+	if len(bites) < nonceLen {
+		return nil, corrupt("truncated nonce (%v bytes)", len(bites))
+	}
+	payload, err := key.aead.Open(nil, bites[:nonceLen], bites[nonceLen:], aad)
+	if err != nil {
+		return nil, corrupt("unable to decrypt with key %08x: %v", keyId, err)
+	}
+	return payload, nil
+}
@@ -0,0 +1,235 @@
+package db
+
+import (
+	"fmt"
+	mdb "github.com/msackman/gomdb"
+	mdbs "github.com/msackman/gomdb/server"
+	"log"
+	"time"
+)
+
+// lmdbDBIs is the set of DBIs in an LMDB environment, one per Table.
+// The MDBServer clones it and opens each DBISettings field.
+type lmdbDBIs struct {
+	*mdbs.MDBServer
+	Vars            *mdbs.DBISettings
+	Proposers       *mdbs.DBISettings
+	BallotOutcomes  *mdbs.DBISettings
+	Transactions    *mdbs.DBISettings
+	TransactionRefs *mdbs.DBISettings
+	ReverseRefs     *mdbs.DBISettings
+}
+
+func newLMDBDBIs() *lmdbDBIs {
+	return &lmdbDBIs{
+		Vars:            &mdbs.DBISettings{Flags: mdb.CREATE},
+		Proposers:       &mdbs.DBISettings{Flags: mdb.CREATE},
+		BallotOutcomes:  &mdbs.DBISettings{Flags: mdb.CREATE},
+		Transactions:    &mdbs.DBISettings{Flags: mdb.CREATE},
+		TransactionRefs: &mdbs.DBISettings{Flags: mdb.CREATE},
+		ReverseRefs:     &mdbs.DBISettings{Flags: mdb.CREATE},
+	}
+}
+
+func (dbis *lmdbDBIs) Clone() mdbs.DBIsInterface {
+	return &lmdbDBIs{
+		Vars:            dbis.Vars.Clone(),
+		Proposers:       dbis.Proposers.Clone(),
+		BallotOutcomes:  dbis.BallotOutcomes.Clone(),
+		Transactions:    dbis.Transactions.Clone(),
+		TransactionRefs: dbis.TransactionRefs.Clone(),
+		ReverseRefs:     dbis.ReverseRefs.Clone(),
+	}
+}
+
+func (dbis *lmdbDBIs) SetServer(server *mdbs.MDBServer) {
+	dbis.MDBServer = server
+}
+
+func (dbis *lmdbDBIs) dbi(table Table) *mdbs.DBISettings {
+	switch table {
+	case Vars:
+		return dbis.Vars
+	case Proposers:
+		return dbis.Proposers
+	case BallotOutcomes:
+		return dbis.BallotOutcomes
+	case Transactions:
+		return dbis.Transactions
+	case TransactionRefs:
+		return dbis.TransactionRefs
+	case ReverseRefs:
+		return dbis.ReverseRefs
+	default:
+		panic(fmt.Sprintf("Unknown table: %v", table))
+	}
+}
+
+// lmdbStorage keeps everything in an LMDB environment on disk.
+type lmdbStorage struct {
+	dbis *lmdbDBIs
+}
+
+// OpenLMDB opens the LMDB environment in dir, creating it if
+// necessary.
+func OpenLMDB(dir string, mapSize uint64, numReaders int, commitLatency time.Duration) (Storage, error) {
+	disk, err := mdbs.NewMDBServer(dir, 0, 0600, mapSize, numReaders, commitLatency, newLMDBDBIs())
+	if err != nil {
+		return nil, err
+	}
+	return &lmdbStorage{dbis: disk.(*lmdbDBIs)}, nil
+}
+
+func (s *lmdbStorage) ReadonlyTransaction(txnFun func(RTxn) interface{}) Future {
+	return s.dbis.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
+		return txnFun(&lmdbRTxn{dbis: s.dbis, rtxn: rtxn})
+	})
+}
+
+func (s *lmdbStorage) ReadWriteTransaction(txnFun func(RWTxn) interface{}) Future {
+	return s.dbis.ReadWriteTransaction(false, func(rwtxn *mdbs.RWTxn) interface{} {
+		return txnFun(&lmdbRWTxn{dbis: s.dbis, rwtxn: rwtxn})
+	})
+}
+
+func (s *lmdbStorage) SetNoSync(noSync bool) Future {
+	return s.dbis.WithEnv(func(env *mdb.Env) (interface{}, error) {
+		return nil, env.SetFlags(mdb.NOSYNC, noSync)
+	})
+}
+
+func (s *lmdbStorage) Shutdown() {
+	s.dbis.Shutdown()
+}
+
+// maybeGrowMap doubles the map once it is mapGrowthThreshold full,
+// limited by maxMapSize (0 for no limit) and by the free space on the
+// disk. It returns an error if the map is nearly full and cannot be
+// grown.
+func (s *lmdbStorage) maybeGrowMap(free, maxMapSize uint64) error {
+	result, err := s.dbis.WithEnv(func(env *mdb.Env) (interface{}, error) {
+		info, err := env.Info()
+		if err != nil {
+			return nil, err
+		}
+		stat, err := env.Stat()
+		if err != nil {
+			return nil, err
+		}
+		mapSize := uint64(info.MapSize)
+		used := uint64(info.LastPNO+1) * uint64(stat.PSize)
+		StorageMetrics.Set("MapSize", intVar(mapSize))
+		StorageMetrics.Set("MapUsedBytes", intVar(used))
+		if float64(used) < float64(mapSize)*mapGrowthThreshold {
+			return nil, nil
+		}
+
+		// The map file is sparse, so growing the map only needs disk
+		// space as it is used.
+		limit := used + free
+		if maxMapSize != 0 && maxMapSize < limit {
+			limit = maxMapSize
+		}
+		newSize := 2 * mapSize
+		if newSize > limit {
+			newSize = limit
+		}
+		if newSize > mapSize {
+			if err := env.SetMapSize(newSize); err != nil {
+				return nil, err
+			}
+			log.Printf("Disk: grew map from %v to %v bytes.\n", mapSize, newSize)
+			StorageMetrics.Add("MapGrowths", 1)
+			StorageMetrics.Set("MapSize", intVar(newSize))
+			mapSize = newSize
+		}
+		if used*100 >= mapSize*mapNearlyFullPercent {
+			return fmt.Errorf("map is %v%% full (%v of %v bytes) and cannot grow further", used*100/mapSize, used, mapSize), nil
+		}
+		return nil, nil
+	}).ResultError()
+	if err != nil {
+		log.Printf("Disk: unable to inspect or grow map: %v\n", err)
+		return nil
+	} else if result != nil {
+		return result.(error)
+	}
+	return nil
+}
+
+func lmdbError(err error) error {
+	if err == mdb.NotFound {
+		return NotFound
+	}
+	return err
+}
+
+type lmdbRTxn struct {
+	dbis *lmdbDBIs
+	rtxn *mdbs.RTxn
+}
+
+func (t *lmdbRTxn) Get(table Table, key []byte) ([]byte, error) {
+	value, err := t.rtxn.Get(t.dbis.dbi(table), key)
+	return value, lmdbError(err)
+}
+
+func (t *lmdbRTxn) WithCursor(table Table, cursorFun func(Cursor) interface{}) interface{} {
+	result, _ := t.rtxn.WithCursor(t.dbis.dbi(table), func(cursor *mdbs.Cursor) interface{} {
+		return cursorFun(&lmdbCursor{cursor: cursor})
+	})
+	return result
+}
+
+func (t *lmdbRTxn) Error(err error) {
+	t.rtxn.Error(err)
+}
+
+type lmdbRWTxn struct {
+	dbis  *lmdbDBIs
+	rwtxn *mdbs.RWTxn
+}
+
+func (t *lmdbRWTxn) Get(table Table, key []byte) ([]byte, error) {
+	value, err := t.rwtxn.Get(t.dbis.dbi(table), key)
+	return value, lmdbError(err)
+}
+
+func (t *lmdbRWTxn) WithCursor(table Table, cursorFun func(Cursor) interface{}) interface{} {
+	result, _ := t.rwtxn.WithCursor(t.dbis.dbi(table), func(cursor *mdbs.Cursor) interface{} {
+		return cursorFun(&lmdbCursor{cursor: cursor})
+	})
+	return result
+}
+
+func (t *lmdbRWTxn) Error(err error) {
+	t.rwtxn.Error(err)
+}
+
+func (t *lmdbRWTxn) Put(table Table, key, value []byte) error {
+	return t.rwtxn.Put(t.dbis.dbi(table), key, value, 0)
+}
+
+func (t *lmdbRWTxn) Del(table Table, key []byte) error {
+	return lmdbError(t.rwtxn.Del(t.dbis.dbi(table), key, nil))
+}
+
+// lmdbCursor relies on mdbs cursors returning copies.
+type lmdbCursor struct {
+	cursor *mdbs.Cursor
+}
+
+func (c *lmdbCursor) First() ([]byte, []byte, error) {
+	key, value, err := c.cursor.Get(nil, nil, mdb.FIRST)
+	return key, value, lmdbError(err)
+}
+
+func (c *lmdbCursor) Seek(key []byte) ([]byte, []byte, error) {
+	key, value, err := c.cursor.Get(key, nil, mdb.SET_RANGE)
+	return key, value, lmdbError(err)
+}
+
+func (c *lmdbCursor) Next() ([]byte, []byte, error) {
+	key, value, err := c.cursor.Get(nil, nil, mdb.NEXT)
+	return key, value, lmdbError(err)
+}
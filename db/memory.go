@@ -0,0 +1,232 @@
+package db
+
+import (
+	"sort"
+	"sync"
+)
+
+// memoryStorage keeps everything in memory, and so loses everything
+// on shutdown. It is intended for tests and ephemeral clusters. As
+// with LMDB, txns are queued and run one at a time, in the order they
+// were submitted, by the storage's own go-routine: so a txn func may
+// itself submit further txns, which run after it.
+type memoryStorage struct {
+	tables     [tableCount]map[string][]byte
+	lock       sync.Mutex
+	queue      []*memoryFuture
+	terminated bool
+	wake       chan struct{}
+	terminate  chan struct{}
+	finished   chan struct{}
+}
+
+func NewMemoryStorage() Storage {
+	s := &memoryStorage{
+		wake:      make(chan struct{}, 1),
+		terminate: make(chan struct{}),
+		finished:  make(chan struct{}),
+	}
+	for idx := range s.tables {
+		s.tables[idx] = make(map[string][]byte)
+	}
+	go s.loop()
+	return s
+}
+
+type memoryFuture struct {
+	run    func() (interface{}, error)
+	result interface{}
+	err    error
+	done   chan struct{}
+}
+
+func (f *memoryFuture) ResultError() (interface{}, error) {
+	<-f.done
+	return f.result, f.err
+}
+
+func (s *memoryStorage) ReadonlyTransaction(txnFun func(RTxn) interface{}) Future {
+	return s.enqueue(func() (interface{}, error) {
+		rtxn := &memoryRTxn{storage: s}
+		result := txnFun(rtxn)
+		if rtxn.err != nil {
+			return nil, rtxn.err
+		}
+		return result, nil
+	})
+}
+
+func (s *memoryStorage) ReadWriteTransaction(txnFun func(RWTxn) interface{}) Future {
+	return s.enqueue(func() (interface{}, error) {
+		rwtxn := &memoryRWTxn{memoryRTxn: memoryRTxn{storage: s}}
+		result := txnFun(rwtxn)
+		if rwtxn.err != nil {
+			rwtxn.rollback()
+			return nil, rwtxn.err
+		}
+		return result, nil
+	})
+}
+
+func (s *memoryStorage) SetNoSync(noSync bool) Future {
+	return s.enqueue(func() (interface{}, error) { return nil, nil })
+}
+
+// Txns still queued at shutdown are not run, and their result is nil.
+func (s *memoryStorage) Shutdown() {
+	s.lock.Lock()
+	if s.terminated {
+		s.lock.Unlock()
+		return
+	}
+	s.terminated = true
+	s.lock.Unlock()
+	close(s.terminate)
+	<-s.finished
+}
+
+func (s *memoryStorage) enqueue(run func() (interface{}, error)) Future {
+	future := &memoryFuture{run: run, done: make(chan struct{})}
+	s.lock.Lock()
+	if s.terminated {
+		s.lock.Unlock()
+		close(future.done)
+		return future
+	}
+	s.queue = append(s.queue, future)
+	s.lock.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return future
+}
+
+func (s *memoryStorage) loop() {
+	defer close(s.finished)
+	for {
+		select {
+		case <-s.wake:
+		case <-s.terminate:
+			s.lock.Lock()
+			queue := s.queue
+			s.queue = nil
+			s.lock.Unlock()
+			for _, future := range queue {
+				close(future.done)
+			}
+			for idx := range s.tables {
+				s.tables[idx] = nil
+			}
+			return
+		}
+		for {
+			s.lock.Lock()
+			if len(s.queue) == 0 || s.terminated {
+				s.lock.Unlock()
+				break
+			}
+			future := s.queue[0]
+			s.queue = s.queue[1:]
+			s.lock.Unlock()
+			future.result, future.err = future.run()
+			close(future.done)
+		}
+	}
+}
+
+type memoryRTxn struct {
+	storage *memoryStorage
+	err     error
+}
+
+func (t *memoryRTxn) Get(table Table, key []byte) ([]byte, error) {
+	if value, found := t.storage.tables[table][string(key)]; found {
+		return append([]byte(nil), value...), nil
+	}
+	return nil, NotFound
+}
+
+func (t *memoryRTxn) WithCursor(table Table, cursorFun func(Cursor) interface{}) interface{} {
+	entries := t.storage.tables[table]
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return cursorFun(&memoryCursor{entries: entries, keys: keys, idx: -1})
+}
+
+func (t *memoryRTxn) Error(err error) {
+	if t.err == nil {
+		t.err = err
+	}
+}
+
+// memoryRWTxn writes straight into the tables, keeping an undo log
+// in case the txn is aborted.
+type memoryRWTxn struct {
+	memoryRTxn
+	undo []func()
+}
+
+func (t *memoryRWTxn) Put(table Table, key, value []byte) error {
+	t.record(table, string(key))
+	t.storage.tables[table][string(key)] = append([]byte(nil), value...)
+	return nil
+}
+
+func (t *memoryRWTxn) Del(table Table, key []byte) error {
+	if _, found := t.storage.tables[table][string(key)]; !found {
+		return NotFound
+	}
+	t.record(table, string(key))
+	delete(t.storage.tables[table], string(key))
+	return nil
+}
+
+func (t *memoryRWTxn) record(table Table, key string) {
+	entries := t.storage.tables[table]
+	if old, found := entries[key]; found {
+		t.undo = append(t.undo, func() { entries[key] = old })
+	} else {
+		t.undo = append(t.undo, func() { delete(entries, key) })
+	}
+}
+
+func (t *memoryRWTxn) rollback() {
+	for idx := len(t.undo) - 1; idx >= 0; idx-- {
+		t.undo[idx]()
+	}
+	t.undo = nil
+}
+
+// memoryCursor iterates over the keys which were present when it was
+// created, skipping any which have since been deleted.
+type memoryCursor struct {
+	entries map[string][]byte
+	keys    []string
+	idx     int
+}
+
+func (c *memoryCursor) First() ([]byte, []byte, error) {
+	c.idx = -1
+	return c.Next()
+}
+
+func (c *memoryCursor) Seek(key []byte) ([]byte, []byte, error) {
+	c.idx = sort.SearchStrings(c.keys, string(key)) - 1
+	return c.Next()
+}
+
+func (c *memoryCursor) Next() ([]byte, []byte, error) {
+	for c.idx+1 < len(c.keys) {
+		c.idx++
+		key := c.keys[c.idx]
+		if value, found := c.entries[key]; found {
+			return []byte(key), append([]byte(nil), value...), nil
+		}
+	}
+	c.idx = len(c.keys)
+	return nil, nil, NotFound
+}
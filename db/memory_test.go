@@ -0,0 +1,168 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"goshawkdb.io/common"
+	"testing"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	disk := DB.WithStorage(NewMemoryStorage())
+	defer disk.Shutdown()
+
+	keys := [][]byte{[]byte("c"), []byte("a"), []byte("b")}
+	_, err := disk.ReadWriteTransaction(func(rwtxn RWTxn) interface{} {
+		for _, key := range keys {
+			if err := rwtxn.Put(Vars, key, append([]byte("value-"), key...)); err != nil {
+				rwtxn.Error(err)
+			}
+		}
+		return nil
+	}).ResultError()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An aborted txn has no effect.
+	abort := errors.New("abort")
+	_, err = disk.ReadWriteTransaction(func(rwtxn RWTxn) interface{} {
+		rwtxn.Put(Vars, []byte("a"), []byte("overwritten"))
+		rwtxn.Put(Vars, []byte("d"), []byte("new"))
+		rwtxn.Del(Vars, []byte("b"))
+		rwtxn.Error(abort)
+		return nil
+	}).ResultError()
+	if err != abort {
+		t.Fatalf("Expected %v; got %v", abort, err)
+	}
+
+	result, err := disk.ReadonlyTransaction(func(rtxn RTxn) interface{} {
+		var found []string
+		rtxn.WithCursor(Vars, func(cursor Cursor) interface{} {
+			key, value, err := cursor.First()
+			for ; err == nil; key, value, err = cursor.Next() {
+				if !bytes.Equal(value, append([]byte("value-"), key...)) {
+					t.Errorf("Unexpected value for %s: %s", key, value)
+				}
+				found = append(found, string(key))
+			}
+			if err != NotFound {
+				rtxn.Error(err)
+			}
+			return nil
+		})
+		if _, err := rtxn.Get(Proposers, []byte("a")); err != NotFound {
+			t.Errorf("Expected tables to be distinct; got %v", err)
+		}
+		return found
+	}).ResultError()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found := result.([]string); len(found) != 3 || found[0] != "a" || found[1] != "b" || found[2] != "c" {
+		t.Fatalf("Expected [a b c]; got %v", found)
+	}
+
+	result, err = disk.ReadonlyTransaction(func(rtxn RTxn) interface{} {
+		return rtxn.WithCursor(Vars, func(cursor Cursor) interface{} {
+			key, _, err := cursor.Seek([]byte("bb"))
+			if err != nil {
+				rtxn.Error(err)
+			}
+			return string(key)
+		})
+	}).ResultError()
+	if err != nil {
+		t.Fatal(err)
+	} else if result.(string) != "c" {
+		t.Fatalf("Expected seek to find c; got %v", result)
+	}
+}
+
+func TestMemoryStorageTxnRefCounts(t *testing.T) {
+	disk := DB.WithStorage(NewMemoryStorage())
+	defer disk.Shutdown()
+
+	txnId := &common.TxnId{}
+	txnId[0] = 1
+	txnBites := []byte{0, 0, 0, 0, 1, 0, 0, 0, 9, 9, 9, 9, 9, 9, 9, 9}
+
+	write := func(f func(rwtxn RWTxn) error) {
+		if _, err := disk.ReadWriteTransaction(func(rwtxn RWTxn) interface{} {
+			if err := f(rwtxn); err != nil {
+				rwtxn.Error(err)
+			}
+			return nil
+		}).ResultError(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func() []byte {
+		result, err := disk.ReadonlyTransaction(func(rtxn RTxn) interface{} {
+			bites, err := disk.ReadTxnBytesFromDisk(rtxn, txnId)
+			if err != nil {
+				rtxn.Error(err)
+			}
+			return bites
+		}).ResultError()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.([]byte)
+	}
+
+	for idx := 0; idx < 2; idx++ {
+		write(func(rwtxn RWTxn) error { return disk.WriteTxnToDisk(rwtxn, txnId, txnBites) })
+	}
+	if bites := read(); !bytes.Equal(bites, txnBites) {
+		t.Fatalf("Expected %v; got %v", txnBites, bites)
+	}
+	write(func(rwtxn RWTxn) error { return disk.DeleteTxnFromDisk(rwtxn, txnId) })
+	if bites := read(); bites == nil {
+		t.Fatal("Txn deleted whilst still referenced")
+	}
+	write(func(rwtxn RWTxn) error { return disk.DeleteTxnFromDisk(rwtxn, txnId) })
+	if bites := read(); bites != nil {
+		t.Fatalf("Expected txn to be deleted; got %v", bites)
+	}
+}
+
+func TestMemoryStorageNestedTxns(t *testing.T) {
+	disk := DB.WithStorage(NewMemoryStorage())
+	defer disk.Shutdown()
+
+	// A txn func which submits another txn must not wait for it: it
+	// runs once the submitting txn has finished.
+	var nested Future
+	_, err := disk.ReadWriteTransaction(func(rwtxn RWTxn) interface{} {
+		rwtxn.Put(Vars, []byte("a"), []byte("outer"))
+		nested = disk.ReadWriteTransaction(func(rwtxn RWTxn) interface{} {
+			bites, err := rwtxn.Get(Vars, []byte("a"))
+			if err != nil {
+				rwtxn.Error(err)
+				return nil
+			}
+			rwtxn.Put(Vars, []byte("a"), []byte("inner"))
+			return string(bites)
+		})
+		return nil
+	}).ResultError()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := nested.ResultError(); err != nil {
+		t.Fatal(err)
+	} else if result.(string) != "outer" {
+		t.Fatalf("Expected nested txn to see outer txn's write; got %v", result)
+	}
+	result, err := disk.ReadonlyTransaction(func(rtxn RTxn) interface{} {
+		bites, _ := rtxn.Get(Vars, []byte("a"))
+		return string(bites)
+	}).ResultError()
+	if err != nil {
+		t.Fatal(err)
+	} else if result.(string) != "inner" {
+		t.Fatalf("Expected txns to run in submission order; got %v", result)
+	}
+}
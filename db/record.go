@@ -0,0 +1,185 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"expvar"
+	"fmt"
+	"hash/crc32"
+)
+
+// Every value in the Vars, Transactions, Proposers and BallotOutcomes
+// DBIs is wrapped in an envelope:
+//
+//	3 bytes magic | 1 byte flags | 4 bytes CRC-32C (big-endian) | body
+//
+// The checksum covers the body. If the record is compressed, the
+// payload is deflated first. Then if the record is encrypted, the
+// body is keyId | nonce | ciphertext (see Keyring), otherwise it is
+// the (possibly compressed) payload itself. The ciphertext of a
+// record with the bound flag is authenticated together with the table
+// and key under which it is stored, so that it cannot be moved to
+// another key; encrypted records written before the flag existed are
+// still read, without that check. Values written before
+// envelopes existed are capnp messages which start with a segment
+// count, and so cannot start with the magic. They have no checksum,
+// but they are returned only if their framing is valid, so a record
+// whose magic has been corrupted is not mistaken for one. Once
+// records are encrypted, records which are not (including those from
+// before envelopes existed) are refused: see Keyring.
+const (
+	recordHeaderLen      = 8
+	recordFlagEncrypted  = 0x01
+	recordFlagCompressed = 0x02
+	recordFlagBound      = 0x04
+	recordFlagsKnown     = recordFlagEncrypted | recordFlagCompressed | recordFlagBound
+	legacyMaxSegments    = 512
+)
+
+var (
+	recordMagic    = []byte{0xc7, 0x5d, 0xb1}
+	recordCRCTable = crc32.MakeTable(crc32.Castagnoli)
+	StorageMetrics = expvar.NewMap("Storage")
+)
+
+type CorruptRecordError struct {
+	Reason string
+}
+
+func (e *CorruptRecordError) Error() string {
+	return fmt.Sprintf("Corrupt record on disk: %v", e.Reason)
+}
+
+func IsCorruptRecord(err error) bool {
+	_, ok := err.(*CorruptRecordError)
+	return ok
+}
+
+// PlaintextRefused is returned when a record which is not encrypted is
+// read but records are encrypted. It is not corruption: the data
+// directory may not yet have been migrated to encryption.
+var PlaintextRefused = errors.New("Record is not encrypted, but encryption is enabled. Run rekey to encrypt the data directory, or accept unencrypted records whilst migrating")
+
+// SealRecord wraps payload in an envelope for storing under key in
+// table.
+func (db *Databases) SealRecord(table Table, key, payload []byte) []byte {
+	return db.seal(table, key, payload, 0)
+}
+
+// SealCompressibleRecord is the same as SealRecord, except that the
+// payload is compressed if compression is enabled and worthwhile.
+func (db *Databases) SealCompressibleRecord(table Table, key, payload []byte) []byte {
+	if db.Compress {
+		if compressed := Compress(payload); compressed != nil {
+			return db.seal(table, key, compressed, recordFlagCompressed)
+		}
+	}
+	return db.seal(table, key, payload, 0)
+}
+
+func (db *Databases) seal(table Table, key, payload []byte, flags byte) []byte {
+	body := db.Keys.encrypt(payload, recordAAD(table, key))
+	if body == nil {
+		body = payload
+	} else {
+		flags |= recordFlagEncrypted | recordFlagBound
+	}
+	bites := make([]byte, recordHeaderLen+len(body))
+	copy(bites, recordMagic)
+	bites[3] = flags
+	binary.BigEndian.PutUint32(bites[4:], crc32.Checksum(body, recordCRCTable))
+	copy(bites[recordHeaderLen:], body)
+	return bites
+}
+
+// OpenRecord verifies the envelope of a record stored under key in
+// table and returns the payload, which may share memory with bites.
+func (db *Databases) OpenRecord(table Table, key, bites []byte) ([]byte, error) {
+	if len(bites) < len(recordMagic) || bites[0] != recordMagic[0] || bites[1] != recordMagic[1] || bites[2] != recordMagic[2] {
+		if err := checkLegacyFraming(bites); err != nil {
+			return nil, err
+		} else if db.Keys.refusesPlaintext() {
+			StorageMetrics.Add("PlaintextRecordsRefused", 1)
+			return nil, PlaintextRefused
+		}
+		return bites, nil
+	}
+	if len(bites) < recordHeaderLen {
+		return nil, corrupt("truncated header (%v bytes)", len(bites))
+	}
+	flags := bites[3]
+	if flags&^recordFlagsKnown != 0 {
+		return nil, corrupt("unknown flags %#x", flags)
+	}
+	body := bites[recordHeaderLen:]
+	stored := binary.BigEndian.Uint32(bites[4:])
+	if computed := crc32.Checksum(body, recordCRCTable); stored != computed {
+		return nil, corrupt("checksum mismatch: stored %08x, computed %08x", stored, computed)
+	}
+	payload := body
+	if flags&recordFlagEncrypted != 0 {
+		var aad []byte
+		if flags&recordFlagBound != 0 {
+			aad = recordAAD(table, key)
+		}
+		var err error
+		if payload, err = db.Keys.decrypt(body, aad); err != nil {
+			return nil, err
+		}
+	} else if flags&recordFlagBound != 0 {
+		return nil, corrupt("unencrypted record has flags %#x", flags)
+	} else if db.Keys.refusesPlaintext() {
+		StorageMetrics.Add("PlaintextRecordsRefused", 1)
+		return nil, PlaintextRefused
+	}
+	if flags&recordFlagCompressed != 0 {
+		decompressed, err := Decompress(payload)
+		if err != nil {
+			return nil, corrupt("unable to decompress: %v", err)
+		}
+		payload = decompressed
+	}
+	return payload, nil
+}
+
+// recordAAD is the additional data authenticated with an encrypted
+// record: the name of its table, a zero byte, and its key.
+func recordAAD(table Table, key []byte) []byte {
+	name := table.String()
+	aad := make([]byte, len(name)+1+len(key))
+	copy(aad, name)
+	copy(aad[len(name)+1:], key)
+	return aad
+}
+
+// checkLegacyFraming checks that bites is exactly one capnp message:
+// a little-endian count of segments less one, the little-endian size
+// in words of each segment, padding to a word boundary, and then the
+// segments themselves.
+func checkLegacyFraming(bites []byte) error {
+	if len(bites) < 8 {
+		return corrupt("truncated record (%v bytes)", len(bites))
+	}
+	segments := uint64(binary.LittleEndian.Uint32(bites)) + 1
+	if segments > legacyMaxSegments {
+		return corrupt("implausible segment count %v", segments)
+	}
+	headerLen := 4 + 4*segments
+	headerLen += headerLen % 8
+	if uint64(len(bites)) < headerLen {
+		return corrupt("truncated segment table (%v bytes)", len(bites))
+	}
+	total := headerLen
+	for idx := uint64(0); idx < segments; idx++ {
+		total += 8 * uint64(binary.LittleEndian.Uint32(bites[4+4*idx:]))
+	}
+	if total != uint64(len(bites)) {
+		return corrupt("segment table gives length %v, record has %v bytes", total, len(bites))
+	}
+	return nil
+}
+
+func corrupt(format string, args ...interface{}) error {
+	StorageMetrics.Add("CorruptRecords", 1)
+	return &CorruptRecordError{Reason: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,281 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	testPayload   = []byte{0, 0, 0, 0, 1, 0, 0, 0, 42, 42, 42, 42, 42, 42, 42, 42}
+	testRecordKey = []byte("record key")
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, KeyLen)
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	plain := &Databases{}
+	keys, err := NewKeyring(testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := &Databases{Keys: keys}
+
+	for _, db := range []*Databases{plain, encrypted} {
+		sealed := db.SealRecord(Vars, testRecordKey, testPayload)
+		opened, err := db.OpenRecord(Vars, testRecordKey, sealed)
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(opened, testPayload) {
+			t.Fatalf("Expected %v; got %v", testPayload, opened)
+		}
+
+	}
+
+	// Values from before envelopes existed pass through.
+	opened, err := plain.OpenRecord(Vars, testRecordKey, testPayload)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(opened, testPayload) {
+		t.Fatalf("Expected %v; got %v", testPayload, opened)
+	}
+
+	if sealed := encrypted.SealRecord(Vars, testRecordKey, testPayload); bytes.Contains(sealed, testPayload[8:]) {
+		t.Fatalf("Payload found in the clear in %v", sealed)
+	}
+}
+
+func TestRecordCorruption(t *testing.T) {
+	keys, err := NewKeyring(testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, db := range []*Databases{&Databases{}, &Databases{Keys: keys}} {
+		sealedLen := len(db.SealRecord(Vars, testRecordKey, testPayload))
+		for idx := 3; idx < sealedLen; idx++ {
+			sealed := db.SealRecord(Vars, testRecordKey, testPayload)
+			sealed[idx] ^= 0x10
+			if _, err := db.OpenRecord(Vars, testRecordKey, sealed); !IsCorruptRecord(err) {
+				t.Fatalf("Flipping a bit in byte %v: expected corrupt record error; got %v", idx, err)
+			}
+		}
+		if _, err := db.OpenRecord(Vars, testRecordKey, db.SealRecord(Vars, testRecordKey, testPayload)[:5]); !IsCorruptRecord(err) {
+			t.Fatalf("Expected corrupt record error for truncated header; got %v", err)
+		}
+		// A corrupt magic must not be mistaken for a legacy record.
+		for idx := 0; idx < 3; idx++ {
+			for bit := uint(0); bit < 8; bit++ {
+				sealed := db.SealRecord(Vars, testRecordKey, testPayload)
+				sealed[idx] ^= 1 << bit
+				if _, err := db.OpenRecord(Vars, testRecordKey, sealed); !IsCorruptRecord(err) {
+					t.Fatalf("Flipping bit %v of magic byte %v: expected corrupt record error; got %v", bit, idx, err)
+				}
+			}
+		}
+	}
+}
+
+func TestRecordLegacyFraming(t *testing.T) {
+	db := &Databases{}
+	twoSegments := []byte{1, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 7, 7, 7, 7, 7, 7, 7, 7, 9, 9, 9, 9, 9, 9, 9, 9}
+	for _, legacy := range [][]byte{testPayload, twoSegments} {
+		if opened, err := db.OpenRecord(Vars, testRecordKey, legacy); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(opened, legacy) {
+			t.Fatalf("Expected %v; got %v", legacy, opened)
+		}
+		for _, bad := range [][]byte{legacy[:len(legacy)-1], legacy[:4], append(append([]byte{}, legacy...), 0)} {
+			if _, err := db.OpenRecord(Vars, testRecordKey, bad); !IsCorruptRecord(err) {
+				t.Fatalf("Expected corrupt record error for %v; got %v", bad, err)
+			}
+		}
+	}
+	huge := append([]byte{0xff, 0xff, 0, 0}, testPayload[4:]...)
+	if _, err := db.OpenRecord(Vars, testRecordKey, huge); !IsCorruptRecord(err) {
+		t.Fatalf("Expected corrupt record error for implausible segment count; got %v", err)
+	}
+}
+
+func TestRecordBinding(t *testing.T) {
+	keys, err := NewKeyring(testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &Databases{Keys: keys}
+	sealed := db.SealRecord(Vars, testRecordKey, testPayload)
+	if _, err := db.OpenRecord(Vars, []byte("other key"), sealed); !IsCorruptRecord(err) {
+		t.Fatalf("Expected corrupt record error for record moved to another key; got %v", err)
+	}
+	if _, err := db.OpenRecord(Proposers, testRecordKey, sealed); !IsCorruptRecord(err) {
+		t.Fatalf("Expected corrupt record error for record moved to another table; got %v", err)
+	}
+
+	// Encrypted records written before records were bound to their
+	// keys can be read under any key.
+	body := keys.encrypt(testPayload, nil)
+	unbound := make([]byte, recordHeaderLen+len(body))
+	copy(unbound, recordMagic)
+	unbound[3] = recordFlagEncrypted
+	binary.BigEndian.PutUint32(unbound[4:], crc32.Checksum(body, recordCRCTable))
+	copy(unbound[recordHeaderLen:], body)
+	if opened, err := db.OpenRecord(Proposers, []byte("other key"), unbound); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(opened, testPayload) {
+		t.Fatalf("Expected %v; got %v", testPayload, opened)
+	}
+}
+
+func TestRecordRekey(t *testing.T) {
+	oldKeys, err := NewKeyring(testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKeys, err := NewKeyring(testKey(2), testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := (&Databases{Keys: oldKeys}).SealRecord(Vars, testRecordKey, testPayload)
+
+	if _, err := (&Databases{}).OpenRecord(Vars, testRecordKey, sealed); err == nil || IsCorruptRecord(err) {
+		t.Fatalf("Expected missing key error; got %v", err)
+	}
+
+	rotating := &Databases{Keys: newKeys}
+	opened, err := rotating.OpenRecord(Vars, testRecordKey, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resealed := rotating.SealRecord(Vars, testRecordKey, opened)
+	if _, err := (&Databases{Keys: oldKeys}).OpenRecord(Vars, testRecordKey, resealed); err == nil {
+		t.Fatal("Expected old key to be unable to open rekeyed record")
+	}
+	onlyNew, err := NewKeyring(testKey(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened, err = (&Databases{Keys: onlyNew}).OpenRecord(Vars, testRecordKey, resealed); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(opened, testPayload) {
+		t.Fatalf("Expected %v; got %v", testPayload, opened)
+	}
+}
+
+func TestRecordCompression(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"name": "goshawk", "tags": ["a", "b"]}`), 64)
+	keys, err := NewKeyring(testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, db := range []*Databases{&Databases{Compress: true}, &Databases{Compress: true, Keys: keys}} {
+		sealed := db.SealCompressibleRecord(Vars, testRecordKey, payload)
+		if len(sealed) >= len(payload) {
+			t.Fatalf("Expected compression: %v >= %v", len(sealed), len(payload))
+		}
+		// Readable whether or not compression is enabled for writing.
+		for _, reader := range []*Databases{db, &Databases{Keys: db.Keys}} {
+			opened, err := reader.OpenRecord(Vars, testRecordKey, sealed)
+			if err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(opened, payload) {
+				t.Fatal("Payload differs after decompression")
+			}
+		}
+	}
+
+	// Incompressible payloads are stored as they are.
+	db := &Databases{Compress: true}
+	if sealed := db.SealCompressibleRecord(Vars, testRecordKey, testPayload); !bytes.Equal(sealed, db.SealRecord(Vars, testRecordKey, testPayload)) {
+		t.Fatalf("Expected incompressible payload to be stored uncompressed")
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	payload := bytes.Repeat([]byte{0}, 4096)
+	compressed := Compress(payload)
+	if inflated, err := decompress(compressed, int64(len(payload))); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(inflated, payload) {
+		t.Fatal("Payload differs after decompression")
+	}
+	if _, err := decompress(compressed, int64(len(payload)-1)); err == nil {
+		t.Fatal("Expected error decompressing beyond the limit")
+	}
+}
+
+func TestRecordPlaintextRefused(t *testing.T) {
+	plain := &Databases{}
+	keys, err := NewKeyring(testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := &Databases{Keys: keys}
+
+	for _, bites := range [][]byte{plain.SealRecord(Vars, testRecordKey, testPayload), testPayload} {
+		if _, err := encrypted.OpenRecord(Vars, testRecordKey, bites); err != PlaintextRefused {
+			t.Fatalf("Expected %v; got %v", PlaintextRefused, err)
+		}
+	}
+
+	// Whilst migrating, unencrypted records are read.
+	keys.AcceptPlaintext()
+	for _, bites := range [][]byte{plain.SealRecord(Vars, testRecordKey, testPayload), testPayload} {
+		if opened, err := encrypted.OpenRecord(Vars, testRecordKey, bites); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(opened, testPayload) {
+			t.Fatalf("Expected %v; got %v", testPayload, opened)
+		}
+	}
+}
+
+func TestLoadKeyringOldKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := func(name string, key []byte) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	oldPath, newPath := keyFile("old", testKey(1)), keyFile("new", testKey(2))
+
+	oldKeys, err := LoadKeyring(oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := (&Databases{Keys: oldKeys}).SealRecord(Vars, testRecordKey, testPayload)
+
+	newKeys, err := LoadKeyring(newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = (&Databases{Keys: newKeys}).OpenRecord(Vars, testRecordKey, sealed); err == nil {
+		t.Fatal("Expected record under the old key to be unreadable without it")
+	}
+
+	rotated, err := LoadKeyring(newPath, oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &Databases{Keys: rotated}
+	if opened, err := db.OpenRecord(Vars, testRecordKey, sealed); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(opened, testPayload) {
+		t.Fatalf("Expected %v; got %v", testPayload, opened)
+	}
+	// New records are written with the new key only.
+	resealed := db.SealRecord(Vars, testRecordKey, testPayload)
+	if _, err = (&Databases{Keys: newKeys}).OpenRecord(Vars, testRecordKey, resealed); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"bytes"
+	"goshawkdb.io/common"
+)
+
+// The ReverseRefs table indexes references backwards: for every var
+// which references target there is an entry with the key target ++
+// referrer and an empty value. So the referrers of target are found
+// by seeking to target and walking forwards.
+//
+// The index is only of use if it has been maintained over every
+// write, so a marker entry records that it is complete. The marker's
+// key is shorter than any entry's, so it can never be mistaken for
+// one.
+var reverseRefsCompleteKey = []byte{0}
+
+func reverseRefKey(target, referrer *common.VarUUId) []byte {
+	key := make([]byte, 2*common.KeyLen)
+	copy(key, target[:])
+	copy(key[common.KeyLen:], referrer[:])
+	return key
+}
+
+// UpdateReverseRefs changes the references of referrer from removed
+// to added. Does nothing unless db.ReverseRefs is set.
+func (db *Databases) UpdateReverseRefs(rwtxn RWTxn, referrer *common.VarUUId, removed, added []*common.VarUUId) error {
+	if !db.ReverseRefs {
+		return nil
+	}
+	adding := make(map[common.VarUUId]bool, len(added))
+	for _, target := range added {
+		adding[*target] = true
+	}
+	for _, target := range removed {
+		if adding[*target] {
+			delete(adding, *target)
+		} else if err := rwtxn.Del(ReverseRefs, reverseRefKey(target, referrer)); err != nil && err != NotFound {
+			return err
+		}
+	}
+	for target := range adding {
+		if err := rwtxn.Put(ReverseRefs, reverseRefKey(&target, referrer), []byte{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadReferrers returns the vars which reference target, according
+// to the index.
+func ReadReferrers(rtxn RTxn, target *common.VarUUId) ([]*common.VarUUId, error) {
+	var referrers []*common.VarUUId
+	var err error
+	rtxn.WithCursor(ReverseRefs, func(cursor Cursor) interface{} {
+		key, _, err1 := cursor.Seek(target[:])
+		for ; err1 == nil && len(key) == 2*common.KeyLen && bytes.HasPrefix(key, target[:]); key, _, err1 = cursor.Next() {
+			referrers = append(referrers, common.MakeVarUUId(key[common.KeyLen:]))
+		}
+		if err1 != nil && err1 != NotFound {
+			err = err1
+		}
+		return nil
+	})
+	return referrers, err
+}
+
+// ReverseRefsComplete returns true iff the index has been maintained
+// over every write.
+func ReverseRefsComplete(rtxn RTxn) (bool, error) {
+	_, err := rtxn.Get(ReverseRefs, reverseRefsCompleteKey)
+	switch err {
+	case nil:
+		return true, nil
+	case NotFound:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// SetReverseRefsComplete sets or clears the marker which records that
+// the index is complete.
+func SetReverseRefsComplete(rwtxn RWTxn, complete bool) error {
+	if complete {
+		return rwtxn.Put(ReverseRefs, reverseRefsCompleteKey, []byte{})
+	} else if err := rwtxn.Del(ReverseRefs, reverseRefsCompleteKey); err != nil && err != NotFound {
+		return err
+	}
+	return nil
+}
+
+// ClearReverseRefs deletes the entire index, including the marker.
+func ClearReverseRefs(rwtxn RWTxn) error {
+	var keys [][]byte
+	var err error
+	rwtxn.WithCursor(ReverseRefs, func(cursor Cursor) interface{} {
+		key, _, err1 := cursor.First()
+		for ; err1 == nil; key, _, err1 = cursor.Next() {
+			keys = append(keys, key)
+		}
+		if err1 != NotFound {
+			err = err1
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := rwtxn.Del(ReverseRefs, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"goshawkdb.io/common"
+	"testing"
+)
+
+func TestReverseRefs(t *testing.T) {
+	disk := DB.WithStorage(NewMemoryStorage())
+	disk.ReverseRefs = true
+	defer disk.Shutdown()
+
+	vUUIds := make([]*common.VarUUId, 4)
+	for idx := range vUUIds {
+		vUUIds[idx] = &common.VarUUId{}
+		vUUIds[idx][0] = byte(idx + 1)
+	}
+	a, b, c, d := vUUIds[0], vUUIds[1], vUUIds[2], vUUIds[3]
+
+	write := func(f func(rwtxn RWTxn) error) {
+		if _, err := disk.ReadWriteTransaction(func(rwtxn RWTxn) interface{} {
+			if err := f(rwtxn); err != nil {
+				rwtxn.Error(err)
+			}
+			return nil
+		}).ResultError(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	referrers := func(target *common.VarUUId) map[common.VarUUId]bool {
+		result, err := disk.ReadonlyTransaction(func(rtxn RTxn) interface{} {
+			referrers, err := ReadReferrers(rtxn, target)
+			if err != nil {
+				rtxn.Error(err)
+			}
+			return referrers
+		}).ResultError()
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := make(map[common.VarUUId]bool)
+		for _, referrer := range result.([]*common.VarUUId) {
+			found[*referrer] = true
+		}
+		return found
+	}
+	complete := func() bool {
+		result, err := disk.ReadonlyTransaction(func(rtxn RTxn) interface{} {
+			complete, err := ReverseRefsComplete(rtxn)
+			if err != nil {
+				rtxn.Error(err)
+			}
+			return complete
+		}).ResultError()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.(bool)
+	}
+
+	write(func(rwtxn RWTxn) error {
+		if err := SetReverseRefsComplete(rwtxn, true); err != nil {
+			return err
+		}
+		if err := disk.UpdateReverseRefs(rwtxn, a, nil, []*common.VarUUId{b, c}); err != nil {
+			return err
+		}
+		return disk.UpdateReverseRefs(rwtxn, d, nil, []*common.VarUUId{c})
+	})
+	if found := referrers(c); len(found) != 2 || !found[*a] || !found[*d] {
+		t.Fatalf("Expected a and d to refer to c; got %v", found)
+	}
+	if found := referrers(a); len(found) != 0 {
+		t.Fatalf("Expected nothing to refer to a; got %v", found)
+	}
+
+	// a now refers to c and d, but no longer to b.
+	write(func(rwtxn RWTxn) error {
+		return disk.UpdateReverseRefs(rwtxn, a, []*common.VarUUId{b, c}, []*common.VarUUId{c, d})
+	})
+	if found := referrers(b); len(found) != 0 {
+		t.Fatalf("Expected nothing to refer to b; got %v", found)
+	}
+	if found := referrers(c); len(found) != 2 || !found[*a] || !found[*d] {
+		t.Fatalf("Expected a and d to refer to c; got %v", found)
+	}
+	if found := referrers(d); len(found) != 1 || !found[*a] {
+		t.Fatalf("Expected a to refer to d; got %v", found)
+	}
+	if !complete() {
+		t.Fatal("Expected index to be complete")
+	}
+
+	write(ClearReverseRefs)
+	if found := referrers(c); len(found) != 0 || complete() {
+		t.Fatalf("Expected index to be cleared; got %v", found)
+	}
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A Table identifies one of the key-value tables in which a node keeps
+// its state. Keys within a table are ordered bytewise.
+type Table uint8
+
+const (
+	Vars Table = iota
+	Proposers
+	BallotOutcomes
+	Transactions
+	TransactionRefs
+	ReverseRefs
+	tableCount
+)
+
+func (t Table) String() string {
+	switch t {
+	case Vars:
+		return "Vars"
+	case Proposers:
+		return "Proposers"
+	case BallotOutcomes:
+		return "BallotOutcomes"
+	case Transactions:
+		return "Transactions"
+	case TransactionRefs:
+		return "TransactionRefs"
+	case ReverseRefs:
+		return "ReverseRefs"
+	default:
+		return fmt.Sprintf("Table(%d)", uint8(t))
+	}
+}
+
+// Tables lists every table, in the order of their ids.
+var Tables = []Table{Vars, Proposers, BallotOutcomes, Transactions, TransactionRefs, ReverseRefs}
+
+// NotFound is returned by Get, and by cursors which have run out of
+// entries.
+var NotFound = errors.New("Not found")
+
+// Storage is the interface to everything a node keeps on disk (or
+// wherever the implementation chooses). Txns are run by the storage
+// in the order they are submitted: read-write txns are serialised,
+// and each one is atomic. Calling Error from within a txn aborts it:
+// the error is then returned by the Future, and a read-write txn has
+// no effect.
+type Storage interface {
+	ReadonlyTransaction(txnFun func(RTxn) interface{}) Future
+	ReadWriteTransaction(txnFun func(RWTxn) interface{}) Future
+	// SetNoSync controls whether commits wait for the data to be
+	// flushed to the disk.
+	SetNoSync(noSync bool) Future
+	Shutdown()
+}
+
+// A Future is the eventual result of a txn: whatever the txn func
+// returned, or the error it was aborted with. If the storage has been
+// shut down, the result is nil.
+type Future interface {
+	ResultError() (interface{}, error)
+}
+
+// RTxn is a read-only txn. Values returned are copies and so may be
+// retained after the txn has finished.
+type RTxn interface {
+	Get(table Table, key []byte) ([]byte, error)
+	WithCursor(table Table, cursorFun func(Cursor) interface{}) interface{}
+	Error(err error)
+}
+
+type RWTxn interface {
+	RTxn
+	Put(table Table, key, value []byte) error
+	Del(table Table, key []byte) error
+}
+
+// A Cursor iterates over a table in key order. Each method returns
+// NotFound once there are no more entries.
+type Cursor interface {
+	First() (key, value []byte, err error)
+	// Seek moves to the first entry with a key >= key.
+	Seek(key []byte) (k, value []byte, err error)
+	Next() (key, value []byte, err error)
+}
@@ -4,67 +4,65 @@ import (
 	"encoding/binary"
 	"goshawkdb.io/common"
 	// "fmt"
-	mdb "github.com/msackman/gomdb"
-	mdbs "github.com/msackman/gomdb/server"
 )
 
-func init() {
-	DB.Transactions = &mdbs.DBISettings{Flags: mdb.CREATE}
-	DB.TransactionRefs = &mdbs.DBISettings{Flags: mdb.CREATE}
-}
-
-func (db *Databases) WriteTxnToDisk(rwtxn *mdbs.RWTxn, txnId *common.TxnId, txnBites []byte) error {
-	bites, err := rwtxn.Get(db.TransactionRefs, txnId[:])
+func (db *Databases) WriteTxnToDisk(rwtxn RWTxn, txnId *common.TxnId, txnBites []byte) error {
+	bites, err := rwtxn.Get(TransactionRefs, txnId[:])
 
 	switch err {
 	case nil:
 		count := binary.BigEndian.Uint32(bites) + 1
 		// fmt.Printf("%v +Refcount now %v\n", txnId, count)
 		binary.BigEndian.PutUint32(bites, count)
-		return rwtxn.Put(db.TransactionRefs, txnId[:], bites, 0)
+		return rwtxn.Put(TransactionRefs, txnId[:], bites)
 
-	case mdb.NotFound:
-		if err = rwtxn.Put(db.Transactions, txnId[:], txnBites, 0); err != nil {
+	case NotFound:
+		if err = rwtxn.Put(Transactions, txnId[:], db.SealCompressibleRecord(Transactions, txnId[:], txnBites)); err != nil {
 			return err
 		}
 
 		bites = []byte{0, 0, 0, 0}
 		binary.BigEndian.PutUint32(bites, 1)
 		// fmt.Printf("%v +Refcount now 1\n", txnId)
-		return rwtxn.Put(db.TransactionRefs, txnId[:], bites, 0)
+		return rwtxn.Put(TransactionRefs, txnId[:], bites)
 
 	default:
 		return err
 	}
 }
 
-func (db *Databases) ReadTxnBytesFromDisk(rtxn *mdbs.RTxn, txnId *common.TxnId) []byte {
-	bites, err := rtxn.Get(db.Transactions, txnId[:])
+// ReadTxnBytesFromDisk returns nil, nil if the txn is not found. If
+// the txn is found but is corrupt, the error is a
+// *CorruptRecordError.
+func (db *Databases) ReadTxnBytesFromDisk(rtxn RTxn, txnId *common.TxnId) ([]byte, error) {
+	bites, err := rtxn.Get(Transactions, txnId[:])
 	if err == nil {
-		return bites
+		return db.OpenRecord(Transactions, txnId[:], bites)
+	} else if err == NotFound {
+		return nil, nil
 	} else {
-		return nil
+		return nil, err
 	}
 }
 
-func (db *Databases) DeleteTxnFromDisk(rwtxn *mdbs.RWTxn, txnId *common.TxnId) error {
-	bites, err := rwtxn.Get(db.TransactionRefs, txnId[:])
+func (db *Databases) DeleteTxnFromDisk(rwtxn RWTxn, txnId *common.TxnId) error {
+	bites, err := rwtxn.Get(TransactionRefs, txnId[:])
 
 	switch err {
 	case nil:
 		if count := binary.BigEndian.Uint32(bites) - 1; count == 0 {
 			// fmt.Printf("%v -Refcount now 0\n", txnId)
-			if err = rwtxn.Del(db.TransactionRefs, txnId[:], nil); err != nil {
+			if err = rwtxn.Del(TransactionRefs, txnId[:]); err != nil {
 				return err
 			}
-			return rwtxn.Del(db.Transactions, txnId[:], nil)
+			return rwtxn.Del(Transactions, txnId[:])
 
 		} else {
 			// fmt.Printf("%v -Refcount now %v\n", txnId, count)
 			binary.BigEndian.PutUint32(bites, count)
-			return rwtxn.Put(db.TransactionRefs, txnId[:], bites, 0)
+			return rwtxn.Put(TransactionRefs, txnId[:], bites)
 		}
-	case mdb.NotFound:
+	case NotFound:
 		return nil
 	default:
 		return err
@@ -0,0 +1,157 @@
+// Package export defines the logical export format. An export
+// stream is newline-delimited JSON. The first line is a Header. Every
+// following line is an Object. Objects are written in
+// depth-first post-order from the roots: where the object graph is
+// acyclic, every object appears after all the objects it references,
+// which lets an importer create each object in one go. Objects that
+// are part of a cycle will reference objects that appear later in the
+// stream.
+//
+// VarUUIds and TxnIds are hex encoded. Values are base64 encoded
+// (this is the standard encoding/json treatment of []byte).
+package export
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	cmsgs "goshawkdb.io/common/capnp"
+	"io"
+)
+
+const (
+	FormatName    = "goshawkdb-export"
+	FormatVersion = 1
+)
+
+const (
+	CapabilityNone      = "None"
+	CapabilityRead      = "Read"
+	CapabilityWrite     = "Write"
+	CapabilityReadWrite = "ReadWrite"
+)
+
+type Header struct {
+	Format        string
+	FormatVersion uint32
+	ClusterId     string
+	Roots         []Root
+}
+
+type Root struct {
+	Name    string
+	VarUUId string
+}
+
+type Object struct {
+	VarUUId    string
+	Version    string
+	Value      []byte
+	References []Reference
+}
+
+type Reference struct {
+	VarUUId    string
+	Capability string
+}
+
+func CapabilityName(capability cmsgs.Capability) string {
+	switch capability.Which() {
+	case cmsgs.CAPABILITY_READ:
+		return CapabilityRead
+	case cmsgs.CAPABILITY_WRITE:
+		return CapabilityWrite
+	case cmsgs.CAPABILITY_READWRITE:
+		return CapabilityReadWrite
+	default:
+		return CapabilityNone
+	}
+}
+
+func SetCapability(capability cmsgs.Capability, name string) error {
+	switch name {
+	case CapabilityNone:
+		capability.SetNone()
+	case CapabilityRead:
+		capability.SetRead()
+	case CapabilityWrite:
+		capability.SetWrite()
+	case CapabilityReadWrite:
+		capability.SetReadWrite()
+	default:
+		return fmt.Errorf("Unknown capability: %v", name)
+	}
+	return nil
+}
+
+func DecodeId(str string, length int) ([]byte, error) {
+	bites, err := hex.DecodeString(str)
+	if err != nil {
+		return nil, err
+	} else if len(bites) != length {
+		return nil, fmt.Errorf("Invalid id %v: expected %v bytes, found %v", str, length, len(bites))
+	}
+	return bites, nil
+}
+
+type Writer struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func NewWriter(w io.Writer, header *Header) (*Writer, error) {
+	bw := bufio.NewWriter(w)
+	ew := &Writer{
+		w:   bw,
+		enc: json.NewEncoder(bw),
+	}
+	header.Format = FormatName
+	header.FormatVersion = FormatVersion
+	if err := ew.enc.Encode(header); err != nil {
+		return nil, err
+	}
+	return ew, nil
+}
+
+func (ew *Writer) Write(obj *Object) error {
+	return ew.enc.Encode(obj)
+}
+
+func (ew *Writer) Flush() error {
+	return ew.w.Flush()
+}
+
+type Reader struct {
+	Header *Header
+	dec    *json.Decoder
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	er := &Reader{
+		Header: &Header{},
+		dec:    json.NewDecoder(bufio.NewReader(r)),
+	}
+	if err := er.dec.Decode(er.Header); err != nil {
+		return nil, err
+	}
+	if er.Header.Format != FormatName {
+		return nil, fmt.Errorf("Not an export stream: format is %q", er.Header.Format)
+	} else if er.Header.FormatVersion != FormatVersion {
+		return nil, fmt.Errorf("Unsupported export format version: %v", er.Header.FormatVersion)
+	}
+	return er, nil
+}
+
+// Read returns io.EOF once the stream is exhausted.
+func (er *Reader) Read() (*Object, error) {
+	obj := &Object{}
+	if err := er.dec.Decode(obj); err != nil {
+		return nil, err
+	}
+	if len(obj.VarUUId) == 0 {
+		return nil, errors.New("Export object has no VarUUId")
+	}
+	return obj, nil
+}
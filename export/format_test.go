@@ -0,0 +1,113 @@
+package export
+
+import (
+	"bytes"
+	capn "github.com/glycerine/go-capnproto"
+	cmsgs "goshawkdb.io/common/capnp"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFormatRoundTrip(t *testing.T) {
+	header := &Header{
+		ClusterId: "test",
+		Roots:     []Root{{Name: "myRoot", VarUUId: "0a0b"}},
+	}
+	objs := []*Object{
+		{VarUUId: "01", Version: "aa", Value: []byte("leaf")},
+		{VarUUId: "02", Version: "bb", Value: []byte{0, 1, 2, 255}, References: []Reference{
+			{VarUUId: "01", Capability: CapabilityRead},
+			{VarUUId: "03", Capability: CapabilityReadWrite},
+		}},
+	}
+
+	buf := new(bytes.Buffer)
+	w, err := NewWriter(buf, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range objs {
+		if err = w.Write(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 1+len(objs) {
+		t.Fatalf("Expected %v lines; got %v", 1+len(objs), lines)
+	}
+
+	r, err := NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Header.Format != FormatName || r.Header.FormatVersion != FormatVersion || r.Header.ClusterId != "test" ||
+		len(r.Header.Roots) != 1 || r.Header.Roots[0] != header.Roots[0] {
+		t.Fatalf("Header did not round trip: %v", r.Header)
+	}
+	for _, expected := range objs {
+		obj, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj.VarUUId != expected.VarUUId || obj.Version != expected.Version || !bytes.Equal(obj.Value, expected.Value) || len(obj.References) != len(expected.References) {
+			t.Fatalf("Expected %v; got %v", expected, obj)
+		}
+		for idx, ref := range expected.References {
+			if obj.References[idx] != ref {
+				t.Fatalf("Expected %v; got %v", ref, obj.References[idx])
+			}
+		}
+	}
+	if _, err = r.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF; got %v", err)
+	}
+}
+
+func TestFormatRefused(t *testing.T) {
+	for _, stream := range []string{
+		`{"Format":"something-else","FormatVersion":1}`,
+		`{"Format":"goshawkdb-export","FormatVersion":2}`,
+	} {
+		if _, err := NewReader(strings.NewReader(stream)); err == nil {
+			t.Fatalf("Expected %v to be refused", stream)
+		}
+	}
+
+	r, err := NewReader(strings.NewReader(`{"Format":"goshawkdb-export","FormatVersion":1}` + "\n" + `{"Version":"aa"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Read(); err == nil {
+		t.Fatal("Expected an object without a VarUUId to be refused")
+	}
+}
+
+func TestCapabilityNames(t *testing.T) {
+	seg := capn.NewBuffer(nil)
+	for _, name := range []string{CapabilityNone, CapabilityRead, CapabilityWrite, CapabilityReadWrite} {
+		capability := cmsgs.NewCapability(seg)
+		if err := SetCapability(capability, name); err != nil {
+			t.Fatal(err)
+		}
+		if found := CapabilityName(capability); found != name {
+			t.Fatalf("Expected %v; got %v", name, found)
+		}
+	}
+	if err := SetCapability(cmsgs.NewCapability(seg), "Execute"); err == nil {
+		t.Fatal("Expected an unknown capability to be refused")
+	}
+}
+
+func TestDecodeId(t *testing.T) {
+	if bites, err := DecodeId("0a0b", 2); err != nil || !bytes.Equal(bites, []byte{10, 11}) {
+		t.Fatalf("Expected [10 11]; got %v, %v", bites, err)
+	}
+	for _, str := range []string{"0a", "0a0b0c", "zz0b"} {
+		if _, err := DecodeId(str, 2); err == nil {
+			t.Fatalf("Expected %v to be refused", str)
+		}
+	}
+}
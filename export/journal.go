@@ -0,0 +1,129 @@
+package export
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	journalStarted   = "started"
+	journalFailed    = "failed"
+	journalCompleted = "completed"
+)
+
+var AlreadyImported = errors.New("Export has already been imported under this root")
+
+// ImportJournal records in a data directory every import that has
+// been started, has failed, or has completed, so that restarting a
+// node with the same import does not import a second copy. An import
+// is identified by the SHA-256 of its export stream and the name of
+// the root it is imported under. A nil ImportJournal records nothing.
+type ImportJournal struct {
+	dir string
+}
+
+func OpenImportJournal(dir string) *ImportJournal {
+	return &ImportJournal{dir: dir}
+}
+
+func (j *ImportJournal) path() string {
+	return filepath.Join(j.dir, "imports")
+}
+
+// Digest returns the hex encoded SHA-256 of the export stream r.
+func Digest(r io.Reader) (string, error) {
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// Begin records that an import is starting. It returns
+// AlreadyImported if the same import has completed. An import which
+// was started but neither completed nor failed was interrupted by the
+// node stopping: it may or may not have been attached to the root, so
+// Begin refuses to start it again until the operator has checked the
+// root and removed the journal entry.
+func (j *ImportJournal) Begin(digest, root string) error {
+	if j == nil {
+		return nil
+	}
+	state, err := j.state(digest, root)
+	switch {
+	case err != nil:
+		return err
+	case state == journalCompleted:
+		return AlreadyImported
+	case state == journalStarted:
+		return fmt.Errorf("Import of %v under root %v was interrupted and may be incomplete. Check root %v, then remove the entries for %v from %v to run it again", digest, root, root, digest, j.path())
+	}
+	return j.append(journalStarted, digest, root)
+}
+
+// Failed records that an import failed. created lists the hex encoded
+// VarUUIds of the objects the import had created: as they were never
+// attached to the root, they are unreachable and garbage collection
+// will remove them. They are written to a report in the journal's
+// directory, whose path is returned. A failed import may be started
+// again.
+func (j *ImportJournal) Failed(digest, root string, created []string) (string, error) {
+	if j == nil {
+		return "", nil
+	}
+	report := filepath.Join(j.dir, fmt.Sprintf("import-%s.orphans", digest[:16]))
+	if err := ioutil.WriteFile(report, []byte(strings.Join(created, "\n")+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return report, j.append(journalFailed, digest, root)
+}
+
+func (j *ImportJournal) Completed(digest, root string) error {
+	if j == nil {
+		return nil
+	}
+	return j.append(journalCompleted, digest, root)
+}
+
+// state returns the latest state of the import, or "" if it is not in
+// the journal.
+func (j *ImportJournal) state(digest, root string) (string, error) {
+	file, err := os.Open(j.path())
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	state := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 3)
+		if len(fields) == 3 && fields[1] == digest && fields[2] == root {
+			state = fields[0]
+		}
+	}
+	return state, scanner.Err()
+}
+
+func (j *ImportJournal) append(state, digest, root string) error {
+	file, err := os.OpenFile(j.path(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(file, "%s %s %s\n", state, digest, root); err == nil {
+		err = file.Sync()
+	}
+	if err1 := file.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
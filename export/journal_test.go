@@ -0,0 +1,60 @@
+package export
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestImportJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	digest, err := Digest(strings.NewReader(`{"Format":"goshawkdb-export"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := OpenImportJournal(dir)
+
+	if err = j.Begin(digest, "myRoot"); err != nil {
+		t.Fatal(err)
+	}
+	// Interrupted: refuses to run again.
+	if err = OpenImportJournal(dir).Begin(digest, "myRoot"); err == nil || err == AlreadyImported {
+		t.Fatalf("Expected interrupted import to be refused, got %v", err)
+	}
+
+	report, err := j.Failed(digest, "myRoot", []string{"aa", "bb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := ioutil.ReadFile(report); err != nil {
+		t.Fatal(err)
+	} else if string(contents) != "aa\nbb\n" {
+		t.Fatalf("Unexpected orphans report: %q", contents)
+	}
+
+	// Failed: may run again.
+	if err = j.Begin(digest, "myRoot"); err != nil {
+		t.Fatal(err)
+	}
+	if err = j.Completed(digest, "myRoot"); err != nil {
+		t.Fatal(err)
+	}
+	if err = j.Begin(digest, "myRoot"); err != AlreadyImported {
+		t.Fatalf("Expected AlreadyImported, got %v", err)
+	}
+	// A different root is a different import.
+	if err = j.Begin(digest, "other root"); err != nil {
+		t.Fatal(err)
+	}
+
+	var nilJournal *ImportJournal
+	if err = nilJournal.Begin(digest, "myRoot"); err != nil {
+		t.Fatal(err)
+	}
+}
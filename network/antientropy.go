@@ -0,0 +1,503 @@
+package network
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/configuration"
+	"goshawkdb.io/server/db"
+	"goshawkdb.io/server/paxos"
+	eng "goshawkdb.io/server/txnengine"
+	"hash"
+	"log"
+)
+
+// Anti-entropy repairs replicas which have fallen behind. For each
+// other replica, the initiator tiles the keyspace of the vars they
+// share into ranges of up to server.AntiEntropyRangeVarCount vars, and
+// sends a hash of each range. The receiver compares with its own
+// hash of the same range. If they differ, either the range is split
+// into subranges of up to server.AntiEntropyLeafVarCount vars and
+// sent back, or, once a range is small enough, the individual entries
+// are exchanged. Whichever side has the newer version of a var (or is
+// the only side to have it) ships the var and its write txn to the
+// other, reusing the MigrationElement encoding.
+//
+// The receiver of a repair feeds it through the same immigration path
+// as a topology change migration. So the var itself decides whether
+// to accept the write: if it is too old or a duplicate of the current
+// frame then it is ignored, and so a repair can never override a
+// frame which is in flight. Additionally, everything is tied to the
+// topology version, and nothing is sent or accepted whilst a
+// topology change is in progress.
+
+type antiEntropyEntry struct {
+	vUUId      []byte
+	writeTxnId []byte
+	clockElem  uint64
+}
+
+// newerThan orders versions in the same way as frameOpen.WriteLearnt.
+func (e *antiEntropyEntry) newerThan(o *antiEntropyEntry) bool {
+	if e.clockElem != o.clockElem {
+		return e.clockElem > o.clockElem
+	}
+	return common.MakeTxnId(e.writeTxnId).Compare(common.MakeTxnId(o.writeTxnId)) == common.GT
+}
+
+// Ranges run from start inclusive to end exclusive. An empty start or
+// end is the start or end of the keyspace.
+type antiEntropyRange struct {
+	start   []byte
+	end     []byte
+	hash    []byte
+	count   uint32
+	entries []*antiEntropyEntry
+}
+
+type antiEntropyRangeBuilder struct {
+	size   uint32
+	ranges []*antiEntropyRange
+	cur    *antiEntropyRange
+	hasher hash.Hash
+}
+
+func newAntiEntropyRangeBuilder(start []byte, size uint32) *antiEntropyRangeBuilder {
+	return &antiEntropyRangeBuilder{
+		size:   size,
+		cur:    &antiEntropyRange{start: start},
+		hasher: sha256.New(),
+	}
+}
+
+// Entries must be added in key order.
+func (b *antiEntropyRangeBuilder) add(e *antiEntropyEntry) {
+	if b.cur.count == b.size {
+		b.close(e.vUUId)
+		b.cur = &antiEntropyRange{start: e.vUUId}
+	}
+	b.cur.count++
+	b.hasher.Write(e.vUUId)
+	b.hasher.Write(e.writeTxnId)
+	elem := []byte{0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(elem, e.clockElem)
+	b.hasher.Write(elem)
+}
+
+func (b *antiEntropyRangeBuilder) close(end []byte) {
+	b.cur.end = end
+	b.cur.hash = b.hasher.Sum(nil)
+	b.ranges = append(b.ranges, b.cur)
+	b.hasher.Reset()
+}
+
+func (b *antiEntropyRangeBuilder) finish(end []byte) []*antiEntropyRange {
+	b.close(end)
+	b.cur = nil
+	return b.ranges
+}
+
+func (s *Scrubber) AntiEntropyRangesReceived(sender common.RMId, ranges *msgs.AntiEntropyRanges) {
+	s.enqueue(func() { s.antiEntropyRangesReceived(sender, ranges) })
+}
+
+func (s *Scrubber) AntiEntropyEntriesReceived(sender common.RMId, entries *msgs.AntiEntropyEntries) {
+	s.enqueue(func() { s.antiEntropyEntriesReceived(sender, entries) })
+}
+
+func (s *Scrubber) AntiEntropyRepairReceived(sender common.RMId, repair *msgs.Migration) {
+	s.enqueue(func() { s.antiEntropyRepairReceived(sender, repair) })
+}
+
+// scanVars calls f for every var in [start, end) for which we are a
+// replica. f is called from within the read txn, so it must copy
+// anything it wishes to keep.
+func (s *Scrubber) scanVars(start, end []byte, f func(vUUIdBytes []byte, varCap *msgs.Var, rmIds []common.RMId)) error {
+	selfRMId := s.connectionManager.RMId
+	_, err := s.db.ReadonlyTransaction(func(rtxn db.RTxn) interface{} {
+		rtxn.WithCursor(db.Vars, func(cursor db.Cursor) interface{} {
+			var vUUIdBytes, varBytes []byte
+			var err error
+			if len(start) == 0 {
+				vUUIdBytes, varBytes, err = cursor.First()
+			} else {
+				vUUIdBytes, varBytes, err = cursor.Seek(start)
+			}
+			for ; err == nil; vUUIdBytes, varBytes, err = cursor.Next() {
+				if len(end) != 0 && bytes.Compare(vUUIdBytes, end) >= 0 {
+					return nil
+				}
+				if bytes.Equal(vUUIdBytes, configuration.TopologyVarUUId[:]) {
+					continue
+				}
+				// A corrupt var is quarantined, and should be repaired
+				// by someone else.
+				varBytes, err := s.db.OpenRecord(db.Vars, vUUIdBytes, varBytes)
+				if err != nil {
+					continue
+				}
+				seg, _, err := capn.ReadFromMemoryZeroCopy(varBytes)
+				if err != nil {
+					rtxn.Error(err)
+					return nil
+				}
+				varCap := msgs.ReadRootVar(seg)
+				rmIds, err := s.resolver.ResolveHashCodes(varCap.Positions().ToArray())
+				if err != nil {
+					rtxn.Error(err)
+					return nil
+				}
+				for _, rmId := range rmIds {
+					if rmId == selfRMId {
+						f(vUUIdBytes, &varCap, rmIds)
+						break
+					}
+				}
+			}
+			if err != db.NotFound {
+				rtxn.Error(err)
+			}
+			return nil
+		})
+		return nil
+	}).ResultError()
+	return err
+}
+
+func newAntiEntropyEntry(vUUIdBytes []byte, varCap *msgs.Var) *antiEntropyEntry {
+	vUUId := common.MakeVarUUId(vUUIdBytes)
+	return &antiEntropyEntry{
+		vUUId:      vUUId[:],
+		writeTxnId: append([]byte(nil), varCap.WriteTxnId()...),
+		clockElem:  eng.VectorClockFromData(varCap.WriteTxnClock(), false).At(vUUId),
+	}
+}
+
+// localRange summarises the vars in [start, end) which both we and
+// peer are replicas of.
+func (s *Scrubber) localRange(peer common.RMId, start, end []byte) (*antiEntropyRange, error) {
+	builder := newAntiEntropyRangeBuilder(start, ^uint32(0))
+	entries := []*antiEntropyEntry{}
+	err := s.scanVars(start, end, func(vUUIdBytes []byte, varCap *msgs.Var, rmIds []common.RMId) {
+		for _, rmId := range rmIds {
+			if rmId == peer {
+				entry := newAntiEntropyEntry(vUUIdBytes, varCap)
+				builder.add(entry)
+				entries = append(entries, entry)
+				return
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	r := builder.finish(end)[0]
+	r.entries = entries
+	return r, nil
+}
+
+func (s *Scrubber) startAntiEntropy() {
+	if s.resolver == nil {
+		return
+	}
+	s.rounds++
+	selfRMId := s.connectionManager.RMId
+	builders := make(map[common.RMId]*antiEntropyRangeBuilder)
+	err := s.scanVars(nil, nil, func(vUUIdBytes []byte, varCap *msgs.Var, rmIds []common.RMId) {
+		var entry *antiEntropyEntry
+		for _, rmId := range rmIds {
+			if rmId == selfRMId {
+				continue
+			}
+			if entry == nil {
+				entry = newAntiEntropyEntry(vUUIdBytes, varCap)
+			}
+			builder, found := builders[rmId]
+			if !found {
+				builder = newAntiEntropyRangeBuilder(nil, server.AntiEntropyRangeVarCount)
+				builders[rmId] = builder
+			}
+			builder.add(entry)
+		}
+	})
+	if err != nil {
+		log.Printf("Scrubber: error when starting anti-entropy: %v\n", err)
+		return
+	}
+	// Peers we share no vars with still need to hear about it in case
+	// they have vars we're missing.
+	for _, rmId := range s.topology.RMs().NonEmpty() {
+		if _, found := builders[rmId]; !found && rmId != selfRMId {
+			builders[rmId] = newAntiEntropyRangeBuilder(nil, server.AntiEntropyRangeVarCount)
+		}
+	}
+	for rmId, builder := range builders {
+		if conn, found := s.conns[rmId]; found {
+			server.Log("Scrubber: starting anti-entropy with", rmId)
+			conn.Send(s.antiEntropyRangesMsg(builder.finish(nil)))
+		}
+	}
+}
+
+func (s *Scrubber) antiEntropyRangesMsg(ranges []*antiEntropyRange) []byte {
+	seg := capn.NewBuffer(nil)
+	msg := msgs.NewRootMessage(seg)
+	rangesCap := msgs.NewAntiEntropyRanges(seg)
+	rangesCap.SetVersion(s.topology.Version)
+	rangeList := msgs.NewAntiEntropyRangeList(seg, len(ranges))
+	for idx, r := range ranges {
+		rangeCap := msgs.NewAntiEntropyRange(seg)
+		rangeCap.SetStart(r.start)
+		rangeCap.SetEnd(r.end)
+		rangeCap.SetHash(r.hash)
+		rangeCap.SetCount(r.count)
+		rangeList.Set(idx, rangeCap)
+	}
+	rangesCap.SetRanges(rangeList)
+	msg.SetAntiEntropyRanges(rangesCap)
+	return server.SegToBytes(seg)
+}
+
+func (s *Scrubber) antiEntropyEntriesMsg(r *antiEntropyRange, wantReply bool) []byte {
+	seg := capn.NewBuffer(nil)
+	msg := msgs.NewRootMessage(seg)
+	entriesCap := msgs.NewAntiEntropyEntries(seg)
+	entriesCap.SetVersion(s.topology.Version)
+	entriesCap.SetStart(r.start)
+	entriesCap.SetEnd(r.end)
+	entryList := msgs.NewAntiEntropyEntryList(seg, len(r.entries))
+	for idx, e := range r.entries {
+		entryCap := msgs.NewAntiEntropyEntry(seg)
+		entryCap.SetId(e.vUUId)
+		entryCap.SetWriteTxnId(e.writeTxnId)
+		entryCap.SetClockElem(e.clockElem)
+		entryList.Set(idx, entryCap)
+	}
+	entriesCap.SetEntries(entryList)
+	entriesCap.SetWantReply(wantReply)
+	msg.SetAntiEntropyEntries(entriesCap)
+	return server.SegToBytes(seg)
+}
+
+func (s *Scrubber) antiEntropyRangesReceived(sender common.RMId, ranges *msgs.AntiEntropyRanges) {
+	// As with digests, if the versions differ then the sender may have
+	// calculated the replicas differently to how we would.
+	if s.resolver == nil || ranges.Version() != s.topology.Version {
+		return
+	}
+	conn, found := s.conns[sender]
+	if !found {
+		return
+	}
+	rangeList := ranges.Ranges()
+	subranges := []*antiEntropyRange{}
+	for idx, l := 0, rangeList.Len(); idx < l; idx++ {
+		rangeCap := rangeList.At(idx)
+		local, err := s.localRange(sender, rangeCap.Start(), rangeCap.End())
+		if err != nil {
+			log.Printf("Scrubber: error when comparing anti-entropy ranges from %v: %v\n", sender, err)
+			return
+		}
+		scrubberMetrics.Add("AntiEntropyRangesCompared", 1)
+		if local.count == rangeCap.Count() && bytes.Equal(local.hash, rangeCap.Hash()) {
+			continue
+		}
+		scrubberMetrics.Add("AntiEntropyRangesMismatched", 1)
+		if local.count <= server.AntiEntropyLeafVarCount || rangeCap.Count() <= server.AntiEntropyLeafVarCount {
+			conn.Send(s.antiEntropyEntriesMsg(local, true))
+		} else {
+			builder := newAntiEntropyRangeBuilder(local.start, server.AntiEntropyLeafVarCount)
+			for _, entry := range local.entries {
+				builder.add(entry)
+			}
+			subranges = append(subranges, builder.finish(local.end)...)
+		}
+	}
+	if len(subranges) != 0 {
+		conn.Send(s.antiEntropyRangesMsg(subranges))
+	}
+}
+
+func (s *Scrubber) antiEntropyEntriesReceived(sender common.RMId, entries *msgs.AntiEntropyEntries) {
+	if s.resolver == nil || entries.Version() != s.topology.Version {
+		return
+	}
+	conn, found := s.conns[sender]
+	if !found {
+		return
+	}
+	local, err := s.localRange(sender, entries.Start(), entries.End())
+	if err != nil {
+		log.Printf("Scrubber: error when comparing anti-entropy entries from %v: %v\n", sender, err)
+		return
+	}
+
+	remote := make(map[common.VarUUId]*antiEntropyEntry)
+	entryList := entries.Entries()
+	for idx, l := 0, entryList.Len(); idx < l; idx++ {
+		entryCap := entryList.At(idx)
+		remote[*common.MakeVarUUId(entryCap.Id())] = &antiEntropyEntry{
+			vUUId:      entryCap.Id(),
+			writeTxnId: entryCap.WriteTxnId(),
+			clockElem:  entryCap.ClockElem(),
+		}
+	}
+
+	ship := []*common.VarUUId{}
+	senderAhead := false
+	for _, entry := range local.entries {
+		vUUId := common.MakeVarUUId(entry.vUUId)
+		remoteEntry, found := remote[*vUUId]
+		delete(remote, *vUUId)
+		switch {
+		case !found || entry.newerThan(remoteEntry):
+			ship = append(ship, vUUId)
+		case remoteEntry.newerThan(entry):
+			senderAhead = true
+		}
+	}
+	// Anything left is something we don't have at all.
+	senderAhead = senderAhead || len(remote) != 0
+
+	if len(ship) != 0 {
+		s.shipVars(conn, ship)
+	}
+	if senderAhead && entries.WantReply() {
+		conn.Send(s.antiEntropyEntriesMsg(local, false))
+	}
+}
+
+// shipVars re-reads the vars and their write txns from disk, so the
+// vars we send are always exactly what we have on disk.
+func (s *Scrubber) shipVars(conn paxos.Connection, vUUIds []*common.VarUUId) {
+	elems := make(map[common.TxnId]*migrationElem)
+	order := []*common.TxnId{}
+	_, err := s.db.ReadonlyTransaction(func(rtxn db.RTxn) interface{} {
+		for _, vUUId := range vUUIds {
+			varBytes, err := rtxn.Get(db.Vars, vUUId[:])
+			if err == db.NotFound {
+				continue
+			} else if err != nil {
+				rtxn.Error(err)
+				return nil
+			}
+			if varBytes, err = s.db.OpenRecord(db.Vars, vUUId[:], varBytes); err != nil {
+				continue
+			}
+			// varBytes is only valid within the txn.
+			seg, _, err := capn.ReadFromMemoryZeroCopy(append([]byte(nil), varBytes...))
+			if err != nil {
+				rtxn.Error(err)
+				return nil
+			}
+			varCap := msgs.ReadRootVar(seg)
+			txnId := common.MakeTxnId(varCap.WriteTxnId())
+			elem, found := elems[*txnId]
+			if !found {
+				txnBytes, err := s.db.ReadTxnBytesFromDisk(rtxn, txnId)
+				if db.IsCorruptRecord(err) || (err == nil && txnBytes == nil) {
+					log.Printf("Scrubber: unable to ship %v: write txn %v is missing or corrupt: %v\n", vUUId, txnId, err)
+					continue
+				} else if err != nil {
+					rtxn.Error(err)
+					return nil
+				}
+				elem = &migrationElem{txn: eng.TxnReaderFromData(append([]byte(nil), txnBytes...))}
+				elems[*txnId] = elem
+				order = append(order, txnId)
+			}
+			elem.vars = append(elem.vars, &varCap)
+		}
+		return nil
+	}).ResultError()
+	if err != nil {
+		log.Printf("Scrubber: error when reading vars to ship to %v: %v\n", conn.RMId(), err)
+		return
+	}
+	if len(order) == 0 {
+		return
+	}
+
+	seg := capn.NewBuffer(nil)
+	msg := msgs.NewRootMessage(seg)
+	migration := msgs.NewMigration(seg)
+	migration.SetVersion(s.topology.Version)
+	elemList := msgs.NewMigrationElementList(seg, len(order))
+	varCount := 0
+	for idx, txnId := range order {
+		elem := elems[*txnId]
+		elemCap := msgs.NewMigrationElement(seg)
+		setMigrationElementTxn(&elemCap, elem.txn.Data, s.db.Compress)
+		vars := msgs.NewVarList(seg, len(elem.vars))
+		for idy, varCap := range elem.vars {
+			vars.Set(idy, *varCap)
+		}
+		elemCap.SetVars(vars)
+		elemList.Set(idx, elemCap)
+		varCount += len(elem.vars)
+	}
+	migration.SetElems(elemList)
+	msg.SetAntiEntropyRepair(migration)
+	server.Log("Scrubber: shipping", varCount, "vars to", conn.RMId())
+	conn.Send(server.SegToBytes(seg))
+	scrubberMetrics.Add("AntiEntropyVarsShipped", int64(varCount))
+}
+
+func (s *Scrubber) antiEntropyRepairReceived(sender common.RMId, repair *msgs.Migration) {
+	if s.resolver == nil || repair.Version() != s.topology.Version {
+		return
+	}
+	// Never create a var here which we are not a replica of, nor
+	// restore one which garbage collection has just deleted here.
+	selfRMId := s.connectionManager.RMId
+	barrier := s.connectionManager.Dispatchers.VarDispatcher.Barrier
+	elemList := repair.Elems()
+	for idx, l := 0, elemList.Len(); idx < l; idx++ {
+		varList := elemList.At(idx).Vars()
+		for idy, m := 0, varList.Len(); idy < m; idy++ {
+			varCap := varList.At(idy)
+			if vUUId := common.MakeVarUUId(varCap.Id()); barrier.IsDeleted(vUUId) {
+				log.Printf("Scrubber: ignoring repair from %v: %v has been deleted as garbage\n", sender, vUUId)
+				return
+			}
+			rmIds, err := s.resolver.ResolveHashCodes(varCap.Positions().ToArray())
+			if err != nil {
+				log.Printf("Scrubber: error when checking repair from %v: %v\n", sender, err)
+				return
+			}
+			isReplica := false
+			for _, rmId := range rmIds {
+				if isReplica = rmId == selfRMId; isReplica {
+					break
+				}
+			}
+			if !isReplica {
+				log.Printf("Scrubber: ignoring repair from %v: we are not a replica of %v\n", sender, common.MakeVarUUId(varCap.Id()))
+				return
+			}
+		}
+	}
+	server.Log("Scrubber: received repair of", elemList.Len(), "txns from", sender)
+	if err := s.immigrate(repair); err != nil {
+		// Nothing has been applied: the next round will try again.
+		log.Printf("Scrubber: rejecting repair from %v: %v\n", sender, err)
+	}
+}
+
+type antiEntropyTxnLocalStateChange struct{}
+
+func (aetlsc antiEntropyTxnLocalStateChange) TxnBallotsComplete(...*eng.Ballot) {
+	panic("TxnBallotsComplete called on anti-entropy txn.")
+}
+
+// Careful: we're in the proposer dispatcher go routine here!
+func (aetlsc antiEntropyTxnLocalStateChange) TxnLocallyComplete(txn *eng.Txn) {
+	txn.CompletionReceived()
+	scrubberMetrics.Add("AntiEntropyTxnsApplied", 1)
+}
+
+func (aetlsc antiEntropyTxnLocalStateChange) TxnFinished(*eng.Txn) {}
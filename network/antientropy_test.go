@@ -0,0 +1,332 @@
+package network
+
+import (
+	"bytes"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/configuration"
+	ch "goshawkdb.io/server/consistenthash"
+	"goshawkdb.io/server/db"
+	"goshawkdb.io/server/paxos"
+	eng "goshawkdb.io/server/txnengine"
+	"testing"
+)
+
+func antiEntropyTestVarUUId(n int) *common.VarUUId {
+	vUUId := common.MakeVarUUId(make([]byte, common.KeyLen))
+	vUUId[0], vUUId[1] = byte(n>>8), byte(n)
+	return vUUId
+}
+
+func antiEntropyTestEntry(n int, txnId byte, clockElem uint64) *antiEntropyEntry {
+	return &antiEntropyEntry{
+		vUUId:      antiEntropyTestVarUUId(n)[:],
+		writeTxnId: (&common.TxnId{txnId})[:],
+		clockElem:  clockElem,
+	}
+}
+
+func TestAntiEntropyRanges(t *testing.T) {
+	build := func(entries []*antiEntropyEntry) []*antiEntropyRange {
+		builder := newAntiEntropyRangeBuilder(nil, 2)
+		for _, e := range entries {
+			builder.add(e)
+		}
+		return builder.finish(nil)
+	}
+	entries := make([]*antiEntropyEntry, 5)
+	for idx := range entries {
+		entries[idx] = antiEntropyTestEntry(idx, 1, 1)
+	}
+	ranges := build(entries)
+
+	// The ranges tile the keyspace.
+	if len(ranges) != 3 {
+		t.Fatalf("Expected 3 ranges; got %v", len(ranges))
+	}
+	if ranges[0].start != nil || ranges[2].end != nil {
+		t.Fatal("Expected the ranges to cover the whole keyspace")
+	}
+	for idx, r := range ranges {
+		if expected := []uint32{2, 2, 1}[idx]; r.count != expected {
+			t.Fatalf("Expected %v vars in range %v; got %v", expected, idx, r.count)
+		}
+		if idx > 0 && !bytes.Equal(ranges[idx-1].end, r.start) {
+			t.Fatalf("Range %v does not start where range %v ends", idx, idx-1)
+		}
+		if idx < 2 && !bytes.Equal(r.end, entries[2*idx+2].vUUId) {
+			t.Fatalf("Range %v ends at %v; expected %v", idx, r.end, entries[2*idx+2].vUUId)
+		}
+	}
+
+	// A newer version of a var changes only the hash of its range.
+	entries[3] = antiEntropyTestEntry(3, 2, 2)
+	changed := build(entries)
+	for idx, r := range changed {
+		if same := bytes.Equal(r.hash, ranges[idx].hash); same != (idx != 1) {
+			t.Fatalf("Unexpected hash for range %v: changed? %v", idx, !same)
+		}
+	}
+
+	// Versions are ordered by clock element, and then by txn id.
+	if !antiEntropyTestEntry(0, 1, 2).newerThan(antiEntropyTestEntry(0, 2, 1)) ||
+		!antiEntropyTestEntry(0, 2, 1).newerThan(antiEntropyTestEntry(0, 1, 1)) ||
+		antiEntropyTestEntry(0, 1, 1).newerThan(antiEntropyTestEntry(0, 1, 1)) {
+		t.Fatal("Unexpected ordering of versions")
+	}
+}
+
+// antiEntropyTestNet connects scrubbers, and delivers the messages
+// they send to each other in order. Repairs are applied straight to
+// disk rather than via the txn engine.
+type antiEntropyTestNet struct {
+	t       *testing.T
+	nodes   map[common.RMId]*Scrubber
+	queue   []*antiEntropyTestMsg
+	sent    map[msgs.Message_Which]int
+	applied map[common.RMId]int
+}
+
+type antiEntropyTestMsg struct {
+	sender, recipient common.RMId
+	msg               msgs.Message
+}
+
+type antiEntropyTestConn struct {
+	net               *antiEntropyTestNet
+	sender, recipient common.RMId
+}
+
+func (conn *antiEntropyTestConn) Host() string        { return "" }
+func (conn *antiEntropyTestConn) RMId() common.RMId   { return conn.recipient }
+func (conn *antiEntropyTestConn) BootCount() uint32   { return 1 }
+func (conn *antiEntropyTestConn) TieBreak() uint32    { return 0 }
+func (conn *antiEntropyTestConn) ClusterUUId() uint64 { return 0 }
+
+func (conn *antiEntropyTestConn) Send(bites []byte) {
+	seg, _, err := capn.ReadFromMemoryZeroCopy(bites)
+	if err != nil {
+		panic(err)
+	}
+	msg := msgs.ReadRootMessage(seg)
+	conn.net.sent[msg.Which()]++
+	conn.net.queue = append(conn.net.queue, &antiEntropyTestMsg{sender: conn.sender, recipient: conn.recipient, msg: msg})
+}
+
+// newAntiEntropyTestNet returns scrubbers on RMs 1 and 2 which are
+// connected to each other. RM 3 is the third replica of every var,
+// but is not connected.
+func newAntiEntropyTestNet(t *testing.T) *antiEntropyTestNet {
+	net := &antiEntropyTestNet{
+		t:       t,
+		nodes:   make(map[common.RMId]*Scrubber),
+		sent:    make(map[msgs.Message_Which]int),
+		applied: make(map[common.RMId]int),
+	}
+	for _, rmId := range []common.RMId{1, 2} {
+		cm := &ConnectionManager{
+			RMId:        rmId,
+			Dispatchers: &paxos.Dispatchers{VarDispatcher: &eng.VarDispatcher{Barrier: eng.NewGCBarrier()}},
+		}
+		s := NewScrubber(db.DB.WithStorage(db.NewMemoryStorage()), cm)
+		s.topology = &configuration.Topology{Configuration: &configuration.Configuration{Version: 1}}
+		s.resolver = ch.NewResolver(common.RMIds{1, 2, 3}, 3)
+		s.conns = make(map[common.RMId]paxos.Connection)
+		rmIdCopy := rmId
+		s.immigrate = func(repair *msgs.Migration) error {
+			net.applied[rmIdCopy]++
+			net.apply(s.db, repair)
+			return nil
+		}
+		net.nodes[rmId] = s
+	}
+	for sender, s := range net.nodes {
+		for recipient := range net.nodes {
+			if sender != recipient {
+				s.conns[recipient] = &antiEntropyTestConn{net: net, sender: sender, recipient: recipient}
+			}
+		}
+	}
+	return net
+}
+
+func (net *antiEntropyTestNet) shutdown() {
+	for _, s := range net.nodes {
+		s.db.Shutdown()
+	}
+}
+
+func (net *antiEntropyTestNet) pump() {
+	for delivered := 0; len(net.queue) != 0; delivered++ {
+		if delivered > 1000 {
+			net.t.Fatal("Anti-entropy did not finish")
+		}
+		m := net.queue[0]
+		net.queue = net.queue[1:]
+		s := net.nodes[m.recipient]
+		switch m.msg.Which() {
+		case msgs.MESSAGE_ANTIENTROPYRANGES:
+			ranges := m.msg.AntiEntropyRanges()
+			s.antiEntropyRangesReceived(m.sender, &ranges)
+		case msgs.MESSAGE_ANTIENTROPYENTRIES:
+			entries := m.msg.AntiEntropyEntries()
+			s.antiEntropyEntriesReceived(m.sender, &entries)
+		case msgs.MESSAGE_ANTIENTROPYREPAIR:
+			repair := m.msg.AntiEntropyRepair()
+			s.antiEntropyRepairReceived(m.sender, &repair)
+		default:
+			net.t.Fatalf("Unexpected message %v", m.msg.Which())
+		}
+	}
+}
+
+func (net *antiEntropyTestNet) apply(disk *db.Databases, repair *msgs.Migration) {
+	_, err := disk.ReadWriteTransaction(func(rwtxn db.RWTxn) interface{} {
+		elems := repair.Elems()
+		for idx, l := 0, elems.Len(); idx < l; idx++ {
+			elem := elems.At(idx)
+			txn := eng.TxnReaderFromData(elem.Txn())
+			vars := elem.Vars()
+			for idy, m := 0, vars.Len(); idy < m; idy++ {
+				varCap := vars.At(idy)
+				if err := disk.WriteTxnToDisk(rwtxn, txn.Id, txn.Data); err != nil {
+					rwtxn.Error(err)
+					return nil
+				}
+				seg := capn.NewBuffer(nil)
+				copied := msgs.NewRootVar(seg)
+				copied.SetId(varCap.Id())
+				copied.SetPositions(seg.NewUInt8List(3))
+				copied.SetWriteTxnId(varCap.WriteTxnId())
+				copied.SetWriteTxnClock(varCap.WriteTxnClock())
+				copied.SetWritesClock(varCap.WritesClock())
+				if err := rwtxn.Put(db.Vars, varCap.Id(), disk.SealRecord(db.Vars, varCap.Id(), server.SegToBytes(seg))); err != nil {
+					rwtxn.Error(err)
+					return nil
+				}
+			}
+		}
+		return nil
+	}).ResultError()
+	if err != nil {
+		net.t.Fatal(err)
+	}
+}
+
+func putAntiEntropyTestVar(t *testing.T, disk *db.Databases, vUUId *common.VarUUId, txnId *common.TxnId, clockElem uint64) {
+	txnSeg := capn.NewBuffer(nil)
+	txnCap := msgs.NewRootTxn(txnSeg)
+	txnCap.SetId(txnId[:])
+
+	seg := capn.NewBuffer(nil)
+	varCap := msgs.NewRootVar(seg)
+	varCap.SetId(vUUId[:])
+	varCap.SetPositions(seg.NewUInt8List(3))
+	varCap.SetWriteTxnId(txnId[:])
+	varCap.SetWriteTxnClock(eng.NewVectorClock().AsMutable().Bump(vUUId, clockElem).AsData())
+
+	_, err := disk.ReadWriteTransaction(func(rwtxn db.RWTxn) interface{} {
+		if err := disk.WriteTxnToDisk(rwtxn, txnId, server.SegToBytes(txnSeg)); err != nil {
+			rwtxn.Error(err)
+		} else if err = rwtxn.Put(db.Vars, vUUId[:], disk.SealRecord(db.Vars, vUUId[:], server.SegToBytes(seg))); err != nil {
+			rwtxn.Error(err)
+		}
+		return nil
+	}).ResultError()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// entries returns every var s has, keyed by VarUUId.
+func antiEntropyTestEntries(t *testing.T, s *Scrubber) map[common.VarUUId]*antiEntropyEntry {
+	entries := make(map[common.VarUUId]*antiEntropyEntry)
+	err := s.scanVars(nil, nil, func(vUUIdBytes []byte, varCap *msgs.Var, rmIds []common.RMId) {
+		entries[*common.MakeVarUUId(vUUIdBytes)] = newAntiEntropyEntry(vUUIdBytes, varCap)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+// Replicas which differ, in either direction, are detected and
+// converge, after which a further round finds nothing to repair.
+func TestAntiEntropyRepairs(t *testing.T) {
+	net := newAntiEntropyTestNet(t)
+	defer net.shutdown()
+	one, two := net.nodes[1], net.nodes[2]
+
+	// Enough vars that the ranges are split before entries are
+	// exchanged.
+	count := server.AntiEntropyLeafVarCount + 8
+	for idx := 1; idx <= count; idx++ {
+		vUUId := antiEntropyTestVarUUId(idx)
+		putAntiEntropyTestVar(t, one.db, vUUId, &common.TxnId{1}, 1)
+		putAntiEntropyTestVar(t, two.db, vUUId, &common.TxnId{1}, 1)
+	}
+	behind, ahead, missing := antiEntropyTestVarUUId(3), antiEntropyTestVarUUId(count-2), antiEntropyTestVarUUId(count+1)
+	putAntiEntropyTestVar(t, one.db, behind, &common.TxnId{2}, 2)
+	putAntiEntropyTestVar(t, two.db, ahead, &common.TxnId{3}, 2)
+	putAntiEntropyTestVar(t, one.db, missing, &common.TxnId{4}, 1)
+
+	one.startAntiEntropy()
+	net.pump()
+
+	entriesOne, entriesTwo := antiEntropyTestEntries(t, one), antiEntropyTestEntries(t, two)
+	if len(entriesOne) != count+1 || len(entriesTwo) != count+1 {
+		t.Fatalf("Expected both replicas to have %v vars; got %v and %v", count+1, len(entriesOne), len(entriesTwo))
+	}
+	for vUUId, e := range entriesOne {
+		if o := entriesTwo[vUUId]; o == nil || e.newerThan(o) || o.newerThan(e) {
+			t.Fatalf("Replicas of %v have not converged", &vUUId)
+		}
+	}
+	if e := entriesTwo[*behind]; !bytes.Equal(e.writeTxnId, (&common.TxnId{2})[:]) {
+		t.Fatalf("Expected %v to have been repaired on 2", behind)
+	}
+	if e := entriesOne[*ahead]; !bytes.Equal(e.writeTxnId, (&common.TxnId{3})[:]) {
+		t.Fatalf("Expected %v to have been repaired on 1", ahead)
+	}
+	if net.applied[1] == 0 || net.applied[2] == 0 {
+		t.Fatalf("Expected repairs to be applied to both replicas; got %v", net.applied)
+	}
+
+	// Nothing differs, so only the ranges are sent.
+	net.sent = make(map[msgs.Message_Which]int)
+	net.applied = make(map[common.RMId]int)
+	one.startAntiEntropy()
+	two.startAntiEntropy()
+	net.pump()
+	if len(net.sent) != 1 || net.sent[msgs.MESSAGE_ANTIENTROPYRANGES] != 2 || len(net.applied) != 0 {
+		t.Fatalf("Expected converged replicas to exchange only their ranges; sent %v", net.sent)
+	}
+}
+
+// A var which garbage collection has deleted on one replica is not
+// restored from another replica which has yet to delete it.
+func TestAntiEntropyKeepsTombstones(t *testing.T) {
+	net := newAntiEntropyTestNet(t)
+	defer net.shutdown()
+	one, two := net.nodes[1], net.nodes[2]
+
+	deleted := antiEntropyTestVarUUId(1)
+	putAntiEntropyTestVar(t, one.db, deleted, &common.TxnId{1}, 1)
+	barrier := two.connectionManager.Dispatchers.VarDispatcher.Barrier
+	barrier.Start()
+	barrier.Deleted(deleted)
+
+	one.startAntiEntropy()
+	net.pump()
+	if net.sent[msgs.MESSAGE_ANTIENTROPYREPAIR] == 0 {
+		t.Fatal("Expected a repair to be sent")
+	}
+	if net.applied[2] != 0 {
+		t.Fatal("Expected the repair of a deleted var to be ignored")
+	}
+	if _, found := antiEntropyTestEntries(t, two)[*deleted]; found {
+		t.Fatalf("Expected %v to stay deleted", deleted)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	cc "github.com/msackman/chancell"
 	"goshawkdb.io/common"
 	cmsgs "goshawkdb.io/common/capnp"
+	"goshawkdb.io/common/certs"
 	"goshawkdb.io/server"
 	msgs "goshawkdb.io/server/capnp"
 	"goshawkdb.io/server/client"
@@ -88,6 +89,15 @@ type connectionMsgStatus struct {
 	*server.StatusConsumer
 }
 
+type connectionMsgCachedVars struct {
+	connectionMsgBasic
+	resultFun func([]*common.VarUUId)
+}
+
+type connectionMsgRevocationsChanged struct{ connectionMsgBasic }
+
+type connectionMsgExpireRetries struct{ connectionMsgBasic }
+
 func (conn *Connection) Shutdown(sync paxos.Blocking) {
 	if conn.enqueueQuery(connectionMsgShutdown{}) && sync == paxos.Sync {
 		conn.cellTail.Wait()
@@ -128,6 +138,18 @@ func (conn *Connection) Status(sc *server.StatusConsumer) {
 	conn.enqueueQuery(connectionMsgStatus{StatusConsumer: sc})
 }
 
+// CachedVars calls resultFun, from some other go-routine, with the
+// vars known to the client on this connection, if any.
+func (conn *Connection) CachedVars(resultFun func([]*common.VarUUId)) {
+	if !conn.enqueueQuery(connectionMsgCachedVars{resultFun: resultFun}) {
+		go resultFun(nil)
+	}
+}
+
+func (conn *Connection) RevocationsChanged() {
+	conn.enqueueQuery(connectionMsgRevocationsChanged{})
+}
+
 type connectionMsgServerConnectionsChanged struct {
 	servers map[common.RMId]paxos.Connection
 	done    func()
@@ -284,6 +306,16 @@ func (conn *Connection) handleMsg(msg connectionMsg) (terminate bool, err error)
 		}
 	case connectionMsgStatus:
 		conn.status(msgT.StatusConsumer)
+	case connectionMsgCachedVars:
+		var vUUIds []*common.VarUUId
+		if conn.submitter != nil {
+			vUUIds = conn.submitter.CachedVars()
+		}
+		go msgT.resultFun(vUUIds)
+	case connectionMsgRevocationsChanged:
+		err = conn.revocationsChanged()
+	case connectionMsgExpireRetries:
+		err = conn.expireRetries()
 	default:
 		err = fmt.Errorf("Fatal to Connection: Received unexpected message: %#v", msgT)
 	}
@@ -300,6 +332,7 @@ func (conn *Connection) handleShutdown(err error) {
 		conn.connectionManager.ClientLost(conn.ConnectionNumber, conn)
 		if conn.submitter != nil {
 			conn.submitter.Shutdown()
+			conn.connectionManager.ReleaseClientLimiter(conn.fingerprint)
 		}
 	}
 	if conn.isServer {
@@ -366,6 +399,7 @@ func (cd *connectionDelay) start() (bool, error) {
 	cd.isServer = false
 	cd.isClient = false
 	cd.peerCerts = nil
+	cd.peerCert = nil
 	if cd.delay == nil {
 		delay := server.ConnectionRestartDelayMin + time.Duration(cd.rng.Intn(server.ConnectionRestartDelayRangeMS))*time.Millisecond
 		cd.delay = time.AfterFunc(delay, func() {
@@ -517,23 +551,60 @@ func (cah *connectionAwaitHandshake) maybeRestartConnection(err error) (bool, er
 }
 
 func (cah *connectionAwaitHandshake) commonTLSConfig() *tls.Config {
-	nodeCertPrivKeyPair := cah.connectionManager.NodeCertificatePrivateKeyPair
-	roots := x509.NewCertPool()
-	roots.AddCert(nodeCertPrivKeyPair.CertificateRoot)
+	nodeCertPrivKeyPair := cah.connectionManager.NodeCertificatePrivateKeyPair()
+	roots := clusterRoots(nodeCertPrivKeyPair, cah.topology)
 
-	return &tls.Config{
+	config := &tls.Config{
 		Certificates: []tls.Certificate{
 			tls.Certificate{
 				Certificate: [][]byte{nodeCertPrivKeyPair.Certificate},
 				PrivateKey:  nodeCertPrivKeyPair.PrivateKey,
 			},
 		},
-		CipherSuites:             []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
-		MinVersion:               tls.VersionTLS12,
 		PreferServerCipherSuites: true,
 		ClientCAs:                roots,
 		RootCAs:                  roots,
 	}
+	cah.connectionManager.TLS.apply(config)
+	return config
+}
+
+// clusterRoots contains the root of our own node certificate, and
+// the cluster certificates of the topology. During a change of
+// configuration, the cluster certificates of both the old and new
+// configurations are trusted.
+func clusterRoots(nodeCertPrivKeyPair *certs.NodeCertificatePrivateKeyPair, topology *configuration.Topology) *x509.CertPool {
+	roots := x509.NewCertPool()
+	roots.AddCert(nodeCertPrivKeyPair.CertificateRoot)
+	if topology != nil {
+		for _, cert := range topology.ClusterCertificates() {
+			roots.AddCert(cert)
+		}
+		if next := topology.Next(); next != nil {
+			for _, cert := range next.ClusterCertificates() {
+				roots.AddCert(cert)
+			}
+		}
+	}
+	return roots
+}
+
+// verifyClusterCertificate checks the certificate chain presented by
+// another node.
+func verifyClusterCertificate(peerCerts []*x509.Certificate, roots *x509.CertPool) error {
+	if len(peerCerts) == 0 {
+		return errors.New("No certificate presented")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       "", // disable server name checking
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range peerCerts[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := peerCerts[0].Verify(opts)
+	return err
 }
 
 // Await Server Handshake
@@ -562,6 +633,11 @@ func (cash *connectionAwaitServerHandshake) start() (bool, error) {
 			return cash.connectionAwaitHandshake.maybeRestartConnection(err)
 		}
 		cash.socket = socket
+		if err := socket.Handshake(); err != nil {
+			err = cash.connectionManager.TLS.handshakeError(socket.RemoteAddr().String(), err)
+			cash.connectionManager.Audit.AuthenticationFailed(socket.RemoteAddr().String(), socket.ConnectionState().PeerCertificates, err)
+			return cash.connectionAwaitHandshake.maybeRestartConnection(err)
+		}
 
 	} else {
 		config.InsecureSkipVerify = true
@@ -577,22 +653,13 @@ func (cash *connectionAwaitServerHandshake) start() (bool, error) {
 		// the verification ourself. Why is TLS asymmetric?!
 
 		if err := socket.Handshake(); err != nil {
-			return cash.connectionAwaitHandshake.maybeRestartConnection(err)
+			return cash.connectionAwaitHandshake.maybeRestartConnection(
+				cash.connectionManager.TLS.handshakeError(cash.remoteHost, err))
 		}
 
-		opts := x509.VerifyOptions{
-			Roots:         config.RootCAs,
-			DNSName:       "", // disable server name checking
-			Intermediates: x509.NewCertPool(),
-		}
-		certs := socket.ConnectionState().PeerCertificates
-		for i, cert := range certs {
-			if i == 0 {
-				continue
-			}
-			opts.Intermediates.AddCert(cert)
-		}
-		if _, err := certs[0].Verify(opts); err != nil {
+		peerCerts := socket.ConnectionState().PeerCertificates
+		if err := verifyClusterCertificate(peerCerts, config.RootCAs); err != nil {
+			cash.connectionManager.Audit.AuthenticationFailed(cash.remoteHost, peerCerts, err)
 			return cash.connectionAwaitHandshake.maybeRestartConnection(err)
 		}
 	}
@@ -654,9 +721,13 @@ func (cash *connectionAwaitServerHandshake) makeHelloServerFromServer() *capn.Se
 
 type connectionAwaitClientHandshake struct {
 	*Connection
-	peerCerts []*x509.Certificate
-	roots     map[string]*common.Capability
-	rootsVar  map[common.VarUUId]*common.Capability
+	peerCerts      []*x509.Certificate
+	peerCertExpiry time.Time
+	peerCert       *x509.Certificate
+	fingerprint    [sha256.Size]byte
+	roots          map[string]*common.Capability
+	rootsVar       map[common.VarUUId]*common.Capability
+	rootsName      map[common.VarUUId]string
 }
 
 func (cach *connectionAwaitClientHandshake) connectionStateMachineComponentWitness() {}
@@ -672,6 +743,8 @@ func (cach *connectionAwaitClientHandshake) start() (bool, error) {
 	socket := tls.Server(cach.socket, config)
 	cach.socket = socket
 	if err := socket.Handshake(); err != nil {
+		err = cach.connectionManager.TLS.handshakeError(socket.RemoteAddr().String(), err)
+		cach.connectionManager.Audit.AuthenticationFailed(socket.RemoteAddr().String(), socket.ConnectionState().PeerCertificates, err)
 		return false, err
 	}
 
@@ -682,8 +755,16 @@ func (cach *connectionAwaitClientHandshake) start() (bool, error) {
 	}
 
 	peerCerts := socket.ConnectionState().PeerCertificates
-	if authenticated, hashsum, roots := cach.verifyPeerCerts(peerCerts); authenticated {
+	if err := cach.connectionManager.TLS.checkClientKeyType(peerCerts); err != nil {
+		err = fmt.Errorf("Client connection rejected: %v", err)
+		cach.connectionManager.Audit.AuthenticationFailed(socket.RemoteAddr().String(), peerCerts, err)
+		return false, err
+	}
+	if cert, hashsum, roots := cach.verifyPeerCerts(peerCerts); cert != nil {
 		cach.peerCerts = peerCerts
+		cach.peerCertExpiry = cert.NotAfter
+		cach.peerCert = cert
+		cach.fingerprint = hashsum
 		cach.roots = roots
 		log.Printf("User '%s' authenticated", hex.EncodeToString(hashsum[:]))
 		helloFromServer := cach.makeHelloClientFromServer()
@@ -694,19 +775,34 @@ func (cach *connectionAwaitClientHandshake) start() (bool, error) {
 		cach.nextState(nil)
 		return false, nil
 	} else {
-		return false, errors.New("Client connection rejected: No client certificate known")
+		err := errors.New("Client connection rejected: No client certificate known, or certificate expired or revoked")
+		cach.connectionManager.Audit.AuthenticationFailed(socket.RemoteAddr().String(), peerCerts, err)
+		return false, err
 	}
 }
 
-func (cach *connectionAwaitClientHandshake) verifyPeerCerts(peerCerts []*x509.Certificate) (authenticated bool, hashsum [sha256.Size]byte, roots map[string]*common.Capability) {
+// verifyPeerCerts returns the first certificate which is known,
+// within its validity period, and not revoked. Failing that, the
+// client's own certificate is accepted if it was issued by a client
+// certificate authority with a rule matching it.
+func (cach *connectionAwaitClientHandshake) verifyPeerCerts(peerCerts []*x509.Certificate) (cert *x509.Certificate, hashsum [sha256.Size]byte, roots map[string]*common.Capability) {
 	fingerprints := cach.topology.Fingerprints()
+	now := time.Now()
 	for _, cert := range peerCerts {
 		hashsum = sha256.Sum256(cert.Raw)
-		if roots, found := fingerprints[hashsum]; found {
-			return true, hashsum, roots
+		if roots, found := fingerprints[hashsum]; found && now.After(cert.NotBefore) && now.Before(cert.NotAfter) && !cach.connectionManager.IsRevoked(hashsum) {
+			return cert, hashsum, roots
+		}
+	}
+	if len(peerCerts) > 0 {
+		cert = peerCerts[0]
+		hashsum = sha256.Sum256(cert.Raw)
+		// Verification against the CA checks the validity period.
+		if roots = cach.topology.ClientCertificateRoots(peerCerts); roots != nil && !cach.connectionManager.IsRevoked(hashsum) {
+			return cert, hashsum, roots
 		}
 	}
-	return false, hashsum, nil
+	return nil, hashsum, nil
 }
 
 func (cach *connectionAwaitClientHandshake) makeHelloClientFromServer() *capn.Segment {
@@ -720,6 +816,7 @@ func (cach *connectionAwaitClientHandshake) makeHelloClientFromServer() *capn.Se
 	rootsCap := cmsgs.NewRootList(seg, len(cach.roots))
 	idy := 0
 	rootsVar := make(map[common.VarUUId]*common.Capability, len(cach.roots))
+	rootsName := make(map[common.VarUUId]string, len(cach.roots))
 	for idx, name := range cach.topology.RootNames() {
 		if capability, found := cach.roots[name]; found {
 			rootCap := rootsCap.At(idy)
@@ -729,10 +826,12 @@ func (cach *connectionAwaitClientHandshake) makeHelloClientFromServer() *capn.Se
 			rootCap.SetVarId(vUUId[:])
 			rootCap.SetCapability(capability.Capability)
 			rootsVar[*vUUId] = capability
+			rootsName[*vUUId] = name
 		}
 	}
 	hello.SetRoots(rootsCap)
 	cach.rootsVar = rootsVar
+	cach.rootsName = rootsName
 	return seg
 }
 
@@ -797,7 +896,15 @@ func (cr *connectionRun) start() (bool, error) {
 		if servers == nil {
 			return false, errors.New("Not ready for client connections")
 		}
-		cr.submitter = client.NewClientTxnSubmitter(cr.connectionManager.RMId, cr.connectionManager.BootCount(), cr.rootsVar, cr.connectionManager)
+		limiter := cr.connectionManager.AcquireClientLimiter(cr.fingerprint, cr.topology.LimitsForClient(cr.fingerprint))
+		auditor := cr.connectionManager.Audit.Client(cr.fingerprint, cr.ConnectionNumber)
+		identity := &client.ClientIdentity{
+			Fingerprint:      cr.fingerprint,
+			Certificate:      cr.peerCert,
+			ConnectionNumber: cr.ConnectionNumber,
+			Roots:            cr.rootsName,
+		}
+		cr.submitter = client.NewClientTxnSubmitter(cr.connectionManager.RMId, cr.connectionManager.BootCount(), cr.rootsVar, limiter, auditor, cr.connectionManager.Authorizer, identity, cr.connectionManager.ClientTxnsPerConn, cr.connectionManager)
 		cr.submitter.TopologyChanged(cr.topology)
 		cr.submitter.ServerConnectionsChanged(servers)
 	}
@@ -832,10 +939,10 @@ func (cr *connectionRun) topologyChanged(tc *connectionMsgTopologyChanged) error
 	}
 	if cr.isClient {
 		if topology != nil {
-			if authenticated, _, roots := cr.verifyPeerCerts(cr.peerCerts); !authenticated {
+			if cert, _, roots := cr.verifyPeerCerts(cr.peerCerts); cert == nil {
 				server.Log("Connection", cr.Connection, "topologyChanged", tc, "(client unauthed)")
 				tc.maybeClose()
-				return errors.New("Client connection closed: No client certificate known")
+				return errors.New("Client connection closed: No client certificate known, or certificate expired or revoked")
 			} else if len(roots) == len(cr.roots) {
 				for name, capsOld := range cr.roots {
 					if capsNew, found := roots[name]; !found || !capsNew.Equal(capsOld) {
@@ -849,6 +956,7 @@ func (cr *connectionRun) topologyChanged(tc *connectionMsgTopologyChanged) error
 				tc.maybeClose()
 				return errors.New("Client connection closed: roots have changed")
 			}
+			cr.submitter.SetLimiter(cr.connectionManager.UpdateClientLimiter(cr.fingerprint, topology.LimitsForClient(cr.fingerprint)))
 		}
 		if err := cr.submitter.TopologyChanged(topology); err != nil {
 			tc.maybeClose()
@@ -869,11 +977,30 @@ func (cr *connectionRun) topologyChanged(tc *connectionMsgTopologyChanged) error
 			if _, found := topology.RMsRemoved()[cr.remoteRMId]; found {
 				cr.restart = false
 			}
+			// If the cluster certificate the remote's certificate was
+			// issued from is no longer trusted, we must reconnect, and
+			// so verify whatever certificate the remote now has.
+			if socket, ok := cr.socket.(*tls.Conn); ok {
+				roots := clusterRoots(cr.connectionManager.NodeCertificatePrivateKeyPair(), topology)
+				if err := verifyClusterCertificate(socket.ConnectionState().PeerCertificates, roots); err != nil {
+					return cr.maybeRestartConnection(fmt.Errorf("Certificate no longer trusted: %v", err))
+				}
+			}
 		}
 	}
 	return nil
 }
 
+func (cr *connectionRun) revocationsChanged() error {
+	if cr.currentState != cr || !cr.isClient {
+		return nil
+	}
+	if cert, _, _ := cr.verifyPeerCerts(cr.peerCerts); cert == nil {
+		return errors.New("Client connection closed: certificate revoked")
+	}
+	return nil
+}
+
 func (cr *connectionRun) serverConnectionsChanged(servers map[common.RMId]paxos.Connection) error {
 	if cr.submitter != nil {
 		return cr.submitter.ServerConnectionsChanged(servers)
@@ -894,7 +1021,17 @@ func (cr *connectionRun) handleMsgFromClient(msg cmsgs.ClientMessage) error {
 	case cmsgs.CLIENTMESSAGE_CLIENTTXNSUBMISSION:
 		ctxn := msg.ClientTxnSubmission()
 		origTxnId := common.MakeTxnId(ctxn.Id())
-		return cr.submitter.SubmitClientTransaction(&ctxn, func(clientOutcome *cmsgs.ClientTxnOutcome, err error) error {
+		if err := cr.connectionManager.Health.Degraded(); err != nil && client.IsWriteTxn(&ctxn) {
+			return cr.clientTxnError(&ctxn, err, origTxnId)
+		}
+		if timeout := cr.connectionManager.ClientRetryTimeout; ctxn.Retry() && timeout > 0 {
+			// Harmless if the txn has finished, or the connection
+			// has gone, by the time this fires.
+			time.AfterFunc(timeout, func() {
+				cr.enqueueQuery(connectionMsgExpireRetries{})
+			})
+		}
+		return cr.submitter.SubmitClientTransaction(&ctxn, cr.connectionManager.ClientRetryTimeout, func(clientOutcome *cmsgs.ClientTxnOutcome, err error) error {
 			switch {
 			case err != nil:
 				return cr.clientTxnError(&ctxn, err, origTxnId)
@@ -912,6 +1049,16 @@ func (cr *connectionRun) handleMsgFromClient(msg cmsgs.ClientMessage) error {
 	}
 }
 
+// Retry txns are normally expired by the timer started when they
+// were submitted. The heartbeat catches any which could not be
+// aborted then, or when they were cancelled.
+func (cr *connectionRun) expireRetries() error {
+	if cr.currentState != cr || cr.submitter == nil {
+		return nil
+	}
+	return cr.submitter.ExpireRetries(time.Now())
+}
+
 func (cr *connectionRun) handleMsgFromServer(msg msgs.Message) error {
 	if cr.currentState != cr {
 		// probably just draining the queue from the reader after a restart
@@ -997,6 +1144,15 @@ func (cr *connectionRun) beat() error {
 		return cr.maybeRestartConnection(
 			fmt.Errorf("Missed too many connection heartbeats. Restarting connection."))
 	}
+	if cr.isClient && time.Now().After(cr.peerCertExpiry) {
+		return cr.maybeRestartConnection(
+			errors.New("Client certificate has expired. Closing connection."))
+	}
+	if cr.isClient {
+		if err := cr.expireRetries(); err != nil {
+			return err
+		}
+	}
 	// Useful for testing recovery from network brownouts
 	/*
 		if cr.rng.Intn(15) == 0 && cr.isServer {
@@ -0,0 +1,241 @@
+package network
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"goshawkdb.io/common/certs"
+	"goshawkdb.io/server"
+	"goshawkdb.io/server/configuration"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+var testSerial int64
+
+// newTestCert creates a certificate from template, signed by parent,
+// or self-signed if parent is nil.
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSerial++
+	template.SerialNumber = big.NewInt(testSerial)
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if template.NotAfter.IsZero() {
+		template.NotAfter = time.Now().Add(time.Hour)
+	}
+	signer, signerCert := key, template
+	if parent != nil {
+		signer, signerCert = parent.key, parent.cert
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+func newTestCA(t *testing.T, name string) *testCert {
+	return newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+}
+
+func newTestLeaf(t *testing.T, parent *testCert, name string, notBefore, notAfter time.Time) *testCert {
+	return newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		NotBefore:   notBefore,
+		NotAfter:    notAfter,
+	}, parent)
+}
+
+func certPEM(cert *testCert) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.cert.Raw}))
+}
+
+// loadTestConfiguration completes config with a single host and
+// loads it as the configuration file would be.
+func loadTestConfiguration(t *testing.T, config *configuration.Configuration) *configuration.Configuration {
+	config.ClusterId = "test"
+	config.Version = 1
+	config.Hosts = []string{"127.0.0.1:7894"}
+	config.MaxRMCount = 1
+	if len(config.ClientCertificateFingerprints) == 0 && len(config.ClientCertificateAuthorities) == 0 {
+		config.ClientCertificateFingerprints = map[string]map[string]*configuration.RootCapability{
+			"0000000000000000000000000000000000000000000000000000000000000000": {"r": {Read: true}},
+		}
+	}
+	bites, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	if _, err = file.Write(bites); err != nil {
+		file.Close()
+		t.Fatal(err)
+	}
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := configuration.LoadConfigurationFromPath(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loaded
+}
+
+// A node trusts certificates issued by the cluster certificate it
+// was started with, and by every cluster certificate listed in the
+// current and next configurations. Once a cluster certificate is no
+// longer listed, certificates issued by it are refused.
+func TestClusterCertificateRotation(t *testing.T) {
+	oldCA, newCA := newTestCA(t, "old cluster"), newTestCA(t, "new cluster")
+	oldNode := newTestLeaf(t, oldCA, "old node", time.Time{}, time.Time{})
+	newNode := newTestLeaf(t, newCA, "new node", time.Time{}, time.Time{})
+	trusts := func(roots *x509.CertPool, node *testCert) bool {
+		return verifyClusterCertificate([]*x509.Certificate{node.cert}, roots) == nil
+	}
+	started := func(ca *testCert) *certs.NodeCertificatePrivateKeyPair {
+		return &certs.NodeCertificatePrivateKeyPair{CertificateRoot: ca.cert}
+	}
+
+	// Not yet rotating.
+	plain := &configuration.Topology{Configuration: loadTestConfiguration(t, &configuration.Configuration{})}
+	roots := clusterRoots(started(oldCA), plain)
+	if !trusts(roots, oldNode) || trusts(roots, newNode) {
+		t.Fatal("Expected only the cluster certificate the node was started with to be trusted")
+	}
+	if roots = clusterRoots(started(oldCA), nil); !trusts(roots, oldNode) {
+		t.Fatal("Expected the cluster certificate the node was started with to be trusted without a topology")
+	}
+
+	// Both listed: nodes may be restarted with the new certificate
+	// one at a time.
+	both := &configuration.Topology{Configuration: loadTestConfiguration(t, &configuration.Configuration{
+		ClusterCertificates: []string{certPEM(oldCA), certPEM(newCA)},
+	})}
+	for _, ca := range []*testCert{oldCA, newCA} {
+		if roots = clusterRoots(started(ca), both); !trusts(roots, oldNode) || !trusts(roots, newNode) {
+			t.Fatalf("Expected both cluster certificates to be trusted by a node started with %v", ca.cert.Subject)
+		}
+	}
+
+	// Whilst changing to a configuration which lists the new
+	// certificate, it is trusted already.
+	changing := &configuration.Topology{Configuration: loadTestConfiguration(t, &configuration.Configuration{})}
+	changing.SetNext(&configuration.NextConfiguration{Configuration: both.Configuration})
+	if roots = clusterRoots(started(oldCA), changing); !trusts(roots, newNode) {
+		t.Fatal("Expected the cluster certificates of the next configuration to be trusted")
+	}
+
+	// Rotated: the old certificate is dropped.
+	rotated := &configuration.Topology{Configuration: loadTestConfiguration(t, &configuration.Configuration{
+		ClusterCertificates: []string{certPEM(newCA)},
+	})}
+	if roots = clusterRoots(started(newCA), rotated); trusts(roots, oldNode) || !trusts(roots, newNode) {
+		t.Fatal("Expected a node issued by a dropped cluster certificate to be refused")
+	}
+
+	if err := verifyClusterCertificate(nil, roots); err == nil {
+		t.Fatal("Expected a node presenting no certificate to be refused")
+	}
+}
+
+// A client certificate is refused once it has expired, before it is
+// valid, and once it is revoked, whether it is known by fingerprint
+// or issued by a client certificate authority.
+func TestVerifyClientCertificate(t *testing.T) {
+	ca := newTestCA(t, "client CA")
+	now := time.Now()
+	valid := newTestLeaf(t, nil, "valid", time.Time{}, time.Time{})
+	expired := newTestLeaf(t, nil, "expired", now.Add(-2*time.Hour), now.Add(-time.Hour))
+	future := newTestLeaf(t, nil, "future", now.Add(time.Hour), now.Add(2*time.Hour))
+	issued := newTestLeaf(t, ca, "issued", time.Time{}, time.Time{})
+	issuedExpired := newTestLeaf(t, ca, "issued expired", now.Add(-2*time.Hour), now.Add(-time.Hour))
+
+	roots := map[string]*configuration.RootCapability{"r": {Read: true, Write: true}}
+	fingerprints := make(map[string]map[string]*configuration.RootCapability)
+	for _, cert := range []*testCert{valid, expired, future} {
+		fingerprints[hex.EncodeToString(fingerprintOf(cert))] = roots
+	}
+	config := loadTestConfiguration(t, &configuration.Configuration{
+		ClientCertificateFingerprints: fingerprints,
+		ClientCertificateAuthorities: []*configuration.ClientCertificateAuthority{{
+			Certificate: certPEM(ca),
+			Rules:       []*configuration.ClientCertificateRule{{CommonName: "issued*", Roots: roots}},
+		}},
+	})
+	cm := &ConnectionManager{}
+	conn := &Connection{connectionManager: cm}
+	conn.topology = &configuration.Topology{Configuration: config}
+	cach := &connectionAwaitClientHandshake{Connection: conn}
+	accepted := func(cert *testCert) bool {
+		found, _, grantedRoots := cach.verifyPeerCerts([]*x509.Certificate{cert.cert})
+		return found != nil && grantedRoots != nil
+	}
+
+	for _, cert := range []*testCert{valid, issued} {
+		if !accepted(cert) {
+			t.Fatalf("Expected %v to be accepted", cert.cert.Subject)
+		}
+	}
+	for _, cert := range []*testCert{expired, future, issuedExpired} {
+		if accepted(cert) {
+			t.Fatalf("Expected %v to be refused outside its validity period", cert.cert.Subject)
+		}
+	}
+
+	revoked := map[[sha256.Size]byte]server.EmptyStruct{}
+	for _, cert := range []*testCert{valid, issued} {
+		ary := [sha256.Size]byte{}
+		copy(ary[:], fingerprintOf(cert))
+		revoked[ary] = server.EmptyStructVal
+	}
+	cm.SetRevokedFingerprints(revoked)
+	for _, cert := range []*testCert{valid, issued} {
+		if accepted(cert) {
+			t.Fatalf("Expected %v to be refused once revoked", cert.cert.Subject)
+		}
+	}
+
+	cm.SetRevokedFingerprints(nil)
+	if !accepted(valid) {
+		t.Fatal("Expected a certificate to be accepted once no longer revoked")
+	}
+}
+
+func fingerprintOf(cert *testCert) []byte {
+	hashsum := sha256.Sum256(cert.cert.Raw)
+	return hashsum[:]
+}
@@ -1,6 +1,7 @@
 package network
 
 import (
+	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
 	capn "github.com/glycerine/go-capnproto"
@@ -8,6 +9,7 @@ import (
 	"goshawkdb.io/common"
 	"goshawkdb.io/common/certs"
 	"goshawkdb.io/server"
+	"goshawkdb.io/server/audit"
 	msgs "goshawkdb.io/server/capnp"
 	"goshawkdb.io/server/client"
 	"goshawkdb.io/server/configuration"
@@ -16,6 +18,7 @@ import (
 	eng "goshawkdb.io/server/txnengine"
 	"log"
 	"sync"
+	"time"
 )
 
 type ShutdownSignaller interface {
@@ -24,23 +27,34 @@ type ShutdownSignaller interface {
 
 type ConnectionManager struct {
 	sync.RWMutex
-	localHost                     string
-	RMId                          common.RMId
-	bootcount                     uint32
-	NodeCertificatePrivateKeyPair *certs.NodeCertificatePrivateKeyPair
-	Transmogrifier                *TopologyTransmogrifier
-	topology                      *configuration.Topology
-	cellTail                      *cc.ChanCellTail
-	enqueueQueryInner             func(connectionManagerMsg, *cc.ChanCell, cc.CurCellConsumer) (bool, cc.CurCellConsumer)
-	queryChan                     <-chan connectionManagerMsg
-	servers                       map[string]*connectionManagerMsgServerEstablished
-	rmToServer                    map[common.RMId]*connectionManagerMsgServerEstablished
-	flushedServers                map[common.RMId]server.EmptyStruct
-	connCountToClient             map[uint32]paxos.ClientConnection
-	desired                       []string
-	serverConnSubscribers         serverConnSubscribers
-	topologySubscribers           topologySubscribers
-	Dispatchers                   *paxos.Dispatchers
+	localHost             string
+	RMId                  common.RMId
+	bootcount             uint32
+	nodeCertPrivKeyPair   *certs.NodeCertificatePrivateKeyPair
+	revoked               map[[sha256.Size]byte]server.EmptyStruct
+	limiters              map[[sha256.Size]byte]*clientLimiter
+	TLS                   *TLSSettings
+	Audit                 *audit.Log
+	Authorizer            client.Authorizer
+	ClientTxnsPerConn     int
+	ClientRetryTimeout    time.Duration
+	Transmogrifier        *TopologyTransmogrifier
+	Scrubber              *Scrubber
+	Collector             *Collector
+	Health                *db.Health
+	LocalConnection       *client.LocalConnection
+	topology              *configuration.Topology
+	cellTail              *cc.ChanCellTail
+	enqueueQueryInner     func(connectionManagerMsg, *cc.ChanCell, cc.CurCellConsumer) (bool, cc.CurCellConsumer)
+	queryChan             <-chan connectionManagerMsg
+	servers               map[string]*connectionManagerMsgServerEstablished
+	rmToServer            map[common.RMId]*connectionManagerMsgServerEstablished
+	flushedServers        map[common.RMId]server.EmptyStruct
+	connCountToClient     map[uint32]paxos.ClientConnection
+	desired               []string
+	serverConnSubscribers serverConnSubscribers
+	topologySubscribers   topologySubscribers
+	Dispatchers           *paxos.Dispatchers
 }
 
 type serverConnSubscribers struct {
@@ -108,6 +122,21 @@ func (cm *ConnectionManager) DispatchMessage(sender common.RMId, msgType msgs.Me
 	case msgs.MESSAGE_MIGRATIONCOMPLETE:
 		migrationComplete := msg.MigrationComplete()
 		cm.Transmogrifier.MigrationCompleteReceived(sender, &migrationComplete)
+	case msgs.MESSAGE_SCRUBDIGEST:
+		digest := msg.ScrubDigest()
+		cm.Scrubber.DigestReceived(sender, &digest)
+	case msgs.MESSAGE_ANTIENTROPYRANGES:
+		ranges := msg.AntiEntropyRanges()
+		cm.Scrubber.AntiEntropyRangesReceived(sender, &ranges)
+	case msgs.MESSAGE_ANTIENTROPYENTRIES:
+		entries := msg.AntiEntropyEntries()
+		cm.Scrubber.AntiEntropyEntriesReceived(sender, &entries)
+	case msgs.MESSAGE_ANTIENTROPYREPAIR:
+		repair := msg.AntiEntropyRepair()
+		cm.Scrubber.AntiEntropyRepairReceived(sender, &repair)
+	case msgs.MESSAGE_GCSTART, msgs.MESSAGE_GCROOTS, msgs.MESSAGE_GCTRACE, msgs.MESSAGE_GCREFERENCES,
+		msgs.MESSAGE_GCFLUSH, msgs.MESSAGE_GCSWEEP, msgs.MESSAGE_GCCANDIDATES, msgs.MESSAGE_GCCONDEMNED:
+		cm.Collector.MessageReceived(sender, &msg)
 	case msgs.MESSAGE_FLUSHED:
 		cm.ServerConnectionFlushed(sender)
 	default:
@@ -286,6 +315,107 @@ func (cm *ConnectionManager) LocalHost() string {
 	return cm.localHost
 }
 
+func (cm *ConnectionManager) NodeCertificatePrivateKeyPair() *certs.NodeCertificatePrivateKeyPair {
+	cm.RLock()
+	defer cm.RUnlock()
+	return cm.nodeCertPrivKeyPair
+}
+
+// SetNodeCertificatePrivateKeyPair replaces the node certificate,
+// for example when the cluster certificate is being rotated. It is
+// used for connections established from now on; existing connections
+// are unaffected.
+func (cm *ConnectionManager) SetNodeCertificatePrivateKeyPair(nodeCertPrivKeyPair *certs.NodeCertificatePrivateKeyPair) {
+	cm.Lock()
+	defer cm.Unlock()
+	cm.nodeCertPrivKeyPair = nodeCertPrivKeyPair
+}
+
+func (cm *ConnectionManager) IsRevoked(fingerprint [sha256.Size]byte) bool {
+	cm.RLock()
+	defer cm.RUnlock()
+	_, found := cm.revoked[fingerprint]
+	return found
+}
+
+// SetRevokedFingerprints replaces the set of revoked client
+// certificates. Live client connections using a certificate which is
+// now revoked are closed.
+func (cm *ConnectionManager) SetRevokedFingerprints(revoked map[[sha256.Size]byte]server.EmptyStruct) {
+	cm.Lock()
+	cm.revoked = revoked
+	conns := make([]*Connection, 0, len(cm.connCountToClient))
+	for _, c := range cm.connCountToClient {
+		if conn, ok := c.(*Connection); ok {
+			conns = append(conns, conn)
+		}
+	}
+	cm.Unlock()
+	for _, conn := range conns {
+		conn.RevocationsChanged()
+	}
+}
+
+// clientLimiter is the Limiter shared by every connection from one
+// client, and a count of those connections. The Limiter is nil
+// whilst the client has no limits.
+type clientLimiter struct {
+	limiter *client.Limiter
+	conns   int
+}
+
+// AcquireClientLimiter is called by each new connection from the
+// client with the given certificate fingerprint, and returns the
+// Limiter shared by all of them, or nil if the client has no limits.
+// Every call must be matched by a call to ReleaseClientLimiter.
+func (cm *ConnectionManager) AcquireClientLimiter(fingerprint [sha256.Size]byte, limits *configuration.ClientLimits) *client.Limiter {
+	cm.Lock()
+	defer cm.Unlock()
+	cl, found := cm.limiters[fingerprint]
+	if !found {
+		cl = &clientLimiter{}
+		cm.limiters[fingerprint] = cl
+	}
+	cl.conns++
+	return cl.setLimits(limits)
+}
+
+// UpdateClientLimiter sets the client's limits, following a topology
+// change, and returns the Limiter the connection should now use.
+func (cm *ConnectionManager) UpdateClientLimiter(fingerprint [sha256.Size]byte, limits *configuration.ClientLimits) *client.Limiter {
+	cm.Lock()
+	defer cm.Unlock()
+	if cl, found := cm.limiters[fingerprint]; found {
+		return cl.setLimits(limits)
+	}
+	return nil
+}
+
+// ReleaseClientLimiter is called as each connection from the client
+// closes. The client's Limiter is forgotten once all its connections
+// have closed.
+func (cm *ConnectionManager) ReleaseClientLimiter(fingerprint [sha256.Size]byte) {
+	cm.Lock()
+	defer cm.Unlock()
+	if cl, found := cm.limiters[fingerprint]; found {
+		if cl.conns--; cl.conns == 0 {
+			delete(cm.limiters, fingerprint)
+		}
+	}
+}
+
+func (cl *clientLimiter) setLimits(limits *configuration.ClientLimits) *client.Limiter {
+	switch {
+	case limits == nil:
+		cl.limiter = nil
+	case cl.limiter == nil:
+		cl.limiter = client.NewLimiter(limits)
+	default:
+		cl.limiter.SetLimits(limits)
+	}
+	return cl.limiter
+}
+
 func (cm *ConnectionManager) AddServerConnectionSubscriber(obs paxos.ServerConnectionSubscriber) {
 	cm.enqueueQuery(connectionManagerMsgServerConnAddSubscriber{ServerConnectionSubscriber: obs})
 }
@@ -349,17 +479,27 @@ func (cm *ConnectionManager) enqueueSyncQuery(msg connectionManagerMsg, resultCh
 	}
 }
 
-func NewConnectionManager(rmId common.RMId, bootCount uint32, procs int, db *db.Databases, nodeCertPrivKeyPair *certs.NodeCertificatePrivateKeyPair, port uint16, ss ShutdownSignaller, config *configuration.Configuration) (*ConnectionManager, *TopologyTransmogrifier) {
+func NewConnectionManager(rmId common.RMId, bootCount uint32, procs int, db *db.Databases, nodeCertPrivKeyPair *certs.NodeCertificatePrivateKeyPair, tlsSettings *TLSSettings, auditLog *audit.Log, authorizer client.Authorizer, clientTxnsPerConn int, clientRetryTimeout time.Duration, port uint16, ss ShutdownSignaller, config *configuration.Configuration) (*ConnectionManager, *TopologyTransmogrifier) {
 	cm := &ConnectionManager{
-		RMId:                          rmId,
-		bootcount:                     bootCount,
-		NodeCertificatePrivateKeyPair: nodeCertPrivKeyPair,
-		servers:           make(map[string]*connectionManagerMsgServerEstablished),
-		rmToServer:        make(map[common.RMId]*connectionManagerMsgServerEstablished),
-		flushedServers:    make(map[common.RMId]server.EmptyStruct),
-		connCountToClient: make(map[uint32]paxos.ClientConnection),
-		desired:           nil,
-	}
+		RMId:                rmId,
+		bootcount:           bootCount,
+		nodeCertPrivKeyPair: nodeCertPrivKeyPair,
+		TLS:                 tlsSettings,
+		Audit:               auditLog,
+		Authorizer:          authorizer,
+		ClientTxnsPerConn:   clientTxnsPerConn,
+		ClientRetryTimeout:  clientRetryTimeout,
+		Health:              db.Health,
+		servers:             make(map[string]*connectionManagerMsgServerEstablished),
+		rmToServer:          make(map[common.RMId]*connectionManagerMsgServerEstablished),
+		flushedServers:      make(map[common.RMId]server.EmptyStruct),
+		connCountToClient:   make(map[uint32]paxos.ClientConnection),
+		limiters:            make(map[[sha256.Size]byte]*clientLimiter),
+		desired:             nil,
+	}
+	// Client write txns are refused whilst the audit log cannot record
+	// them.
+	auditLog.SetFailureHandler(db.Health.SetAuditFailed)
 	cm.serverConnSubscribers.subscribers = make(map[paxos.ServerConnectionSubscriber]server.EmptyStruct)
 	cm.serverConnSubscribers.ConnectionManager = cm
 
@@ -399,11 +539,16 @@ func NewConnectionManager(rmId common.RMId, bootCount uint32, procs int, db *db.
 	cm.rmToServer[cd.rmId] = cd
 	cm.servers[cd.host] = cd
 	lc := client.NewLocalConnection(rmId, bootCount, cm)
+	cm.LocalConnection = lc
 	cm.Dispatchers = paxos.NewDispatchers(cm, rmId, uint8(procs), db, lc)
 	transmogrifier, localEstablished := NewTopologyTransmogrifier(db, cm, lc, port, ss, config)
 	cm.Transmogrifier = transmogrifier
+	cm.Scrubber = NewScrubber(db, cm)
+	cm.Collector = NewCollector(db, cm)
 	go cm.actorLoop(head)
 	<-localEstablished
+	cm.Scrubber.Start()
+	cm.Collector.Start()
 	return cm, transmogrifier
 }
 
@@ -460,6 +605,8 @@ func (cm *ConnectionManager) actorLoop(head *cc.ChanCellHead) {
 	if err != nil {
 		panic(err)
 	}
+	cm.Scrubber.Shutdown()
+	cm.Collector.Shutdown()
 	cm.cellTail.Terminate()
 	for _, cd := range cm.servers {
 		cd.Shutdown(paxos.Sync)
@@ -713,6 +860,8 @@ func (cm *ConnectionManager) status(sc *server.StatusConsumer) {
 	cm.Dispatchers.VarDispatcher.Status(sc.Fork())
 	cm.Dispatchers.ProposerDispatcher.Status(sc.Fork())
 	cm.Dispatchers.AcceptorDispatcher.Status(sc.Fork())
+	cm.Scrubber.Status(sc.Fork())
+	cm.Collector.Status(sc.Fork())
 	sc.Join()
 }
 
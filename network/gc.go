@@ -0,0 +1,806 @@
+package network
+
+import (
+	"expvar"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/configuration"
+	"goshawkdb.io/server/db"
+	"goshawkdb.io/server/paxos"
+	eng "goshawkdb.io/server/txnengine"
+	"log"
+	"sync"
+	"time"
+)
+
+// Collector is the cluster-wide mark and sweep garbage collector. The
+// RM with the lowest RMId in the topology coordinates each cycle, and
+// every RM (the coordinator included) takes part:
+//
+// 1. The coordinator sends GcStart to every RM. Each RM starts its
+// write barrier (see eng.GCBarrier), waits server.GcSettleDelay for
+// txns already in flight to reach disk, and then sends its roots to
+// the coordinator: every var known to the clients connected to it,
+// every var held by its local connection (see
+// client.LocalConnection.HoldRoots), and whatever the barrier has
+// recorded so far.
+//
+// 2. The coordinator marks the roots of the topology and the roots it
+// has been sent, and traces from them in batches of
+// server.GcBatchVarCount, one batch every server.GcBatchDelay. Each
+// batch is sent to every RM in a GcTrace, and each RM replies with
+// the references of those vars it has on disk.
+//
+// 3. Once there is nothing left to trace, the coordinator sends
+// GcFlush, and each RM replies with what its barrier has recorded
+// since. Tracing continues until a flush finds nothing new.
+//
+// 4. The coordinator then sends GcSweep. Each RM works through the
+// vars on its disk, in rate limited batches, sending them to the
+// coordinator as candidates, and the coordinator replies with those
+// which are not marked. Each RM deletes those vars, and their write
+// txns, unless its barrier has recorded them (they have just been
+// created) or they are active, in which case they cannot be garbage.
+// In a dry run, nothing is deleted and the RMs just count.
+//
+// Anything reachable when the cycle starts, known to a connected
+// client, held by a local connection, or created during the cycle is
+// thus never deleted. Any
+// topology change, or the loss of a connection, abandons the cycle.
+//
+// A client can only name the vars its connection has seen (see
+// client.ClientTxnSubmitter.CachedVars), so a client which reconnects
+// cannot use references it held from before. Txns which reach a var
+// whilst it is being deleted wait for the deletion to finish (see
+// eng.VarManager.DeleteGarbage), and anti-entropy does not restore a
+// var which has just been deleted (see eng.GCBarrier).
+//
+// Limitations: the coordinator holds the marked set in memory.
+// Tombstones are not persisted, so if a node restarts after deleting
+// a var which another replica has yet to delete, anti-entropy may
+// restore it; the next cycle deletes it again.
+type Collector struct {
+	db                *db.Databases
+	connectionManager *ConnectionManager
+	topologyChan      chan *configuration.Topology
+	connsChan         chan map[common.RMId]paxos.Connection
+	receivedChan      chan func()
+	statusChan        chan *server.StatusConsumer
+	shutdownChan      chan struct{}
+	// everything below is only accessed from the collector's own
+	// go-routine.
+	interval    time.Duration
+	dryRun      bool
+	nextCycle   time.Time
+	topology    *configuration.Topology
+	conns       map[common.RMId]paxos.Connection
+	coordinator *gcCoordinator
+	participant *gcParticipant
+	cycles      uint64
+	lastReport  string
+	toSelf      [][]byte
+}
+
+type gcPhase uint8
+
+const (
+	gcRoots    gcPhase = iota
+	gcTracing  gcPhase = iota
+	gcFlushing gcPhase = iota
+	gcSweeping gcPhase = iota
+)
+
+func (p gcPhase) String() string {
+	switch p {
+	case gcRoots:
+		return "roots"
+	case gcTracing:
+		return "tracing"
+	case gcFlushing:
+		return "flushing"
+	case gcSweeping:
+		return "sweeping"
+	default:
+		return "unknown"
+	}
+}
+
+// gcCoordinator is the state of the cycle we are coordinating.
+type gcCoordinator struct {
+	cycle        uint64
+	version      uint32
+	dryRun       bool
+	phase        gcPhase
+	rmIds        []common.RMId
+	pending      map[common.RMId]server.EmptyStruct
+	marked       map[common.VarUUId]server.EmptyStruct
+	frontier     []*common.VarUUId
+	flushFound   bool
+	condemned    uint64
+	started      time.Time
+	lastProgress time.Time
+}
+
+// gcParticipant is the state of the cycle we are taking part in.
+type gcParticipant struct {
+	coordinator  common.RMId
+	cycle        uint64
+	version      uint32
+	dryRun       bool
+	sweeping     bool
+	position     []byte
+	awaiting     bool
+	condemned    uint64
+	lastProgress time.Time
+}
+
+var gcMetrics = expvar.NewMap("GC")
+
+func NewCollector(db *db.Databases, cm *ConnectionManager) *Collector {
+	return &Collector{
+		db:                db,
+		connectionManager: cm,
+		topologyChan:      make(chan *configuration.Topology, 1),
+		connsChan:         make(chan map[common.RMId]paxos.Connection, 1),
+		receivedChan:      make(chan func(), 16),
+		statusChan:        make(chan *server.StatusConsumer),
+		shutdownChan:      make(chan struct{}),
+	}
+}
+
+// Start must not be called from the connection manager's go-routine.
+func (c *Collector) Start() {
+	topology := c.connectionManager.AddTopologySubscriber(eng.CollectorSubscriber, c)
+	c.TopologyChanged(topology, func(bool) {})
+	c.connectionManager.AddServerConnectionSubscriber(c)
+	go c.loop()
+}
+
+func (c *Collector) Shutdown() {
+	select {
+	case <-c.shutdownChan:
+	default:
+		close(c.shutdownChan)
+		c.connectionManager.RemoveServerConnectionSubscriber(c)
+		c.connectionManager.RemoveTopologySubscriberAsync(eng.CollectorSubscriber, c)
+	}
+}
+
+// Schedule starts a cycle every interval (0 to never start cycles),
+// whenever we are the coordinator. In a dry run, the cycle reports
+// what it would delete but deletes nothing.
+func (c *Collector) Schedule(interval time.Duration, dryRun bool) {
+	c.enqueue(func() {
+		c.interval = interval
+		c.dryRun = dryRun
+		c.nextCycle = time.Now().Add(interval)
+	})
+}
+
+func (c *Collector) enqueue(f func()) {
+	select {
+	case c.receivedChan <- f:
+	case <-c.shutdownChan:
+	}
+}
+
+func (c *Collector) Status(sc *server.StatusConsumer) {
+	select {
+	case c.statusChan <- sc:
+	case <-c.shutdownChan:
+		sc.Join()
+	}
+}
+
+func (c *Collector) TopologyChanged(topology *configuration.Topology, done func(bool)) {
+	for {
+		select {
+		case c.topologyChan <- topology:
+			done(true)
+			return
+		default:
+			select {
+			case <-c.topologyChan:
+			default:
+			}
+		}
+	}
+}
+
+func (c *Collector) ConnectedRMs(conns map[common.RMId]paxos.Connection) {
+	c.publishConns(conns)
+}
+
+func (c *Collector) ConnectionLost(rmId common.RMId, conns map[common.RMId]paxos.Connection) {
+	c.publishConns(conns)
+}
+
+func (c *Collector) ConnectionEstablished(rmId common.RMId, conn paxos.Connection, conns map[common.RMId]paxos.Connection, done func()) {
+	defer done()
+	c.publishConns(conns)
+}
+
+func (c *Collector) publishConns(conns map[common.RMId]paxos.Connection) {
+	for {
+		select {
+		case c.connsChan <- conns:
+			return
+		default:
+			select {
+			case <-c.connsChan:
+			default:
+			}
+		}
+	}
+}
+
+// MessageReceived handles all the GC messages.
+func (c *Collector) MessageReceived(sender common.RMId, msg *msgs.Message) {
+	c.enqueue(func() { c.messageReceived(sender, msg) })
+}
+
+func (c *Collector) messageReceived(sender common.RMId, msg *msgs.Message) {
+	switch msg.Which() {
+	case msgs.MESSAGE_GCSTART:
+		start := msg.GcStart()
+		c.startReceived(sender, &start)
+	case msgs.MESSAGE_GCROOTS:
+		vars := msg.GcRoots()
+		c.rootsReceived(sender, &vars)
+	case msgs.MESSAGE_GCTRACE:
+		vars := msg.GcTrace()
+		c.traceReceived(sender, &vars)
+	case msgs.MESSAGE_GCREFERENCES:
+		vars := msg.GcReferences()
+		c.referencesReceived(sender, &vars)
+	case msgs.MESSAGE_GCFLUSH:
+		vars := msg.GcFlush()
+		c.flushReceived(sender, &vars)
+	case msgs.MESSAGE_GCSWEEP:
+		vars := msg.GcSweep()
+		c.sweepReceived(sender, &vars)
+	case msgs.MESSAGE_GCCANDIDATES:
+		vars := msg.GcCandidates()
+		c.candidatesReceived(sender, &vars)
+	case msgs.MESSAGE_GCCONDEMNED:
+		vars := msg.GcCondemned()
+		c.condemnedReceived(sender, &vars)
+	default:
+		panic(fmt.Sprintf("Unexpected GC message received from %v (%v)", sender, msg.Which()))
+	}
+}
+
+func (c *Collector) loop() {
+	ticker := time.NewTicker(server.GcBatchDelay)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.shutdownChan:
+			c.abandonParticipation("shutting down")
+			return
+		case topology := <-c.topologyChan:
+			c.setTopology(topology)
+		case conns := <-c.connsChan:
+			c.setConns(conns)
+		case f := <-c.receivedChan:
+			f()
+		case sc := <-c.statusChan:
+			c.status(sc)
+		case now := <-ticker.C:
+			c.tick(now)
+		}
+		c.deliverToSelf()
+	}
+}
+
+// Messages we send to ourself are delivered from our own go-routine,
+// in order, once we have finished with the current event. Going via
+// the connection manager would deadlock if receivedChan were full.
+func (c *Collector) deliverToSelf() {
+	selfRMId := c.connectionManager.RMId
+	for len(c.toSelf) != 0 {
+		bites := c.toSelf[0]
+		c.toSelf = c.toSelf[1:]
+		seg, _, err := capn.ReadFromMemoryZeroCopy(bites)
+		server.CheckFatal(err)
+		msg := msgs.ReadRootMessage(seg)
+		c.messageReceived(selfRMId, &msg)
+	}
+}
+
+func (c *Collector) setTopology(topology *configuration.Topology) {
+	c.topology = topology
+	c.abandonCoordination("topology changed")
+	c.abandonParticipation("topology changed")
+}
+
+func (c *Collector) setConns(conns map[common.RMId]paxos.Connection) {
+	c.conns = conns
+	if co := c.coordinator; co != nil {
+		for _, rmId := range co.rmIds {
+			if _, found := conns[rmId]; !found && rmId != c.connectionManager.RMId {
+				c.abandonCoordination(fmt.Sprintf("lost connection to %v", rmId))
+				break
+			}
+		}
+	}
+	if p := c.participant; p != nil {
+		if _, found := conns[p.coordinator]; !found && p.coordinator != c.connectionManager.RMId {
+			c.abandonParticipation(fmt.Sprintf("lost connection to coordinator %v", p.coordinator))
+		}
+	}
+}
+
+func (c *Collector) tick(now time.Time) {
+	if co := c.coordinator; co != nil {
+		if now.Sub(co.lastProgress) > server.GcStallTimeout {
+			c.abandonCoordination("stalled")
+		} else if co.phase == gcTracing && len(co.pending) == 0 {
+			c.traceBatch()
+		}
+	} else if c.interval > 0 && now.After(c.nextCycle) {
+		c.nextCycle = now.Add(c.interval)
+		c.startCycle()
+	}
+	if p := c.participant; p != nil {
+		if now.Sub(p.lastProgress) > server.GcStallTimeout {
+			c.abandonParticipation("stalled")
+		} else if p.sweeping && !p.awaiting {
+			c.sweepBatch()
+		}
+	}
+}
+
+func (c *Collector) send(rmId common.RMId, msg []byte) {
+	if rmId == c.connectionManager.RMId {
+		c.toSelf = append(c.toSelf, msg)
+	} else if conn, found := c.conns[rmId]; found {
+		conn.Send(msg)
+	}
+}
+
+// coordinator
+
+func (c *Collector) isCoordinator() bool {
+	if c.topology == nil || c.topology.IsBlank() || c.topology.Next() != nil {
+		return false
+	}
+	selfRMId := c.connectionManager.RMId
+	for _, rmId := range c.topology.RMs().NonEmpty() {
+		if rmId < selfRMId {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Collector) startCycle() {
+	if !c.isCoordinator() {
+		return
+	}
+	rmIds := c.topology.RMs().NonEmpty()
+	for _, rmId := range rmIds {
+		if _, found := c.conns[rmId]; !found && rmId != c.connectionManager.RMId {
+			log.Printf("GC: not starting cycle as %v is not connected.\n", rmId)
+			return
+		}
+	}
+	now := time.Now()
+	co := &gcCoordinator{
+		cycle:        uint64(now.UnixNano()),
+		version:      c.topology.Version,
+		dryRun:       c.dryRun,
+		phase:        gcRoots,
+		rmIds:        rmIds,
+		marked:       make(map[common.VarUUId]server.EmptyStruct),
+		started:      now,
+		lastProgress: now,
+	}
+	c.coordinator = co
+	c.cycles++
+	gcMetrics.Add("Cycles", 1)
+	co.mark(configuration.TopologyVarUUId)
+	for _, root := range c.topology.Roots {
+		co.mark(root.VarUUId)
+	}
+	log.Printf("GC: starting cycle %v (dry run: %v).\n", co.cycle, co.dryRun)
+
+	seg := capn.NewBuffer(nil)
+	msg := msgs.NewRootMessage(seg)
+	start := msgs.NewGcStart(seg)
+	start.SetCycle(co.cycle)
+	start.SetVersion(co.version)
+	start.SetDryRun(co.dryRun)
+	msg.SetGcStart(start)
+	c.sendToAll(server.SegToBytes(seg))
+}
+
+func (co *gcCoordinator) mark(vUUId *common.VarUUId) bool {
+	if _, found := co.marked[*vUUId]; found {
+		return false
+	}
+	co.marked[*vUUId] = server.EmptyStructVal
+	co.frontier = append(co.frontier, vUUId)
+	return true
+}
+
+// current returns the coordinator iff vars belong to the cycle it is
+// coordinating.
+func (c *Collector) current(vars *msgs.GcVars) *gcCoordinator {
+	if co := c.coordinator; co != nil && co.cycle == vars.Cycle() && co.version == vars.Version() {
+		co.lastProgress = time.Now()
+		return co
+	}
+	return nil
+}
+
+func (c *Collector) sendToAll(msg []byte) {
+	co := c.coordinator
+	co.pending = make(map[common.RMId]server.EmptyStruct, len(co.rmIds))
+	for _, rmId := range co.rmIds {
+		co.pending[rmId] = server.EmptyStructVal
+		c.send(rmId, msg)
+	}
+}
+
+func (c *Collector) rootsReceived(sender common.RMId, vars *msgs.GcVars) {
+	co := c.current(vars)
+	if co == nil || (co.phase != gcRoots && co.phase != gcFlushing) {
+		return
+	}
+	vUUIds := vars.Vars()
+	for idx, l := 0, vUUIds.Len(); idx < l; idx++ {
+		if co.mark(common.MakeVarUUId(vUUIds.At(idx))) {
+			co.flushFound = true
+		}
+	}
+	if !vars.Last() {
+		return
+	}
+	delete(co.pending, sender)
+	if len(co.pending) != 0 {
+		return
+	}
+	if co.phase == gcRoots || co.flushFound || len(co.frontier) != 0 {
+		co.phase = gcTracing
+	} else {
+		c.startSweep()
+	}
+}
+
+func (c *Collector) traceBatch() {
+	co := c.coordinator
+	if len(co.frontier) == 0 {
+		co.phase = gcFlushing
+		co.flushFound = false
+		c.sendToAll(gcVarsMsg(msgs.Message.SetGcFlush, co.cycle, co.version, nil, true))
+		return
+	}
+	batch := co.frontier
+	if len(batch) > server.GcBatchVarCount {
+		batch = batch[:server.GcBatchVarCount]
+	}
+	co.frontier = co.frontier[len(batch):]
+	gcMetrics.Add("VarsTraced", int64(len(batch)))
+	c.sendToAll(gcVarsMsg(msgs.Message.SetGcTrace, co.cycle, co.version, batch, true))
+}
+
+func (c *Collector) referencesReceived(sender common.RMId, vars *msgs.GcVars) {
+	co := c.current(vars)
+	if co == nil || co.phase != gcTracing {
+		return
+	}
+	vUUIds := vars.Vars()
+	for idx, l := 0, vUUIds.Len(); idx < l; idx++ {
+		co.mark(common.MakeVarUUId(vUUIds.At(idx)))
+	}
+	delete(co.pending, sender)
+}
+
+func (c *Collector) startSweep() {
+	co := c.coordinator
+	co.phase = gcSweeping
+	gcMetrics.Add("VarsMarked", int64(len(co.marked)))
+	server.Log("GC: cycle", co.cycle, "marked", len(co.marked), "vars; sweeping")
+	c.sendToAll(gcVarsMsg(msgs.Message.SetGcSweep, co.cycle, co.version, nil, true))
+}
+
+func (c *Collector) candidatesReceived(sender common.RMId, vars *msgs.GcVars) {
+	co := c.current(vars)
+	if co == nil || co.phase != gcSweeping {
+		return
+	}
+	vUUIds := vars.Vars()
+	var condemned []*common.VarUUId
+	for idx, l := 0, vUUIds.Len(); idx < l; idx++ {
+		vUUId := common.MakeVarUUId(vUUIds.At(idx))
+		if _, found := co.marked[*vUUId]; !found {
+			condemned = append(condemned, vUUId)
+		}
+	}
+	co.condemned += uint64(len(condemned))
+	c.send(sender, gcVarsMsg(msgs.Message.SetGcCondemned, co.cycle, co.version, condemned, vars.Last()))
+	if !vars.Last() {
+		return
+	}
+	delete(co.pending, sender)
+	if len(co.pending) == 0 {
+		c.lastReport = fmt.Sprintf("cycle %v: %v vars reachable; %v unreachable var replicas found (dry run: %v) in %v",
+			co.cycle, len(co.marked), co.condemned, co.dryRun, time.Since(co.started))
+		log.Printf("GC: %v.\n", c.lastReport)
+		c.coordinator = nil
+	}
+}
+
+func (c *Collector) abandonCoordination(reason string) {
+	if co := c.coordinator; co != nil {
+		log.Printf("GC: abandoning cycle %v whilst %v: %v.\n", co.cycle, co.phase, reason)
+		gcMetrics.Add("CyclesAbandoned", 1)
+		c.coordinator = nil
+	}
+}
+
+// participant
+
+// participating returns the participant iff vars belong to the cycle
+// we are taking part in.
+func (c *Collector) participating(sender common.RMId, vars *msgs.GcVars) *gcParticipant {
+	if p := c.participant; p != nil && p.coordinator == sender && p.cycle == vars.Cycle() && p.version == vars.Version() {
+		p.lastProgress = time.Now()
+		return p
+	}
+	return nil
+}
+
+func (c *Collector) startReceived(sender common.RMId, start *msgs.GcStart) {
+	if c.topology == nil || start.Version() != c.topology.Version {
+		return
+	}
+	c.abandonParticipation("superseded")
+	p := &gcParticipant{
+		coordinator:  sender,
+		cycle:        start.Cycle(),
+		version:      start.Version(),
+		dryRun:       start.DryRun(),
+		lastProgress: time.Now(),
+	}
+	c.participant = p
+	c.connectionManager.Dispatchers.VarDispatcher.Barrier.Start()
+	time.AfterFunc(server.GcSettleDelay, func() {
+		c.enqueue(func() {
+			if c.participant == p {
+				c.gatherRoots(p)
+			}
+		})
+	})
+}
+
+// gatherRoots asks every client connection for the vars its client
+// knows of, and adds the vars held by the local connection. If any
+// fails to answer in time, we abandon the cycle
+// rather than risk missing a root.
+func (c *Collector) gatherRoots(p *gcParticipant) {
+	var clients []*Connection
+	c.connectionManager.RLock()
+	for _, conn := range c.connectionManager.connCountToClient {
+		if client, ok := conn.(*Connection); ok {
+			clients = append(clients, client)
+		}
+	}
+	c.connectionManager.RUnlock()
+
+	var (
+		lock   sync.Mutex
+		roots  []*common.VarUUId
+		wg     sync.WaitGroup
+		waited = make(chan struct{})
+	)
+	wg.Add(len(clients))
+	for _, client := range clients {
+		client.CachedVars(func(vUUIds []*common.VarUUId) {
+			lock.Lock()
+			roots = append(roots, vUUIds...)
+			lock.Unlock()
+			wg.Done()
+		})
+	}
+	go func() {
+		wg.Wait()
+		close(waited)
+	}()
+	go func() {
+		timer := time.NewTimer(server.GcStallTimeout)
+		defer timer.Stop()
+		select {
+		case <-waited:
+			c.enqueue(func() {
+				if c.participant == p {
+					lock.Lock()
+					defer lock.Unlock()
+					roots = append(roots, c.connectionManager.LocalConnection.HeldRoots()...)
+					roots = append(roots, c.connectionManager.Dispatchers.VarDispatcher.Barrier.Take()...)
+					c.sendVars(p, msgs.Message.SetGcRoots, roots)
+				}
+			})
+		case <-timer.C:
+			c.enqueue(func() {
+				if c.participant == p {
+					c.abandonParticipation("client connections did not report their vars")
+				}
+			})
+		case <-c.shutdownChan:
+		}
+	}()
+}
+
+// sendVars sends vUUIds to the coordinator in batches, the last of
+// which is marked as such.
+func (c *Collector) sendVars(p *gcParticipant, setter func(msgs.Message, msgs.GcVars), vUUIds []*common.VarUUId) {
+	for {
+		batch := vUUIds
+		if len(batch) > server.GcBatchVarCount {
+			batch = batch[:server.GcBatchVarCount]
+		}
+		vUUIds = vUUIds[len(batch):]
+		c.send(p.coordinator, gcVarsMsg(setter, p.cycle, p.version, batch, len(vUUIds) == 0))
+		if len(vUUIds) == 0 {
+			return
+		}
+	}
+}
+
+func (c *Collector) flushReceived(sender common.RMId, vars *msgs.GcVars) {
+	if p := c.participating(sender, vars); p != nil {
+		c.sendVars(p, msgs.Message.SetGcRoots, c.connectionManager.Dispatchers.VarDispatcher.Barrier.Take())
+	}
+}
+
+func (c *Collector) traceReceived(sender common.RMId, vars *msgs.GcVars) {
+	p := c.participating(sender, vars)
+	if p == nil {
+		return
+	}
+	vUUIds := vars.Vars()
+	result, err := c.db.ReadonlyTransaction(func(rtxn db.RTxn) interface{} {
+		var references []*common.VarUUId
+		for idx, l := 0, vUUIds.Len(); idx < l; idx++ {
+			vUUId := common.MakeVarUUId(vUUIds.At(idx))
+			refs, err := eng.ReadReferences(rtxn, c.db, vUUId)
+			if err != nil {
+				rtxn.Error(err)
+				return nil
+			}
+			references = append(references, refs...)
+		}
+		return references
+	}).ResultError()
+	if err != nil {
+		// We must not pretend the var has no references.
+		c.abandonParticipation(fmt.Sprintf("error when tracing: %v", err))
+		return
+	} else if result == nil { // shutdown
+		return
+	}
+	c.send(p.coordinator, gcVarsMsg(msgs.Message.SetGcReferences, p.cycle, p.version, result.([]*common.VarUUId), true))
+}
+
+func (c *Collector) sweepReceived(sender common.RMId, vars *msgs.GcVars) {
+	if p := c.participating(sender, vars); p != nil {
+		p.sweeping = true
+	}
+}
+
+// sweepBatch sends the next batch of vars on disk to the coordinator,
+// which replies with those which are garbage.
+func (c *Collector) sweepBatch() {
+	p := c.participant
+	var candidates []*common.VarUUId
+	var next []byte
+	_, err := c.db.ReadonlyTransaction(func(rtxn db.RTxn) interface{} {
+		rtxn.WithCursor(db.Vars, func(cursor db.Cursor) interface{} {
+			var vUUIdBytes []byte
+			var err error
+			if p.position == nil {
+				vUUIdBytes, _, err = cursor.First()
+			} else {
+				vUUIdBytes, _, err = cursor.Seek(p.position)
+			}
+			for ; err == nil && len(candidates) < server.GcBatchVarCount; vUUIdBytes, _, err = cursor.Next() {
+				candidates = append(candidates, common.MakeVarUUId(vUUIdBytes))
+			}
+			if err == nil {
+				next = append([]byte(nil), vUUIdBytes...)
+			} else if err != db.NotFound {
+				rtxn.Error(err)
+			}
+			return nil
+		})
+		return nil
+	}).ResultError()
+	if err != nil {
+		c.abandonParticipation(fmt.Sprintf("error when reading vars: %v", err))
+		return
+	}
+	p.position = next
+	p.awaiting = true
+	c.send(p.coordinator, gcVarsMsg(msgs.Message.SetGcCandidates, p.cycle, p.version, candidates, next == nil))
+}
+
+func (c *Collector) condemnedReceived(sender common.RMId, vars *msgs.GcVars) {
+	p := c.participating(sender, vars)
+	if p == nil || !p.awaiting {
+		return
+	}
+	p.awaiting = false
+	vd := c.connectionManager.Dispatchers.VarDispatcher
+	vUUIds := vars.Vars()
+	for idx, l := 0, vUUIds.Len(); idx < l; idx++ {
+		vUUId := common.MakeVarUUId(vUUIds.At(idx))
+		if vd.Barrier.Contains(vUUId) || vUUId.Compare(configuration.TopologyVarUUId) == common.EQ {
+			continue
+		}
+		p.condemned++
+		if p.dryRun {
+			server.Log("GC: would delete", vUUId)
+			continue
+		}
+		vd.DeleteGarbage(vUUId, func(deleted bool) {
+			if deleted {
+				gcMetrics.Add("VarsDeleted", 1)
+			}
+		})
+	}
+	if vars.Last() {
+		if p.dryRun {
+			log.Printf("GC: cycle %v: found %v unreachable vars here (dry run).\n", p.cycle, p.condemned)
+		} else {
+			log.Printf("GC: cycle %v: deleting %v unreachable vars here.\n", p.cycle, p.condemned)
+		}
+		gcMetrics.Add("VarsCondemned", int64(p.condemned))
+		c.participant = nil
+		vd.Barrier.Stop()
+	}
+}
+
+func (c *Collector) abandonParticipation(reason string) {
+	if p := c.participant; p != nil {
+		server.Log("GC: abandoning participation in cycle", p.cycle, "from", p.coordinator, ":", reason)
+		c.participant = nil
+		c.connectionManager.Dispatchers.VarDispatcher.Barrier.Stop()
+	}
+}
+
+func gcVarsMsg(setter func(msgs.Message, msgs.GcVars), cycle uint64, version uint32, vUUIds []*common.VarUUId, last bool) []byte {
+	seg := capn.NewBuffer(nil)
+	msg := msgs.NewRootMessage(seg)
+	gcVars := msgs.NewGcVars(seg)
+	gcVars.SetCycle(cycle)
+	gcVars.SetVersion(version)
+	list := seg.NewDataList(len(vUUIds))
+	for idx, vUUId := range vUUIds {
+		list.Set(idx, vUUId[:])
+	}
+	gcVars.SetVars(list)
+	gcVars.SetLast(last)
+	setter(msg, gcVars)
+	return server.SegToBytes(seg)
+}
+
+func (c *Collector) status(sc *server.StatusConsumer) {
+	sc.Emit("Garbage Collector")
+	sc.Emit(fmt.Sprintf("- Interval: %v (dry run: %v)", c.interval, c.dryRun))
+	sc.Emit(fmt.Sprintf("- Cycles started here: %v", c.cycles))
+	if co := c.coordinator; co != nil {
+		sc.Emit(fmt.Sprintf("- Coordinating cycle %v: %v; %v vars marked; %v to trace", co.cycle, co.phase, len(co.marked), len(co.frontier)))
+	}
+	if p := c.participant; p != nil {
+		sc.Emit(fmt.Sprintf("- Taking part in cycle %v from %v; sweeping? %v", p.cycle, p.coordinator, p.sweeping))
+	}
+	if c.lastReport != "" {
+		sc.Emit(fmt.Sprintf("- Last completed: %v", c.lastReport))
+	}
+	sc.Join()
+}
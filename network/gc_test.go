@@ -0,0 +1,218 @@
+package network
+
+import (
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/configuration"
+	"goshawkdb.io/server/paxos"
+	eng "goshawkdb.io/server/txnengine"
+	"testing"
+	"time"
+)
+
+type gcTestConn struct {
+	rmId common.RMId
+	sent []msgs.Message
+}
+
+func (conn *gcTestConn) Host() string        { return "" }
+func (conn *gcTestConn) RMId() common.RMId   { return conn.rmId }
+func (conn *gcTestConn) BootCount() uint32   { return 1 }
+func (conn *gcTestConn) TieBreak() uint32    { return 0 }
+func (conn *gcTestConn) ClusterUUId() uint64 { return 0 }
+
+func (conn *gcTestConn) Send(bites []byte) {
+	seg, _, err := capn.ReadFromMemoryZeroCopy(bites)
+	if err != nil {
+		panic(err)
+	}
+	conn.sent = append(conn.sent, msgs.ReadRootMessage(seg))
+}
+
+func (conn *gcTestConn) last(t *testing.T, which msgs.Message_Which) msgs.Message {
+	if len(conn.sent) == 0 {
+		t.Fatalf("Expected %v to have been sent to %v", which, conn.rmId)
+	}
+	msg := conn.sent[len(conn.sent)-1]
+	if msg.Which() != which {
+		t.Fatalf("Expected %v to have been sent to %v; got %v", which, conn.rmId, msg.Which())
+	}
+	return msg
+}
+
+func gcTestVarUUId(n byte) *common.VarUUId {
+	vUUId := common.MakeVarUUId(make([]byte, common.KeyLen))
+	vUUId[0] = n
+	return vUUId
+}
+
+func gcTestVars(setter func(msgs.Message, msgs.GcVars), cycle uint64, vUUIds []*common.VarUUId, last bool) *msgs.GcVars {
+	seg, _, err := capn.ReadFromMemoryZeroCopy(gcVarsMsg(setter, cycle, 1, vUUIds, last))
+	if err != nil {
+		panic(err)
+	}
+	msg := msgs.ReadRootMessage(seg)
+	var vars msgs.GcVars
+	switch msg.Which() {
+	case msgs.MESSAGE_GCROOTS:
+		vars = msg.GcRoots()
+	case msgs.MESSAGE_GCREFERENCES:
+		vars = msg.GcReferences()
+	case msgs.MESSAGE_GCCANDIDATES:
+		vars = msg.GcCandidates()
+	case msgs.MESSAGE_GCCONDEMNED:
+		vars = msg.GcCondemned()
+	}
+	return &vars
+}
+
+func gcVarsOf(vars msgs.GcVars) []*common.VarUUId {
+	list := vars.Vars()
+	vUUIds := make([]*common.VarUUId, list.Len())
+	for idx := range vUUIds {
+		vUUIds[idx] = common.MakeVarUUId(list.At(idx))
+	}
+	return vUUIds
+}
+
+// newTestCollector returns a collector on RM 1 connected to RMs 2
+// and 3.
+func newTestCollector() (*Collector, *gcTestConn, *gcTestConn) {
+	two, three := &gcTestConn{rmId: 2}, &gcTestConn{rmId: 3}
+	c := &Collector{
+		connectionManager: &ConnectionManager{
+			RMId:        1,
+			Dispatchers: &paxos.Dispatchers{VarDispatcher: &eng.VarDispatcher{Barrier: eng.NewGCBarrier()}},
+		},
+		conns: map[common.RMId]paxos.Connection{2: two, 3: three},
+	}
+	return c, two, three
+}
+
+// The coordinator marks everything reachable from the roots it is
+// sent, including vars which the barriers report whilst flushing, and
+// condemns only what is left.
+func TestGCTraceAndSweep(t *testing.T) {
+	const cycle = 7
+	root, a, b, garbage, clientRoot, created := gcTestVarUUId(1), gcTestVarUUId(2), gcTestVarUUId(3), gcTestVarUUId(4), gcTestVarUUId(5), gcTestVarUUId(6)
+	// What RM 2 has on disk. RM 3 has none of it.
+	references := map[common.VarUUId][]*common.VarUUId{
+		*root:    {a},
+		*a:       {b},
+		*garbage: {a},
+	}
+
+	c, two, three := newTestCollector()
+	co := &gcCoordinator{
+		cycle:        cycle,
+		version:      1,
+		phase:        gcRoots,
+		rmIds:        []common.RMId{2, 3},
+		pending:      map[common.RMId]server.EmptyStruct{2: server.EmptyStructVal, 3: server.EmptyStructVal},
+		marked:       make(map[common.VarUUId]server.EmptyStruct),
+		started:      time.Now(),
+		lastProgress: time.Now(),
+	}
+	c.coordinator = co
+	co.mark(root)
+
+	// Roots from some other cycle are ignored.
+	c.rootsReceived(2, gcTestVars(msgs.Message.SetGcRoots, cycle+1, []*common.VarUUId{garbage}, true))
+	c.rootsReceived(2, gcTestVars(msgs.Message.SetGcRoots, cycle, nil, true))
+	c.rootsReceived(3, gcTestVars(msgs.Message.SetGcRoots, cycle, []*common.VarUUId{clientRoot}, true))
+	if co.phase != gcTracing {
+		t.Fatalf("Expected to be tracing; got %v", co.phase)
+	}
+
+	flushes := 0
+	for co.phase != gcSweeping {
+		switch co.phase {
+		case gcTracing:
+			c.traceBatch()
+			if co.phase == gcFlushing {
+				continue
+			}
+			var refs []*common.VarUUId
+			for _, vUUId := range gcVarsOf(two.last(t, msgs.MESSAGE_GCTRACE).GcTrace()) {
+				refs = append(refs, references[*vUUId]...)
+			}
+			c.referencesReceived(2, gcTestVars(msgs.Message.SetGcReferences, cycle, refs, true))
+			c.referencesReceived(3, gcTestVars(msgs.Message.SetGcReferences, cycle, nil, true))
+		case gcFlushing:
+			two.last(t, msgs.MESSAGE_GCFLUSH)
+			three.last(t, msgs.MESSAGE_GCFLUSH)
+			flushes++
+			var barrier []*common.VarUUId
+			if flushes == 1 {
+				// created during the cycle
+				barrier = []*common.VarUUId{created}
+			}
+			c.rootsReceived(2, gcTestVars(msgs.Message.SetGcRoots, cycle, barrier, true))
+			c.rootsReceived(3, gcTestVars(msgs.Message.SetGcRoots, cycle, nil, true))
+		default:
+			t.Fatalf("Unexpected phase %v", co.phase)
+		}
+	}
+	if flushes != 2 {
+		t.Fatalf("Expected to flush until nothing new was found; flushed %v times", flushes)
+	}
+	two.last(t, msgs.MESSAGE_GCSWEEP)
+	three.last(t, msgs.MESSAGE_GCSWEEP)
+
+	for _, vUUId := range []*common.VarUUId{root, a, b, clientRoot, created} {
+		if _, found := co.marked[*vUUId]; !found {
+			t.Fatalf("Expected %v to be marked", vUUId)
+		}
+	}
+
+	c.candidatesReceived(2, gcTestVars(msgs.Message.SetGcCandidates, cycle, []*common.VarUUId{root, a, b, garbage}, true))
+	condemned := gcVarsOf(two.last(t, msgs.MESSAGE_GCCONDEMNED).GcCondemned())
+	if len(condemned) != 1 || condemned[0].Compare(garbage) != common.EQ {
+		t.Fatalf("Expected only %v to be condemned; got %v", garbage, condemned)
+	}
+	c.candidatesReceived(3, gcTestVars(msgs.Message.SetGcCandidates, cycle, []*common.VarUUId{clientRoot, created}, true))
+	if condemned = gcVarsOf(three.last(t, msgs.MESSAGE_GCCONDEMNED).GcCondemned()); len(condemned) != 0 {
+		t.Fatalf("Expected nothing to be condemned; got %v", condemned)
+	}
+	if c.coordinator != nil || c.lastReport == "" {
+		t.Fatal("Expected the cycle to have completed")
+	}
+}
+
+// In a dry run, a participant counts what is condemned but deletes
+// nothing. The collector has no var managers, so any deletion would
+// panic.
+func TestGCDryRun(t *testing.T) {
+	const cycle = 7
+	c, _, _ := newTestCollector()
+	barrier := c.connectionManager.Dispatchers.VarDispatcher.Barrier
+	barrier.Start()
+	p := &gcParticipant{
+		coordinator:  2,
+		cycle:        cycle,
+		version:      1,
+		dryRun:       true,
+		sweeping:     true,
+		awaiting:     true,
+		lastProgress: time.Now(),
+	}
+	c.participant = p
+
+	condemned := []*common.VarUUId{gcTestVarUUId(1), configuration.TopologyVarUUId, gcTestVarUUId(2)}
+	// Only the coordinator is listened to.
+	c.condemnedReceived(3, gcTestVars(msgs.Message.SetGcCondemned, cycle, condemned, true))
+	if p.condemned != 0 || c.participant != p {
+		t.Fatal("Expected condemnation from a non-coordinator to be ignored")
+	}
+
+	c.condemnedReceived(2, gcTestVars(msgs.Message.SetGcCondemned, cycle, condemned, true))
+	// The topology var is never condemned.
+	if p.condemned != 2 {
+		t.Fatalf("Expected 2 vars to be condemned; got %v", p.condemned)
+	}
+	if c.participant != nil {
+		t.Fatal("Expected the cycle to have completed")
+	}
+}
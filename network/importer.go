@@ -0,0 +1,425 @@
+package network
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/client"
+	"goshawkdb.io/server/configuration"
+	"goshawkdb.io/server/export"
+	eng "goshawkdb.io/server/txnengine"
+	"io"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// Importer loads an export stream into the cluster. Every object is
+// created afresh, so every object gets a new VarUUId from the local
+// connection's namespace: VarUUIds from another cluster could
+// otherwise collide with VarUUIds already in use here. Objects are
+// created in batches of server.ImportBatchObjectCount per
+// transaction. Objects that reference objects that appear later in
+// the stream (i.e. cycles) are created without those references and
+// then rewritten with their full references once everything has been
+// created. Finally, references to the exported roots are appended to
+// the references of the named root. Until then, every object created
+// is held as a root (see client.LocalConnection.HoldRoots) so that
+// the garbage collector does not delete it.
+type Importer struct {
+	connectionManager *ConnectionManager
+	localConnection   *client.LocalConnection
+	reader            *export.Reader
+	rootName          string
+	topologyChan      chan *configuration.Topology
+	backoff           *server.BinaryBackoffEngine
+	imported          map[string]*importedVar
+	deferred          []*export.Object
+	created           int
+	releases          []func()
+}
+
+type importedVar struct {
+	vUUId     *common.VarUUId
+	positions *common.Positions
+}
+
+var errImportShutdown = errors.New("Import abandoned due to shutdown")
+
+func NewImporter(cm *ConnectionManager, reader *export.Reader, rootName string) *Importer {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return &Importer{
+		connectionManager: cm,
+		localConnection:   cm.LocalConnection,
+		reader:            reader,
+		rootName:          rootName,
+		topologyChan:      make(chan *configuration.Topology, 1),
+		backoff:           server.NewBinaryBackoffEngine(rng, server.SubmissionMinSubmitDelay, server.SubmissionMaxSubmitDelay),
+		imported:          make(map[string]*importedVar),
+	}
+}
+
+func (i *Importer) TopologyChanged(topology *configuration.Topology, done func(bool)) {
+	i.publishTopology(topology)
+	done(true)
+}
+
+func (i *Importer) publishTopology(topology *configuration.Topology) {
+	for {
+		select {
+		case i.topologyChan <- topology:
+			return
+		default:
+			select {
+			case <-i.topologyChan:
+			default:
+			}
+		}
+	}
+}
+
+// Created returns the hex encoded VarUUIds of every object created so
+// far. Until the import has completed, none of them are reachable from
+// any root, though they are held from the garbage collector.
+func (i *Importer) Created() []string {
+	created := make([]string, 0, len(i.imported))
+	for _, iv := range i.imported {
+		created = append(created, hex.EncodeToString(iv.vUUId[:]))
+	}
+	return created
+}
+
+// Run blocks until the import has completed or failed.
+func (i *Importer) Run() error {
+	topology := i.connectionManager.AddTopologySubscriber(eng.ImporterSubscriber, i)
+	defer i.connectionManager.RemoveTopologySubscriberAsync(eng.ImporterSubscriber, i)
+	i.publishTopology(topology)
+	defer i.releaseRoots()
+
+	root, err := i.awaitRoot()
+	if err != nil {
+		return err
+	}
+	log.Printf("Import: importing into root %v (%v).\n", i.rootName, root.VarUUId)
+
+	batch := make([]*export.Object, 0, server.ImportBatchObjectCount)
+	for {
+		obj, err := i.reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		batch = append(batch, obj)
+		if len(batch) == cap(batch) {
+			if err = i.createBatch(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) != 0 {
+		if err = i.createBatch(batch); err != nil {
+			return err
+		}
+	}
+
+	if err = i.writeDeferred(); err != nil {
+		return err
+	}
+	if err = i.attachToRoot(root); err != nil {
+		return err
+	}
+	log.Printf("Import: complete. %v objects created under root %v.\n", i.created, i.rootName)
+	return nil
+}
+
+func (i *Importer) awaitRoot() (*configuration.Root, error) {
+	for {
+		topology := <-i.topologyChan
+		if topology == nil || topology.IsBlank() || topology.Next() != nil || len(topology.Roots) == 0 {
+			continue
+		}
+		for idx, name := range topology.RootNames() {
+			if name == i.rootName {
+				return &topology.Roots[idx], nil
+			}
+		}
+		return nil, fmt.Errorf("Import: root %v does not exist in the current configuration", i.rootName)
+	}
+}
+
+func (i *Importer) createBatch(objs []*export.Object) error {
+	for {
+		seg := capn.NewBuffer(nil)
+		ctxn := cmsgs.NewClientTxn(seg)
+		ctxn.SetRetry(false)
+		actions := cmsgs.NewClientActionList(seg, len(objs))
+		ctxn.SetActions(actions)
+
+		batchIds := make(map[string]*common.VarUUId, len(objs))
+		vUUIds := make([]*common.VarUUId, 0, len(objs))
+		for _, obj := range objs {
+			vUUId := i.localConnection.NextVarUUId()
+			batchIds[obj.VarUUId] = vUUId
+			vUUIds = append(vUUIds, vUUId)
+		}
+		i.releases = append(i.releases, i.localConnection.HoldRoots(vUUIds...))
+		varPosMap := make(map[common.VarUUId]*common.Positions)
+		deferred := []*export.Object{}
+
+		for idx, obj := range objs {
+			vUUId := batchIds[obj.VarUUId]
+			action := actions.At(idx)
+			action.SetVarId(vUUId[:])
+			action.SetCreate()
+			create := action.Create()
+			create.SetValue(obj.Value)
+			refs, complete, err := i.references(seg, obj, batchIds, varPosMap)
+			if err != nil {
+				return err
+			}
+			create.SetReferences(refs)
+			if !complete {
+				deferred = append(deferred, obj)
+			}
+		}
+
+		txnReader, outcome, retry, err := i.run(&ctxn, varPosMap)
+		if err != nil {
+			return err
+		} else if retry {
+			continue
+		} else if outcome.Which() != msgs.OUTCOME_COMMIT {
+			return fmt.Errorf("Import: internal error: creation of objects gave rerun outcome")
+		}
+
+		committed := txnReader.Actions(true).Actions()
+		for idx, obj := range objs {
+			action := committed.At(idx)
+			vUUId := common.MakeVarUUId(action.VarId())
+			if expected := batchIds[obj.VarUUId]; vUUId.Compare(expected) != common.EQ {
+				return fmt.Errorf("Import: internal error: actions changed order! At %v expecting %v, found %v", idx, expected, vUUId)
+			}
+			if action.Which() != msgs.ACTION_CREATE {
+				return fmt.Errorf("Import: internal error: actions changed type! At %v expecting create, found %v", idx, action.Which())
+			}
+			positions := action.Create().Positions()
+			i.imported[obj.VarUUId] = &importedVar{
+				vUUId:     vUUId,
+				positions: (*common.Positions)(&positions),
+			}
+		}
+		i.deferred = append(i.deferred, deferred...)
+		i.created += len(objs)
+		server.Log("Import: created", i.created)
+		return nil
+	}
+}
+
+// releaseRoots stops holding the objects created. Once the import has
+// completed they are reachable from the named root; if it has failed
+// they are garbage.
+func (i *Importer) releaseRoots() {
+	for _, release := range i.releases {
+		release()
+	}
+	i.releases = nil
+}
+
+// writeDeferred rewrites the objects that were created before some
+// of the objects they reference existed.
+func (i *Importer) writeDeferred() error {
+	for len(i.deferred) != 0 {
+		objs := i.deferred
+		if len(objs) > server.ImportBatchObjectCount {
+			objs = objs[:server.ImportBatchObjectCount]
+		}
+
+		seg := capn.NewBuffer(nil)
+		ctxn := cmsgs.NewClientTxn(seg)
+		ctxn.SetRetry(false)
+		actions := cmsgs.NewClientActionList(seg, len(objs))
+		ctxn.SetActions(actions)
+		varPosMap := make(map[common.VarUUId]*common.Positions)
+
+		for idx, obj := range objs {
+			iv := i.imported[obj.VarUUId]
+			varPosMap[*iv.vUUId] = iv.positions
+			action := actions.At(idx)
+			action.SetVarId(iv.vUUId[:])
+			action.SetWrite()
+			write := action.Write()
+			write.SetValue(obj.Value)
+			refs, complete, err := i.references(seg, obj, nil, varPosMap)
+			if err != nil {
+				return err
+			} else if !complete {
+				return fmt.Errorf("Import: object %v references objects not found in the export", obj.VarUUId)
+			}
+			write.SetReferences(refs)
+		}
+
+		_, outcome, retry, err := i.run(&ctxn, varPosMap)
+		if err != nil {
+			return err
+		} else if retry {
+			continue
+		} else if outcome.Which() != msgs.OUTCOME_COMMIT {
+			return fmt.Errorf("Import: internal error: rewriting of objects gave rerun outcome")
+		}
+		i.deferred = i.deferred[len(objs):]
+	}
+	return nil
+}
+
+// references translates the references of obj. Any references to
+// objects which are neither in batchIds nor already imported are
+// omitted, in which case complete is false.
+func (i *Importer) references(seg *capn.Segment, obj *export.Object, batchIds map[string]*common.VarUUId, varPosMap map[common.VarUUId]*common.Positions) (refs cmsgs.ClientVarIdPos_List, complete bool, err error) {
+	targets := make([]*common.VarUUId, len(obj.References))
+	found := 0
+	for idx, ref := range obj.References {
+		if vUUId, ok := batchIds[ref.VarUUId]; ok {
+			targets[idx] = vUUId
+			found++
+		} else if iv, ok := i.imported[ref.VarUUId]; ok {
+			targets[idx] = iv.vUUId
+			varPosMap[*iv.vUUId] = iv.positions
+			found++
+		}
+	}
+	refs = cmsgs.NewClientVarIdPosList(seg, found)
+	idy := 0
+	for idx, ref := range obj.References {
+		if targets[idx] == nil {
+			continue
+		}
+		varIdPos := refs.At(idy)
+		idy++
+		varIdPos.SetVarId(targets[idx][:])
+		capability := cmsgs.NewCapability(seg)
+		if err = export.SetCapability(capability, ref.Capability); err != nil {
+			return refs, false, err
+		}
+		varIdPos.SetCapability(capability)
+	}
+	return refs, found == len(obj.References), nil
+}
+
+// attachToRoot appends references to the exported roots to the
+// references of the named root. We discover the current value of the
+// root by reading it at version zero, which must abort with a rerun
+// containing the current value.
+func (i *Importer) attachToRoot(root *configuration.Root) error {
+	roots := make([]*importedVar, len(i.reader.Header.Roots))
+	for idx, exportRoot := range i.reader.Header.Roots {
+		iv, found := i.imported[exportRoot.VarUUId]
+		if !found {
+			return fmt.Errorf("Import: exported root %v (%v) not found in the export", exportRoot.Name, exportRoot.VarUUId)
+		}
+		roots[idx] = iv
+	}
+
+	version := common.VersionZero
+	var (
+		value []byte
+		refs  msgs.VarIdPos_List
+	)
+	for {
+		seg := capn.NewBuffer(nil)
+		ctxn := cmsgs.NewClientTxn(seg)
+		ctxn.SetRetry(false)
+		actions := cmsgs.NewClientActionList(seg, 1)
+		ctxn.SetActions(actions)
+		action := actions.At(0)
+		action.SetVarId(root.VarUUId[:])
+		varPosMap := map[common.VarUUId]*common.Positions{*root.VarUUId: root.Positions}
+
+		if version == common.VersionZero {
+			action.SetRead()
+			action.Read().SetVersion(version[:])
+		} else {
+			action.SetReadwrite()
+			rw := action.Readwrite()
+			rw.SetVersion(version[:])
+			rw.SetValue(value)
+			clientRefs := cmsgs.NewClientVarIdPosList(seg, refs.Len()+len(roots))
+			for idx, l := 0, refs.Len(); idx < l; idx++ {
+				ref := refs.At(idx)
+				clientRef := clientRefs.At(idx)
+				clientRef.SetVarId(ref.Id())
+				clientRef.SetCapability(ref.Capability())
+				positions := common.Positions(ref.Positions())
+				varPosMap[*common.MakeVarUUId(ref.Id())] = &positions
+			}
+			for idx, iv := range roots {
+				clientRef := clientRefs.At(refs.Len() + idx)
+				clientRef.SetVarId(iv.vUUId[:])
+				capability := cmsgs.NewCapability(seg)
+				capability.SetReadWrite()
+				clientRef.SetCapability(capability)
+				varPosMap[*iv.vUUId] = iv.positions
+			}
+			rw.SetReferences(clientRefs)
+		}
+
+		_, outcome, retry, err := i.run(&ctxn, varPosMap)
+		if err != nil {
+			return err
+		} else if retry {
+			continue
+		} else if outcome.Which() == msgs.OUTCOME_COMMIT {
+			if version == common.VersionZero {
+				return fmt.Errorf("Import: internal error: read of root at version zero failed to abort")
+			}
+			return nil
+		}
+
+		updates := outcome.Abort().Rerun()
+		found := false
+		for idx, l := 0, updates.Len(); idx < l && !found; idx++ {
+			update := updates.At(idx)
+			updateActions := eng.TxnActionsFromData(update.Actions(), true).Actions()
+			for idy, m := 0, updateActions.Len(); idy < m; idy++ {
+				updateAction := updateActions.At(idy)
+				if common.MakeVarUUId(updateAction.VarId()).Compare(root.VarUUId) != common.EQ {
+					continue
+				} else if updateAction.Which() != msgs.ACTION_WRITE {
+					return fmt.Errorf("Import: internal error: read of root gave non-write update")
+				}
+				write := updateAction.Write()
+				version = common.MakeTxnId(update.TxnId())
+				value = write.Value()
+				refs = write.References()
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("Import: internal error: unable to find update for root %v", root.VarUUId)
+		}
+	}
+}
+
+// run submits the txn. If retry is true, the txn should be rebuilt
+// and resubmitted.
+func (i *Importer) run(ctxn *cmsgs.ClientTxn, varPosMap map[common.VarUUId]*common.Positions) (txnReader *eng.TxnReader, outcome *msgs.Outcome, retry bool, err error) {
+	txnReader, outcome, err = i.localConnection.RunClientTransaction(ctxn, varPosMap, nil)
+	if err != nil {
+		return nil, nil, false, err
+	} else if outcome == nil {
+		return nil, nil, false, errImportShutdown
+	} else if outcome.Which() == msgs.OUTCOME_ABORT && outcome.Abort().Which() == msgs.OUTCOMEABORT_RESUBMIT {
+		i.backoff.Advance()
+		time.Sleep(i.backoff.Cur)
+		return nil, nil, true, nil
+	}
+	i.backoff.Shrink(0)
+	return txnReader, outcome, false, nil
+}
@@ -0,0 +1,107 @@
+package network
+
+import (
+	"bytes"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	"goshawkdb.io/server/export"
+	"testing"
+)
+
+// An object whose references are not yet imported is created without
+// them, and is complete once they have been imported.
+func TestImporterReferences(t *testing.T) {
+	// As the exporter writes a cycle: b is written before a, which it
+	// references.
+	objs := []*export.Object{
+		{VarUUId: "0b", References: []export.Reference{{VarUUId: "0a", Capability: export.CapabilityRead}}},
+		{VarUUId: "0a", References: []export.Reference{{VarUUId: "0b", Capability: export.CapabilityReadWrite}}},
+		{VarUUId: "01", References: []export.Reference{{VarUUId: "0a", Capability: export.CapabilityWrite}, {VarUUId: "0b", Capability: export.CapabilityNone}}},
+	}
+	buf := new(bytes.Buffer)
+	w, err := export.NewWriter(buf, &export.Header{Roots: []export.Root{{Name: "test", VarUUId: "01"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range objs {
+		if err = w.Write(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := export.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := &Importer{reader: reader, imported: make(map[string]*importedVar)}
+	translated := make(map[string]*common.VarUUId)
+	checkRefs := func(obj *export.Object, refs cmsgs.ClientVarIdPos_List, varPosMap map[common.VarUUId]*common.Positions) {
+		if refs.Len() != len(obj.References) {
+			t.Fatalf("Expected %v references from %v; got %v", len(obj.References), obj.VarUUId, refs.Len())
+		}
+		for idx, ref := range obj.References {
+			refCap := refs.At(idx)
+			if vUUId := common.MakeVarUUId(refCap.VarId()); vUUId.Compare(translated[ref.VarUUId]) != common.EQ {
+				t.Fatalf("Expected a reference to %v; got %v", translated[ref.VarUUId], vUUId)
+			} else if _, found := varPosMap[*vUUId]; !found {
+				t.Fatalf("Expected the positions of %v to be supplied", vUUId)
+			} else if capability := export.CapabilityName(refCap.Capability()); capability != ref.Capability {
+				t.Fatalf("Expected capability %v; got %v", ref.Capability, capability)
+			}
+		}
+	}
+
+	// One object per batch.
+	var deferred []*export.Object
+	for n := byte(1); ; n++ {
+		obj, err := reader.Read()
+		if err != nil {
+			break
+		}
+		seg := capn.NewBuffer(nil)
+		varPosMap := make(map[common.VarUUId]*common.Positions)
+		refs, complete, err := i.references(seg, obj, nil, varPosMap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if complete {
+			checkRefs(obj, refs, varPosMap)
+		} else {
+			deferred = append(deferred, obj)
+		}
+		vUUId := common.MakeVarUUId(make([]byte, common.KeyLen))
+		vUUId[0] = n
+		positions := common.Positions(seg.NewUInt8List(3))
+		i.imported[obj.VarUUId] = &importedVar{vUUId: vUUId, positions: &positions}
+		translated[obj.VarUUId] = vUUId
+	}
+	if len(i.imported) != len(objs) {
+		t.Fatalf("Expected %v objects to be imported; got %v", len(objs), len(i.imported))
+	}
+	if len(deferred) != 1 || deferred[0].VarUUId != "0b" {
+		t.Fatalf("Expected only 0b to be deferred; got %v", deferred)
+	}
+
+	// Once everything is imported, the deferred object is complete.
+	seg := capn.NewBuffer(nil)
+	varPosMap := make(map[common.VarUUId]*common.Positions)
+	refs, complete, err := i.references(seg, deferred[0], nil, varPosMap)
+	if err != nil {
+		t.Fatal(err)
+	} else if !complete {
+		t.Fatalf("Expected %v to be complete", deferred[0].VarUUId)
+	}
+	checkRefs(deferred[0], refs, varPosMap)
+
+	// Within a batch, references to other objects in the batch are
+	// complete.
+	batchIds := map[string]*common.VarUUId{"0a": translated["0a"], "0b": translated["0b"]}
+	i.imported = make(map[string]*importedVar)
+	if _, complete, err = i.references(seg, objs[0], batchIds, varPosMap); err != nil || !complete {
+		t.Fatalf("Expected references within a batch to be complete; got %v, %v", complete, err)
+	}
+}
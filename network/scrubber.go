@@ -0,0 +1,422 @@
+package network
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/configuration"
+	ch "goshawkdb.io/server/consistenthash"
+	"goshawkdb.io/server/db"
+	"goshawkdb.io/server/paxos"
+	eng "goshawkdb.io/server/txnengine"
+	"log"
+	"time"
+)
+
+// Scrubber is the online version of the consistencychecker. In the
+// background, it works through the Vars on disk here in batches of
+// server.ScrubBatchVarCount, and for each var for which we are a
+// replica, sends the var's write TxnId and writes clock to the other
+// replicas in a ScrubDigest. On receipt of a digest, we compare with
+// our own vars on disk.
+//
+// What is on disk lags behind what is in memory, and the replicas
+// write to disk independently, so a single mismatch means nothing:
+// the var may just be mid-write. We only consider a var divergent if
+// it mismatches on two consecutive passes with the same write TxnId
+// from the sender.
+//
+// Every server.AntiEntropyInterval, the scrubber also starts a round
+// of anti-entropy with each of the other replicas: see antientropy.go.
+type Scrubber struct {
+	db                *db.Databases
+	connectionManager *ConnectionManager
+	topologyChan      chan *configuration.Topology
+	connsChan         chan map[common.RMId]paxos.Connection
+	receivedChan      chan func()
+	statusChan        chan *server.StatusConsumer
+	shutdownChan      chan struct{}
+	// everything below is only accessed from the scrubber's own
+	// go-routine.
+	topology  *configuration.Topology
+	resolver  *ch.Resolver
+	conns     map[common.RMId]paxos.Connection
+	position  []byte
+	passes    uint64
+	rounds    uint64
+	suspects  map[scrubKey]*common.TxnId
+	divergent map[scrubKey]string
+	// immigrate applies an anti-entropy repair.
+	immigrate func(*msgs.Migration) error
+}
+
+type scrubKey struct {
+	rmId  common.RMId
+	vUUId common.VarUUId
+}
+
+type scrubVar struct {
+	vUUId       []byte
+	writeTxnId  []byte
+	writesClock []byte
+}
+
+var (
+	scrubberMetrics   = expvar.NewMap("Scrubber")
+	scrubberDivergent = new(expvar.Int)
+)
+
+func init() {
+	scrubberMetrics.Set("Divergent", scrubberDivergent)
+}
+
+func NewScrubber(db *db.Databases, cm *ConnectionManager) *Scrubber {
+	s := &Scrubber{
+		db:                db,
+		connectionManager: cm,
+		topologyChan:      make(chan *configuration.Topology, 1),
+		connsChan:         make(chan map[common.RMId]paxos.Connection, 1),
+		receivedChan:      make(chan func(), 16),
+		statusChan:        make(chan *server.StatusConsumer),
+		shutdownChan:      make(chan struct{}),
+		suspects:          make(map[scrubKey]*common.TxnId),
+		divergent:         make(map[scrubKey]string),
+	}
+	s.immigrate = func(repair *msgs.Migration) error {
+		return cm.Dispatchers.ProposerDispatcher.ImmigrationReceived(repair, antiEntropyTxnLocalStateChange{})
+	}
+	return s
+}
+
+// Start must not be called from the connection manager's go-routine.
+func (s *Scrubber) Start() {
+	topology := s.connectionManager.AddTopologySubscriber(eng.ScrubberSubscriber, s)
+	s.TopologyChanged(topology, func(bool) {})
+	s.connectionManager.AddServerConnectionSubscriber(s)
+	go s.loop()
+}
+
+func (s *Scrubber) Shutdown() {
+	select {
+	case <-s.shutdownChan:
+	default:
+		close(s.shutdownChan)
+		s.connectionManager.RemoveServerConnectionSubscriber(s)
+		s.connectionManager.RemoveTopologySubscriberAsync(eng.ScrubberSubscriber, s)
+	}
+}
+
+func (s *Scrubber) DigestReceived(sender common.RMId, digest *msgs.ScrubDigest) {
+	s.enqueue(func() { s.digestReceived(sender, digest) })
+}
+
+func (s *Scrubber) enqueue(f func()) {
+	select {
+	case s.receivedChan <- f:
+	case <-s.shutdownChan:
+	}
+}
+
+func (s *Scrubber) Status(sc *server.StatusConsumer) {
+	select {
+	case s.statusChan <- sc:
+	case <-s.shutdownChan:
+		sc.Join()
+	}
+}
+
+func (s *Scrubber) TopologyChanged(topology *configuration.Topology, done func(bool)) {
+	for {
+		select {
+		case s.topologyChan <- topology:
+			done(true)
+			return
+		default:
+			select {
+			case <-s.topologyChan:
+			default:
+			}
+		}
+	}
+}
+
+func (s *Scrubber) ConnectedRMs(conns map[common.RMId]paxos.Connection) {
+	s.publishConns(conns)
+}
+
+func (s *Scrubber) ConnectionLost(rmId common.RMId, conns map[common.RMId]paxos.Connection) {
+	s.publishConns(conns)
+}
+
+func (s *Scrubber) ConnectionEstablished(rmId common.RMId, conn paxos.Connection, conns map[common.RMId]paxos.Connection, done func()) {
+	defer done()
+	s.publishConns(conns)
+}
+
+func (s *Scrubber) publishConns(conns map[common.RMId]paxos.Connection) {
+	for {
+		select {
+		case s.connsChan <- conns:
+			return
+		default:
+			select {
+			case <-s.connsChan:
+			default:
+			}
+		}
+	}
+}
+
+func (s *Scrubber) loop() {
+	timer := time.NewTimer(server.ScrubBatchDelay)
+	defer timer.Stop()
+	antiEntropyTicker := time.NewTicker(server.AntiEntropyInterval)
+	defer antiEntropyTicker.Stop()
+	for {
+		select {
+		case <-s.shutdownChan:
+			return
+		case topology := <-s.topologyChan:
+			s.setTopology(topology)
+		case conns := <-s.connsChan:
+			s.conns = conns
+		case f := <-s.receivedChan:
+			f()
+		case sc := <-s.statusChan:
+			s.status(sc)
+		case <-timer.C:
+			delay := server.ScrubBatchDelay
+			if s.scrubBatch() {
+				delay = server.ScrubPassDelay
+			}
+			timer.Reset(delay)
+		case <-antiEntropyTicker.C:
+			s.startAntiEntropy()
+		}
+	}
+}
+
+// Whenever the topology changes, we start again: the replicas of each
+// var may have changed. We don't scrub at all whilst a topology change
+// is in progress as vars are moving about.
+func (s *Scrubber) setTopology(topology *configuration.Topology) {
+	s.topology = topology
+	s.resolver = nil
+	s.position = nil
+	s.suspects = make(map[scrubKey]*common.TxnId)
+	s.divergent = make(map[scrubKey]string)
+	scrubberDivergent.Set(0)
+	if topology != nil && !topology.IsBlank() && topology.Next() == nil {
+		s.resolver = ch.NewResolver(topology.RMs(), topology.TwoFInc)
+	}
+}
+
+// scrubBatch returns true iff it has reached the end of the pass.
+func (s *Scrubber) scrubBatch() bool {
+	if s.resolver == nil {
+		return false
+	}
+	selfRMId := s.connectionManager.RMId
+	digests := make(map[common.RMId][]*scrubVar)
+	var next []byte
+	_, err := s.db.ReadonlyTransaction(func(rtxn db.RTxn) interface{} {
+		rtxn.WithCursor(db.Vars, func(cursor db.Cursor) interface{} {
+			var vUUIdBytes, varBytes []byte
+			var err error
+			if s.position == nil {
+				vUUIdBytes, varBytes, err = cursor.First()
+			} else {
+				vUUIdBytes, varBytes, err = cursor.Seek(s.position)
+			}
+			count := 0
+			for ; err == nil && count < server.ScrubBatchVarCount; vUUIdBytes, varBytes, err = cursor.Next() {
+				count++
+				if bytes.Equal(vUUIdBytes, configuration.TopologyVarUUId[:]) {
+					continue
+				}
+				varBytes, err := s.db.OpenRecord(db.Vars, vUUIdBytes, varBytes)
+				if err != nil {
+					log.Printf("Scrubber: skipping %v: %v\n", common.MakeVarUUId(vUUIdBytes), err)
+					continue
+				}
+				seg, _, err := capn.ReadFromMemoryZeroCopy(varBytes)
+				if err != nil {
+					rtxn.Error(err)
+					return nil
+				}
+				varCap := msgs.ReadRootVar(seg)
+				rmIds, err := s.resolver.ResolveHashCodes(varCap.Positions().ToArray())
+				if err != nil {
+					rtxn.Error(err)
+					return nil
+				}
+				isReplica := false
+				for _, rmId := range rmIds {
+					if isReplica = rmId == selfRMId; isReplica {
+						break
+					}
+				}
+				if !isReplica {
+					// It must have emigrated but we don't delete.
+					continue
+				}
+				sv := &scrubVar{
+					vUUId:       append([]byte(nil), vUUIdBytes...),
+					writeTxnId:  append([]byte(nil), varCap.WriteTxnId()...),
+					writesClock: append([]byte(nil), varCap.WritesClock()...),
+				}
+				for _, rmId := range rmIds {
+					if rmId != selfRMId {
+						digests[rmId] = append(digests[rmId], sv)
+					}
+				}
+			}
+			if err == nil {
+				next = append([]byte(nil), vUUIdBytes...)
+			} else if err != db.NotFound {
+				rtxn.Error(err)
+			}
+			return nil
+		})
+		return nil
+	}).ResultError()
+	if err != nil {
+		log.Printf("Scrubber: error when reading vars: %v\n", err)
+		return false
+	}
+
+	for rmId, svs := range digests {
+		if conn, found := s.conns[rmId]; found {
+			conn.Send(s.digestMsg(svs))
+			scrubberMetrics.Add("VarsSent", int64(len(svs)))
+		}
+	}
+
+	s.position = next
+	if next == nil {
+		s.passes++
+		scrubberMetrics.Add("Passes", 1)
+		server.Log("Scrubber: pass", s.passes, "complete")
+		return true
+	}
+	return false
+}
+
+func (s *Scrubber) digestMsg(svs []*scrubVar) []byte {
+	seg := capn.NewBuffer(nil)
+	msg := msgs.NewRootMessage(seg)
+	digest := msgs.NewScrubDigest(seg)
+	digest.SetVersion(s.topology.Version)
+	vars := msgs.NewScrubVarList(seg, len(svs))
+	for idx, sv := range svs {
+		svCap := msgs.NewScrubVar(seg)
+		svCap.SetId(sv.vUUId)
+		svCap.SetWriteTxnId(sv.writeTxnId)
+		svCap.SetWritesClock(sv.writesClock)
+		vars.Set(idx, svCap)
+	}
+	digest.SetVars(vars)
+	msg.SetScrubDigest(digest)
+	return server.SegToBytes(seg)
+}
+
+func (s *Scrubber) digestReceived(sender common.RMId, digest *msgs.ScrubDigest) {
+	// If the versions differ then the sender may have calculated the
+	// replicas differently to how we would.
+	if s.resolver == nil || digest.Version() != s.topology.Version {
+		return
+	}
+	vars := digest.Vars()
+	mismatches := make([]string, vars.Len())
+	_, err := s.db.ReadonlyTransaction(func(rtxn db.RTxn) interface{} {
+		for idx, l := 0, vars.Len(); idx < l; idx++ {
+			sv := vars.At(idx)
+			varBytes, err := rtxn.Get(db.Vars, sv.Id())
+			if err == db.NotFound {
+				mismatches[idx] = "missing here"
+				continue
+			} else if err != nil {
+				rtxn.Error(err)
+				return nil
+			}
+			if varBytes, err = s.db.OpenRecord(db.Vars, sv.Id(), varBytes); err != nil {
+				mismatches[idx] = err.Error()
+				continue
+			}
+			seg, _, err := capn.ReadFromMemoryZeroCopy(varBytes)
+			if err != nil {
+				rtxn.Error(err)
+				return nil
+			}
+			varCap := msgs.ReadRootVar(seg)
+			if !bytes.Equal(varCap.WriteTxnId(), sv.WriteTxnId()) {
+				mismatches[idx] = fmt.Sprintf("write txn here is %v; on sender is %v",
+					common.MakeTxnId(varCap.WriteTxnId()), common.MakeTxnId(sv.WriteTxnId()))
+			} else if !clocksEqual(varCap.WritesClock(), sv.WritesClock()) {
+				mismatches[idx] = "writes clocks differ"
+			}
+		}
+		return nil
+	}).ResultError()
+	if err != nil {
+		log.Printf("Scrubber: error when checking digest from %v: %v\n", sender, err)
+		return
+	}
+	scrubberMetrics.Add("VarsChecked", int64(vars.Len()))
+
+	for idx, mismatch := range mismatches {
+		sv := vars.At(idx)
+		key := scrubKey{rmId: sender, vUUId: *common.MakeVarUUId(sv.Id())}
+		s.compared(key, common.MakeTxnId(sv.WriteTxnId()), mismatch)
+	}
+}
+
+func (s *Scrubber) compared(key scrubKey, senderTxnId *common.TxnId, mismatch string) {
+	if mismatch == "" {
+		delete(s.suspects, key)
+		if _, found := s.divergent[key]; found {
+			delete(s.divergent, key)
+			scrubberDivergent.Add(-1)
+		}
+		return
+	}
+	if prev, found := s.suspects[key]; found && prev.Compare(senderTxnId) == common.EQ {
+		if _, found := s.divergent[key]; !found {
+			log.Printf("Scrubber: %v has diverged from %v: %v\n", &key.vUUId, key.rmId, mismatch)
+			scrubberDivergent.Add(1)
+			scrubberMetrics.Add("DivergenceFound", 1)
+		}
+		s.divergent[key] = mismatch
+	} else {
+		s.suspects[key] = senderTxnId
+	}
+}
+
+func clocksEqual(a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	vcA, vcB := eng.VectorClockFromData(a, true), eng.VectorClockFromData(b, true)
+	if vcA.Len() != vcB.Len() {
+		return false
+	}
+	return vcA.ForEach(func(vUUId *common.VarUUId, v uint64) bool {
+		return vcB.At(vUUId) == v
+	})
+}
+
+func (s *Scrubber) status(sc *server.StatusConsumer) {
+	sc.Emit("Scrubber")
+	sc.Emit(fmt.Sprintf("- Completed passes: %v", s.passes))
+	sc.Emit(fmt.Sprintf("- Anti-entropy rounds started: %v", s.rounds))
+	sc.Emit(fmt.Sprintf("- Suspect vars: %v", len(s.suspects)))
+	sc.Emit(fmt.Sprintf("- Divergent vars: %v", len(s.divergent)))
+	for key, mismatch := range s.divergent {
+		sc.Emit(fmt.Sprintf("  %v against %v: %v", &key.vUUId, key.rmId, mismatch))
+	}
+	sc.Join()
+}
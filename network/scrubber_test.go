@@ -0,0 +1,187 @@
+package network
+
+import (
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/configuration"
+	ch "goshawkdb.io/server/consistenthash"
+	"goshawkdb.io/server/db"
+	"goshawkdb.io/server/paxos"
+	"testing"
+)
+
+// newTestScrubber returns a scrubber on RM 1 connected to RMs 2 and
+// 3, all three of which are replicas of every var.
+func newTestScrubber() (*Scrubber, *gcTestConn, *gcTestConn) {
+	two, three := &gcTestConn{rmId: 2}, &gcTestConn{rmId: 3}
+	s := NewScrubber(db.DB.WithStorage(db.NewMemoryStorage()), &ConnectionManager{RMId: 1})
+	s.topology = &configuration.Topology{Configuration: &configuration.Configuration{Version: 1}}
+	s.resolver = ch.NewResolver(common.RMIds{1, 2, 3}, 3)
+	s.conns = map[common.RMId]paxos.Connection{2: two, 3: three}
+	return s, two, three
+}
+
+func putScrubTestVar(t *testing.T, disk *db.Databases, vUUId *common.VarUUId, writeTxnId *common.TxnId) {
+	seg := capn.NewBuffer(nil)
+	varCap := msgs.NewRootVar(seg)
+	varCap.SetId(vUUId[:])
+	varCap.SetPositions(seg.NewUInt8List(3))
+	varCap.SetWriteTxnId(writeTxnId[:])
+	putScrubTestRecord(t, disk, vUUId, disk.SealRecord(db.Vars, vUUId[:], server.SegToBytes(seg)))
+}
+
+func putScrubTestRecord(t *testing.T, disk *db.Databases, vUUId *common.VarUUId, bites []byte) {
+	_, err := disk.ReadWriteTransaction(func(rwtxn db.RWTxn) interface{} {
+		if err := rwtxn.Put(db.Vars, vUUId[:], bites); err != nil {
+			rwtxn.Error(err)
+		}
+		return nil
+	}).ResultError()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func scrubTestDigest(s *Scrubber, vUUId *common.VarUUId, writeTxnId *common.TxnId) *msgs.ScrubDigest {
+	seg, _, err := capn.ReadFromMemoryZeroCopy(s.digestMsg([]*scrubVar{{vUUId: vUUId[:], writeTxnId: writeTxnId[:]}}))
+	if err != nil {
+		panic(err)
+	}
+	digest := msgs.ReadRootMessage(seg).ScrubDigest()
+	return &digest
+}
+
+// A var is only divergent once it has mismatched twice in a row
+// against the same write txn from the sender, and stops being so once
+// it matches.
+func TestScrubberDivergence(t *testing.T) {
+	s, _, _ := newTestScrubber()
+	defer s.db.Shutdown()
+	stale, corrupt, missing := gcTestVarUUId(1), gcTestVarUUId(2), gcTestVarUUId(3)
+	txnA, txnB, txnC := &common.TxnId{1}, &common.TxnId{2}, &common.TxnId{3}
+	putScrubTestVar(t, s.db, stale, txnA)
+	putScrubTestVar(t, s.db, corrupt, txnA)
+	sealed, err := s.db.ReadonlyTransaction(func(rtxn db.RTxn) interface{} {
+		bites, err := rtxn.Get(db.Vars, corrupt[:])
+		if err != nil {
+			rtxn.Error(err)
+		}
+		return bites
+	}).ResultError()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bites := append([]byte(nil), sealed.([]byte)...)
+	bites[len(bites)-1] ^= 0xff
+	putScrubTestRecord(t, s.db, corrupt, bites)
+
+	isDivergent := func(vUUId *common.VarUUId) bool {
+		_, found := s.divergent[scrubKey{rmId: 2, vUUId: *vUUId}]
+		return found
+	}
+	divergent := scrubberDivergent.Value()
+
+	for _, vUUId := range []*common.VarUUId{stale, corrupt, missing} {
+		s.digestReceived(2, scrubTestDigest(s, vUUId, txnB))
+	}
+	for _, vUUId := range []*common.VarUUId{stale, corrupt, missing} {
+		if isDivergent(vUUId) {
+			t.Fatalf("Did not expect %v to be divergent after a single mismatch", vUUId)
+		}
+	}
+
+	// The sender has moved on, so it may just be mid-write.
+	s.digestReceived(2, scrubTestDigest(s, stale, txnC))
+	s.digestReceived(2, scrubTestDigest(s, corrupt, txnB))
+	s.digestReceived(2, scrubTestDigest(s, missing, txnB))
+	if isDivergent(stale) || !isDivergent(corrupt) || !isDivergent(missing) {
+		t.Fatal("Expected only vars mismatching twice against the same write txn to be divergent")
+	}
+	if d := scrubberDivergent.Value() - divergent; d != 2 {
+		t.Fatalf("Expected 2 divergent vars to be reported; got %v", d)
+	}
+	if mismatch := s.divergent[scrubKey{rmId: 2, vUUId: *missing}]; mismatch != "missing here" {
+		t.Fatalf("Expected %v to be reported missing; got %v", missing, mismatch)
+	}
+
+	s.digestReceived(2, scrubTestDigest(s, stale, txnC))
+	if !isDivergent(stale) {
+		t.Fatalf("Expected %v to be divergent", stale)
+	}
+
+	// Once repaired, it is no longer divergent.
+	putScrubTestVar(t, s.db, stale, txnC)
+	s.digestReceived(2, scrubTestDigest(s, stale, txnC))
+	if isDivergent(stale) {
+		t.Fatalf("Did not expect %v to be divergent once it matched", stale)
+	}
+	if d := scrubberDivergent.Value() - divergent; d != 2 {
+		t.Fatalf("Expected 2 divergent vars to be reported; got %v", d)
+	}
+
+	// Digests from a different topology are ignored.
+	topology := s.topology
+	s.topology = &configuration.Topology{Configuration: &configuration.Configuration{Version: 2}}
+	digest := scrubTestDigest(s, stale, txnB)
+	s.topology = topology
+	s.digestReceived(2, digest)
+	s.digestReceived(2, digest)
+	if isDivergent(stale) {
+		t.Fatalf("Did not expect %v to be divergent from digests of another topology", stale)
+	}
+}
+
+// Each batch reads at most server.ScrubBatchVarCount vars, and each
+// var is sent to every other replica exactly once per pass.
+func TestScrubberBatches(t *testing.T) {
+	s, two, three := newTestScrubber()
+	defer s.db.Shutdown()
+	count := 2*server.ScrubBatchVarCount + 1
+	// The topology var is all zeros, and is never scrubbed.
+	for idx := 1; idx <= count; idx++ {
+		vUUId := common.MakeVarUUId(make([]byte, common.KeyLen))
+		vUUId[0], vUUId[1] = byte(idx>>8), byte(idx)
+		putScrubTestVar(t, s.db, vUUId, &common.TxnId{1})
+	}
+
+	for pass := 1; pass <= 2; pass++ {
+		batches := 0
+		for {
+			batches++
+			if s.scrubBatch() {
+				break
+			} else if batches > count {
+				t.Fatal("Expected the pass to complete")
+			}
+		}
+		if batches != 3 {
+			t.Fatalf("Expected 3 batches in pass %v; got %v", pass, batches)
+		}
+		if s.passes != uint64(pass) {
+			t.Fatalf("Expected %v passes; got %v", pass, s.passes)
+		}
+	}
+
+	for _, conn := range []*gcTestConn{two, three} {
+		seen := make(map[common.VarUUId]int)
+		for _, msg := range conn.sent {
+			vars := msg.ScrubDigest().Vars()
+			if vars.Len() > server.ScrubBatchVarCount {
+				t.Fatalf("Expected at most %v vars per digest; got %v", server.ScrubBatchVarCount, vars.Len())
+			}
+			for idx, l := 0, vars.Len(); idx < l; idx++ {
+				seen[*common.MakeVarUUId(vars.At(idx).Id())]++
+			}
+		}
+		if len(seen) != count {
+			t.Fatalf("Expected %v vars sent to %v; got %v", count, conn.rmId, len(seen))
+		}
+		for vUUId, n := range seen {
+			if n != 2 {
+				t.Fatalf("Expected %v to be sent to %v once per pass; sent %v times", &vUUId, conn.rmId, n)
+			}
+		}
+	}
+}
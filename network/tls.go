@@ -0,0 +1,133 @@
+package network
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// TLSSettings are the TLS parameters of every connection, both
+// between nodes and from clients. Node certificates always have
+// ECDSA keys, so with TLS 1.2 at least one ECDSA cipher suite is
+// required. In TLS 1.3 the cipher suites are not configurable.
+type TLSSettings struct {
+	MinVersion     uint16
+	MaxVersion     uint16
+	CipherSuites   []uint16
+	ClientKeyTypes []x509.PublicKeyAlgorithm
+}
+
+var (
+	tlsVersions = map[string]uint16{
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+	keyTypes = map[string]x509.PublicKeyAlgorithm{
+		"ecdsa":   x509.ECDSA,
+		"rsa":     x509.RSA,
+		"ed25519": x509.Ed25519,
+	}
+)
+
+// NewTLSSettings parses the settings from their command line forms:
+// versions are "1.2" or "1.3"; cipher suites and key types are comma
+// separated lists of cipher suite names (as in crypto/tls) and of
+// ecdsa, rsa and ed25519.
+func NewTLSSettings(minVersion, maxVersion, cipherSuites, clientKeyTypes string) (*TLSSettings, error) {
+	settings := &TLSSettings{}
+	var found bool
+	if settings.MinVersion, found = tlsVersions[minVersion]; !found {
+		return nil, fmt.Errorf("Unsupported minimum TLS version: %v. Supported versions are 1.2 and 1.3", minVersion)
+	}
+	if settings.MaxVersion, found = tlsVersions[maxVersion]; !found {
+		return nil, fmt.Errorf("Unsupported maximum TLS version: %v. Supported versions are 1.2 and 1.3", maxVersion)
+	}
+	if settings.MinVersion > settings.MaxVersion {
+		return nil, fmt.Errorf("Minimum TLS version (%v) is greater than maximum TLS version (%v)", minVersion, maxVersion)
+	}
+
+	suites := make(map[string]*tls.CipherSuite)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite
+	}
+	ecdsaFound := false
+	for _, name := range splitList(cipherSuites) {
+		suite, found := suites[name]
+		if !found {
+			return nil, fmt.Errorf("Unknown or insecure cipher suite: %v", name)
+		}
+		if len(suite.SupportedVersions) == 1 && suite.SupportedVersions[0] == tls.VersionTLS13 {
+			return nil, fmt.Errorf("Cipher suite %v is TLS 1.3 only: TLS 1.3 cipher suites are not configurable", name)
+		}
+		ecdsaFound = ecdsaFound || strings.Contains(name, "_ECDSA_")
+		settings.CipherSuites = append(settings.CipherSuites, suite.ID)
+	}
+	if settings.MinVersion < tls.VersionTLS13 && !ecdsaFound {
+		return nil, fmt.Errorf("With TLS 1.2, at least one ECDHE_ECDSA cipher suite is required (node certificates use ECDSA keys)")
+	}
+
+	for _, name := range splitList(clientKeyTypes) {
+		keyType, found := keyTypes[name]
+		if !found {
+			return nil, fmt.Errorf("Unsupported client key type: %v. Supported key types are ecdsa, rsa and ed25519", name)
+		}
+		settings.ClientKeyTypes = append(settings.ClientKeyTypes, keyType)
+	}
+	if len(settings.ClientKeyTypes) == 0 {
+		return nil, fmt.Errorf("No client key types given")
+	}
+	return settings, nil
+}
+
+func splitList(str string) []string {
+	elems := []string{}
+	for _, elem := range strings.Split(str, ",") {
+		if elem = strings.TrimSpace(elem); len(elem) != 0 {
+			elems = append(elems, elem)
+		}
+	}
+	return elems
+}
+
+func (settings *TLSSettings) apply(config *tls.Config) {
+	config.MinVersion = settings.MinVersion
+	config.MaxVersion = settings.MaxVersion
+	config.CipherSuites = settings.CipherSuites
+}
+
+// checkClientKeyType verifies the key type of the client's own
+// certificate: the first in the chain.
+func (settings *TLSSettings) checkClientKeyType(peerCerts []*x509.Certificate) error {
+	if len(peerCerts) == 0 {
+		return fmt.Errorf("No client certificate presented")
+	}
+	keyType := peerCerts[0].PublicKeyAlgorithm
+	for _, accepted := range settings.ClientKeyTypes {
+		if keyType == accepted {
+			return nil
+		}
+	}
+	return fmt.Errorf("Client certificate key type %v is not accepted (accepted: %v)", keyType, settings.ClientKeyTypes)
+}
+
+// handshakeError explains a failed TLS handshake in terms of what we
+// would have accepted, as the remote typically gets no more detail
+// than "handshake failure".
+func (settings *TLSSettings) handshakeError(remote string, err error) error {
+	suites := make([]string, len(settings.CipherSuites))
+	for idx, suite := range settings.CipherSuites {
+		suites[idx] = tls.CipherSuiteName(suite)
+	}
+	return fmt.Errorf("TLS handshake with %v failed: %v. (This node accepts TLS versions %v to %v; for TLS 1.2, cipher suites %v)",
+		remote, err, tlsVersionName(settings.MinVersion), tlsVersionName(settings.MaxVersion), strings.Join(suites, ", "))
+}
+
+func tlsVersionName(version uint16) string {
+	for name, v := range tlsVersions {
+		if v == version {
+			return name
+		}
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
@@ -0,0 +1,98 @@
+package network
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"reflect"
+	"testing"
+)
+
+func TestNewTLSSettings(t *testing.T) {
+	const (
+		ecdsaSuite = "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"
+		rsaSuite   = "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"
+	)
+	tests := []struct {
+		name                         string
+		minVersion, maxVersion       string
+		cipherSuites, clientKeyTypes string
+		expected                     *TLSSettings
+	}{
+		{
+			name:       "defaults",
+			minVersion: "1.2", maxVersion: "1.3", cipherSuites: ecdsaSuite, clientKeyTypes: "ecdsa",
+			expected: &TLSSettings{
+				MinVersion:     tls.VersionTLS12,
+				MaxVersion:     tls.VersionTLS13,
+				CipherSuites:   []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				ClientKeyTypes: []x509.PublicKeyAlgorithm{x509.ECDSA},
+			},
+		},
+		{
+			name:       "lists with spaces",
+			minVersion: "1.2", maxVersion: "1.2", cipherSuites: rsaSuite + ", " + ecdsaSuite, clientKeyTypes: " rsa,ed25519 ,",
+			expected: &TLSSettings{
+				MinVersion:     tls.VersionTLS12,
+				MaxVersion:     tls.VersionTLS12,
+				CipherSuites:   []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				ClientKeyTypes: []x509.PublicKeyAlgorithm{x509.RSA, x509.Ed25519},
+			},
+		},
+		{
+			name:       "TLS 1.3 only needs no ECDSA suite",
+			minVersion: "1.3", maxVersion: "1.3", cipherSuites: "", clientKeyTypes: "ecdsa",
+			expected: &TLSSettings{
+				MinVersion:     tls.VersionTLS13,
+				MaxVersion:     tls.VersionTLS13,
+				ClientKeyTypes: []x509.PublicKeyAlgorithm{x509.ECDSA},
+			},
+		},
+		{name: "unknown min version", minVersion: "1.1", maxVersion: "1.3", cipherSuites: ecdsaSuite, clientKeyTypes: "ecdsa"},
+		{name: "unknown max version", minVersion: "1.2", maxVersion: "2", cipherSuites: ecdsaSuite, clientKeyTypes: "ecdsa"},
+		{name: "min above max", minVersion: "1.3", maxVersion: "1.2", cipherSuites: ecdsaSuite, clientKeyTypes: "ecdsa"},
+		{name: "no ECDSA suite with TLS 1.2", minVersion: "1.2", maxVersion: "1.3", cipherSuites: rsaSuite, clientKeyTypes: "ecdsa"},
+		{name: "no suites with TLS 1.2", minVersion: "1.2", maxVersion: "1.3", cipherSuites: "", clientKeyTypes: "ecdsa"},
+		{name: "unknown suite", minVersion: "1.2", maxVersion: "1.3", cipherSuites: ecdsaSuite + ",TLS_BOGUS", clientKeyTypes: "ecdsa"},
+		{name: "insecure suite", minVersion: "1.2", maxVersion: "1.3", cipherSuites: ecdsaSuite + ",TLS_RSA_WITH_RC4_128_SHA", clientKeyTypes: "ecdsa"},
+		{name: "TLS 1.3 suite", minVersion: "1.2", maxVersion: "1.3", cipherSuites: ecdsaSuite + ",TLS_AES_128_GCM_SHA256", clientKeyTypes: "ecdsa"},
+		{name: "unknown key type", minVersion: "1.2", maxVersion: "1.3", cipherSuites: ecdsaSuite, clientKeyTypes: "ecdsa,dsa"},
+		{name: "no key types", minVersion: "1.2", maxVersion: "1.3", cipherSuites: ecdsaSuite, clientKeyTypes: " , "},
+	}
+	for _, test := range tests {
+		settings, err := NewTLSSettings(test.minVersion, test.maxVersion, test.cipherSuites, test.clientKeyTypes)
+		switch {
+		case test.expected == nil && err == nil:
+			t.Errorf("%v: expected error; got %+v", test.name, settings)
+		case test.expected != nil && err != nil:
+			t.Errorf("%v: %v", test.name, err)
+		case test.expected != nil && !reflect.DeepEqual(settings, test.expected):
+			t.Errorf("%v: expected %+v; got %+v", test.name, test.expected, settings)
+		}
+	}
+}
+
+func TestCheckClientKeyType(t *testing.T) {
+	settings := &TLSSettings{ClientKeyTypes: []x509.PublicKeyAlgorithm{x509.ECDSA, x509.Ed25519}}
+	cert := func(keyType x509.PublicKeyAlgorithm) *x509.Certificate {
+		return &x509.Certificate{PublicKeyAlgorithm: keyType}
+	}
+	tests := []struct {
+		name      string
+		peerCerts []*x509.Certificate
+		accepted  bool
+	}{
+		{"ecdsa", []*x509.Certificate{cert(x509.ECDSA)}, true},
+		{"ed25519", []*x509.Certificate{cert(x509.Ed25519)}, true},
+		{"rsa", []*x509.Certificate{cert(x509.RSA)}, false},
+		{"rsa signed by ecdsa", []*x509.Certificate{cert(x509.RSA), cert(x509.ECDSA)}, false},
+		{"ecdsa signed by rsa", []*x509.Certificate{cert(x509.ECDSA), cert(x509.RSA)}, true},
+		{"no certificate", nil, false},
+	}
+	for _, test := range tests {
+		if err := settings.checkClientKeyType(test.peerCerts); test.accepted && err != nil {
+			t.Errorf("%v: %v", test.name, err)
+		} else if !test.accepted && err == nil {
+			t.Errorf("%v: expected key type to be refused", test.name)
+		}
+	}
+}
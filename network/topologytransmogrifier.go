@@ -6,8 +6,6 @@ import (
 	"fmt"
 	capn "github.com/glycerine/go-capnproto"
 	cc "github.com/msackman/chancell"
-	mdb "github.com/msackman/gomdb"
-	mdbs "github.com/msackman/gomdb/server"
 	"goshawkdb.io/common"
 	cmsgs "goshawkdb.io/common/capnp"
 	"goshawkdb.io/server"
@@ -310,9 +308,7 @@ func (tt *TopologyTransmogrifier) setActive(topology *configuration.Topology) er
 			}
 			log.Printf(">==> We are %v (%v) <==<\n", localHost, tt.connectionManager.RMId)
 
-			future := tt.db.WithEnv(func(env *mdb.Env) (interface{}, error) {
-				return nil, env.SetFlags(mdb.NOSYNC, topology.NoSync)
-			})
+			future := tt.db.SetNoSync(topology.NoSync)
 			tt.connectionManager.SetDesiredServers(localHost, remoteHosts)
 			for version := range tt.migrations {
 				if version <= topology.Version {
@@ -476,7 +472,12 @@ func (tt *TopologyTransmogrifier) migrationReceived(migration topologyTransmogri
 	}
 	txnCount := int32(migration.migration.Elems().Len())
 	lsc := tt.newTxnLSC(txnCount, inprogressPtr)
-	tt.connectionManager.Dispatchers.ProposerDispatcher.ImmigrationReceived(migration.migration, lsc)
+	if err := tt.connectionManager.Dispatchers.ProposerDispatcher.ImmigrationReceived(migration.migration, lsc); err != nil {
+		// Nothing has been applied, and this batch stays in
+		// progress: the topology change must not complete without
+		// these txns.
+		log.Printf("Topology: rejecting migration from %v: %v\n", sender, err)
+	}
 	return nil
 }
 
@@ -1960,13 +1961,19 @@ type dbIterator struct {
 }
 
 func (it *dbIterator) iterate() {
-	ran, err := it.db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
-		result, _ := rtxn.WithCursor(it.db.Vars, func(cursor *mdbs.Cursor) interface{} {
-			vUUIdBytes, varBytes, err := cursor.Get(nil, nil, mdb.FIRST)
-			for ; err == nil; vUUIdBytes, varBytes, err = cursor.Get(nil, nil, mdb.NEXT) {
+	ran, err := it.db.ReadonlyTransaction(func(rtxn db.RTxn) interface{} {
+		return rtxn.WithCursor(db.Vars, func(cursor db.Cursor) interface{} {
+			vUUIdBytes, varBytes, err := cursor.First()
+			for ; err == nil; vUUIdBytes, varBytes, err = cursor.Next() {
+				varBytes, err := it.db.OpenRecord(db.Vars, vUUIdBytes, varBytes)
+				if err != nil {
+					// Quarantined: we can't migrate it.
+					log.Printf("Topology: Unable to migrate %v: %v\n", common.MakeVarUUId(vUUIdBytes), err)
+					continue
+				}
 				seg, _, err := capn.ReadFromMemoryZeroCopy(varBytes)
 				if err != nil {
-					cursor.Error(err)
+					rtxn.Error(err)
 					return true
 				}
 				varCap := msgs.ReadRootVar(seg)
@@ -1974,8 +1981,14 @@ func (it *dbIterator) iterate() {
 					continue
 				}
 				txnId := common.MakeTxnId(varCap.WriteTxnId())
-				txnBytes := it.db.ReadTxnBytesFromDisk(cursor.RTxn, txnId)
-				if txnBytes == nil {
+				txnBytes, err := it.db.ReadTxnBytesFromDisk(rtxn, txnId)
+				if db.IsCorruptRecord(err) {
+					log.Printf("Topology: Unable to migrate %v: %v\n", common.MakeVarUUId(vUUIdBytes), err)
+					continue
+				} else if err != nil {
+					rtxn.Error(err)
+					return true
+				} else if txnBytes == nil {
 					return true
 				}
 				txn := eng.TxnReaderFromData(txnBytes)
@@ -1988,7 +2001,7 @@ func (it *dbIterator) iterate() {
 				// ignore the allocations here, and just work through the
 				// actions directly.
 				actions := txn.Actions(true).Actions()
-				varCaps, err := it.filterVars(cursor, vUUIdBytes, txnId[:], actions)
+				varCaps, err := it.filterVars(rtxn, vUUIdBytes, txnId[:], actions)
 				if err != nil {
 					return true
 				} else if len(varCaps) == 0 {
@@ -1997,21 +2010,20 @@ func (it *dbIterator) iterate() {
 				for _, sb := range it.batch {
 					matchingVarCaps, err := it.matchVarsAgainstCond(sb.cond, varCaps)
 					if err != nil {
-						cursor.Error(err)
+						rtxn.Error(err)
 						return true
 					} else if len(matchingVarCaps) != 0 {
 						sb.add(txn, matchingVarCaps)
 					}
 				}
 			}
-			if err == mdb.NotFound {
+			if err == db.NotFound {
 				return true
 			} else {
-				cursor.Error(err)
+				rtxn.Error(err)
 				return true
 			}
 		})
-		return result
 	}).ResultError()
 	if err != nil {
 		panic(fmt.Sprintf("Topology iterator error: %v", err))
@@ -2023,7 +2035,7 @@ func (it *dbIterator) iterate() {
 	}
 }
 
-func (it *dbIterator) filterVars(cursor *mdbs.Cursor, vUUIdBytes []byte, txnIdBytes []byte, actions *msgs.Action_List) ([]*msgs.Var, error) {
+func (it *dbIterator) filterVars(rtxn db.RTxn, vUUIdBytes []byte, txnIdBytes []byte, actions *msgs.Action_List) ([]*msgs.Var, error) {
 	varCaps := make([]*msgs.Var, 0, actions.Len()>>1)
 	for idx, l := 0, actions.Len(); idx < l; idx++ {
 		action := actions.At(idx)
@@ -2033,17 +2045,20 @@ func (it *dbIterator) filterVars(cursor *mdbs.Cursor, vUUIdBytes []byte, txnIdBy
 			continue
 		}
 		actionVarUUIdBytes := action.VarId()
-		varBytes, err := cursor.RTxn.Get(it.db.Vars, actionVarUUIdBytes)
-		if err == mdb.NotFound {
+		varBytes, err := rtxn.Get(db.Vars, actionVarUUIdBytes)
+		if err == db.NotFound {
 			continue
 		} else if err != nil {
-			cursor.Error(err)
+			rtxn.Error(err)
 			return nil, err
 		}
+		if varBytes, err = it.db.OpenRecord(db.Vars, actionVarUUIdBytes, varBytes); err != nil {
+			continue
+		}
 
 		seg, _, err := capn.ReadFromMemoryZeroCopy(varBytes)
 		if err != nil {
-			cursor.Error(err)
+			rtxn.Error(err)
 			return nil, err
 		}
 		varCap := msgs.ReadRootVar(seg)
@@ -2110,10 +2125,11 @@ func (it *dbIterator) ConnectionEstablished(rmId common.RMId, conn paxos.Connect
 }
 
 type sendBatch struct {
-	version uint32
-	conn    paxos.Connection
-	cond    configuration.Cond
-	elems   []*migrationElem
+	version  uint32
+	conn     paxos.Connection
+	cond     configuration.Cond
+	elems    []*migrationElem
+	compress bool
 }
 
 type migrationElem struct {
@@ -2123,10 +2139,11 @@ type migrationElem struct {
 
 func (e *emigrator) newBatch(conn paxos.Connection, cond configuration.Cond) *sendBatch {
 	return &sendBatch{
-		version: e.topology.Next().Version,
-		conn:    conn,
-		cond:    cond,
-		elems:   make([]*migrationElem, 0, server.MigrationBatchElemCount),
+		version:  e.topology.Next().Version,
+		conn:     conn,
+		cond:     cond,
+		elems:    make([]*migrationElem, 0, server.MigrationBatchElemCount),
+		compress: e.db.Compress,
 	}
 }
 
@@ -2141,7 +2158,7 @@ func (sb *sendBatch) flush() {
 	elems := msgs.NewMigrationElementList(seg, len(sb.elems))
 	for idx, elem := range sb.elems {
 		elemCap := msgs.NewMigrationElement(seg)
-		elemCap.SetTxn(elem.txn.Data)
+		setMigrationElementTxn(&elemCap, elem.txn.Data, sb.compress)
 		vars := msgs.NewVarList(seg, len(elem.vars))
 		for idy, varCap := range elem.vars {
 			vars.Set(idy, *varCap)
@@ -2157,6 +2174,17 @@ func (sb *sendBatch) flush() {
 	sb.elems = sb.elems[:0]
 }
 
+func setMigrationElementTxn(elemCap *msgs.MigrationElement, txnData []byte, compress bool) {
+	if compress {
+		if compressed := db.Compress(txnData); compressed != nil {
+			elemCap.SetTxn(compressed)
+			elemCap.SetCompressed(true)
+			return
+		}
+	}
+	elemCap.SetTxn(txnData)
+}
+
 func (sb *sendBatch) add(txn *eng.TxnReader, varCaps []*msgs.Var) {
 	elem := &migrationElem{
 		txn:  txn,
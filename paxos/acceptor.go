@@ -3,11 +3,11 @@ package paxos
 import (
 	"fmt"
 	capn "github.com/glycerine/go-capnproto"
-	mdbs "github.com/msackman/gomdb/server"
 	"goshawkdb.io/common"
 	"goshawkdb.io/server"
 	msgs "goshawkdb.io/server/capnp"
 	"goshawkdb.io/server/configuration"
+	"goshawkdb.io/server/db"
 	eng "goshawkdb.io/server/txnengine"
 	"log"
 )
@@ -244,13 +244,13 @@ func (awtd *acceptorWriteToDisk) start() {
 	// to ensure correct order of writes, schedule the write from
 	// the current go-routine...
 	server.Log(awtd.txnId, "Writing 2B to disk...")
-	future := awtd.acceptorManager.DB.ReadWriteTransaction(false, func(rwtxn *mdbs.RWTxn) interface{} {
-		rwtxn.Put(awtd.acceptorManager.DB.BallotOutcomes, awtd.txnId[:], data, 0)
+	result := awtd.acceptorManager.DB.DurableReadWriteTransaction(func(rwtxn db.RWTxn) interface{} {
+		rwtxn.Put(db.BallotOutcomes, awtd.txnId[:], awtd.acceptorManager.DB.SealRecord(db.BallotOutcomes, awtd.txnId[:], data))
 		return true
 	})
 	go func() {
 		// ... but process the result in a new go-routine to avoid blocking the executor.
-		if ran, err := future.ResultError(); err != nil {
+		if ran, err := result(); err != nil {
 			panic(fmt.Sprintf("Error: %v Acceptor Write error: %v", awtd.txnId, err))
 		} else if ran != nil {
 			server.Log(awtd.txnId, "Writing 2B to disk...done.")
@@ -417,12 +417,12 @@ func (adfd *acceptorDeleteFromDisk) start() {
 		adfd.acceptorManager.RemoveServerConnectionSubscriber(adfd.twoBSender)
 		adfd.twoBSender = nil
 	}
-	future := adfd.acceptorManager.DB.ReadWriteTransaction(false, func(rwtxn *mdbs.RWTxn) interface{} {
-		rwtxn.Del(adfd.acceptorManager.DB.BallotOutcomes, adfd.txnId[:], nil)
+	result := adfd.acceptorManager.DB.DurableReadWriteTransaction(func(rwtxn db.RWTxn) interface{} {
+		rwtxn.Del(db.BallotOutcomes, adfd.txnId[:])
 		return true
 	})
 	go func() {
-		if ran, err := future.ResultError(); err != nil {
+		if ran, err := result(); err != nil {
 			panic(fmt.Sprintf("Error: %v Acceptor Deletion error: %v", adfd.txnId, err))
 		} else if ran != nil {
 			server.Log(adfd.txnId, "Deleted 2B from disk...done.")
@@ -2,8 +2,6 @@ package paxos
 
 import (
 	"fmt"
-	mdb "github.com/msackman/gomdb"
-	mdbs "github.com/msackman/gomdb/server"
 	"goshawkdb.io/common"
 	"goshawkdb.io/server"
 	msgs "goshawkdb.io/server/capnp"
@@ -63,27 +61,30 @@ func (ad *AcceptorDispatcher) Status(sc *server.StatusConsumer) {
 	sc.Join()
 }
 
-func (ad *AcceptorDispatcher) loadFromDisk(db *db.Databases) {
-	res, err := db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
-		res, _ := rtxn.WithCursor(db.BallotOutcomes, func(cursor *mdbs.Cursor) interface{} {
-			// cursor.Get returns a copy of the data. So it's fine for us
+func (ad *AcceptorDispatcher) loadFromDisk(disk *db.Databases) {
+	res, err := disk.ReadonlyTransaction(func(rtxn db.RTxn) interface{} {
+		return rtxn.WithCursor(db.BallotOutcomes, func(cursor db.Cursor) interface{} {
+			// The cursor returns copies of the data. So it's fine for us
 			// to store and process this later - it's not about to be
 			// overwritten on disk.
 			acceptorStates := make(map[*common.TxnId][]byte)
-			txnIdData, acceptorState, err := cursor.Get(nil, nil, mdb.FIRST)
-			for ; err == nil; txnIdData, acceptorState, err = cursor.Get(nil, nil, mdb.NEXT) {
+			txnIdData, acceptorState, err := cursor.First()
+			for ; err == nil; txnIdData, acceptorState, err = cursor.Next() {
 				txnId := common.MakeTxnId(txnIdData)
+				if acceptorState, err = disk.OpenRecord(db.BallotOutcomes, txnIdData, acceptorState); err != nil {
+					rtxn.Error(fmt.Errorf("%v: %v", txnId, err))
+					return nil
+				}
 				acceptorStates[txnId] = acceptorState
 			}
-			if err == mdb.NotFound {
+			if err == db.NotFound {
 				// fine, we just fell off the end as expected.
 				return acceptorStates
 			} else {
-				cursor.Error(err)
+				rtxn.Error(err)
 				return nil
 			}
 		})
-		return res
 	}).ResultError()
 	if err != nil {
 		panic(fmt.Sprintf("AcceptorDispatcher error loading from disk: %v", err))
@@ -5,8 +5,6 @@ import (
 	"encoding/binary"
 	"fmt"
 	capn "github.com/glycerine/go-capnproto"
-	mdb "github.com/msackman/gomdb"
-	mdbs "github.com/msackman/gomdb/server"
 	"goshawkdb.io/common"
 	"goshawkdb.io/server"
 	msgs "goshawkdb.io/server/capnp"
@@ -16,10 +14,6 @@ import (
 	eng "goshawkdb.io/server/txnengine"
 )
 
-func init() {
-	db.DB.BallotOutcomes = &mdbs.DBISettings{Flags: mdb.CREATE}
-}
-
 type AcceptorManager struct {
 	ServerConnectionPublisher
 	RMId      common.RMId
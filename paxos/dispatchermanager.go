@@ -1,8 +1,6 @@
 package paxos
 
 import (
-	mdb "github.com/msackman/gomdb"
-	mdbs "github.com/msackman/gomdb/server"
 	"goshawkdb.io/common"
 	"goshawkdb.io/server/db"
 	eng "goshawkdb.io/server/txnengine"
@@ -37,12 +35,11 @@ func NewDispatchers(cm ConnectionManager, rmId common.RMId, count uint8, db *db.
 }
 
 func (d *Dispatchers) IsDatabaseEmpty() (bool, error) {
-	res, err := d.db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
-		res, _ := rtxn.WithCursor(d.db.Vars, func(cursor *mdbs.Cursor) interface{} {
-			_, _, err := cursor.Get(nil, nil, mdb.FIRST)
-			return err == mdb.NotFound
+	res, err := d.db.ReadonlyTransaction(func(rtxn db.RTxn) interface{} {
+		return rtxn.WithCursor(db.Vars, func(cursor db.Cursor) interface{} {
+			_, _, err := cursor.First()
+			return err == db.NotFound
 		})
-		return res
 	}).ResultError()
 	if err != nil || res == nil {
 		return false, err
@@ -3,11 +3,11 @@ package paxos
 import (
 	"fmt"
 	capn "github.com/glycerine/go-capnproto"
-	mdbs "github.com/msackman/gomdb/server"
 	"goshawkdb.io/common"
 	"goshawkdb.io/server"
 	msgs "goshawkdb.io/server/capnp"
 	"goshawkdb.io/server/configuration"
+	"goshawkdb.io/server/db"
 	eng "goshawkdb.io/server/txnengine"
 	"log"
 )
@@ -418,12 +418,12 @@ func (palc *proposerAwaitLocallyComplete) maybeWriteToDisk() {
 
 	data := server.SegToBytes(stateSeg)
 
-	future := palc.proposerManager.DB.ReadWriteTransaction(false, func(rwtxn *mdbs.RWTxn) interface{} {
-		rwtxn.Put(palc.proposerManager.DB.Proposers, palc.txnId[:], data, 0)
+	result := palc.proposerManager.DB.DurableReadWriteTransaction(func(rwtxn db.RWTxn) interface{} {
+		rwtxn.Put(db.Proposers, palc.txnId[:], palc.proposerManager.DB.SealRecord(db.Proposers, palc.txnId[:], data))
 		return true
 	})
 	go func() {
-		if ran, err := future.ResultError(); err != nil {
+		if ran, err := result(); err != nil {
 			panic(fmt.Sprintf("Error: %v when writing proposer to disk: %v\n", palc.txnId, err))
 		} else if ran != nil {
 			palc.proposerManager.Exe.Enqueue(palc.writeDone)
@@ -507,12 +507,12 @@ func (paf *proposerAwaitFinished) TxnFinished(*eng.Txn) {
 	server.Log(paf.txnId, "Txn Finished Callback")
 	if paf.currentState == paf {
 		paf.nextState()
-		future := paf.proposerManager.DB.ReadWriteTransaction(false, func(rwtxn *mdbs.RWTxn) interface{} {
-			rwtxn.Del(paf.proposerManager.DB.Proposers, paf.txnId[:], nil)
+		result := paf.proposerManager.DB.DurableReadWriteTransaction(func(rwtxn db.RWTxn) interface{} {
+			rwtxn.Del(db.Proposers, paf.txnId[:])
 			return true
 		})
 		go func() {
-			if ran, err := future.ResultError(); err != nil {
+			if ran, err := result(); err != nil {
 				panic(fmt.Sprintf("Error: %v when deleting proposer from disk: %v\n", paf.txnId, err))
 			} else if ran != nil {
 				paf.proposerManager.Exe.Enqueue(func() {
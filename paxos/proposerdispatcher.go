@@ -2,8 +2,6 @@ package paxos
 
 import (
 	"fmt"
-	mdb "github.com/msackman/gomdb"
-	mdbs "github.com/msackman/gomdb/server"
 	"goshawkdb.io/common"
 	"goshawkdb.io/server"
 	msgs "goshawkdb.io/server/capnp"
@@ -65,16 +63,29 @@ func (pd *ProposerDispatcher) TxnSubmissionAbortReceived(sender common.RMId, tsa
 	pd.withProposerManager(txnId, func(pm *ProposerManager) { pm.TxnSubmissionAbortReceived(sender, txnId) })
 }
 
-func (pd *ProposerDispatcher) ImmigrationReceived(migration *msgs.Migration, stateChange eng.TxnLocalStateChange) {
+// ImmigrationReceived applies every txn in migration, or, if any of
+// them cannot be decoded, none of them.
+func (pd *ProposerDispatcher) ImmigrationReceived(migration *msgs.Migration, stateChange eng.TxnLocalStateChange) error {
 	elemsList := migration.Elems()
 	elemsCount := elemsList.Len()
+	txns := make([]*eng.TxnReader, elemsCount)
 	for idx := 0; idx < elemsCount; idx++ {
 		elem := elemsList.At(idx)
-		txn := eng.TxnReaderFromData(elem.Txn())
-		txnId := txn.Id
-		varCaps := elem.Vars()
-		pd.withProposerManager(txnId, func(pm *ProposerManager) { pm.ImmigrationReceived(txn, &varCaps, stateChange) })
+		txnData := elem.Txn()
+		if elem.Compressed() {
+			var err error
+			if txnData, err = db.Decompress(txnData); err != nil {
+				return fmt.Errorf("Unable to decompress immigrating txn %v of %v: %v", idx, elemsCount, err)
+			}
+		}
+		txns[idx] = eng.TxnReaderFromData(txnData)
 	}
+	for idx := range txns {
+		txn := txns[idx]
+		varCaps := elemsList.At(idx).Vars()
+		pd.withProposerManager(txn.Id, func(pm *ProposerManager) { pm.ImmigrationReceived(txn, &varCaps, stateChange) })
+	}
+	return nil
 }
 
 func (pd *ProposerDispatcher) Status(sc *server.StatusConsumer) {
@@ -88,27 +99,30 @@ func (pd *ProposerDispatcher) Status(sc *server.StatusConsumer) {
 	sc.Join()
 }
 
-func (pd *ProposerDispatcher) loadFromDisk(db *db.Databases) {
-	res, err := db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
-		res, _ := rtxn.WithCursor(db.Proposers, func(cursor *mdbs.Cursor) interface{} {
-			// cursor.Get returns a copy of the data. So it's fine for us
+func (pd *ProposerDispatcher) loadFromDisk(disk *db.Databases) {
+	res, err := disk.ReadonlyTransaction(func(rtxn db.RTxn) interface{} {
+		return rtxn.WithCursor(db.Proposers, func(cursor db.Cursor) interface{} {
+			// The cursor returns copies of the data. So it's fine for us
 			// to store and process this later - it's not about to be
 			// overwritten on disk.
 			proposerStates := make(map[*common.TxnId][]byte)
-			txnIdData, proposerState, err := cursor.Get(nil, nil, mdb.FIRST)
-			for ; err == nil; txnIdData, proposerState, err = cursor.Get(nil, nil, mdb.NEXT) {
+			txnIdData, proposerState, err := cursor.First()
+			for ; err == nil; txnIdData, proposerState, err = cursor.Next() {
 				txnId := common.MakeTxnId(txnIdData)
+				if proposerState, err = disk.OpenRecord(db.Proposers, txnIdData, proposerState); err != nil {
+					rtxn.Error(fmt.Errorf("%v: %v", txnId, err))
+					return nil
+				}
 				proposerStates[txnId] = proposerState
 			}
-			if err == mdb.NotFound {
+			if err == db.NotFound {
 				// fine, we just fell off the end as expected.
 				return proposerStates
 			} else {
-				cursor.Error(err)
+				rtxn.Error(err)
 				return nil
 			}
 		})
-		return res
 	}).ResultError()
 	if err != nil {
 		panic(fmt.Sprintf("ProposerDispatcher error loading from disk: %v", err))
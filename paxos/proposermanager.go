@@ -4,8 +4,6 @@ import (
 	"encoding/binary"
 	"fmt"
 	capn "github.com/glycerine/go-capnproto"
-	mdb "github.com/msackman/gomdb"
-	mdbs "github.com/msackman/gomdb/server"
 	"goshawkdb.io/common"
 	"goshawkdb.io/server"
 	msgs "goshawkdb.io/server/capnp"
@@ -16,10 +14,6 @@ import (
 	"log"
 )
 
-func init() {
-	db.DB.Proposers = &mdbs.DBISettings{Flags: mdb.CREATE}
-}
-
 const ( //                  txnId  rmId
 	instanceIdPrefixLen = common.KeyLen + 4
 )
@@ -0,0 +1,142 @@
+package txnengine
+
+import (
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"sync"
+)
+
+// GCBarrier is the write barrier for garbage collection. Whilst a
+// collection cycle is in progress, every write which a var here
+// commits records both the references it removes and the references
+// it adds, and a create records the new var itself. So anything which
+// was reachable when the cycle started, or which becomes reachable
+// during it, is recorded here and can be marked by the collector even
+// though the collector traces what is on disk.
+//
+// The barrier also keeps a tombstone for every var deleted here in
+// the current and the previous cycle. Other replicas of a var delete
+// it at slightly different times, and anti-entropy must not copy it
+// back here from a replica which has yet to delete it.
+//
+// The barrier is shared by all the VarManagers, and so is locked.
+type GCBarrier struct {
+	lock          sync.Mutex
+	active        bool
+	recorded      map[common.VarUUId]server.EmptyStruct
+	fresh         []*common.VarUUId
+	tombstones    map[common.VarUUId]server.EmptyStruct
+	oldTombstones map[common.VarUUId]server.EmptyStruct
+}
+
+func NewGCBarrier() *GCBarrier {
+	return &GCBarrier{
+		tombstones: make(map[common.VarUUId]server.EmptyStruct),
+	}
+}
+
+// Start discards anything recorded in a previous cycle, along with
+// the tombstones of the cycle before that, and starts recording.
+func (b *GCBarrier) Start() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.active = true
+	b.recorded = make(map[common.VarUUId]server.EmptyStruct)
+	b.fresh = nil
+	b.oldTombstones = b.tombstones
+	b.tombstones = make(map[common.VarUUId]server.EmptyStruct)
+}
+
+func (b *GCBarrier) Stop() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.active = false
+	b.recorded = nil
+	b.fresh = nil
+}
+
+// Take returns the vars recorded since the last call to Take.
+func (b *GCBarrier) Take() []*common.VarUUId {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	fresh := b.fresh
+	b.fresh = nil
+	return fresh
+}
+
+// Contains returns true iff vUUId has been recorded at any point in
+// the current cycle.
+func (b *GCBarrier) Contains(vUUId *common.VarUUId) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	_, found := b.recorded[*vUUId]
+	return found
+}
+
+// IsDeleted returns true iff vUUId has been deleted here in the
+// current or previous cycle.
+func (b *GCBarrier) IsDeleted(vUUId *common.VarUUId) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, found := b.tombstones[*vUUId]; found {
+		return true
+	}
+	_, found := b.oldTombstones[*vUUId]
+	return found
+}
+
+// Deleted records a tombstone for vUUId, which has just been deleted
+// here.
+func (b *GCBarrier) Deleted(vUUId *common.VarUUId) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.tombstones[*vUUId] = server.EmptyStructVal
+}
+
+// frameChanged is called by a var when f replaces old as its current
+// frame.
+func (b *GCBarrier) frameChanged(v *Var, old *frame, action *localAction) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !b.active {
+		return
+	}
+	if old != nil {
+		b.recordReferences(old.writtenReferences())
+	}
+	if action.writeAction.Which() == msgs.ACTION_CREATE {
+		b.record(v.UUId)
+	}
+	b.recordReferences(WrittenReferences(action.writeAction))
+}
+
+func (b *GCBarrier) recordReferences(references *msgs.VarIdPos_List) {
+	for idx, l := 0, references.Len(); idx < l; idx++ {
+		b.record(common.MakeVarUUId(references.At(idx).Id()))
+	}
+}
+
+func (b *GCBarrier) record(vUUId *common.VarUUId) {
+	if _, found := b.recorded[*vUUId]; !found {
+		b.recorded[*vUUId] = server.EmptyStructVal
+		b.fresh = append(b.fresh, vUUId)
+	}
+}
+
+// WrittenReferences returns the references in the value written by
+// actionCap. A roll rewrites the references it was created from.
+func WrittenReferences(actionCap *msgs.Action) *msgs.VarIdPos_List {
+	var references msgs.VarIdPos_List
+	switch actionCap.Which() {
+	case msgs.ACTION_WRITE:
+		references = actionCap.Write().References()
+	case msgs.ACTION_READWRITE:
+		references = actionCap.Readwrite().References()
+	case msgs.ACTION_CREATE:
+		references = actionCap.Create().References()
+	case msgs.ACTION_ROLL:
+		references = actionCap.Roll().References()
+	}
+	return &references
+}
@@ -0,0 +1,112 @@
+package txnengine
+
+import (
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	msgs "goshawkdb.io/server/capnp"
+	"testing"
+)
+
+func testVarUUId(n byte) *common.VarUUId {
+	vUUId := common.MakeVarUUId(make([]byte, common.KeyLen))
+	vUUId[0] = n
+	return vUUId
+}
+
+func testAction(seg *capn.Segment, vUUId *common.VarUUId, create bool, refs ...*common.VarUUId) *msgs.Action {
+	action := msgs.NewAction(seg)
+	action.SetVarId(vUUId[:])
+	references := msgs.NewVarIdPosList(seg, len(refs))
+	for idx, ref := range refs {
+		references.At(idx).SetId(ref[:])
+	}
+	if create {
+		action.SetCreate()
+		action.Create().SetReferences(references)
+	} else {
+		action.SetWrite()
+		action.Write().SetReferences(references)
+	}
+	return &action
+}
+
+// testFrame is a frame written by action.
+func testFrame(seg *capn.Segment, v *Var, action *msgs.Action) *frame {
+	actions := msgs.NewActionList(seg, 1)
+	actions.Set(0, *action)
+	return &frame{v: v, frameTxnActions: &TxnActions{decoded: true, actionsCap: actions}}
+}
+
+func checkVars(t *testing.T, expected, found []*common.VarUUId) {
+	if len(expected) != len(found) {
+		t.Fatalf("Expected %v; got %v", expected, found)
+	}
+	for idx, vUUId := range expected {
+		if vUUId.Compare(found[idx]) != common.EQ {
+			t.Fatalf("Expected %v; got %v", expected, found)
+		}
+	}
+}
+
+func TestGCBarrierRecordsWrites(t *testing.T) {
+	seg := capn.NewBuffer(nil)
+	b := NewGCBarrier()
+	v := &Var{UUId: testVarUUId(1)}
+	old := testFrame(seg, v, testAction(seg, v.UUId, false, testVarUUId(2)))
+
+	// Nothing is recorded outside a cycle.
+	b.frameChanged(v, old, &localAction{writeAction: testAction(seg, v.UUId, false, testVarUUId(3))})
+	checkVars(t, nil, b.Take())
+	if b.Contains(testVarUUId(2)) {
+		t.Fatal("Expected nothing to be recorded outside a cycle")
+	}
+
+	b.Start()
+	// A write records the references it removes and those it adds.
+	b.frameChanged(v, old, &localAction{writeAction: testAction(seg, v.UUId, false, testVarUUId(3))})
+	checkVars(t, []*common.VarUUId{testVarUUId(2), testVarUUId(3)}, b.Take())
+	checkVars(t, nil, b.Take())
+
+	// A create records the new var too.
+	created := &Var{UUId: testVarUUId(4)}
+	b.frameChanged(created, nil, &localAction{writeAction: testAction(seg, created.UUId, true, testVarUUId(3), testVarUUId(5))})
+	checkVars(t, []*common.VarUUId{testVarUUId(4), testVarUUId(5)}, b.Take())
+
+	for n := byte(2); n <= 5; n++ {
+		if !b.Contains(testVarUUId(n)) {
+			t.Fatalf("Expected %v to be recorded", testVarUUId(n))
+		}
+	}
+	if b.Contains(v.UUId) {
+		t.Fatalf("Did not expect %v to be recorded", v.UUId)
+	}
+
+	b.Stop()
+	if b.Contains(testVarUUId(2)) {
+		t.Fatal("Expected the recorded vars to be discarded when stopped")
+	}
+	b.Start()
+	if b.Contains(testVarUUId(2)) {
+		t.Fatal("Expected the recorded vars to be discarded when restarted")
+	}
+}
+
+func TestGCBarrierTombstones(t *testing.T) {
+	b := NewGCBarrier()
+	b.Start()
+	b.Deleted(testVarUUId(1))
+	if !b.IsDeleted(testVarUUId(1)) || b.IsDeleted(testVarUUId(2)) {
+		t.Fatal("Expected only the deleted var to have a tombstone")
+	}
+	b.Stop()
+	// Tombstones survive the end of the cycle and the whole of the
+	// next.
+	b.Start()
+	if !b.IsDeleted(testVarUUId(1)) {
+		t.Fatal("Expected the tombstone to survive into the next cycle")
+	}
+	b.Start()
+	if b.IsDeleted(testVarUUId(1)) {
+		t.Fatal("Expected the tombstone to be discarded after two cycles")
+	}
+}
@@ -0,0 +1,121 @@
+package txnengine
+
+import (
+	"bytes"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/db"
+)
+
+// MaintainReverseRefs prepares the ReverseRefs index at start up. If
+// the index is to be maintained but is not complete, because it has
+// never been built or because it was not maintained by a previous
+// run, then it is rebuilt from the vars on disk, in a single txn. If
+// it is not to be maintained then it is marked as incomplete, as it
+// will become stale.
+func MaintainReverseRefs(disk *db.Databases) error {
+	_, err := disk.ReadWriteTransaction(func(rwtxn db.RWTxn) interface{} {
+		complete, err := db.ReverseRefsComplete(rwtxn)
+		if err == nil {
+			if !disk.ReverseRefs {
+				err = db.SetReverseRefsComplete(rwtxn, false)
+			} else if !complete {
+				err = rebuildReverseRefs(rwtxn, disk)
+			}
+		}
+		if err != nil {
+			rwtxn.Error(err)
+		}
+		return true
+	}).ResultError()
+	return err
+}
+
+func rebuildReverseRefs(rwtxn db.RWTxn, disk *db.Databases) error {
+	if err := db.ClearReverseRefs(rwtxn); err != nil {
+		return err
+	}
+	var vUUIds []*common.VarUUId
+	var err error
+	rwtxn.WithCursor(db.Vars, func(cursor db.Cursor) interface{} {
+		key, _, err1 := cursor.First()
+		for ; err1 == nil; key, _, err1 = cursor.Next() {
+			vUUIds = append(vUUIds, common.MakeVarUUId(key))
+		}
+		if err1 != db.NotFound {
+			err = err1
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, vUUId := range vUUIds {
+		references, err := ReadReferences(rwtxn, disk, vUUId)
+		if err != nil {
+			return err
+		}
+		if err = disk.UpdateReverseRefs(rwtxn, vUUId, nil, references); err != nil {
+			return err
+		}
+	}
+	return db.SetReverseRefsComplete(rwtxn, true)
+}
+
+// ReadReferences returns the references of vUUId as it is on disk,
+// or nothing if it is not on disk.
+func ReadReferences(rtxn db.RTxn, disk *db.Databases, vUUId *common.VarUUId) ([]*common.VarUUId, error) {
+	varBytes, err := rtxn.Get(db.Vars, vUUId[:])
+	if err == db.NotFound {
+		return nil, nil
+	} else if err == nil {
+		varBytes, err = disk.OpenRecord(db.Vars, vUUId[:], varBytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	seg, _, err := capn.ReadFromMemoryZeroCopy(varBytes)
+	if err != nil {
+		return nil, err
+	}
+	varCap := msgs.ReadRootVar(seg)
+	txnId := common.MakeTxnId(varCap.WriteTxnId())
+	txnBytes, err := disk.ReadTxnBytesFromDisk(rtxn, txnId)
+	if err != nil {
+		return nil, err
+	} else if txnBytes == nil {
+		return nil, fmt.Errorf("%v: unable to find write txn %v", vUUId, txnId)
+	}
+	actions := TxnReaderFromData(txnBytes).Actions(true).Actions()
+	for idx, l := 0, actions.Len(); idx < l; idx++ {
+		if action := actions.At(idx); bytes.Equal(action.VarId(), vUUId[:]) {
+			return referencedVars(WrittenReferences(&action)), nil
+		}
+	}
+	return nil, nil
+}
+
+// writtenReferences returns the references in the value of f's var
+// as written by f's txn.
+func (f *frame) writtenReferences() *msgs.VarIdPos_List {
+	if f.frameTxnActions != nil {
+		actions := f.frameTxnActions.Actions()
+		for idx, l := 0, actions.Len(); idx < l; idx++ {
+			if action := actions.At(idx); bytes.Equal(action.VarId(), f.v.UUId[:]) {
+				return WrittenReferences(&action)
+			}
+		}
+	}
+	var references msgs.VarIdPos_List
+	return &references
+}
+
+func referencedVars(references *msgs.VarIdPos_List) []*common.VarUUId {
+	vUUIds := make([]*common.VarUUId, references.Len())
+	for idx := range vUUIds {
+		vUUIds[idx] = common.MakeVarUUId(references.At(idx).Id())
+	}
+	return vUUIds
+}
@@ -3,12 +3,12 @@ package txnengine
 import (
 	"fmt"
 	capn "github.com/glycerine/go-capnproto"
-	mdbs "github.com/msackman/gomdb/server"
 	"goshawkdb.io/common"
 	"goshawkdb.io/server"
 	msgs "goshawkdb.io/server/capnp"
 	"goshawkdb.io/server/db"
 	"goshawkdb.io/server/dispatcher"
+	"log"
 	"math/rand"
 	"time"
 )
@@ -31,16 +31,21 @@ type Var struct {
 	vm              *VarManager
 	varCap          *msgs.Var
 	rng             *rand.Rand
+	// non-nil if the var on disk is corrupt: see VarManager.find
+	quarantined *quarantine
+	// the write txn of the corrupt copy on disk, released by the
+	// write which repairs it
+	corruptTxnId *common.TxnId
 }
 
-func VarFromData(data []byte, exe *dispatcher.Executor, db *db.Databases, vm *VarManager) (*Var, error) {
+func VarFromData(data []byte, exe *dispatcher.Executor, disk *db.Databases, vm *VarManager) (*Var, error) {
 	seg, _, err := capn.ReadFromMemoryZeroCopy(data)
 	if err != nil {
 		return nil, err
 	}
 	varCap := msgs.ReadRootVar(seg)
 
-	v := newVar(common.MakeVarUUId(varCap.Id()), exe, db, vm)
+	v := newVar(common.MakeVarUUId(varCap.Id()), exe, disk, vm)
 	positions := varCap.Positions()
 	if positions.Len() != 0 {
 		v.positions = (*common.Positions)(&positions)
@@ -51,8 +56,13 @@ func VarFromData(data []byte, exe *dispatcher.Executor, db *db.Databases, vm *Va
 	writesClock := VectorClockFromData(varCap.WritesClock(), true).AsMutable()
 	server.Log(v.UUId, "Restored", writeTxnId)
 
-	if result, err := db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
-		return db.ReadTxnBytesFromDisk(rtxn, writeTxnId)
+	if result, err := disk.ReadonlyTransaction(func(rtxn db.RTxn) interface{} {
+		bites, err := disk.ReadTxnBytesFromDisk(rtxn, writeTxnId)
+		if err != nil {
+			rtxn.Error(err)
+			return nil
+		}
+		return bites
 	}).ResultError(); err == nil && result != nil {
 		txn := TxnReaderFromData(result.([]byte))
 		v.curFrame = NewFrame(nil, v, writeTxnId, txn.Actions(false), writeTxnClock, writesClock)
@@ -64,6 +74,20 @@ func VarFromData(data []byte, exe *dispatcher.Executor, db *db.Databases, vm *Va
 	}
 }
 
+// writeTxnIdFromData returns the write txn of the var in data, or nil
+// if data cannot be read.
+func writeTxnIdFromData(data []byte) *common.TxnId {
+	seg, _, err := capn.ReadFromMemoryZeroCopy(data)
+	if err != nil {
+		return nil
+	}
+	varCap := msgs.ReadRootVar(seg)
+	if txnId := varCap.WriteTxnId(); len(txnId) == common.KeyLen {
+		return common.MakeTxnId(txnId)
+	}
+	return nil
+}
+
 func NewVar(uuid *common.VarUUId, exe *dispatcher.Executor, db *db.Databases, vm *VarManager) *Var {
 	v := newVar(uuid, exe, db, vm)
 
@@ -109,6 +133,13 @@ func (v *Var) ReceiveTxn(action *localAction) {
 	server.Log(v.UUId, "ReceiveTxn", action)
 	isRead, isWrite := action.IsRead(), action.IsWrite()
 
+	if v.quarantined != nil {
+		server.Log(v.UUId, "quarantined: voting to abort", action.Id)
+		db.StorageMetrics.Add("QuarantinedVarAborts", 1)
+		action.VoteDeadlock(v.curFrame.frameTxnClock)
+		return
+	}
+
 	if isRead && action.Retry {
 		if voted := v.curFrame.ReadRetry(action); !voted {
 			v.AddWriteSubscriber(action.Id,
@@ -145,6 +176,20 @@ func (v *Var) ReceiveTxnOutcome(action *localAction) {
 	case action.Retry:
 		v.RemoveWriteSubscriber(action.Id)
 
+	case v.quarantined != nil && (!isWrite || action.aborted):
+		// Nothing we can learn from, so we remain quarantined.
+		action.LocallyComplete()
+		v.maybeMakeInactive()
+
+	case v.quarantined != nil:
+		// A committed write, either learnt or immigrating from a
+		// replica repairing us, replaces the corrupt var.
+		log.Printf("%v repaired by %v\n", v.UUId, action.Id)
+		v.corruptTxnId = v.quarantined.writeTxnId
+		v.quarantined = nil
+		v.vm.unquarantine(v.UUId)
+		v.ReceiveTxnOutcome(action)
+
 	case action.frame == nil:
 		if (isWrite && !v.curFrame.WriteLearnt(action)) ||
 			(!isWrite && isRead && !v.curFrame.ReadLearnt(action)) {
@@ -179,6 +224,7 @@ func (v *Var) ReceiveTxnOutcome(action *localAction) {
 
 func (v *Var) SetCurFrame(f *frame, action *localAction, positions *common.Positions) {
 	server.Log(v.UUId, "SetCurFrame", action)
+	v.vm.barrier.frameChanged(v, v.curFrame, action)
 	v.curFrame = f
 
 	if positions != nil {
@@ -248,31 +294,44 @@ func (v *Var) maybeWriteFrame(f *frame, action *localAction, positions *common.P
 	varCap.SetWriteTxnId(f.frameTxnId[:])
 	varCap.SetWriteTxnClock(f.frameTxnClock.AsData())
 	varCap.SetWritesClock(f.frameWritesClock.AsData())
-	varData := server.SegToBytes(varSeg)
+	varData := v.db.SealCompressibleRecord(db.Vars, v.UUId[:], server.SegToBytes(varSeg))
 
 	txnBytes := action.TxnReader.Data
+	corruptTxnId := v.corruptTxnId
+
+	var oldReferences, newReferences []*common.VarUUId
+	if v.db.ReverseRefs {
+		if v.curFrameOnDisk != nil {
+			oldReferences = referencedVars(v.curFrameOnDisk.writtenReferences())
+		}
+		newReferences = referencedVars(WrittenReferences(action.writeAction))
+	}
 
 	// to ensure correct order of writes, schedule the write from
 	// the current go-routine...
-	future := v.db.ReadWriteTransaction(false, func(rwtxn *mdbs.RWTxn) interface{} {
+	result := v.db.DurableReadWriteTransaction(func(rwtxn db.RWTxn) interface{} {
 		if err := v.db.WriteTxnToDisk(rwtxn, f.frameTxnId, txnBytes); err == nil {
-			if err = rwtxn.Put(v.db.Vars, v.UUId[:], varData, 0); err == nil {
+			if err = rwtxn.Put(db.Vars, v.UUId[:], varData); err == nil {
 				if v.curFrameOnDisk != nil {
 					v.db.DeleteTxnFromDisk(rwtxn, v.curFrameOnDisk.frameTxnId)
+				} else if corruptTxnId != nil {
+					v.db.DeleteTxnFromDisk(rwtxn, corruptTxnId)
 				}
+				v.db.UpdateReverseRefs(rwtxn, v.UUId, oldReferences, newReferences)
 			}
 		}
 		return true
 	})
 	go func() {
 		// ... but process the result in a new go-routine to avoid blocking the executor.
-		if ran, err := future.ResultError(); err != nil {
+		if ran, err := result(); err != nil {
 			panic(fmt.Sprintf("Var error when writing to disk: %v\n", err))
 		} else if ran != nil {
 			// Switch back to the right go-routine
 			v.applyToVar(func() {
 				server.Log(v.UUId, "Wrote", f.frameTxnId)
 				v.curFrameOnDisk = f
+				v.corruptTxnId = nil
 				for ancestor := f.parent; ancestor != nil && ancestor.DescendentOnDisk(); ancestor = ancestor.parent {
 				}
 				v.writeInProgress()
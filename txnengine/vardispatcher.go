@@ -29,21 +29,26 @@ const (
 	ConnectionSubscriber              TopologyChangeSubscriberType = iota
 	ConnectionManagerSubscriber       TopologyChangeSubscriberType = iota
 	EmigratorSubscriber               TopologyChangeSubscriberType = iota
+	ImporterSubscriber                TopologyChangeSubscriberType = iota
+	ScrubberSubscriber                TopologyChangeSubscriberType = iota
+	CollectorSubscriber               TopologyChangeSubscriberType = iota
 	TopologyChangeSubscriberTypeLimit int                          = iota
 )
 
 type VarDispatcher struct {
 	dispatcher.Dispatcher
+	Barrier     *GCBarrier
 	varmanagers []*VarManager
 }
 
 func NewVarDispatcher(count uint8, rmId common.RMId, cm TopologyPublisher, db *db.Databases, lc LocalConnection) *VarDispatcher {
 	vd := &VarDispatcher{
+		Barrier:     NewGCBarrier(),
 		varmanagers: make([]*VarManager, count),
 	}
 	vd.Dispatcher.Init(count)
 	for idx, exe := range vd.Executors {
-		vd.varmanagers[idx] = NewVarManager(exe, rmId, cm, db, lc)
+		vd.varmanagers[idx] = NewVarManager(exe, rmId, cm, db, lc, vd.Barrier)
 	}
 	return vd
 }
@@ -52,6 +57,12 @@ func (vd *VarDispatcher) ApplyToVar(fun func(*Var), createIfMissing bool, vUUId
 	vd.withVarManager(vUUId, func(vm *VarManager) { vm.ApplyToVar(fun, createIfMissing, vUUId) })
 }
 
+func (vd *VarDispatcher) DeleteGarbage(vUUId *common.VarUUId, done func(bool)) {
+	if !vd.withVarManager(vUUId, func(vm *VarManager) { vm.DeleteGarbage(vUUId, done) }) {
+		done(false)
+	}
+}
+
 func (vd *VarDispatcher) Status(sc *server.StatusConsumer) {
 	sc.Emit("Vars")
 	for idx, executor := range vd.Executors {
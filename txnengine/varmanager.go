@@ -2,14 +2,15 @@ package txnengine
 
 import (
 	"fmt"
-	mdb "github.com/msackman/gomdb"
-	mdbs "github.com/msackman/gomdb/server"
+	capn "github.com/glycerine/go-capnproto"
 	tw "github.com/msackman/gotimerwheel"
 	"goshawkdb.io/common"
 	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
 	"goshawkdb.io/server/configuration"
 	"goshawkdb.io/server/db"
 	"goshawkdb.io/server/dispatcher"
+	"log"
 	"time"
 )
 
@@ -19,6 +20,9 @@ type VarManager struct {
 	RMId             common.RMId
 	db               *db.Databases
 	active           map[common.VarUUId]*Var
+	quarantined      map[common.VarUUId]*quarantine
+	deleting         map[common.VarUUId][]func()
+	barrier          *GCBarrier
 	RollAllowed      bool
 	onDisk           func(bool)
 	tw               *tw.TimerWheel
@@ -26,16 +30,15 @@ type VarManager struct {
 	exe              *dispatcher.Executor
 }
 
-func init() {
-	db.DB.Vars = &mdbs.DBISettings{Flags: mdb.CREATE}
-}
-
-func NewVarManager(exe *dispatcher.Executor, rmId common.RMId, tp TopologyPublisher, db *db.Databases, lc LocalConnection) *VarManager {
+func NewVarManager(exe *dispatcher.Executor, rmId common.RMId, tp TopologyPublisher, db *db.Databases, lc LocalConnection, barrier *GCBarrier) *VarManager {
 	vm := &VarManager{
 		LocalConnection: lc,
 		RMId:            rmId,
 		db:              db,
 		active:          make(map[common.VarUUId]*Var),
+		quarantined:     make(map[common.VarUUId]*quarantine),
+		deleting:        make(map[common.VarUUId][]func()),
+		barrier:         barrier,
 		RollAllowed:     false,
 		tw:              tw.NewTimerWheel(time.Now(), 25*time.Millisecond),
 		exe:             exe,
@@ -92,7 +95,15 @@ func (vm *VarManager) TopologyChanged(topology *configuration.Topology, done fun
 	}
 }
 
+// ApplyToVar calls fun with the var, loading it from disk if
+// necessary. If the var is being deleted as garbage, fun is queued
+// until the deletion has finished, and then sees the var as it is
+// afterwards.
 func (vm *VarManager) ApplyToVar(fun func(*Var), createIfMissing bool, uuid *common.VarUUId) {
+	if queued, found := vm.deleting[*uuid]; found {
+		vm.deleting[*uuid] = append(queued, func() { vm.ApplyToVar(fun, createIfMissing, uuid) })
+		return
+	}
 	v, shutdown := vm.find(uuid)
 	if shutdown {
 		return
@@ -142,28 +153,48 @@ func (vm *VarManager) SetInactive(v *Var) {
 	}
 }
 
+// A var which is corrupt on disk is quarantined: in its place we use
+// a new var which votes to abort every txn, and which is never written
+// to disk until a committed write replaces the corrupt copy (see
+// Var.ReceiveTxnOutcome). That write may come from anti-entropy
+// repair. If the var record itself could be read, the repairing write
+// releases the corrupt copy's reference to its write txn. Otherwise we
+// cannot know which txn that is, and the txn is left orphaned on disk
+// for the consistency checker to find and remove.
 func (vm *VarManager) find(uuid *common.VarUUId) (*Var, bool) {
 	if v, found := vm.active[*uuid]; found {
 		return v, false
+	} else if q, found := vm.quarantined[*uuid]; found {
+		return vm.newQuarantinedVar(uuid, q), false
 	}
 
-	result, err := vm.db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
+	result, err := vm.db.ReadonlyTransaction(func(rtxn db.RTxn) interface{} {
 		// rtxn.Get returns a copy of the data, so we don't need to
 		// worry about pointers into the db
-		if bites, err := rtxn.Get(vm.db.Vars, uuid[:]); err == nil {
+		if bites, err := rtxn.Get(db.Vars, uuid[:]); err == nil {
+			if bites, err = vm.db.OpenRecord(db.Vars, uuid[:], bites); err != nil {
+				rtxn.Error(err)
+				return nil
+			}
 			return bites
 		} else {
 			return true
 		}
 	}).ResultError()
 
-	if err != nil {
+	if db.IsCorruptRecord(err) {
+		return vm.newQuarantinedVar(uuid, vm.quarantine(uuid, err, nil)), false
+	} else if err != nil {
 		panic(fmt.Sprintf("Error when loading %v from disk: %v", uuid, err))
 	} else if result == nil { // shutdown
 		return nil, true
 	} else if bites, ok := result.([]byte); ok {
 		v, err := VarFromData(bites, vm.exe, vm.db, vm)
-		if err != nil {
+		if db.IsCorruptRecord(err) {
+			// The var is fine, so it's its write txn which is corrupt.
+			q := vm.quarantine(uuid, err, writeTxnIdFromData(bites))
+			return vm.newQuarantinedVar(uuid, q), false
+		} else if err != nil {
 			panic(fmt.Sprintf("Error when recreating %v: %v", uuid, err))
 		} else if v == nil { // shutdown
 			return v, true
@@ -176,8 +207,112 @@ func (vm *VarManager) find(uuid *common.VarUUId) (*Var, bool) {
 	}
 }
 
+type quarantine struct {
+	err error
+	// nil if the corrupt copy's write txn is not known
+	writeTxnId *common.TxnId
+}
+
+func (vm *VarManager) quarantine(uuid *common.VarUUId, err error, writeTxnId *common.TxnId) *quarantine {
+	log.Printf("Quarantining %v: %v\n", uuid, err)
+	q := &quarantine{err: err, writeTxnId: writeTxnId}
+	vm.quarantined[*uuid] = q
+	db.StorageMetrics.Add("QuarantinedVars", 1)
+	return q
+}
+
+func (vm *VarManager) newQuarantinedVar(uuid *common.VarUUId, q *quarantine) *Var {
+	v := NewVar(uuid, vm.exe, vm.db, vm)
+	v.quarantined = q
+	vm.active[*v.UUId] = v
+	return v
+}
+
+func (vm *VarManager) unquarantine(uuid *common.VarUUId) {
+	delete(vm.quarantined, *uuid)
+	db.StorageMetrics.Add("QuarantinedVars", -1)
+}
+
+// DeleteGarbage removes the var, which the garbage collector has found
+// to be unreachable, from disk along with its write txn. done is
+// called, from some other go-routine, with true iff the var was
+// deleted. An active var is left alone: something is using it, so it
+// is not garbage after all. Whilst the deletion is in progress,
+// ApplyToVar queues calls for the var (see ApplyToVar), and once it
+// has finished they are run in order. A var which is deleted is
+// recorded in the barrier so that anti-entropy does not restore it.
+func (vm *VarManager) DeleteGarbage(uuid *common.VarUUId, done func(bool)) {
+	_, active := vm.active[*uuid]
+	_, quarantined := vm.quarantined[*uuid]
+	_, deleting := vm.deleting[*uuid]
+	if active || quarantined || deleting {
+		go done(false)
+		return
+	}
+	vm.deleting[*uuid] = nil
+	result := vm.db.DurableReadWriteTransaction(func(rwtxn db.RWTxn) interface{} {
+		bites, err := rwtxn.Get(db.Vars, uuid[:])
+		if err == db.NotFound {
+			return false
+		} else if err == nil {
+			bites, err = vm.db.OpenRecord(db.Vars, uuid[:], bites)
+		}
+		if err != nil {
+			rwtxn.Error(err)
+			return nil
+		}
+		seg, _, err := capn.ReadFromMemoryZeroCopy(bites)
+		if err != nil {
+			rwtxn.Error(err)
+			return nil
+		}
+		varCap := msgs.ReadRootVar(seg)
+		if vm.db.ReverseRefs {
+			references, err := ReadReferences(rwtxn, vm.db, uuid)
+			if err == nil {
+				err = vm.db.UpdateReverseRefs(rwtxn, uuid, references, nil)
+			}
+			if err != nil {
+				rwtxn.Error(err)
+				return nil
+			}
+		}
+		if err = rwtxn.Del(db.Vars, uuid[:]); err == nil {
+			err = vm.db.DeleteTxnFromDisk(rwtxn, common.MakeTxnId(varCap.WriteTxnId()))
+		}
+		if err != nil {
+			rwtxn.Error(err)
+			return nil
+		}
+		return true
+	})
+	go func() {
+		ran, err := result()
+		if err != nil {
+			log.Printf("Error when deleting garbage %v: %v\n", uuid, err)
+		}
+		deleted, _ := ran.(bool)
+		if deleted {
+			vm.barrier.Deleted(uuid)
+		}
+		vm.exe.Enqueue(func() {
+			queued := vm.deleting[*uuid]
+			delete(vm.deleting, *uuid)
+			for _, fun := range queued {
+				fun()
+			}
+		})
+		done(deleted)
+	}()
+}
+
 func (vm *VarManager) Status(sc *server.StatusConsumer) {
 	sc.Emit(fmt.Sprintf("- Active Vars: %v", len(vm.active)))
+	sc.Emit(fmt.Sprintf("- Quarantined Vars: %v", len(vm.quarantined)))
+	sc.Emit(fmt.Sprintf("- Vars being deleted: %v", len(vm.deleting)))
+	for uuid, q := range vm.quarantined {
+		sc.Emit(fmt.Sprintf("  %v: %v", &uuid, q.err))
+	}
 	sc.Emit(fmt.Sprintf("- Callbacks: %v", vm.tw.Length()))
 	sc.Emit(fmt.Sprintf("- Beater live? %v", vm.beaterTerminator != nil))
 	sc.Emit(fmt.Sprintf("- Roll allowed? %v", vm.RollAllowed))